@@ -1,25 +1,33 @@
 package api
 
 import (
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
-	"os"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
-	"github.com/golang-jwt/jwt/v5"
 	"github.com/xV0lk/hotel-reservations/db"
+	"github.com/xV0lk/hotel-reservations/mail"
 	"github.com/xV0lk/hotel-reservations/types"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
+// passwordResetTTL is how long the token mailed to reset a password works
+const passwordResetTTL = time.Hour
+
 type AuthHandler struct {
-	userStore db.UserStore
+	store  *db.Store
+	mailer mail.Mailer
 }
 
-func NewAuthHandler(userStore db.UserStore) *AuthHandler {
+func NewAuthHandler(store *db.Store, mailer mail.Mailer) *AuthHandler {
 	return &AuthHandler{
-		userStore: userStore,
+		store:  store,
+		mailer: mailer,
 	}
 }
 
@@ -28,45 +36,264 @@ type AuthParams struct {
 	Password string `json:"password"`
 }
 
+type RefreshParams struct {
+	RefreshToken string `json:"refreshToken"`
+}
+
+// AuthResponse carries a short lived access token, valid until ExpiresAt,
+// and the refresh token that replaces it until the session expires.
 type AuthResponse struct {
-	User  *types.User `json:"user"`
-	Token string      `json:"token"`
+	User         *types.User `json:"user"`
+	Token        string      `json:"token"`
+	ExpiresAt    time.Time   `json:"expiresAt"`
+	RefreshToken string      `json:"refreshToken"`
+}
+
+func ErrInvalidRefreshToken() Error {
+	return NewError(http.StatusUnauthorized, "The refresh token is invalid or has expired")
+}
+
+func ErrInvalidVerificationToken() Error {
+	return NewError(http.StatusBadRequest, "The verification token is invalid or has expired")
+}
+
+func ErrInvalidResetToken() Error {
+	return NewError(http.StatusBadRequest, "The reset token is invalid or has expired")
 }
 
+// HandleAuthenticate logs the user in, starting a new session
 func (h *AuthHandler) HandleAuthenticate(c *fiber.Ctx) error {
 	var body AuthParams
 	if err := c.BodyParser(&body); err != nil {
 		return err
 	}
-	user, err := h.userStore.GetUser(c.Context(), bson.M{"email": body.Email})
+	user, err := h.store.User.GetUser(c.UserContext(), bson.M{"email": body.Email})
 	if err != nil {
 		return ErrNotFound()
 	}
 	if !types.IsValidPassword(body.Password, user.Password) {
 		return NewError(http.StatusBadRequest, "Invalid password")
 	}
-	token, err := CreateUserToken(user)
+	now := time.Now()
+	session := &types.Session{
+		ID:        primitive.NewObjectID(),
+		UserID:    user.ID,
+		CreatedAt: now,
+		ExpiresAt: now.Add(refreshTokenTTL),
+	}
+	refresh, hash, err := types.NewRefreshToken(session.ID)
 	if err != nil {
-		fmt.Println("Error: ", err)
 		return ErrInternal()
 	}
-	resp := AuthResponse{
-		User:  user,
-		Token: token,
+	session.TokenHash = hash
+	if err := h.store.Session.InsertSession(c.UserContext(), session); err != nil {
+		return ErrInternal()
 	}
-	return c.Status(http.StatusOK).JSON(resp)
+	return h.respond(c, user, session, refresh, now)
+}
+
+// HandleRefresh exchanges a refresh token for a new access token and a new
+// refresh token, the used one being no longer valid. Reusing a replaced
+// refresh token ends the session, as it may have been stolen.
+func (h *AuthHandler) HandleRefresh(c *fiber.Ctx) error {
+	var params RefreshParams
+	if err := c.BodyParser(&params); err != nil {
+		return ErrBadRequest()
+	}
+	sessionID, hash, err := types.ParseRefreshToken(params.RefreshToken)
+	if err != nil {
+		return ErrInvalidRefreshToken()
+	}
+	now := time.Now()
+	session, err := h.store.Session.GetSessionById(c.UserContext(), sessionID)
+	if err != nil || !session.IsActive(now) {
+		return ErrInvalidRefreshToken()
+	}
+	if !session.Matches(hash) {
+		if err := revokeSession(c.UserContext(), h.store.Session, session.ID, now); err != nil {
+			return ErrInternal()
+		}
+		return ErrInvalidRefreshToken()
+	}
+	user, err := h.store.User.GetUserById(c.UserContext(), session.UserID.Hex())
+	if err != nil {
+		return ErrInvalidRefreshToken()
+	}
+	refresh, newHash, err := types.NewRefreshToken(session.ID)
+	if err != nil {
+		return ErrInternal()
+	}
+	if err := h.store.Session.RotateSession(c.UserContext(), session.ID, hash, newHash, now.Add(refreshTokenTTL)); err != nil {
+		if errors.Is(err, db.ErrSessionRotated) {
+			return ErrInvalidRefreshToken()
+		}
+		return ErrInternal()
+	}
+	return h.respond(c, user, session, refresh, now)
+}
+
+// HandleLogout ends the session of the access token, which revokes all the
+// access tokens of the session, and revokes the token itself
+func (h *AuthHandler) HandleLogout(c *fiber.Ctx) error {
+	claims, ok := c.Context().UserValue("claims").(*AccessClaims)
+	if !ok {
+		return ErrInternal()
+	}
+	if claims.SessionID != "" {
+		sessionID, err := primitive.ObjectIDFromHex(claims.SessionID)
+		if err != nil {
+			return ErrUnauthorized()
+		}
+		if err := revokeSession(c.UserContext(), h.store.Session, sessionID, time.Now()); err != nil {
+			return ErrInternal()
+		}
+	}
+	if err := revokeToken(c.UserContext(), h.store.Session, claims); err != nil {
+		return ErrInternal()
+	}
+	return c.JSON(fiber.Map{"message": "Logged out successfully!"})
 }
 
-func CreateUserToken(user *types.User) (string, error) {
+// HandleForgotPassword mails the user a token to choose a new password. It
+// answers the same whether the email belongs to a user or not, so it can't
+// be used to find out who has an account.
+func (h *AuthHandler) HandleForgotPassword(c *fiber.Ctx) error {
+	var params types.ForgotPasswordParams
+	if err := c.BodyParser(&params); err != nil {
+		return ErrBadRequest()
+	}
+	resp := fiber.Map{"message": "If the email belongs to an account, a reset token was sent to it"}
+	user, err := h.store.User.GetUser(c.UserContext(), bson.M{"email": params.Email})
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return c.JSON(resp)
+	}
+	if err != nil {
+		return ErrInternal()
+	}
 	now := time.Now()
-	expire := now.Add(time.Hour * 24).Unix()
-	claims := jwt.MapClaims{
-		"id":         user.ID,
-		"email":      user.Email,
-		"expiration": expire,
-	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	secret := os.Getenv("JWT_SECRET")
-	ts, err := token.SignedString([]byte(secret))
-	return ts, err
+	reset := &types.PasswordReset{
+		ID:        primitive.NewObjectID(),
+		UserID:    user.ID,
+		CreatedAt: now,
+		ExpiresAt: now.Add(passwordResetTTL),
+	}
+	token, hash, err := types.NewResetToken(reset.ID)
+	if err != nil {
+		return ErrInternal()
+	}
+	reset.TokenHash = hash
+	// failing only for existing emails would tell them apart, so the token
+	// that couldn't be stored or sent is only logged
+	if err := h.store.PasswordReset.InsertPasswordReset(c.UserContext(), reset); err != nil {
+		log.Printf("storing the password reset of user %s: %v", user.ID.Hex(), err)
+		return c.JSON(resp)
+	}
+	if err := h.mailer.Send(c.UserContext(), passwordResetMessage(user, token)); err != nil {
+		log.Printf("mailing the password reset of user %s: %v", user.ID.Hex(), err)
+	}
+	return c.JSON(resp)
+}
+
+// HandleResetPassword sets the new password of the user a reset token was
+// mailed to. The token can only be used once, and every session of the
+// user ends, as well as the access tokens issued before the reset.
+func (h *AuthHandler) HandleResetPassword(c *fiber.Ctx) error {
+	var params types.ResetPasswordParams
+	if err := c.BodyParser(&params); err != nil {
+		return ErrBadRequest()
+	}
+	if errors := params.Validate(); len(errors) > 0 {
+		return NewMapError(http.StatusBadRequest, errors)
+	}
+	resetID, hash, err := types.ParseResetToken(params.Token)
+	if err != nil {
+		return ErrInvalidResetToken()
+	}
+	password, err := types.HashPassword(params.Password)
+	if err != nil {
+		return ErrInternal()
+	}
+	now := time.Now()
+	reset, err := h.store.PasswordReset.UsePasswordReset(c.UserContext(), resetID, hash, now)
+	if errors.Is(err, db.ErrResetInvalid) {
+		return ErrInvalidResetToken()
+	}
+	if err != nil {
+		return ErrInternal()
+	}
+	if err := h.store.User.UpdatePassword(c.UserContext(), reset.UserID.Hex(), password, now); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return ErrInvalidResetToken()
+		}
+		return ErrInternal()
+	}
+	if err := h.store.Session.RevokeUserSessions(c.UserContext(), reset.UserID, now); err != nil {
+		return ErrInternal()
+	}
+	if err := h.store.PasswordReset.RevokeUserResets(c.UserContext(), reset.UserID, now); err != nil {
+		return ErrInternal()
+	}
+	return c.JSON(fiber.Map{"message": "Password updated successfully!"})
+}
+
+// HandleVerifyEmail verifies the email of the user the link was mailed to
+func (h *AuthHandler) HandleVerifyEmail(c *fiber.Ctx) error {
+	verificationID, hash, err := types.ParseVerificationToken(c.Query("token"))
+	if err != nil {
+		return ErrInvalidVerificationToken()
+	}
+	verification, err := h.store.EmailVerification.UseEmailVerification(c.UserContext(), verificationID, hash, time.Now())
+	if errors.Is(err, db.ErrVerificationInvalid) {
+		return ErrInvalidVerificationToken()
+	}
+	if err != nil {
+		return ErrInternal()
+	}
+	if err := h.store.User.VerifyEmail(c.UserContext(), verification.UserID.Hex()); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return ErrInvalidVerificationToken()
+		}
+		return ErrInternal()
+	}
+	return c.JSON(fiber.Map{"message": "Email verified successfully!"})
+}
+
+// HandleResendVerification mails the logged in user a new verification link
+func (h *AuthHandler) HandleResendVerification(c *fiber.Ctx) error {
+	user, ok := c.Context().UserValue("user").(*types.User)
+	if !ok {
+		return ErrInternal()
+	}
+	if user.EmailVerified {
+		return NewError(http.StatusConflict, "Email already verified")
+	}
+	if err := sendVerification(c.UserContext(), h.store, h.mailer, user); err != nil {
+		return ErrInternal()
+	}
+	return c.JSON(fiber.Map{"message": "Verification email sent"})
+}
+
+func passwordResetMessage(user *types.User, token string) mail.Message {
+	return mail.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hi %s,\n\n"+
+			"Use this token to choose a new password in the next %d minutes:\n\n%s\n\n"+
+			"If you didn't ask to reset your password, you can ignore this email.\n",
+			user.FirstName, int(passwordResetTTL.Minutes()), token),
+	}
+}
+
+func (h *AuthHandler) respond(c *fiber.Ctx, user *types.User, session *types.Session, refresh string, now time.Time) error {
+	token, expiresAt, err := createAccessToken(user, session.ID.Hex(), now)
+	if err != nil {
+		return ErrInternal()
+	}
+	resp := AuthResponse{
+		User:         user,
+		Token:        token,
+		ExpiresAt:    expiresAt,
+		RefreshToken: refresh,
+	}
+	return c.Status(http.StatusOK).JSON(resp)
 }
@@ -2,15 +2,25 @@ package api
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"reflect"
+	"regexp"
 	"testing"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/xV0lk/hotel-reservations/db/fixtures"
+	"github.com/xV0lk/hotel-reservations/mail"
 	"github.com/xV0lk/hotel-reservations/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 type failAuthResponse struct {
@@ -23,7 +33,7 @@ func TestAuth(t *testing.T) {
 	insertedUser := fixtures.AddUser(db.Store, "test", "user", false)
 
 	app := fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
-	authHandler := NewAuthHandler(db.Store.User)
+	authHandler := NewAuthHandler(db.Store, newOutbox(t))
 	app.Post("/auth", authHandler.HandleAuthenticate)
 
 	for _, tc := range authTests {
@@ -37,7 +47,10 @@ func (tc testCase[AuthParams]) testAuth(t *testing.T, app *fiber.App, expectedUs
 	b, _ := json.Marshal(tc.input)
 	req := httptest.NewRequest("POST", "/auth", bytes.NewReader(b))
 	req.Header.Add("Content-Type", "application/json")
-	res, _ := app.Test(req)
+	res, err := app.Test(req, testTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
 	if res.StatusCode != tc.status {
 		t.Fatalf("expected status %d, got %s", tc.status, res.Status)
 	}
@@ -103,3 +116,254 @@ var authTests = []testCase[AuthParams]{
 		},
 	},
 }
+
+func TestAuthSessions(t *testing.T) {
+	db := setup(t)
+	defer db.Drop(t)
+	user := fixtures.AddUser(db.Store, "test", "user", false)
+
+	app := fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+	authHandler := NewAuthHandler(db.Store, newOutbox(t))
+	app.Post("/auth", authHandler.HandleAuthenticate)
+	app.Post("/auth/refresh", authHandler.HandleRefresh)
+	app.Post("/auth/logout", JWTAuth(db.Store.User, db.Store.Session), authHandler.HandleLogout)
+	app.Get("/private", JWTAuth(db.Store.User, db.Store.Session), func(c *fiber.Ctx) error {
+		return c.SendStatus(http.StatusOK)
+	})
+
+	post := func(path, token string, body any) *http.Response {
+		b, _ := json.Marshal(body)
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(b))
+		req.Header.Add("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Add("Authorization", token)
+		}
+		res, _ := app.Test(req, testTimeout)
+		return res
+	}
+	auth := func(res *http.Response) AuthResponse {
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("expected status %d, got %d", http.StatusOK, res.StatusCode)
+		}
+		var resp AuthResponse
+		if err := json.NewDecoder(res.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	private := func(token string) int {
+		req := httptest.NewRequest(http.MethodGet, "/private", nil)
+		req.Header.Add("Authorization", token)
+		res, _ := app.Test(req, testTimeout)
+		return res.StatusCode
+	}
+
+	login := auth(post("/auth", "", AuthParams{Email: "test@user.com", Password: "test_user_P4$$"}))
+	if login.RefreshToken == "" || login.ExpiresAt.After(time.Now().Add(accessTokenTTL)) {
+		t.Fatalf("expected a refresh token and a short lived access token, got %+v", login)
+	}
+	claims, err := ValidateToken(login.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Subject != user.ID.Hex() || claims.ID == "" || claims.IssuedAt == nil || claims.SessionID == "" {
+		t.Errorf("expected the standard sub, jti and iat claims with a session, got %+v", claims)
+	}
+	if status := private(login.Token); status != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, status)
+	}
+
+	refreshed := auth(post("/auth/refresh", "", RefreshParams{RefreshToken: login.RefreshToken}))
+	if refreshed.RefreshToken == login.RefreshToken || refreshed.Token == login.Token {
+		t.Fatal("expected the tokens to be rotated")
+	}
+	if status := private(refreshed.Token); status != http.StatusOK {
+		t.Errorf("expected the refreshed token to be accepted, got status %d", status)
+	}
+	// reusing a replaced refresh token ends the session
+	if res := post("/auth/refresh", "", RefreshParams{RefreshToken: login.RefreshToken}); res.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected status %d for a reused refresh token, got %d", http.StatusUnauthorized, res.StatusCode)
+	}
+	if res := post("/auth/refresh", "", RefreshParams{RefreshToken: refreshed.RefreshToken}); res.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected the session to be revoked, got status %d", res.StatusCode)
+	}
+	if status := private(refreshed.Token); status != http.StatusUnauthorized {
+		t.Errorf("expected the access tokens of the revoked session to be rejected, got status %d", status)
+	}
+
+	// logging out rejects every access token of the session
+	session := auth(post("/auth", "", AuthParams{Email: "test@user.com", Password: "test_user_P4$$"}))
+	sibling := auth(post("/auth/refresh", "", RefreshParams{RefreshToken: session.RefreshToken}))
+	if status := private(sibling.Token); status != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, status)
+	}
+	if res := post("/auth/logout", session.Token, nil); res.StatusCode != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, res.StatusCode)
+	}
+	if status := private(session.Token); status != http.StatusUnauthorized {
+		t.Errorf("expected the logged out token to be rejected, got status %d", status)
+	}
+	if status := private(sibling.Token); status != http.StatusUnauthorized {
+		t.Errorf("expected the other tokens of the session to be rejected, got status %d", status)
+	}
+	if res := post("/auth/refresh", "", RefreshParams{RefreshToken: session.RefreshToken}); res.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected the logged out refresh token to be rejected, got status %d", res.StatusCode)
+	}
+	revoked, err := db.Store.Session.GetRevokedTokens(context.TODO(), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revoked) != 1 {
+		t.Errorf("expected the revoked token to be stored, got %d", len(revoked))
+	}
+
+	// sessions revoked by another instance are checked in the store
+	other := auth(post("/auth", "", AuthParams{Email: "test@user.com", Password: "test_user_P4$$"}))
+	otherClaims, err := ValidateToken(other.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sessionID, _ := primitive.ObjectIDFromHex(otherClaims.SessionID)
+	if err := db.Store.Session.RevokeSession(context.TODO(), sessionID, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if status := private(other.Token); status != http.StatusUnauthorized {
+		t.Errorf("expected the token of a session revoked elsewhere to be rejected, got status %d", status)
+	}
+
+	// tokens with the former custom expiration claim are rejected
+	legacy := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"id":         user.ID,
+		"email":      user.Email,
+		"expiration": time.Now().Add(time.Hour).Unix(),
+	})
+	legacyToken, _ := legacy.SignedString([]byte(os.Getenv("JWT_SECRET")))
+	if status := private(legacyToken); status != http.StatusUnauthorized {
+		t.Errorf("expected status %d for a token without exp, got %d", http.StatusUnauthorized, status)
+	}
+}
+
+func TestPasswordReset(t *testing.T) {
+	db := setup(t)
+	defer db.Drop(t)
+	fixtures.AddUser(db.Store, "test", "user", false)
+	outbox := newOutbox(t)
+
+	app := fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+	authHandler := NewAuthHandler(db.Store, outbox)
+	app.Post("/auth", authHandler.HandleAuthenticate)
+	app.Post("/auth/refresh", authHandler.HandleRefresh)
+	app.Post("/auth/forgot", authHandler.HandleForgotPassword)
+	app.Post("/auth/reset", authHandler.HandleResetPassword)
+	app.Get("/private", JWTAuth(db.Store.User, db.Store.Session), func(c *fiber.Ctx) error {
+		return c.SendStatus(http.StatusOK)
+	})
+
+	post := func(path string, body any) *http.Response {
+		b, _ := json.Marshal(body)
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(b))
+		req.Header.Add("Content-Type", "application/json")
+		res, _ := app.Test(req, testTimeout)
+		return res
+	}
+	expectStatus := func(name string, res *http.Response, status int) {
+		t.Helper()
+		if res.StatusCode != status {
+			t.Fatalf("%s: expected status %d, got %d", name, status, res.StatusCode)
+		}
+	}
+	tokenRe := regexp.MustCompile(`[0-9a-f]{24}\.[A-Za-z0-9_-]+`)
+	forgot := func() string {
+		t.Helper()
+		expectStatus("forgot", post("/auth/forgot", types.ForgotPasswordParams{Email: "test@user.com"}), http.StatusOK)
+		msgs, err := outbox.Messages()
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg := msgs[len(msgs)-1]
+		if msg.To != "test@user.com" {
+			t.Fatalf("expected the email to be sent to the user, got %q", msg.To)
+		}
+		token := tokenRe.FindString(msg.Body)
+		if token == "" {
+			t.Fatalf("expected a reset token in %q", msg.Body)
+		}
+		return token
+	}
+
+	var login AuthResponse
+	res := post("/auth", AuthParams{Email: "test@user.com", Password: "test_user_P4$$"})
+	expectStatus("login", res, http.StatusOK)
+	if err := json.NewDecoder(res.Body).Decode(&login); err != nil {
+		t.Fatal(err)
+	}
+
+	// unknown emails get the same answer without any email
+	expectStatus("unknown email", post("/auth/forgot", types.ForgotPasswordParams{Email: "nobody@user.com"}), http.StatusOK)
+	if msgs, _ := outbox.Messages(); len(msgs) != 0 {
+		t.Fatalf("expected no email for an unknown address, got %d", len(msgs))
+	}
+
+	older := forgot()
+	token := forgot()
+	newPassword := "N3w_password!"
+	expectStatus("weak password", post("/auth/reset", types.ResetPasswordParams{Token: token, Password: "weak"}), http.StatusBadRequest)
+	expectStatus("forged token", post("/auth/reset", types.ResetPasswordParams{Token: token[:25] + "forged", Password: newPassword}), http.StatusBadRequest)
+	expectStatus("reset", post("/auth/reset", types.ResetPasswordParams{Token: token, Password: newPassword}), http.StatusOK)
+	expectStatus("reused token", post("/auth/reset", types.ResetPasswordParams{Token: token, Password: newPassword}), http.StatusBadRequest)
+	expectStatus("older token", post("/auth/reset", types.ResetPasswordParams{Token: older, Password: newPassword}), http.StatusBadRequest)
+
+	// the reset ends every session of the user
+	expectStatus("old refresh token", post("/auth/refresh", RefreshParams{RefreshToken: login.RefreshToken}), http.StatusUnauthorized)
+	user, err := db.Store.User.GetUser(context.TODO(), bson.M{"email": "test@user.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// tokens issued in the second of the reset can't be told apart from the
+	// ones issued right before it, so they are rejected too
+	oldToken, _, err := createAccessToken(user, "", *user.PasswordChangedAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/private", nil)
+	req.Header.Add("Authorization", oldToken)
+	res, _ = app.Test(req, testTimeout)
+	expectStatus("old access token", res, http.StatusUnauthorized)
+
+	expectStatus("old password", post("/auth", AuthParams{Email: "test@user.com", Password: "test_user_P4$$"}), http.StatusBadRequest)
+	expectStatus("new password", post("/auth", AuthParams{Email: "test@user.com", Password: newPassword}), http.StatusOK)
+}
+
+// failingMailer can't send any email
+type failingMailer struct{}
+
+func (failingMailer) Send(ctx context.Context, msg mail.Message) error {
+	return errors.New("mail server unavailable")
+}
+
+func TestForgotPasswordMailFailure(t *testing.T) {
+	db := setup(t)
+	defer db.Drop(t)
+	fixtures.AddUser(db.Store, "test", "user", false)
+
+	app := fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+	authHandler := NewAuthHandler(db.Store, failingMailer{})
+	app.Post("/auth/forgot", authHandler.HandleForgotPassword)
+
+	// emails that couldn't be sent get the answer of unknown addresses
+	answers := []string{}
+	for _, email := range []string{"test@user.com", "nobody@user.com"} {
+		b, _ := json.Marshal(types.ForgotPasswordParams{Email: email})
+		req := httptest.NewRequest(http.MethodPost, "/auth/forgot", bytes.NewReader(b))
+		req.Header.Add("Content-Type", "application/json")
+		res, _ := app.Test(req, testTimeout)
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("expected status %d for %s, got %d", http.StatusOK, email, res.StatusCode)
+		}
+		body, _ := io.ReadAll(res.Body)
+		answers = append(answers, string(body))
+	}
+	if answers[0] != answers[1] {
+		t.Errorf("expected the same answer for both emails, got %q and %q", answers[0], answers[1])
+	}
+}
@@ -0,0 +1,56 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/xV0lk/hotel-reservations/db"
+	"github.com/xV0lk/hotel-reservations/pricing"
+	"github.com/xV0lk/hotel-reservations/types"
+)
+
+type AvailabilityHandler struct {
+	store *db.Store
+}
+
+func NewAvailabilityHandler(store *db.Store) *AvailabilityHandler {
+	return &AvailabilityHandler{
+		store: store,
+	}
+}
+
+// HandleSearch returns, per hotel, the rooms free for the whole date range
+// with the total price of the stay, also shown in the currency given with
+// ?currency=.
+func (h *AvailabilityHandler) HandleSearch(c *fiber.Ctx) error {
+	var query types.AvailabilityQuery
+	if err := c.QueryParser(&query); err != nil {
+		return ErrBadRequest()
+	}
+	if errors := query.Validate(); len(errors) != 0 {
+		return NewMapError(http.StatusBadRequest, errors)
+	}
+	convert, err := currencyConverter(c.UserContext(), h.store, query.Currency)
+	if err != nil {
+		return err
+	}
+	hotels, err := h.store.Room.SearchAvailability(c.UserContext(), query)
+	if err != nil {
+		return ErrInternal()
+	}
+	b := query.BookingBody()
+	for _, hotel := range hotels {
+		for _, room := range hotel.Rooms {
+			quote := pricing.HotelQuote(&hotel.Hotel, &room.Room, b.FromDate, b.UntilDate, b.NumPeople)
+			room.TotalPrice = quote.Total
+			room.Currency = hotel.Hotel.CurrencyCode()
+			if convert != nil {
+				total := types.Money{Amount: quote.Total, Currency: room.Currency}
+				if room.DisplayPrice, err = convert(total); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return c.JSON(hotels)
+}
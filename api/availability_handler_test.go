@@ -0,0 +1,101 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/xV0lk/hotel-reservations/db/fixtures"
+	"github.com/xV0lk/hotel-reservations/types"
+)
+
+func TestAvailabilitySearch(t *testing.T) {
+	db := setup(t)
+	defer db.Drop(t)
+
+	user := fixtures.AddUser(db.Store, "test", "user", false)
+	userToken, _ := CreateUserToken(user)
+	london := fixtures.AddHotel(db.Store, "Sherlock hideout", "London", 4.9, 3)
+	fixtures.AddHotel(db.Store, "Yokai Inn", "Japan", 4.2, 2)
+	booked := fixtures.AddRoom(db.Store, types.Double, 100, london.ID)
+
+	from := time.Now().AddDate(0, 0, 10)
+	until := from.AddDate(0, 0, 3)
+	fixtures.AddBooking(db.Store, user.ID, booked, from, until, 2)
+
+	app := fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+	availHandler := NewAvailabilityHandler(db.Store)
+	app.Get("/availability", JWTAuth(db.Store.User, db.Store.Session), availHandler.HandleSearch)
+
+	search := func(query string) (*http.Response, []types.HotelAvailability) {
+		req := httptest.NewRequest(http.MethodGet, "/availability?"+query, nil)
+		req.Header.Add("Authorization", userToken)
+		res, _ := app.Test(req, testTimeout)
+		var hotels []types.HotelAvailability
+		if res.StatusCode == http.StatusOK {
+			if err := json.NewDecoder(res.Body).Decode(&hotels); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return res, hotels
+	}
+	dates := fmt.Sprintf("from=%s&until=%s", from.Format("2006-01-02"), until.Format("2006-01-02"))
+
+	res, hotels := search(dates + "&location=london&type=2")
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, res.StatusCode)
+	}
+	if len(hotels) != 1 || hotels[0].Hotel.ID != london.ID {
+		t.Fatalf("expected only hotel %s, got %v", london.ID.Hex(), hotels)
+	}
+	if len(hotels[0].Rooms) != 1 {
+		t.Fatalf("expected 1 free double room, got %d", len(hotels[0].Rooms))
+	}
+	room := hotels[0].Rooms[0]
+	if room.ID == booked.ID {
+		t.Errorf("expected booked room %s not to be available", booked.ID.Hex())
+	}
+	if room.TotalPrice != room.BasePrice*3 {
+		t.Errorf("expected total price %d, got %d", room.BasePrice*3, room.TotalPrice)
+	}
+
+	_, hotels = search(dates + "&guests=4")
+	for _, hotel := range hotels {
+		for _, room := range hotel.Rooms {
+			if room.Type != types.Deluxe {
+				t.Errorf("expected only deluxe rooms to fit 4 guests, got %s", room.Type)
+			}
+		}
+	}
+	if len(hotels) != 2 {
+		t.Errorf("expected 2 hotels with rooms for 4 guests, got %d", len(hotels))
+	}
+
+	today := time.Now().UTC()
+	res, _ = search(fmt.Sprintf("from=%s&until=%s", today.Format("2006-01-02"), today.AddDate(0, 0, 1).Format("2006-01-02")))
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("expected a search starting today to succeed, got status %d", res.StatusCode)
+	}
+
+	tests := []struct {
+		name  string
+		query string
+	}{
+		{"missing dates", "guests=2"},
+		{"starts yesterday", fmt.Sprintf("from=%s&until=%s", today.AddDate(0, 0, -1).Format("2006-01-02"), today.AddDate(0, 0, 1).Format("2006-01-02"))},
+		{"until before from", fmt.Sprintf("from=%s&until=%s", until.Format("2006-01-02"), from.Format("2006-01-02"))},
+		{"invalid date", "from=tomorrow&until=2030-01-01"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			res, _ := search(tc.query)
+			if res.StatusCode != http.StatusBadRequest {
+				t.Fatalf("expected status %d, got %d", http.StatusBadRequest, res.StatusCode)
+			}
+		})
+	}
+}
@@ -1,22 +1,33 @@
 package api
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/xV0lk/hotel-reservations/db"
+	"github.com/xV0lk/hotel-reservations/invoices"
+	"github.com/xV0lk/hotel-reservations/payments"
+	"github.com/xV0lk/hotel-reservations/pricing"
 	"github.com/xV0lk/hotel-reservations/types"
 	iutils "github.com/xV0lk/hotel-reservations/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 type BookingHandler struct {
 	store *db.Store
+	payer payer
 }
 
-func NewBookingHandler(store *db.Store) *BookingHandler {
+func NewBookingHandler(store *db.Store, gateway payments.Gateway) *BookingHandler {
 	return &BookingHandler{
 		store: store,
+		payer: payer{store: store, gateway: gateway},
 	}
 }
 
@@ -27,7 +38,7 @@ func (h *BookingHandler) HandleMonthBookings(c *fiber.Ctx) error {
 		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": err})
 	}
 	filter := reqBody.CreateMonthFilter()
-	bookings, err := h.store.Booking.FilterBookings(c.Context(), filter)
+	bookings, err := h.store.Booking.FilterBookings(c.UserContext(), filter)
 	if err != nil {
 		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": err})
 	}
@@ -35,16 +46,21 @@ func (h *BookingHandler) HandleMonthBookings(c *fiber.Ctx) error {
 }
 
 func (h *BookingHandler) HandleGetBookings(c *fiber.Ctx) error {
-	bookings, err := h.store.Booking.GetBookings(c.Context())
+	var query types.BookingQuery
+	page, err := parseListQuery(c, &query, types.BookingSortFields)
 	if err != nil {
-		return ErrNotFound()
+		return err
+	}
+	bookings, err := h.store.Booking.ListBookings(c.UserContext(), query.CreateFilter(), page)
+	if err != nil {
+		return listError(err)
 	}
 	return c.JSON(bookings)
 }
 
 func (h *BookingHandler) HandleGetBooking(c *fiber.Ctx) error {
 	bookingId := c.Params("id")
-	booking, err := h.store.Booking.GetBookingById(c.Context(), bookingId)
+	booking, err := h.store.Booking.GetBookingById(c.UserContext(), bookingId)
 	if err != nil {
 		return ErrNotFound()
 	}
@@ -54,22 +70,385 @@ func (h *BookingHandler) HandleGetBooking(c *fiber.Ctx) error {
 	return c.JSON(booking)
 }
 
+// HandleCancelBooking cancels the booking, refunding its price according to
+// the cancellation policy of the hotel. Guests can only cancel bookings whose
+// stay hasn't started yet.
 func (h *BookingHandler) HandleCancelBooking(c *fiber.Ctx) error {
-	bookingId := c.Params("id")
-	booking, err := h.store.Booking.GetBookingById(c.Context(), bookingId)
+	booking, err := h.store.Booking.GetBookingById(c.UserContext(), c.Params("id"))
+	if err != nil {
+		return ErrNotFound()
+	}
+	if err := bookingAuthorization(c, booking); err != nil {
+		return ErrForbidden()
+	}
+	user, err := iutils.GetAuthUser(c)
+	if err != nil {
+		return ErrInternal()
+	}
+	hotel, err := h.bookingHotel(c.UserContext(), booking)
+	if err != nil {
+		return ErrInternal()
+	}
+	updated, err := cancelBooking(c.UserContext(), h.payer, booking, booking.CancellationPolicy(hotel), user, time.Now())
+	if err != nil {
+		return err
+	}
+	return c.JSON(updated)
+}
+
+// cancelBooking cancels the booking on behalf of user. Nothing was paid for
+// holds, so they are just released; other bookings are refunded through the
+// gateway what was paid beyond the penalty of the policy.
+func cancelBooking(ctx context.Context, p payer, booking *types.Booking, policy types.CancellationPolicy, user *types.User, now time.Time) (*types.Booking, error) {
+	if booking.Status == types.BookingHeld {
+		change := types.StatusChange{Status: types.BookingCancelled, At: now}
+		updated, err := p.store.Booking.TransitionBooking(ctx, booking.ID.Hex(), change)
+		if err != nil {
+			return nil, transitionError(booking, change.Status, err)
+		}
+		return updated, nil
+	}
+	if booking.HasStarted(now) && !user.IsAdmin {
+		return nil, NewError(http.StatusBadRequest, "Bookings can't be cancelled once the stay has started")
+	}
+	paid, err := p.store.Payment.GetPayments(ctx, booking.ID)
+	if err != nil {
+		return nil, ErrInternal()
+	}
+	cancellation := policy.Cancel(booking, types.SummarizePayments(paid), now)
+	updated, err := p.store.Booking.CancelBooking(ctx, booking.ID.Hex(), cancellation)
+	if err != nil {
+		return nil, transitionError(booking, cancellation.Status(), err)
+	}
+	refunded, err := p.refundBooking(ctx, updated)
+	if err != nil {
+		// the refund stays pending and is retried by RetryRefunds
+		return updated, nil
+	}
+	return refunded, nil
+}
+
+// HandlePatchBooking changes the dates, guests or room of the booking. The
+// booking is re-validated and re-priced, and the price difference recorded.
+func (h *BookingHandler) HandlePatchBooking(c *fiber.Ctx) error {
+	var (
+		jsonData map[string]any
+		params   types.UpdateBookingParams
+	)
+	booking, err := h.store.Booking.GetBookingById(c.UserContext(), c.Params("id"))
+	if err != nil {
+		return ErrNotFound()
+	}
+	if err := bookingAuthorization(c, booking); err != nil {
+		return ErrForbidden()
+	}
+	if err := c.BodyParser(&params); err != nil {
+		return ErrBadRequest()
+	}
+	if err := c.BodyParser(&jsonData); err != nil {
+		return ErrBadRequest()
+	}
+	if err := params.CheckBody(jsonData); err != nil {
+		return NewError(http.StatusBadRequest, err.Error())
+	}
+	now := time.Now()
+	if !booking.IsModifiable(now) {
+		return NewError(http.StatusConflict, "Only upcoming pending or confirmed bookings can be changed")
+	}
+	if !booking.IsAssigned() {
+		return NewError(http.StatusConflict, "Bookings of a room type can't be changed before they are assigned a room")
+	}
+	room, err := h.bookingRoom(c.UserContext(), booking, params.RoomID)
+	if err != nil {
+		return err
+	}
+	body := params.Apply(booking)
+	if vErrors := body.Validate(room); len(vErrors) != 0 {
+		return NewMapError(http.StatusBadRequest, vErrors)
+	}
+	filter := body.CreateAvailabilityFilter(room.ID, time.Now())
+	filter["_id"] = bson.M{"$ne": booking.ID}
+	conflicts, err := h.store.Booking.FilterBookings(c.UserContext(), filter)
+	if err != nil {
+		return ErrInternal()
+	}
+	if len(conflicts) != 0 {
+		return ErrRoomUnavailable()
+	}
+	hotel, err := h.store.Hotel.GetHotelById(c.UserContext(), room.HotelId.Hex())
+	if err != nil {
+		return ErrInternal()
+	}
+	// bookings made with a promo code keep its discount, as long as the
+	// changed stay still meets the rules of the code
+	var discount *types.Discount
+	if booking.Promo != nil {
+		promo, err := h.store.Promo.GetPromoByCode(c.UserContext(), booking.Promo.Code)
+		if err != nil {
+			return ErrInternal()
+		}
+		if errors := promo.CheckChange(body.PromoUse(booking.UserID, hotel, now)); len(errors) != 0 {
+			return NewMapError(http.StatusBadRequest, errors)
+		}
+		discount = &booking.Promo.Discount
+	}
+	quote := pricing.DiscountedQuote(hotel, room, body.FromDate, body.UntilDate, body.NumPeople, discount)
+	change := types.NewBookingChange(booking, quote.Total, now)
+	modified := *booking
+	modified.RoomID = room.ID
+	modified.FromDate = body.FromDate
+	modified.UntilDate = body.UntilDate
+	modified.NumPeople = body.NumPeople
+	modified.Price = quote.Total
+	modified.Nightly = quote.Nights
+	modified.Taxes = quote.Taxes
+	if booking.Promo != nil {
+		promo := *booking.Promo
+		promo.Amount = quote.Discount
+		modified.Promo = &promo
+	}
+	if err := releaseExpiredHolds(c.UserContext(), h.store); err != nil {
+		return ErrInternal()
+	}
+	updated, err := h.store.Booking.ModifyBooking(c.UserContext(), &modified, change)
+	if err != nil {
+		if errors.Is(err, db.ErrRoomUnavailable) {
+			return ErrRoomUnavailable()
+		}
+		if errors.Is(err, db.ErrInvalidTransition) {
+			return NewError(http.StatusConflict, "The booking can't be changed anymore")
+		}
+		return ErrInternal()
+	}
+	return c.JSON(updated)
+}
+
+// bookingRoom returns the room the booking is moved to, which must belong to
+// the hotel of its current room. Without roomId the booking keeps its room.
+func (h *BookingHandler) bookingRoom(ctx context.Context, booking *types.Booking, roomId string) (*types.Room, error) {
+	current, err := h.store.Room.GetRoomById(ctx, booking.RoomID.Hex())
+	if err != nil {
+		return nil, ErrInternal()
+	}
+	if roomId == "" || roomId == current.ID.Hex() {
+		return current, nil
+	}
+	room, err := h.store.Room.GetRoomById(ctx, roomId)
+	if err != nil || room.HotelId != current.HotelId {
+		return nil, NewMapError(http.StatusBadRequest, map[string]string{"roomId": "the room must belong to the same hotel"})
+	}
+	return room, nil
+}
+
+// releaseExpiredHolds expires the holds that ran out but weren't swept yet,
+// so their nights can be booked right away as availability already shows.
+func releaseExpiredHolds(ctx context.Context, store *db.Store) error {
+	_, err := store.Booking.ExpireHolds(ctx, time.Now())
+	return err
+}
+
+// bookingHotel returns the hotel of the booked room
+func (h *BookingHandler) bookingHotel(ctx context.Context, booking *types.Booking) (*types.Hotel, error) {
+	if !booking.HotelID.IsZero() {
+		return h.store.Hotel.GetHotelById(ctx, booking.HotelID.Hex())
+	}
+	room, err := h.store.Room.GetRoomById(ctx, booking.RoomID.Hex())
+	if err != nil {
+		return nil, err
+	}
+	return h.store.Hotel.GetHotelById(ctx, room.HotelId.Hex())
+}
+
+// HandleBookHold pays for a hold that hasn't expired, turning it into a
+// confirmed booking.
+func (h *BookingHandler) HandleBookHold(c *fiber.Ctx) error {
+	var params types.PaymentParams
+	if len(c.Body()) != 0 {
+		if err := c.BodyParser(&params); err != nil {
+			return ErrBadRequest()
+		}
+	}
+	booking, err := h.store.Booking.GetBookingById(c.UserContext(), c.Params("id"))
 	if err != nil {
 		return ErrNotFound()
 	}
 	if err := bookingAuthorization(c, booking); err != nil {
 		return ErrForbidden()
 	}
-	booking, err = h.store.Booking.CancelBooking(c.Context(), bookingId)
+	if booking.IsExpired(time.Now()) {
+		return NewError(http.StatusConflict, "The hold has expired")
+	}
+	hotel, err := h.bookingHotel(c.UserContext(), booking)
 	if err != nil {
 		return ErrInternal()
 	}
+	// the promo code of the hold is only redeemed now it is paid for
+	reserve := redeemingPromo(c.UserContext(), h.store, booking, func() error {
+		change := types.StatusChange{Status: types.BookingPending, At: time.Now()}
+		if _, err := h.store.Booking.TransitionBooking(c.UserContext(), booking.ID.Hex(), change); err != nil {
+			return transitionError(booking, change.Status, err)
+		}
+		return nil
+	})
+	if err := h.payer.payBookings(c.UserContext(), hotel.RatePlan, params.PaymentToken, []*types.Booking{booking}, reserve); err != nil {
+		return err
+	}
 	return c.JSON(booking)
 }
 
+// HandleGetPayments lists the payment transactions of the booking, oldest
+// first.
+func (h *BookingHandler) HandleGetPayments(c *fiber.Ctx) error {
+	booking, err := h.store.Booking.GetBookingById(c.UserContext(), c.Params("id"))
+	if err != nil {
+		return ErrNotFound()
+	}
+	if err := bookingAuthorization(c, booking); err != nil {
+		return ErrForbidden()
+	}
+	paid, err := h.store.Payment.GetPayments(c.UserContext(), booking.ID)
+	if err != nil {
+		return ErrInternal()
+	}
+	return c.JSON(paid)
+}
+
+// HandleGetInvoice returns the invoice of the booking as JSON, or rendered as
+// an HTML or plain text document with ?format=html or ?format=text. The
+// booking is given the next invoice number of its hotel the first time.
+// Holds, expired and cancelled bookings owe nothing, so they have none.
+func (h *BookingHandler) HandleGetInvoice(c *fiber.Ctx) error {
+	format := c.Query("format", "json")
+	if format != "json" && format != "html" && format != "text" {
+		return NewMapError(http.StatusBadRequest, map[string]string{"format": fmt.Sprintf("unknown invoice format '%s'", format)})
+	}
+	booking, err := h.store.Booking.GetBookingById(c.UserContext(), c.Params("id"))
+	if err != nil {
+		return ErrNotFound()
+	}
+	if err := bookingAuthorization(c, booking); err != nil {
+		return ErrForbidden()
+	}
+	if !booking.Status.IsBillable() {
+		return NewError(http.StatusConflict, fmt.Sprintf("A %s booking has no invoice", booking.Status))
+	}
+	hotel, err := h.bookingHotel(c.UserContext(), booking)
+	if err != nil {
+		return ErrInternal()
+	}
+	var room *types.Room
+	if booking.IsAssigned() {
+		if room, err = h.store.Room.GetRoomById(c.UserContext(), booking.RoomID.Hex()); err != nil {
+			return ErrInternal()
+		}
+	}
+	guest, err := h.store.User.GetUserById(c.UserContext(), booking.UserID.Hex())
+	if err != nil {
+		return ErrInternal()
+	}
+	paid, err := h.store.Payment.GetPayments(c.UserContext(), booking.ID)
+	if err != nil {
+		return ErrInternal()
+	}
+	if booking.InvoiceNumber == 0 {
+		if booking, err = h.store.Booking.IssueInvoiceNumber(c.UserContext(), booking.ID.Hex(), hotel.ID); err != nil {
+			return ErrInternal()
+		}
+	}
+	invoice := types.NewInvoice(booking, room, hotel, guest, paid, time.Now())
+
+	var doc bytes.Buffer
+	switch format {
+	case "html":
+		err = invoices.RenderHTML(&doc, invoice)
+		c.Type("html", "utf-8")
+	case "text":
+		err = invoices.RenderText(&doc, invoice)
+		c.Type("txt", "utf-8")
+	default:
+		return c.JSON(invoice)
+	}
+	if err != nil {
+		return ErrInternal()
+	}
+	return c.Send(doc.Bytes())
+}
+
+// HandleTransition returns a handler for the staff action that moves a
+// booking to status, e.g. confirming it or checking the guest in.
+func (h *BookingHandler) HandleTransition(status types.BookingStatus) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		booking, err := h.store.Booking.GetBookingById(c.UserContext(), c.Params("id"))
+		if err != nil {
+			return ErrNotFound()
+		}
+		if status == types.BookingCheckedIn && !booking.IsAssigned() {
+			return NewError(http.StatusConflict, "The booking needs a room before the guest checks in")
+		}
+		if err := booking.CheckTransitionDate(status, time.Now()); err != nil {
+			return NewError(http.StatusConflict, err.Error())
+		}
+		return h.transition(c, booking, status)
+	}
+}
+
+// HandleAssignRoom assigns a room to a room type booking, or moves a booking
+// to another room of the same hotel fitting its guests.
+func (h *BookingHandler) HandleAssignRoom(c *fiber.Ctx) error {
+	var params types.AssignRoomParams
+	if err := c.BodyParser(&params); err != nil {
+		return ErrBadRequest()
+	}
+	booking, err := h.store.Booking.GetBookingById(c.UserContext(), c.Params("id"))
+	if err != nil {
+		return ErrNotFound()
+	}
+	hotel, err := h.bookingHotel(c.UserContext(), booking)
+	if err != nil {
+		return ErrInternal()
+	}
+	room, err := h.store.Room.GetRoomById(c.UserContext(), params.RoomID)
+	if err != nil || room.HotelId != hotel.ID {
+		return NewMapError(http.StatusBadRequest, map[string]string{"roomId": "the room must belong to the hotel of the booking"})
+	}
+	if errors := params.Validate(booking, room); len(errors) != 0 {
+		return NewMapError(http.StatusBadRequest, errors)
+	}
+	if err := releaseExpiredHolds(c.UserContext(), h.store); err != nil {
+		return ErrInternal()
+	}
+	updated, err := h.store.Booking.AssignRoom(c.UserContext(), booking.ID.Hex(), room.ID)
+	if err != nil {
+		if errors.Is(err, db.ErrRoomUnavailable) {
+			return ErrRoomUnavailable()
+		}
+		if errors.Is(err, db.ErrInvalidTransition) {
+			return NewError(http.StatusConflict, fmt.Sprintf("A %s booking can't be assigned a room", booking.Status))
+		}
+		return ErrInternal()
+	}
+	return c.JSON(updated)
+}
+
+func (h *BookingHandler) transition(c *fiber.Ctx, booking *types.Booking, status types.BookingStatus) error {
+	change := types.StatusChange{Status: status, At: time.Now()}
+	updated, err := h.store.Booking.TransitionBooking(c.UserContext(), booking.ID.Hex(), change)
+	if err != nil {
+		return transitionError(booking, status, err)
+	}
+	return c.JSON(updated)
+}
+
+func transitionError(booking *types.Booking, status types.BookingStatus, err error) error {
+	if errors.Is(err, db.ErrInvalidTransition) {
+		return NewError(http.StatusConflict, fmt.Sprintf("A %s booking can't be moved to %s", booking.Status, status))
+	}
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return ErrNotFound()
+	}
+	return ErrInternal()
+}
+
 func bookingAuthorization(c *fiber.Ctx, booking *types.Booking) error {
 	user, err := iutils.GetAuthUser(c)
 	if err != nil {
@@ -1,16 +1,23 @@
 package api
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/xV0lk/hotel-reservations/db"
 	"github.com/xV0lk/hotel-reservations/db/fixtures"
+	"github.com/xV0lk/hotel-reservations/payments"
 	"github.com/xV0lk/hotel-reservations/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 func TestBookings(t *testing.T) {
@@ -23,8 +30,8 @@ func TestBookings(t *testing.T) {
 	fixtures.AddBooking(db.Store, user.ID, room, time.Now(), time.Now().AddDate(0, 0, 5), 1)
 
 	app := fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
-	bookingHandler := NewBookingHandler(db.Store)
-	api := app.Group("/", JWTAuth(db.Store.User))
+	bookingHandler := NewBookingHandler(db.Store, payments.NewFakeGateway())
+	api := app.Group("/", JWTAuth(db.Store.User, db.Store.Session))
 	admin := api.Group("/bookings", AdminAuth)
 	api.Get("/booking", bookingHandler.HandleGetBooking)
 	admin.Get("/", bookingHandler.HandleGetBookings)
@@ -41,17 +48,17 @@ func (tc bookingCase) getBookings(t *testing.T, app *fiber.App, store *db.Store)
 	userToken, _ := CreateUserToken(user)
 	req := httptest.NewRequest(http.MethodGet, "/bookings", nil)
 	req.Header.Add("Authorization", userToken)
-	res, _ := app.Test(req)
+	res, _ := app.Test(req, testTimeout)
 	if res.StatusCode != tc.status {
 		t.Fatalf("expected status %d, got %d", tc.status, res.StatusCode)
 	}
 	if tc.ttype == TESTPASS {
-		var resBody []types.Booking
+		var resBody types.Page[types.Booking]
 		if err := json.NewDecoder(res.Body).Decode(&resBody); err != nil {
 			t.Fatal(err)
 		}
-		if len(resBody) < 1 {
-			t.Fatalf("expected at least 1 booking, got %d", len(resBody))
+		if len(resBody.Data) < 1 {
+			t.Fatalf("expected at least 1 booking, got %d", len(resBody.Data))
 		}
 	}
 	if tc.ttype == TESTFAIL {
@@ -106,3 +113,336 @@ var getBookingTests = []bookingCase{
 		},
 	},
 }
+
+func TestBookingLifecycle(t *testing.T) {
+	db := setup(t)
+	defer db.Drop(t)
+
+	admin := fixtures.AddUser(db.Store, "test", "admin", true)
+	adminToken, _ := CreateUserToken(admin)
+	hotel := fixtures.AddHotel(db.Store, "test hotel", "test address", 4, 2)
+	room := fixtures.AddRoom(db.Store, types.Double, 100, hotel.ID)
+	now := time.Now()
+	booking := fixtures.AddBooking(db.Store, admin.ID, room, now, now.AddDate(0, 0, 2), 2)
+	future := fixtures.AddBooking(db.Store, admin.ID, room, now.AddDate(0, 0, 3), now.AddDate(0, 0, 5), 2)
+	cancelled := fixtures.AddBooking(db.Store, admin.ID, room, now.AddDate(0, 0, 6), now.AddDate(0, 0, 8), 2)
+	other := fixtures.AddRoom(db.Store, types.Double, 100, hotel.ID)
+	missed := fixtures.AddBooking(db.Store, admin.ID, other, now.AddDate(0, 0, -1), now.AddDate(0, 0, 1), 2)
+
+	app := fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+	bookingHandler := NewBookingHandler(db.Store, payments.NewFakeGateway())
+	api := app.Group("/", JWTAuth(db.Store.User, db.Store.Session))
+	adminApi := api.Group("/admin", AdminAuth)
+	api.Delete("/booking/:id", bookingHandler.HandleCancelBooking)
+	adminApi.Post("/booking/:id/check-in", bookingHandler.HandleTransition(types.BookingCheckedIn))
+	adminApi.Post("/booking/:id/check-out", bookingHandler.HandleTransition(types.BookingCheckedOut))
+	adminApi.Post("/booking/:id/no-show", bookingHandler.HandleTransition(types.BookingNoShow))
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		status int
+	}{
+		{"check out before check in", http.MethodPost, "/admin/booking/" + booking.ID.Hex() + "/check-out", http.StatusConflict},
+		{"no show on the arrival day", http.MethodPost, "/admin/booking/" + booking.ID.Hex() + "/no-show", http.StatusConflict},
+		{"check in before the arrival day", http.MethodPost, "/admin/booking/" + future.ID.Hex() + "/check-in", http.StatusConflict},
+		{"no show before the arrival day", http.MethodPost, "/admin/booking/" + future.ID.Hex() + "/no-show", http.StatusConflict},
+		{"no show after the arrival day", http.MethodPost, "/admin/booking/" + missed.ID.Hex() + "/no-show", http.StatusOK},
+		{"check in", http.MethodPost, "/admin/booking/" + booking.ID.Hex() + "/check-in", http.StatusOK},
+		{"no show after check in", http.MethodPost, "/admin/booking/" + booking.ID.Hex() + "/no-show", http.StatusConflict},
+		{"cancel after check in", http.MethodDelete, "/booking/" + booking.ID.Hex(), http.StatusConflict},
+		{"check out", http.MethodPost, "/admin/booking/" + booking.ID.Hex() + "/check-out", http.StatusOK},
+		{"cancel", http.MethodDelete, "/booking/" + cancelled.ID.Hex(), http.StatusOK},
+		{"cancel twice", http.MethodDelete, "/booking/" + cancelled.ID.Hex(), http.StatusConflict},
+		{"unknown booking", http.MethodPost, "/admin/booking/" + primitive.NewObjectID().Hex() + "/check-in", http.StatusNotFound},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, tc.path, nil)
+			req.Header.Add("Authorization", adminToken)
+			res, _ := app.Test(req, testTimeout)
+			if res.StatusCode != tc.status {
+				t.Fatalf("expected status %d, got %d", tc.status, res.StatusCode)
+			}
+		})
+	}
+
+	updated, err := db.Store.Booking.GetBookingById(context.TODO(), booking.ID.Hex())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []types.BookingStatus{types.BookingConfirmed, types.BookingCheckedIn, types.BookingCheckedOut}
+	if len(updated.History) != len(expected) {
+		t.Fatalf("expected %d status changes, got %d", len(expected), len(updated.History))
+	}
+	for i, change := range updated.History {
+		if change.Status != expected[i] {
+			t.Errorf("expected change %d to be %s, got %s", i, expected[i], change.Status)
+		}
+	}
+	// The nights of the cancelled booking can be booked again
+	rebook := &types.Booking{RoomID: room.ID, FromDate: cancelled.FromDate, UntilDate: cancelled.UntilDate, Status: types.BookingPending}
+	if err := db.Store.Booking.ReserveBooking(context.TODO(), rebook); err != nil {
+		t.Fatalf("expected cancelled nights to be released, got %v", err)
+	}
+}
+
+func TestCancelBooking(t *testing.T) {
+	db := setup(t)
+	defer db.Drop(t)
+
+	guest := fixtures.AddUser(db.Store, "test", "guest", false)
+	guestToken, _ := CreateUserToken(guest)
+	admin := fixtures.AddUser(db.Store, "test", "admin", true)
+	adminToken, _ := CreateUserToken(admin)
+	hotel := fixtures.AddHotel(db.Store, "test hotel", "test address", 4, 2)
+	policy := types.CancellationPolicy{Tiers: []types.CancellationTier{
+		{HoursBefore: 48, Percent: 100},
+		{HoursBefore: 0, Percent: 50},
+	}}
+	update := bson.M{"$set": bson.M{"cancellation": policy}}
+	if _, err := db.Store.Hotel.Update(context.TODO(), bson.M{"_id": hotel.ID}, update); err != nil {
+		t.Fatal(err)
+	}
+	room := fixtures.AddRoom(db.Store, types.Double, 100, hotel.ID)
+	now := time.Now()
+	early := fixtures.AddBooking(db.Store, guest.ID, room, now.AddDate(0, 0, 5), now.AddDate(0, 0, 7), 2)
+	late := fixtures.AddBooking(db.Store, guest.ID, room, now.Add(24*time.Hour), now.AddDate(0, 0, 3), 2)
+	started := fixtures.AddBooking(db.Store, guest.ID, room, now.AddDate(0, 0, -1), now.Add(12*time.Hour), 2)
+	// Bookings made before policies were recorded follow the hotel's current one
+	legacy := &types.Booking{UserID: guest.ID, RoomID: room.ID, FromDate: now.AddDate(0, 0, 10), UntilDate: now.AddDate(0, 0, 12), NumPeople: 2, Price: 200}
+	legacy.SetStatus(types.BookingConfirmed, now)
+	if err := db.Store.Booking.ReserveBooking(context.TODO(), legacy); err != nil {
+		t.Fatal(err)
+	}
+	// Changing the policy doesn't affect bookings made under the previous one
+	update = bson.M{"$set": bson.M{"cancellation": types.CancellationPolicy{NonRefundable: true}}}
+	if _, err := db.Store.Hotel.Update(context.TODO(), bson.M{"_id": hotel.ID}, update); err != nil {
+		t.Fatal(err)
+	}
+
+	app := fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+	bookingHandler := NewBookingHandler(db.Store, payments.NewFakeGateway())
+	api := app.Group("/", JWTAuth(db.Store.User, db.Store.Session))
+	api.Delete("/booking/:id", bookingHandler.HandleCancelBooking)
+
+	tests := []struct {
+		name    string
+		booking *types.Booking
+		token   string
+		status  int
+		result  types.BookingStatus
+		penalty int
+	}{
+		{"free cancellation", early, guestToken, http.StatusOK, types.BookingCancelled, 0},
+		{"late cancellation", late, guestToken, http.StatusOK, types.BookingCancelledWithFee, late.Price - late.Price/2},
+		{"guest cancels started stay", started, guestToken, http.StatusBadRequest, "", 0},
+		{"admin cancels started stay", started, adminToken, http.StatusOK, types.BookingCancelledWithFee, started.Price},
+		{"booking without policy", legacy, guestToken, http.StatusOK, types.BookingCancelledWithFee, legacy.Price},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodDelete, "/booking/"+tc.booking.ID.Hex(), nil)
+			req.Header.Add("Authorization", tc.token)
+			res, _ := app.Test(req, testTimeout)
+			if res.StatusCode != tc.status {
+				t.Fatalf("expected status %d, got %d", tc.status, res.StatusCode)
+			}
+			if tc.status != http.StatusOK {
+				return
+			}
+			var booking types.Booking
+			if err := json.NewDecoder(res.Body).Decode(&booking); err != nil {
+				t.Fatal(err)
+			}
+			if booking.Status != tc.result {
+				t.Errorf("expected status %s, got %s", tc.result, booking.Status)
+			}
+			if booking.Cancellation == nil {
+				t.Fatal("expected the cancellation to be stored")
+			}
+			if booking.Cancellation.Penalty != tc.penalty {
+				t.Errorf("expected penalty %d, got %d", tc.penalty, booking.Cancellation.Penalty)
+			}
+			// Nothing was paid for the fixtures, so nothing is refunded
+			if booking.Cancellation.Refund != 0 {
+				t.Errorf("expected no refund, got %d", booking.Cancellation.Refund)
+			}
+		})
+	}
+}
+
+func TestPatchBooking(t *testing.T) {
+	db := setup(t)
+	defer db.Drop(t)
+
+	guest := fixtures.AddUser(db.Store, "test", "guest", false)
+	guestToken, _ := CreateUserToken(guest)
+	hotel := fixtures.AddHotel(db.Store, "test hotel", "test address", 4, 2)
+	other := fixtures.AddHotel(db.Store, "other hotel", "other address", 4, 2)
+	room := fixtures.AddRoom(db.Store, types.Double, 100, hotel.ID)
+	free := fixtures.AddRoom(db.Store, types.Deluxe, 200, hotel.ID)
+	foreign := fixtures.AddRoom(db.Store, types.Double, 100, other.ID)
+	from := time.Now().AddDate(0, 0, 1)
+	booking := fixtures.AddBooking(db.Store, guest.ID, room, from, from.AddDate(0, 0, 2), 2)
+	fixtures.AddBooking(db.Store, guest.ID, room, from.AddDate(0, 0, 4), from.AddDate(0, 0, 6), 2)
+
+	app := fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+	bookingHandler := NewBookingHandler(db.Store, payments.NewFakeGateway())
+	api := app.Group("/", JWTAuth(db.Store.User, db.Store.Session))
+	api.Patch("/booking/:id", bookingHandler.HandlePatchBooking)
+
+	tests := []struct {
+		name   string
+		body   map[string]any
+		status int
+		price  int
+	}{
+		{"unknown key", map[string]any{"price": 1}, http.StatusBadRequest, 0},
+		{"over capacity", map[string]any{"numPeople": 5}, http.StatusBadRequest, 0},
+		{"room of another hotel", map[string]any{"roomId": foreign.ID.Hex()}, http.StatusBadRequest, 0},
+		{"overlap other booking", map[string]any{"untilDate": from.AddDate(0, 0, 5)}, http.StatusConflict, 0},
+		{"extend over own nights", map[string]any{"untilDate": from.AddDate(0, 0, 3)}, http.StatusOK, 300},
+		{"move room", map[string]any{"roomId": free.ID.Hex()}, http.StatusOK, 600},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			b, _ := json.Marshal(tc.body)
+			req := httptest.NewRequest(http.MethodPatch, "/booking/"+booking.ID.Hex(), bytes.NewReader(b))
+			req.Header.Add("Content-Type", "application/json")
+			req.Header.Add("Authorization", guestToken)
+			res, _ := app.Test(req, testTimeout)
+			if res.StatusCode != tc.status {
+				t.Fatalf("expected status %d, got %d", tc.status, res.StatusCode)
+			}
+			if tc.status != http.StatusOK {
+				return
+			}
+			var updated types.Booking
+			if err := json.NewDecoder(res.Body).Decode(&updated); err != nil {
+				t.Fatal(err)
+			}
+			if updated.Price != tc.price {
+				t.Errorf("expected price %d, got %d", tc.price, updated.Price)
+			}
+			change := updated.Changes[len(updated.Changes)-1]
+			if change.PriceDifference != updated.Price-change.Price {
+				t.Errorf("expected price difference %d, got %d", updated.Price-change.Price, change.PriceDifference)
+			}
+		})
+	}
+
+	// The nights of the old room are free again and the new ones taken
+	released := &types.Booking{RoomID: room.ID, FromDate: from, UntilDate: from.AddDate(0, 0, 3)}
+	if err := db.Store.Booking.ReserveBooking(context.TODO(), released); err != nil {
+		t.Fatalf("expected the old room nights to be released, got %v", err)
+	}
+	taken := &types.Booking{RoomID: free.ID, FromDate: from, UntilDate: from.AddDate(0, 0, 1)}
+	if err := db.Store.Booking.ReserveBooking(context.TODO(), taken); err == nil {
+		t.Fatal("expected the new room nights to be taken")
+	}
+}
+
+func TestBookingInvoice(t *testing.T) {
+	db := setup(t)
+	defer db.Drop(t)
+
+	guest := fixtures.AddUser(db.Store, "test", "guest", false)
+	guestToken, _ := CreateUserToken(guest)
+	other := fixtures.AddUser(db.Store, "test", "other", false)
+	otherToken, _ := CreateUserToken(other)
+	hotel := fixtures.AddHotel(db.Store, "test hotel", "test address", 4, 2)
+	room := fixtures.AddRoom(db.Store, types.Double, 100, hotel.ID)
+	otherHotel := fixtures.AddHotel(db.Store, "other hotel", "other address", 4, 2)
+	otherRoom := fixtures.AddRoom(db.Store, types.Double, 100, otherHotel.ID)
+	from := time.Now().AddDate(0, 0, 1)
+	first := fixtures.AddBooking(db.Store, guest.ID, room, from, from.AddDate(0, 0, 2), 2)
+	second := fixtures.AddBooking(db.Store, guest.ID, room, from.AddDate(0, 0, 5), from.AddDate(0, 0, 7), 2)
+	elsewhere := fixtures.AddBooking(db.Store, guest.ID, otherRoom, from, from.AddDate(0, 0, 2), 2)
+	cancelled := fixtures.AddBooking(db.Store, guest.ID, room, from.AddDate(0, 0, 10), from.AddDate(0, 0, 12), 2)
+	change := types.StatusChange{Status: types.BookingCancelled, At: time.Now()}
+	if _, err := db.Store.Booking.TransitionBooking(context.TODO(), cancelled.ID.Hex(), change); err != nil {
+		t.Fatal(err)
+	}
+
+	app := fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+	bookingHandler := NewBookingHandler(db.Store, payments.NewFakeGateway())
+	api := app.Group("/", JWTAuth(db.Store.User, db.Store.Session))
+	api.Get("/booking/:id/invoice", bookingHandler.HandleGetInvoice)
+
+	get := func(booking *types.Booking, format, token string) *http.Response {
+		req := httptest.NewRequest(http.MethodGet, "/booking/"+booking.ID.Hex()+"/invoice?format="+format, nil)
+		req.Header.Add("Authorization", token)
+		res, _ := app.Test(req, testTimeout)
+		return res
+	}
+	invoice := func(booking *types.Booking) *types.Invoice {
+		res := get(booking, "json", guestToken)
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("expected status %d, got %d", http.StatusOK, res.StatusCode)
+		}
+		var invoice types.Invoice
+		if err := json.NewDecoder(res.Body).Decode(&invoice); err != nil {
+			t.Fatal(err)
+		}
+		return &invoice
+	}
+
+	if res := get(cancelled, "json", guestToken); res.StatusCode != http.StatusConflict {
+		t.Fatalf("expected status %d for a cancelled booking, got %d", http.StatusConflict, res.StatusCode)
+	}
+	numbers := []struct {
+		name    string
+		booking *types.Booking
+		number  int
+	}{
+		{"first invoice of the hotel", first, 1},
+		{"second invoice of the hotel", second, 2},
+		{"invoice issued again", first, 1},
+		{"first invoice of another hotel", elsewhere, 1},
+	}
+	for _, tc := range numbers {
+		t.Run(tc.name, func(t *testing.T) {
+			inv := invoice(tc.booking)
+			if inv.Number != tc.number {
+				t.Fatalf("expected invoice number %d, got %d", tc.number, inv.Number)
+			}
+			if inv.Total != tc.booking.Price || len(inv.Nights) != 2 {
+				t.Fatalf("expected 2 nights totalling %d, got %d nights totalling %d", tc.booking.Price, len(inv.Nights), inv.Total)
+			}
+		})
+	}
+
+	documents := []struct {
+		name        string
+		format      string
+		token       string
+		status      int
+		contentType string
+		contains    string
+	}{
+		{"text", "text", guestToken, http.StatusOK, "text/plain", "INVOICE #000001"},
+		{"html", "html", guestToken, http.StatusOK, "text/html", "<h1>Invoice #000001</h1>"},
+		{"unknown format", "pdf", guestToken, http.StatusBadRequest, "", ""},
+		{"not the owner", "json", otherToken, http.StatusForbidden, "", ""},
+	}
+	for _, tc := range documents {
+		t.Run(tc.name, func(t *testing.T) {
+			res := get(first, tc.format, tc.token)
+			if res.StatusCode != tc.status {
+				t.Fatalf("expected status %d, got %d", tc.status, res.StatusCode)
+			}
+			if tc.contains == "" {
+				return
+			}
+			if ct := res.Header.Get("Content-Type"); !strings.HasPrefix(ct, tc.contentType) {
+				t.Fatalf("expected content type %s, got %s", tc.contentType, ct)
+			}
+			b, _ := io.ReadAll(res.Body)
+			if !strings.Contains(string(b), tc.contains) {
+				t.Fatalf("expected the document to contain %q, got:\n%s", tc.contains, b)
+			}
+		})
+	}
+}
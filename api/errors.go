@@ -68,3 +68,7 @@ func ErrUnauthorized() Error {
 func ErrForbidden() Error {
 	return NewError(http.StatusForbidden, "You don't have permission to access this resource")
 }
+
+func ErrRoomUnavailable() Error {
+	return NewError(http.StatusConflict, "This room is not available for the time selected")
+}
@@ -0,0 +1,73 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/xV0lk/hotel-reservations/db"
+	"github.com/xV0lk/hotel-reservations/types"
+)
+
+type ExchangeRateHandler struct {
+	store *db.Store
+}
+
+func NewExchangeRateHandler(store *db.Store) *ExchangeRateHandler {
+	return &ExchangeRateHandler{
+		store: store,
+	}
+}
+
+func (h *ExchangeRateHandler) HandleGetExchangeRates(c *fiber.Ctx) error {
+	rates, err := h.store.ExchangeRate.GetExchangeRates(c.UserContext())
+	if err != nil {
+		return ErrInternal()
+	}
+	return c.JSON(rates)
+}
+
+// HandlePutExchangeRates replaces the exchange rate table used to show
+// prices in the currency guests ask for
+func (h *ExchangeRateHandler) HandlePutExchangeRates(c *fiber.Ctx) error {
+	var rates types.ExchangeRates
+	if err := c.BodyParser(&rates); err != nil {
+		return ErrBadRequest()
+	}
+	if errors := rates.Validate(); len(errors) != 0 {
+		return NewMapError(http.StatusBadRequest, errors)
+	}
+	if rates.Rates == nil {
+		rates.Rates = map[string]float64{}
+	}
+	rates.UpdatedAt = time.Now()
+	if err := h.store.ExchangeRate.PutExchangeRates(c.UserContext(), &rates); err != nil {
+		return ErrInternal()
+	}
+	return c.JSON(rates)
+}
+
+// currencyConverter returns a function converting prices to currency, to
+// show them next to the price charged. It returns nil when the guest didn't
+// ask for a currency.
+func currencyConverter(ctx context.Context, store *db.Store, currency string) (func(types.Money) (*types.Money, error), error) {
+	if currency == "" {
+		return nil, nil
+	}
+	if !types.IsCurrency(currency) {
+		return nil, NewMapError(http.StatusBadRequest, map[string]string{"currency": fmt.Sprintf("unsupported currency '%s'", currency)})
+	}
+	rates, err := store.ExchangeRate.GetExchangeRates(ctx)
+	if err != nil {
+		return nil, ErrInternal()
+	}
+	return func(price types.Money) (*types.Money, error) {
+		converted, err := rates.Convert(price, currency)
+		if err != nil {
+			return nil, NewError(http.StatusBadRequest, fmt.Sprintf("There is no exchange rate from %s to %s", price.Currency, currency))
+		}
+		return &converted, nil
+	}, nil
+}
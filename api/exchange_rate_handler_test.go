@@ -0,0 +1,135 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/xV0lk/hotel-reservations/db/fixtures"
+	"github.com/xV0lk/hotel-reservations/payments"
+	"github.com/xV0lk/hotel-reservations/types"
+)
+
+func TestExchangeRates(t *testing.T) {
+	db := setup(t)
+	defer db.Drop(t)
+
+	admin := fixtures.AddUser(db.Store, "test", "admin", true)
+	adminToken, _ := CreateUserToken(admin)
+	user := fixtures.AddUser(db.Store, "test", "user", false)
+	userToken, _ := CreateUserToken(user)
+	hotel := fixtures.AddHotelWithCurrency(db.Store, "Yokai Inn", "Japan", "JPY", 4.2, 2)
+	room := fixtures.AddRoom(db.Store, types.Double, 15000, hotel.ID)
+
+	app := fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+	rateHandler := NewExchangeRateHandler(db.Store)
+	roomHandler := NewRoomHandler(db.Store, payments.NewFakeGateway())
+	availHandler := NewAvailabilityHandler(db.Store)
+	api := app.Group("/", JWTAuth(db.Store.User, db.Store.Session))
+	adminApi := app.Group("/admin", JWTAuth(db.Store.User, db.Store.Session), AdminAuth)
+	api.Get("/exchange-rates", rateHandler.HandleGetExchangeRates)
+	adminApi.Put("/exchange-rates", rateHandler.HandlePutExchangeRates)
+	api.Post("/room/:id/quote", roomHandler.HandleQuoteRoom)
+	api.Post("/room/:id/book", roomHandler.HandleBookRoom)
+	api.Get("/availability", availHandler.HandleSearch)
+
+	do := func(method, path, token string, body any) *http.Response {
+		b, _ := json.Marshal(body)
+		req := httptest.NewRequest(method, path, bytes.NewReader(b))
+		req.Header.Add("Content-Type", "application/json")
+		req.Header.Add("Authorization", token)
+		res, _ := app.Test(req, testTimeout)
+		return res
+	}
+
+	rates := types.ExchangeRates{Base: "USD", Rates: map[string]float64{"EUR": 0.9, "JPY": 150}}
+	tests := []struct {
+		name   string
+		token  string
+		body   any
+		status int
+	}{
+		{"put rates as user", userToken, rates, http.StatusForbidden},
+		{"put unsupported currency", adminToken, types.ExchangeRates{Base: "USD", Rates: map[string]float64{"XYZ": 2}}, http.StatusBadRequest},
+		{"put negative rate", adminToken, types.ExchangeRates{Base: "USD", Rates: map[string]float64{"EUR": -1}}, http.StatusBadRequest},
+		{"put rates", adminToken, rates, http.StatusOK},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			res := do(http.MethodPut, "/admin/exchange-rates", tc.token, tc.body)
+			if res.StatusCode != tc.status {
+				t.Fatalf("expected status %d, got %d", tc.status, res.StatusCode)
+			}
+		})
+	}
+
+	var stored types.ExchangeRates
+	if err := json.NewDecoder(do(http.MethodGet, "/exchange-rates", userToken, nil).Body).Decode(&stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored.Rates["JPY"] != 150 || stored.UpdatedAt.IsZero() {
+		t.Fatalf("expected the stored rates, got %+v", stored)
+	}
+
+	from := time.Now().AddDate(0, 0, 1)
+	body := types.BookingBody{FromDate: from, UntilDate: from.AddDate(0, 0, 2), NumPeople: 2}
+	quote := func(currency string) (*http.Response, types.QuoteResponse) {
+		var resp types.QuoteResponse
+		res := do(http.MethodPost, fmt.Sprintf("/room/%s/quote?currency=%s", room.ID.Hex(), currency), userToken, body)
+		if res.StatusCode == http.StatusOK {
+			if err := json.NewDecoder(res.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return res, resp
+	}
+
+	// 30000 JPY are 200 USD, which are 180 EUR
+	res, resp := quote("EUR")
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, res.StatusCode)
+	}
+	if resp.Total != 30000 || resp.Currency != "JPY" {
+		t.Errorf("expected a total of 30000 JPY, got %d %s", resp.Total, resp.Currency)
+	}
+	if resp.Display == nil || *resp.Display != (types.Money{Amount: 18000, Currency: "EUR"}) {
+		t.Errorf("expected 180.00 EUR to be displayed, got %v", resp.Display)
+	}
+	if res, _ := quote("XYZ"); res.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected status %d for an unsupported currency, got %d", http.StatusBadRequest, res.StatusCode)
+	}
+	if res, _ := quote("SEK"); res.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected status %d for a currency without rate, got %d", http.StatusBadRequest, res.StatusCode)
+	}
+
+	var hotels []types.HotelAvailability
+	query := fmt.Sprintf("/availability?from=%s&until=%s&location=japan&currency=EUR", body.FromDate.Format("2006-01-02"), body.UntilDate.Format("2006-01-02"))
+	if err := json.NewDecoder(do(http.MethodGet, query, userToken, nil).Body).Decode(&hotels); err != nil {
+		t.Fatal(err)
+	}
+	if len(hotels) != 1 || len(hotels[0].Rooms) == 0 {
+		t.Fatalf("expected rooms of hotel %s, got %v", hotel.ID.Hex(), hotels)
+	}
+	for _, r := range hotels[0].Rooms {
+		if r.Currency != "JPY" || r.DisplayPrice == nil || r.DisplayPrice.Currency != "EUR" {
+			t.Errorf("expected room %s priced in JPY and shown in EUR, got %s and %v", r.ID.Hex(), r.Currency, r.DisplayPrice)
+		}
+	}
+
+	res = do(http.MethodPost, fmt.Sprintf("/room/%s/book", room.ID.Hex()), userToken, body)
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("expected status %d, got %d", http.StatusCreated, res.StatusCode)
+	}
+	var booking types.Booking
+	if err := json.NewDecoder(res.Body).Decode(&booking); err != nil {
+		t.Fatal(err)
+	}
+	if booking.Charge() != (types.Money{Amount: 30000, Currency: "JPY"}) {
+		t.Errorf("expected the booking to be charged 30000 JPY, got %v", booking.Charge())
+	}
+}
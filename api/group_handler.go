@@ -0,0 +1,191 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/xV0lk/hotel-reservations/db"
+	"github.com/xV0lk/hotel-reservations/payments"
+	"github.com/xV0lk/hotel-reservations/pricing"
+	"github.com/xV0lk/hotel-reservations/types"
+	iutils "github.com/xV0lk/hotel-reservations/utils"
+)
+
+type GroupHandler struct {
+	store *db.Store
+	payer payer
+}
+
+func NewGroupHandler(store *db.Store, gateway payments.Gateway) *GroupHandler {
+	return &GroupHandler{
+		store: store,
+		payer: payer{store: store, gateway: gateway},
+	}
+}
+
+// HandlePostGroup books several rooms of a hotel for the same dates. Either
+// every room is booked or none of them is.
+func (h *GroupHandler) HandlePostGroup(c *fiber.Ctx) error {
+	var reqBody types.GroupBookingBody
+	if err := c.BodyParser(&reqBody); err != nil {
+		return ErrBadRequest()
+	}
+	if errors := reqBody.Validate(); len(errors) != 0 {
+		return NewMapError(http.StatusBadRequest, errors)
+	}
+	rooms := []*types.Room{}
+	for i, r := range reqBody.Rooms {
+		room, err := h.store.Room.GetRoomById(c.UserContext(), r.RoomID)
+		if err != nil {
+			return NewMapError(http.StatusBadRequest, map[string]string{fmt.Sprintf("rooms.%d", i): "room not found"})
+		}
+		if len(rooms) != 0 && room.HotelId != rooms[0].HotelId {
+			return NewMapError(http.StatusBadRequest, map[string]string{"rooms": "all the rooms must belong to the same hotel"})
+		}
+		rooms = append(rooms, room)
+	}
+	if errors := reqBody.ValidateRooms(rooms); len(errors) != 0 {
+		return NewMapError(http.StatusBadRequest, errors)
+	}
+	for i, room := range rooms {
+		conflicts, err := h.store.Booking.FilterBookings(c.UserContext(), reqBody.Booking(i).CreateAvailabilityFilter(room.ID, time.Now()))
+		if err != nil {
+			return ErrInternal()
+		}
+		if len(conflicts) != 0 {
+			return ErrRoomUnavailable()
+		}
+	}
+	user, err := iutils.GetAuthUser(c)
+	if err != nil {
+		return ErrInternal()
+	}
+	hotel, err := h.store.Hotel.GetHotelById(c.UserContext(), rooms[0].HotelId.Hex())
+	if err != nil {
+		return ErrInternal()
+	}
+
+	now := time.Now()
+	group := &types.GroupBooking{
+		UserID:    user.ID,
+		HotelID:   hotel.ID,
+		FromDate:  reqBody.FromDate,
+		UntilDate: reqBody.UntilDate,
+		Currency:  hotel.CurrencyCode(),
+		CreatedAt: now,
+	}
+	bookings := []*types.Booking{}
+	for i, room := range rooms {
+		body := reqBody.Booking(i)
+		quote := pricing.HotelQuote(hotel, room, body.FromDate, body.UntilDate, body.NumPeople)
+		booking := &types.Booking{
+			UserID:    user.ID,
+			RoomID:    room.ID,
+			FromDate:  body.FromDate,
+			UntilDate: body.UntilDate,
+			NumPeople: body.NumPeople,
+			Price:     quote.Total,
+			Currency:  hotel.CurrencyCode(),
+			Nightly:   quote.Nights,
+			Taxes:     quote.Taxes,
+			Policy:    &hotel.Cancellation,
+		}
+		booking.SetStatus(types.BookingPending, now)
+		group.Price += booking.Price
+		bookings = append(bookings, booking)
+	}
+	reserve := func() error {
+		if err := releaseExpiredHolds(c.UserContext(), h.store); err != nil {
+			return ErrInternal()
+		}
+		if err := h.store.Booking.ReserveGroup(c.UserContext(), group, bookings); err != nil {
+			if errors.Is(err, db.ErrRoomUnavailable) {
+				return ErrRoomUnavailable()
+			}
+			return ErrInternal()
+		}
+		return nil
+	}
+	if err := h.payer.payBookings(c.UserContext(), hotel.RatePlan, reqBody.PaymentToken, bookings, reserve); err != nil {
+		return err
+	}
+	return c.Status(http.StatusCreated).JSON(group)
+}
+
+func (h *GroupHandler) HandleGetGroup(c *fiber.Ctx) error {
+	group, err := h.store.Booking.GetGroupById(c.UserContext(), c.Params("id"))
+	if err != nil {
+		return ErrNotFound()
+	}
+	if err := groupAuthorization(c, group); err != nil {
+		return ErrForbidden()
+	}
+	return c.JSON(group)
+}
+
+// HandleCancelGroup cancels every booking of the group that still holds its
+// room. Single bookings of the group are cancelled like any other booking.
+func (h *GroupHandler) HandleCancelGroup(c *fiber.Ctx) error {
+	group, err := h.store.Booking.GetGroupById(c.UserContext(), c.Params("id"))
+	if err != nil {
+		return ErrNotFound()
+	}
+	if err := groupAuthorization(c, group); err != nil {
+		return ErrForbidden()
+	}
+	user, err := iutils.GetAuthUser(c)
+	if err != nil {
+		return ErrInternal()
+	}
+	hotel, err := h.store.Hotel.GetHotelById(c.UserContext(), group.HotelID.Hex())
+	if err != nil {
+		return ErrInternal()
+	}
+	now := time.Now()
+	if !user.IsAdmin && group.FromDate.Before(now) {
+		return NewError(http.StatusBadRequest, "Bookings can't be cancelled once the stay has started")
+	}
+	// every booking is cancelled even when some can't be. The ones that
+	// failed are reported by id along the current state of the group, with
+	// the status of the first failure.
+	failures := map[string]string{}
+	status := http.StatusOK
+	for i, booking := range group.Bookings {
+		if !booking.Status.IsActive() {
+			continue
+		}
+		updated, err := cancelBooking(c.UserContext(), h.payer, booking, booking.CancellationPolicy(hotel), user, now)
+		if err != nil {
+			if len(failures) == 0 {
+				status = http.StatusInternalServerError
+				if apiError, ok := err.(Error); ok {
+					status = apiError.Code
+				}
+			}
+			failures[booking.ID.Hex()] = err.Error()
+			continue
+		}
+		group.Bookings[i] = updated
+	}
+	if len(failures) == 0 {
+		return c.JSON(group)
+	}
+	if current, err := h.store.Booking.GetGroupById(c.UserContext(), group.ID.Hex()); err == nil {
+		group = current
+	}
+	return c.Status(status).JSON(fiber.Map{"error": failures, "group": group})
+}
+
+func groupAuthorization(c *fiber.Ctx, group *types.GroupBooking) error {
+	user, err := iutils.GetAuthUser(c)
+	if err != nil {
+		return err
+	}
+	if group.UserID != user.ID && !user.IsAdmin {
+		return fmt.Errorf("Unauthorized")
+	}
+	return nil
+}
@@ -0,0 +1,198 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/xV0lk/hotel-reservations/db"
+	"github.com/xV0lk/hotel-reservations/db/fixtures"
+	"github.com/xV0lk/hotel-reservations/payments"
+	"github.com/xV0lk/hotel-reservations/types"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestGroupBooking(t *testing.T) {
+	db := setup(t)
+	defer db.Drop(t)
+
+	user := fixtures.AddUser(db.Store, "test", "user", false)
+	userToken, _ := CreateUserToken(user)
+	hotel := fixtures.AddHotel(db.Store, "test hotel", "test address", 4, 2)
+	other := fixtures.AddHotel(db.Store, "other hotel", "other address", 4, 2)
+	single := fixtures.AddRoom(db.Store, types.Single, 100, hotel.ID)
+	double := fixtures.AddRoom(db.Store, types.Double, 150, hotel.ID)
+	booked := fixtures.AddRoom(db.Store, types.Double, 150, hotel.ID)
+	foreign := fixtures.AddRoom(db.Store, types.Double, 150, other.ID)
+	from := time.Now().AddDate(0, 0, 3)
+	until := from.AddDate(0, 0, 2)
+	fixtures.AddBooking(db.Store, user.ID, booked, from, until, 1)
+
+	app := fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+	gateway := payments.NewFakeGateway()
+	groupHandler := NewGroupHandler(db.Store, gateway)
+	bookingHandler := NewBookingHandler(db.Store, gateway)
+	api := app.Group("/", JWTAuth(db.Store.User, db.Store.Session))
+	api.Post("/group", groupHandler.HandlePostGroup)
+	api.Get("/group/:id", groupHandler.HandleGetGroup)
+	api.Delete("/group/:id", groupHandler.HandleCancelGroup)
+	api.Delete("/booking/:id", bookingHandler.HandleCancelBooking)
+
+	do := func(method, path string, body any) *http.Response {
+		b, _ := json.Marshal(body)
+		req := httptest.NewRequest(method, path, bytes.NewReader(b))
+		req.Header.Add("Content-Type", "application/json")
+		req.Header.Add("Authorization", userToken)
+		res, _ := app.Test(req, testTimeout)
+		return res
+	}
+	group := func(rooms ...types.GroupRoom) types.GroupBookingBody {
+		return types.GroupBookingBody{FromDate: from, UntilDate: until, Rooms: rooms}
+	}
+
+	tests := []struct {
+		name   string
+		body   types.GroupBookingBody
+		status int
+	}{
+		{"no rooms", group(), http.StatusBadRequest},
+		{"repeated room", group(types.GroupRoom{RoomID: single.ID.Hex(), NumPeople: 1}, types.GroupRoom{RoomID: single.ID.Hex(), NumPeople: 1}), http.StatusBadRequest},
+		{"rooms of two hotels", group(types.GroupRoom{RoomID: single.ID.Hex(), NumPeople: 1}, types.GroupRoom{RoomID: foreign.ID.Hex(), NumPeople: 1}), http.StatusBadRequest},
+		{"over capacity", group(types.GroupRoom{RoomID: single.ID.Hex(), NumPeople: 2}, types.GroupRoom{RoomID: double.ID.Hex(), NumPeople: 2}), http.StatusBadRequest},
+		{"one room taken", group(types.GroupRoom{RoomID: single.ID.Hex(), NumPeople: 1}, types.GroupRoom{RoomID: booked.ID.Hex(), NumPeople: 2}), http.StatusConflict},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			res := do(http.MethodPost, "/group", tc.body)
+			if res.StatusCode != tc.status {
+				t.Fatalf("expected status %d, got %d", tc.status, res.StatusCode)
+			}
+		})
+	}
+
+	// A group losing one of its rooms in the store doesn't keep the others
+	bookings := []*types.Booking{
+		{RoomID: single.ID, FromDate: from, UntilDate: until, Status: types.BookingPending},
+		{RoomID: booked.ID, FromDate: from, UntilDate: until, Status: types.BookingPending},
+	}
+	if err := db.Store.Booking.ReserveGroup(context.TODO(), &types.GroupBooking{}, bookings); err == nil {
+		t.Fatal("expected the group to be rejected")
+	}
+
+	res := do(http.MethodPost, "/group", group(types.GroupRoom{RoomID: single.ID.Hex(), NumPeople: 1}, types.GroupRoom{RoomID: double.ID.Hex(), NumPeople: 2}))
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("expected status %d, got %d", http.StatusCreated, res.StatusCode)
+	}
+	var created types.GroupBooking
+	if err := json.NewDecoder(res.Body).Decode(&created); err != nil {
+		t.Fatal(err)
+	}
+	if len(created.Bookings) != 2 || created.Price != 500 {
+		t.Fatalf("expected 2 bookings costing 500, got %d costing %d", len(created.Bookings), created.Price)
+	}
+
+	if res := do(http.MethodDelete, "/booking/"+created.Bookings[0].ID.Hex(), nil); res.StatusCode != http.StatusOK {
+		t.Fatalf("expected a single booking of the group to be cancelled, got status %d", res.StatusCode)
+	}
+	if res := do(http.MethodDelete, "/group/"+created.ID.Hex(), nil); res.StatusCode != http.StatusOK {
+		t.Fatalf("expected the group to be cancelled, got status %d", res.StatusCode)
+	}
+	res = do(http.MethodGet, "/group/"+created.ID.Hex(), nil)
+	var cancelled types.GroupBooking
+	if err := json.NewDecoder(res.Body).Decode(&cancelled); err != nil {
+		t.Fatal(err)
+	}
+	for _, booking := range cancelled.Bookings {
+		if booking.Status != types.BookingCancelled {
+			t.Errorf("expected booking %s to be cancelled, got %s", booking.ID.Hex(), booking.Status)
+		}
+	}
+}
+
+// failingCancellations is a booking store that can't cancel the booking id
+type failingCancellations struct {
+	db.BookingStore
+	id primitive.ObjectID
+}
+
+func (s *failingCancellations) CancelBooking(ctx context.Context, id string, cancellation *types.Cancellation) (*types.Booking, error) {
+	if id == s.id.Hex() {
+		return nil, errors.New("store unavailable")
+	}
+	return s.BookingStore.CancelBooking(ctx, id, cancellation)
+}
+
+func TestCancelGroupFailures(t *testing.T) {
+	testdb := setup(t)
+	defer testdb.Drop(t)
+
+	user := fixtures.AddUser(testdb.Store, "test", "user", false)
+	userToken, _ := CreateUserToken(user)
+	hotel := fixtures.AddHotel(testdb.Store, "test hotel", "test address", 4, 2)
+	rooms := []*types.Room{
+		fixtures.AddRoom(testdb.Store, types.Single, 100, hotel.ID),
+		fixtures.AddRoom(testdb.Store, types.Single, 100, hotel.ID),
+		fixtures.AddRoom(testdb.Store, types.Single, 100, hotel.ID),
+	}
+	from := time.Now().AddDate(0, 0, 3)
+	body := types.GroupBookingBody{FromDate: from, UntilDate: from.AddDate(0, 0, 2)}
+	for _, room := range rooms {
+		body.Rooms = append(body.Rooms, types.GroupRoom{RoomID: room.ID.Hex(), NumPeople: 1})
+	}
+
+	store := *testdb.Store
+	bookings := &failingCancellations{BookingStore: testdb.Store.Booking}
+	store.Booking = bookings
+	app := fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+	groupHandler := NewGroupHandler(&store, payments.NewFakeGateway())
+	api := app.Group("/", JWTAuth(store.User, store.Session))
+	api.Post("/group", groupHandler.HandlePostGroup)
+	api.Delete("/group/:id", groupHandler.HandleCancelGroup)
+
+	do := func(method, path string, body any) *http.Response {
+		b, _ := json.Marshal(body)
+		req := httptest.NewRequest(method, path, bytes.NewReader(b))
+		req.Header.Add("Content-Type", "application/json")
+		req.Header.Add("Authorization", userToken)
+		res, _ := app.Test(req, testTimeout)
+		return res
+	}
+	res := do(http.MethodPost, "/group", body)
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("expected status %d, got %d", http.StatusCreated, res.StatusCode)
+	}
+	var created types.GroupBooking
+	if err := json.NewDecoder(res.Body).Decode(&created); err != nil {
+		t.Fatal(err)
+	}
+
+	// the booking that can't be cancelled doesn't keep the others booked
+	failed := created.Bookings[0].ID
+	bookings.id = failed
+	res = do(http.MethodDelete, "/group/"+created.ID.Hex(), nil)
+	if res.StatusCode != http.StatusInternalServerError {
+		t.Fatalf("expected status %d, got %d", http.StatusInternalServerError, res.StatusCode)
+	}
+	var resp struct {
+		Error map[string]string  `json:"error"`
+		Group types.GroupBooking `json:"group"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Error) != 1 || resp.Error[failed.Hex()] == "" {
+		t.Errorf("expected the failure of booking %s, got %v", failed.Hex(), resp.Error)
+	}
+	for _, booking := range resp.Group.Bookings {
+		cancelled := booking.Status == types.BookingCancelled
+		if cancelled == (booking.ID == failed) {
+			t.Errorf("expected only booking %s to be left, got %s %s", failed.Hex(), booking.ID.Hex(), booking.Status)
+		}
+	}
+}
@@ -2,9 +2,12 @@ package api
 
 import (
 	"net/http"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/xV0lk/hotel-reservations/db"
+	"github.com/xV0lk/hotel-reservations/pricing"
+	"github.com/xV0lk/hotel-reservations/types"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -22,7 +25,7 @@ func NewHotelHandler(store *db.Store) *HotelHandler {
 
 func (h *HotelHandler) HandleGetHotel(c *fiber.Ctx) error {
 	var id = c.Params("id")
-	hotel, err := h.store.Hotel.GetHotelById(c.Context(), id)
+	hotel, err := h.store.Hotel.GetHotelById(c.UserContext(), id)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
 			return ErrNotFound()
@@ -33,9 +36,14 @@ func (h *HotelHandler) HandleGetHotel(c *fiber.Ctx) error {
 }
 
 func (h *HotelHandler) HandleGetHotels(c *fiber.Ctx) error {
-	hotels, err := h.store.Hotel.GetHotels(c.Context())
+	var query types.HotelQuery
+	page, err := parseListQuery(c, &query, types.HotelSortFields)
 	if err != nil {
-		return ErrInternal()
+		return err
+	}
+	hotels, err := h.store.Hotel.ListHotels(c.UserContext(), query.CreateFilter(), page)
+	if err != nil {
+		return listError(err)
 	}
 	return c.JSON(hotels)
 }
@@ -43,16 +51,55 @@ func (h *HotelHandler) HandleGetHotels(c *fiber.Ctx) error {
 func (h *HotelHandler) HandleGetRooms(c *fiber.Ctx) error {
 	var id = c.Params("id")
 	objectId, _ := primitive.ObjectIDFromHex(id)
-	rooms, err := h.store.Room.GetRooms(c.Context(), bson.M{"hotelId": objectId})
+	rooms, err := h.store.Room.GetRooms(c.UserContext(), bson.M{"hotelId": objectId})
 	if err != nil {
 		return ErrInternal()
 	}
 	return c.JSON(rooms)
 }
 
+// HandleGetCalendar returns the night by night calendar of every room of the
+// hotel in the requested range.
+func (h *HotelHandler) HandleGetCalendar(c *fiber.Ctx) error {
+	var query types.CalendarQuery
+	if err := c.QueryParser(&query); err != nil {
+		return ErrBadRequest()
+	}
+	if errors := query.Validate(); len(errors) != 0 {
+		return NewMapError(http.StatusBadRequest, errors)
+	}
+	hotel, err := h.store.Hotel.GetHotelById(c.UserContext(), c.Params("id"))
+	if err != nil {
+		return ErrNotFound()
+	}
+	rooms, err := h.store.Room.GetRooms(c.UserContext(), bson.M{"hotelId": hotel.ID})
+	if err != nil {
+		return ErrInternal()
+	}
+	filter := query.BookingBody().CreateRoomsAvailabilityFilter(types.RoomIDs(rooms), time.Now())
+	bookings, err := h.store.Booking.FilterBookings(c.UserContext(), filter)
+	if err != nil {
+		return ErrInternal()
+	}
+	now := time.Now()
+	calendar := types.HotelCalendar{
+		HotelID: hotel.ID,
+		From:    query.From,
+		Until:   query.Until,
+		Rooms:   []types.RoomCalendar{},
+	}
+	for _, room := range rooms {
+		price := func(night time.Time) int {
+			return pricing.NightlyRate(hotel.RatePlan, room, night)
+		}
+		calendar.Rooms = append(calendar.Rooms, types.NewRoomCalendar(room, bookings, query, now, price))
+	}
+	return c.JSON(calendar)
+}
+
 func (h *HotelHandler) HandleGetBookingsById(c *fiber.Ctx) error {
 	var id = c.Params("id")
-	bookings, err := h.store.Hotel.GetHotelBookings(c.Context(), id)
+	bookings, err := h.store.Hotel.GetHotelBookings(c.UserContext(), id)
 	if err != nil {
 		return ErrNotFound()
 	}
@@ -60,9 +107,140 @@ func (h *HotelHandler) HandleGetBookingsById(c *fiber.Ctx) error {
 }
 
 func (h *HotelHandler) HandleGetBookings(c *fiber.Ctx) error {
-	bookings, err := h.store.Hotel.GetHotelBookings(c.Context(), "")
+	bookings, err := h.store.Hotel.GetHotelBookings(c.UserContext(), "")
 	if err != nil {
 		return ErrInternal()
 	}
 	return c.Status(http.StatusOK).JSON(bookings)
 }
+
+func (h *HotelHandler) HandlePostHotel(c *fiber.Ctx) error {
+	var params types.NewHotelParams
+	if err := c.BodyParser(&params); err != nil {
+		return ErrBadRequest()
+	}
+	if errors := params.Validate(); len(errors) != 0 {
+		return NewMapError(http.StatusBadRequest, errors)
+	}
+	hotel := types.NewHotelFromParams(&params)
+	if err := h.store.Hotel.InsertHotel(c.UserContext(), hotel); err != nil {
+		return ErrInternal()
+	}
+	return c.Status(http.StatusCreated).JSON(hotel)
+}
+
+// HandlePutHotel replaces every editable property of the hotel
+func (h *HotelHandler) HandlePutHotel(c *fiber.Ctx) error {
+	var params types.NewHotelParams
+	if err := c.BodyParser(&params); err != nil {
+		return ErrBadRequest()
+	}
+	if errors := params.Validate(); len(errors) != 0 {
+		return NewMapError(http.StatusBadRequest, errors)
+	}
+	return h.updateHotel(c, params.ToBson())
+}
+
+// HandlePatchHotel updates only the properties present in the body
+func (h *HotelHandler) HandlePatchHotel(c *fiber.Ctx) error {
+	var (
+		jsonData map[string]any
+		params   types.UpdateHotelParams
+	)
+	if err := c.BodyParser(&params); err != nil {
+		return ErrBadRequest()
+	}
+	if err := c.BodyParser(&jsonData); err != nil {
+		return ErrBadRequest()
+	}
+	if err := params.CheckBody(jsonData); err != nil {
+		return NewError(http.StatusBadRequest, err.Error())
+	}
+	if errors := params.Validate(); len(errors) != 0 {
+		return NewMapError(http.StatusBadRequest, errors)
+	}
+	return h.updateHotel(c, params.ToBson())
+}
+
+// HandlePutRates replaces the rate plan used to price the hotel's rooms
+func (h *HotelHandler) HandlePutRates(c *fiber.Ctx) error {
+	var plan types.RatePlan
+	if err := c.BodyParser(&plan); err != nil {
+		return ErrBadRequest()
+	}
+	if errors := plan.Validate(); len(errors) != 0 {
+		return NewMapError(http.StatusBadRequest, errors)
+	}
+	return h.updateHotel(c, bson.M{"ratePlan": plan})
+}
+
+// HandlePutCancellation replaces the cancellation policy of the hotel. It
+// applies to bookings made from then on; existing bookings keep the policy
+// they were made under.
+func (h *HotelHandler) HandlePutCancellation(c *fiber.Ctx) error {
+	var policy types.CancellationPolicy
+	if err := c.BodyParser(&policy); err != nil {
+		return ErrBadRequest()
+	}
+	if errors := policy.Validate(); len(errors) != 0 {
+		return NewMapError(http.StatusBadRequest, errors)
+	}
+	return h.updateHotel(c, bson.M{"cancellation": policy})
+}
+
+// HandlePutTaxes replaces the taxes and fees the hotel charges. They apply to
+// bookings priced from then on.
+func (h *HotelHandler) HandlePutTaxes(c *fiber.Ctx) error {
+	var rules types.TaxRules
+	if err := c.BodyParser(&rules); err != nil {
+		return ErrBadRequest()
+	}
+	if errors := rules.Validate(); len(errors) != 0 {
+		return NewMapError(http.StatusBadRequest, errors)
+	}
+	return h.updateHotel(c, bson.M{"taxes": rules})
+}
+
+func (h *HotelHandler) updateHotel(c *fiber.Ctx, values bson.M) error {
+	objectId, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return ErrNotFound()
+	}
+	filter := bson.M{"_id": objectId}
+	update := bson.M{"$set": values}
+	hotel, err := h.store.Hotel.Update(c.UserContext(), filter, update)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return ErrNotFound()
+		}
+		return ErrInternal()
+	}
+	return c.JSON(hotel)
+}
+
+// HandleDeleteHotel deletes the hotel together with its rooms. It is refused
+// while any of the rooms still has an upcoming or ongoing booking.
+func (h *HotelHandler) HandleDeleteHotel(c *fiber.Ctx) error {
+	var id = c.Params("id")
+	hotel, err := h.store.Hotel.GetHotelById(c.UserContext(), id)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return ErrNotFound()
+		}
+		return ErrInternal()
+	}
+	bookings, err := h.store.Booking.FilterBookings(c.UserContext(), hotel.CreateActiveBookingsFilter(time.Now()))
+	if err != nil {
+		return ErrInternal()
+	}
+	if len(bookings) != 0 {
+		return NewError(http.StatusConflict, "This hotel has rooms with upcoming bookings")
+	}
+	if err := h.store.Room.DeleteRooms(c.UserContext(), bson.M{"hotelId": hotel.ID}); err != nil {
+		return ErrInternal()
+	}
+	if err := h.store.Hotel.DeleteHotel(c.UserContext(), id); err != nil {
+		return ErrInternal()
+	}
+	return c.JSON(fiber.Map{"message": "Hotel deleted successfully!"})
+}
@@ -0,0 +1,162 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/xV0lk/hotel-reservations/db/fixtures"
+	"github.com/xV0lk/hotel-reservations/types"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestAdminHotels(t *testing.T) {
+	db := setup(t)
+	defer db.Drop(t)
+
+	admin := fixtures.AddUser(db.Store, "test", "admin", true)
+	adminToken, _ := CreateUserToken(admin)
+	user := fixtures.AddUser(db.Store, "test", "user", false)
+	userToken, _ := CreateUserToken(user)
+	hotel := fixtures.AddHotel(db.Store, "test hotel", "test address", 4, 2)
+	booked := fixtures.AddHotel(db.Store, "booked hotel", "test address", 4, 2)
+	room := fixtures.AddRoom(db.Store, types.Single, 100, booked.ID)
+	fixtures.AddBooking(db.Store, user.ID, room, time.Now().AddDate(0, 0, 1), time.Now().AddDate(0, 0, 3), 1)
+
+	app := fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+	hotelHandler := NewHotelHandler(db.Store)
+	adminApi := app.Group("/admin", JWTAuth(db.Store.User, db.Store.Session), AdminAuth)
+	adminApi.Post("/hotel", hotelHandler.HandlePostHotel)
+	adminApi.Put("/hotel/:id", hotelHandler.HandlePutHotel)
+	adminApi.Patch("/hotel/:id", hotelHandler.HandlePatchHotel)
+	adminApi.Delete("/hotel/:id", hotelHandler.HandleDeleteHotel)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		token  string
+		body   any
+		status int
+	}{
+		{"create hotel", http.MethodPost, "/admin/hotel", adminToken, types.NewHotelParams{Name: "new hotel", Location: "Bogota", Rating: 4.5}, http.StatusCreated},
+		{"create invalid hotel", http.MethodPost, "/admin/hotel", adminToken, types.NewHotelParams{Name: "n", Rating: 7}, http.StatusBadRequest},
+		{"create hotel as user", http.MethodPost, "/admin/hotel", userToken, types.NewHotelParams{Name: "new hotel", Location: "Bogota"}, http.StatusForbidden},
+		{"put hotel", http.MethodPut, "/admin/hotel/" + hotel.ID.Hex(), adminToken, types.NewHotelParams{Name: "renamed", Location: "Lima", Rating: 3}, http.StatusOK},
+		{"patch hotel", http.MethodPatch, "/admin/hotel/" + hotel.ID.Hex(), adminToken, map[string]any{"rating": 5}, http.StatusOK},
+		{"patch unknown key", http.MethodPatch, "/admin/hotel/" + hotel.ID.Hex(), adminToken, map[string]any{"rooms": []string{}}, http.StatusBadRequest},
+		{"patch missing hotel", http.MethodPatch, "/admin/hotel/000000000000000000000001", adminToken, map[string]any{"name": "missing"}, http.StatusNotFound},
+		{"delete hotel with bookings", http.MethodDelete, "/admin/hotel/" + booked.ID.Hex(), adminToken, nil, http.StatusConflict},
+		{"delete hotel", http.MethodDelete, "/admin/hotel/" + hotel.ID.Hex(), adminToken, nil, http.StatusOK},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			b, _ := json.Marshal(tc.body)
+			req := httptest.NewRequest(tc.method, tc.path, bytes.NewReader(b))
+			req.Header.Add("Content-Type", "application/json")
+			req.Header.Add("Authorization", tc.token)
+			res, _ := app.Test(req, testTimeout)
+			if res.StatusCode != tc.status {
+				t.Fatalf("expected status %d, got %d", tc.status, res.StatusCode)
+			}
+		})
+	}
+
+	updated, err := db.Store.Hotel.GetHotelById(context.TODO(), booked.ID.Hex())
+	if err != nil {
+		t.Fatalf("expected booked hotel to still exist, got %v", err)
+	}
+	if updated.Name != booked.Name {
+		t.Errorf("expected name %s, got %s", booked.Name, updated.Name)
+	}
+	if _, err := db.Store.Hotel.GetHotelById(context.TODO(), hotel.ID.Hex()); err == nil {
+		t.Errorf("expected hotel %s to be deleted", hotel.ID.Hex())
+	}
+	rooms, _ := db.Store.Room.GetRooms(context.TODO(), bson.M{"hotelId": hotel.ID})
+	if len(rooms) != 0 {
+		t.Errorf("expected rooms of hotel %s to be deleted, got %d", hotel.ID.Hex(), len(rooms))
+	}
+}
+
+func TestGetHotelsPagination(t *testing.T) {
+	db := setup(t)
+	defer db.Drop(t)
+
+	user := fixtures.AddUser(db.Store, "test", "user", false)
+	userToken, _ := CreateUserToken(user)
+	for i, rating := range []float64{3.5, 4.9, 1.2, 4.2, 2.8} {
+		fixtures.AddHotel(db.Store, fmt.Sprintf("hotel %d", i), "test address", rating, 1)
+	}
+
+	app := fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+	hotelHandler := NewHotelHandler(db.Store)
+	app.Get("/hotel", JWTAuth(db.Store.User, db.Store.Session), hotelHandler.HandleGetHotels)
+
+	get := func(query string) (*http.Response, types.Page[types.Hotel]) {
+		req := httptest.NewRequest(http.MethodGet, "/hotel?"+query, nil)
+		req.Header.Add("Authorization", userToken)
+		res, _ := app.Test(req, testTimeout)
+		var page types.Page[types.Hotel]
+		if res.StatusCode == http.StatusOK {
+			if err := json.NewDecoder(res.Body).Decode(&page); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return res, page
+	}
+
+	ratings := []float64{}
+	query := "limit=2&sort=-rating"
+	for pages := 0; ; pages++ {
+		if pages > 3 {
+			t.Fatal("expected pagination to end after 3 pages")
+		}
+		res, page := get(query)
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("expected status %d, got %d", http.StatusOK, res.StatusCode)
+		}
+		for _, hotel := range page.Data {
+			ratings = append(ratings, hotel.Rating)
+		}
+		if page.Page.Next == "" {
+			break
+		}
+		query = "limit=2&sort=-rating&cursor=" + page.Page.Next
+	}
+	expected := []float64{4.9, 4.2, 3.5, 2.8, 1.2}
+	if fmt.Sprint(ratings) != fmt.Sprint(expected) {
+		t.Errorf("expected ratings %v, got %v", expected, ratings)
+	}
+
+	_, page := get("minRating=4")
+	if page.Page.Count != 2 {
+		t.Errorf("expected 2 hotels rated 4 or more, got %d", page.Page.Count)
+	}
+
+	tests := []struct {
+		name  string
+		query string
+	}{
+		{"unknown sort field", "sort=rooms"},
+		{"limit too big", "limit=1000"},
+		{"invalid cursor", "cursor=nope"},
+		{"cursor from another sort", "sort=name&cursor=" + func() string {
+			_, page := get("limit=1&sort=-rating")
+			return page.Page.Next
+		}()},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			res, _ := get(tc.query)
+			if res.StatusCode != http.StatusBadRequest {
+				t.Fatalf("expected status %d, got %d", http.StatusBadRequest, res.StatusCode)
+			}
+		})
+	}
+}
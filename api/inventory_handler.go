@@ -0,0 +1,139 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/xV0lk/hotel-reservations/db"
+	"github.com/xV0lk/hotel-reservations/payments"
+	"github.com/xV0lk/hotel-reservations/pricing"
+	"github.com/xV0lk/hotel-reservations/types"
+	iutils "github.com/xV0lk/hotel-reservations/utils"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+type InventoryHandler struct {
+	store *db.Store
+	payer payer
+}
+
+func NewInventoryHandler(store *db.Store, gateway payments.Gateway) *InventoryHandler {
+	return &InventoryHandler{
+		store: store,
+		payer: payer{store: store, gateway: gateway},
+	}
+}
+
+// HandleGetInventory returns how many rooms of every type of the hotel are
+// free on each night of the requested range.
+func (h *InventoryHandler) HandleGetInventory(c *fiber.Ctx) error {
+	var query types.CalendarQuery
+	if err := c.QueryParser(&query); err != nil {
+		return ErrBadRequest()
+	}
+	if errors := query.Validate(); len(errors) != 0 {
+		return NewMapError(http.StatusBadRequest, errors)
+	}
+	hotel, err := h.store.Hotel.GetHotelById(c.UserContext(), c.Params("id"))
+	if err != nil {
+		return ErrNotFound()
+	}
+	rooms, err := h.store.Room.GetRooms(c.UserContext(), bson.M{"hotelId": hotel.ID})
+	if err != nil {
+		return ErrInternal()
+	}
+	// the nights of holds that ran out are free even before the sweep
+	if err := releaseExpiredHolds(c.UserContext(), h.store); err != nil {
+		return ErrInternal()
+	}
+	body := query.BookingBody()
+	nights := types.Booking{FromDate: body.FromDate, UntilDate: body.UntilDate}.Nights()
+	inventory := types.HotelInventory{
+		HotelID: hotel.ID,
+		From:    query.From,
+		Until:   query.Until,
+		Types:   []types.TypeInventory{},
+	}
+	for roomType, typeRooms := range types.RoomsByType(rooms) {
+		taken, err := h.store.Booking.CountRoomNights(c.UserContext(), types.RoomIDs(typeRooms), nights)
+		if err != nil {
+			return ErrInternal()
+		}
+		inventory.Types = append(inventory.Types, types.NewTypeInventory(roomType, len(typeRooms), nights, taken))
+	}
+	sort.Slice(inventory.Types, func(i, j int) bool { return inventory.Types[i].Type < inventory.Types[j].Type })
+	return c.JSON(inventory)
+}
+
+// HandleBookRoomType books any room of a type of the hotel free for the
+// whole stay. The room is assigned by the staff later on, before the guest
+// checks in.
+func (h *InventoryHandler) HandleBookRoomType(c *fiber.Ctx) error {
+	var reqBody types.TypeBookingBody
+	if err := c.BodyParser(&reqBody); err != nil {
+		return ErrBadRequest()
+	}
+	hotel, err := h.store.Hotel.GetHotelById(c.UserContext(), c.Params("id"))
+	if err != nil {
+		return ErrNotFound()
+	}
+	rooms, err := h.store.Room.GetRooms(c.UserContext(), bson.M{"hotelId": hotel.ID, "type": reqBody.Type})
+	if err != nil {
+		return ErrInternal()
+	}
+	user, err := iutils.GetAuthUser(c)
+	if err != nil {
+		return ErrInternal()
+	}
+	fits, vErrors := reqBody.Validate(types.RoomsByType(rooms)[reqBody.Type])
+	promo, pErrors, err := bookingPromo(c.UserContext(), h.store, reqBody.PromoCode, reqBody.PromoUse(user.ID, hotel, time.Now()))
+	if err != nil {
+		return err
+	}
+	if len(vErrors) != 0 || len(pErrors) != 0 {
+		errors := map[string]string{}
+		for k, v := range vErrors {
+			errors[k] = v
+		}
+		for k, v := range pErrors {
+			errors[k] = v
+		}
+		return NewMapError(http.StatusBadRequest, errors)
+	}
+	// Guests are charged the rate of the cheapest room of the type
+	quote := pricing.DiscountedQuote(hotel, fits[0], reqBody.FromDate, reqBody.UntilDate, reqBody.NumPeople, promo.GetDiscount())
+	booking := &types.Booking{
+		UserID:    user.ID,
+		HotelID:   hotel.ID,
+		RoomType:  reqBody.Type,
+		FromDate:  reqBody.FromDate,
+		UntilDate: reqBody.UntilDate,
+		NumPeople: reqBody.NumPeople,
+		Price:     quote.Total,
+		Currency:  hotel.CurrencyCode(),
+		Nightly:   quote.Nights,
+		Taxes:     quote.Taxes,
+		Promo:     promo.Apply(quote.Discount),
+		Policy:    &hotel.Cancellation,
+	}
+	booking.SetStatus(types.BookingPending, time.Now())
+	reserve := redeemingPromo(c.UserContext(), h.store, booking, func() error {
+		if err := releaseExpiredHolds(c.UserContext(), h.store); err != nil {
+			return ErrInternal()
+		}
+		if err := h.store.Booking.ReserveInventory(c.UserContext(), booking, types.RoomIDs(fits)); err != nil {
+			if errors.Is(err, db.ErrRoomUnavailable) {
+				return ErrRoomUnavailable()
+			}
+			return ErrInternal()
+		}
+		return nil
+	})
+	if err := h.payer.payBookings(c.UserContext(), hotel.RatePlan, reqBody.PaymentToken, []*types.Booking{booking}, reserve); err != nil {
+		return err
+	}
+	return c.Status(http.StatusCreated).JSON(booking)
+}
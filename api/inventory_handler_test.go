@@ -0,0 +1,150 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/xV0lk/hotel-reservations/db/fixtures"
+	"github.com/xV0lk/hotel-reservations/payments"
+	"github.com/xV0lk/hotel-reservations/types"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestRoomTypeBooking(t *testing.T) {
+	db := setup(t)
+	defer db.Drop(t)
+
+	admin := fixtures.AddUser(db.Store, "test", "admin", true)
+	adminToken, _ := CreateUserToken(admin)
+	// A hotel without the fixture rooms, to control its inventory
+	hotel := &types.Hotel{Name: "test hotel", Location: "test address", Rooms: []primitive.ObjectID{}}
+	if err := db.Store.Hotel.InsertHotel(context.TODO(), hotel); err != nil {
+		t.Fatal(err)
+	}
+	first := fixtures.AddRoom(db.Store, types.Double, 100, hotel.ID)
+	second := fixtures.AddRoom(db.Store, types.Double, 120, hotel.ID)
+	single := fixtures.AddRoom(db.Store, types.Single, 80, hotel.ID)
+	from := time.Now().AddDate(0, 0, 1).UTC().Truncate(24 * time.Hour).Add(14 * time.Hour)
+	// The cheaper double is taken on the second night only
+	fixtures.AddBooking(db.Store, admin.ID, first, from.AddDate(0, 0, 1), from.AddDate(0, 0, 2), 1)
+
+	app := fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+	gateway := payments.NewFakeGateway()
+	invHandler := NewInventoryHandler(db.Store, gateway)
+	bookingHandler := NewBookingHandler(db.Store, gateway)
+	roomHandler := NewRoomHandler(db.Store, gateway)
+	api := app.Group("/", JWTAuth(db.Store.User, db.Store.Session))
+	adminApi := api.Group("/admin", AdminAuth)
+	api.Get("/hotel/:id/inventory", invHandler.HandleGetInventory)
+	api.Post("/hotel/:id/book", invHandler.HandleBookRoomType)
+	api.Post("/room/:id/book", roomHandler.HandleBookRoom)
+	adminApi.Post("/booking/:id/assign", bookingHandler.HandleAssignRoom)
+	adminApi.Post("/booking/:id/check-in", bookingHandler.HandleTransition(types.BookingCheckedIn))
+
+	do := func(method, path string, body any) *http.Response {
+		b, _ := json.Marshal(body)
+		req := httptest.NewRequest(method, path, bytes.NewReader(b))
+		req.Header.Add("Content-Type", "application/json")
+		req.Header.Add("Authorization", adminToken)
+		res, _ := app.Test(req, testTimeout)
+		return res
+	}
+	stay := types.BookingBody{FromDate: from, UntilDate: from.AddDate(0, 0, 3), NumPeople: 2}
+	bookType := func(body types.TypeBookingBody, status int) *types.Booking {
+		res := do(http.MethodPost, fmt.Sprintf("/hotel/%s/book", hotel.ID.Hex()), body)
+		if res.StatusCode != status {
+			t.Fatalf("expected status %d, got %d", status, res.StatusCode)
+		}
+		var booking types.Booking
+		json.NewDecoder(res.Body).Decode(&booking)
+		return &booking
+	}
+
+	bookType(types.TypeBookingBody{BookingBody: stay, Type: types.Single}, http.StatusBadRequest)
+	bookType(types.TypeBookingBody{BookingBody: stay, Type: types.Deluxe}, http.StatusBadRequest)
+	// Only the other double is free for the whole stay
+	allocated := bookType(types.TypeBookingBody{BookingBody: stay, Type: types.Double}, http.StatusCreated)
+	if allocated.IsAssigned() || allocated.AllocatedRoom != second.ID {
+		t.Fatalf("expected an unassigned booking allocated to the second double, got %v", allocated.AllocatedRoom)
+	}
+	if allocated.Price != 300 {
+		t.Errorf("expected the cheapest double rate 300, got %d", allocated.Price)
+	}
+	// The cheaper double is free on the first and last nights, but guests
+	// aren't moved between rooms
+	bookType(types.TypeBookingBody{BookingBody: stay, Type: types.Double}, http.StatusConflict)
+	if res := do(http.MethodPost, fmt.Sprintf("/room/%s/book", second.ID.Hex()), stay); res.StatusCode != http.StatusConflict {
+		t.Fatalf("expected allocated room to be unavailable, got status %d", res.StatusCode)
+	}
+
+	// A hold that ran out before being swept doesn't take the single
+	ranOut := time.Now().Add(-time.Minute)
+	unswept := &types.Booking{UserID: admin.ID, RoomID: single.ID, FromDate: stay.FromDate, UntilDate: stay.UntilDate, NumPeople: 1, ExpiresAt: &ranOut}
+	unswept.SetStatus(types.BookingHeld, time.Now().Add(-holdTTL))
+	if err := db.Store.Booking.ReserveBooking(context.TODO(), unswept); err != nil {
+		t.Fatal(err)
+	}
+
+	res := do(http.MethodGet, fmt.Sprintf("/hotel/%s/inventory?from=%s&until=%s", hotel.ID.Hex(), from.Format("2006-01-02"), from.AddDate(0, 0, 3).Format("2006-01-02")), nil)
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, res.StatusCode)
+	}
+	var inventory types.HotelInventory
+	if err := json.NewDecoder(res.Body).Decode(&inventory); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[types.RoomType][]int{types.Single: {1, 1, 1}, types.Double: {1, 0, 1}}
+	for _, inv := range inventory.Types {
+		for i, night := range inv.Nights {
+			if night.Free != expected[inv.Type][i] {
+				t.Errorf("expected %d free %s rooms on %s, got %d", expected[inv.Type][i], inv.Type, night.Date, night.Free)
+			}
+		}
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   any
+		status int
+	}{
+		{"check in unassigned", http.MethodPost, "/admin/booking/" + allocated.ID.Hex() + "/check-in", nil, http.StatusConflict},
+		{"assign other type", http.MethodPost, "/admin/booking/" + allocated.ID.Hex() + "/assign", types.AssignRoomParams{RoomID: single.ID.Hex()}, http.StatusBadRequest},
+		{"assign taken room", http.MethodPost, "/admin/booking/" + allocated.ID.Hex() + "/assign", types.AssignRoomParams{RoomID: first.ID.Hex()}, http.StatusConflict},
+		{"assign free room", http.MethodPost, "/admin/booking/" + allocated.ID.Hex() + "/assign", types.AssignRoomParams{RoomID: second.ID.Hex()}, http.StatusOK},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			res := do(tc.method, tc.path, tc.body)
+			if res.StatusCode != tc.status {
+				t.Fatalf("expected status %d, got %d", tc.status, res.StatusCode)
+			}
+		})
+	}
+
+	// The stay never took the cheaper double, which is still free on the
+	// first night
+	first1 := types.BookingBody{FromDate: from, UntilDate: from.AddDate(0, 0, 1), NumPeople: 1}
+	if res := do(http.MethodPost, fmt.Sprintf("/room/%s/book", first.ID.Hex()), first1); res.StatusCode != http.StatusCreated {
+		t.Fatalf("expected the first double to be free, got status %d", res.StatusCode)
+	}
+
+	// Bookings made before nights were locked count once migrated
+	alone := types.BookingBody{FromDate: from, UntilDate: from.AddDate(0, 0, 2), NumPeople: 1}
+	legacy := &types.Booking{UserID: admin.ID, RoomID: single.ID, FromDate: alone.FromDate, UntilDate: alone.UntilDate, NumPeople: 1, Status: types.BookingConfirmed}
+	if err := db.Store.Booking.InsertBooking(context.TODO(), legacy); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Store.Booking.MigrateBookings(context.TODO()); err != nil {
+		t.Fatal(err)
+	}
+	bookType(types.TypeBookingBody{BookingBody: alone, Type: types.Single}, http.StatusConflict)
+}
@@ -1,6 +1,9 @@
 package api
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
@@ -9,55 +12,121 @@ import (
 	"github.com/gofiber/fiber/v2"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/xV0lk/hotel-reservations/db"
+	"github.com/xV0lk/hotel-reservations/types"
 )
 
-func JWTAuth(userStore db.UserStore) fiber.Handler {
+const (
+	// accessTokenTTL is kept short since access tokens are only revoked
+	// on logout, refresh tokens renew them for the life of the session
+	accessTokenTTL  = 15 * time.Minute
+	refreshTokenTTL = 30 * 24 * time.Hour
+)
+
+var ErrTokenExpired = errors.New("token expired")
+
+// AccessClaims are the claims of access tokens. Subject is the user id, ID
+// the token id checked against the revoked tokens and SessionID the session
+// the token was issued for, if any.
+type AccessClaims struct {
+	Email     string `json:"email"`
+	SessionID string `json:"sid,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// JWTAuth authenticates the request with its access token, rejecting the
+// tokens revoked on their own or through their session.
+func JWTAuth(userStore db.UserStore, sessionStore db.SessionStore) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		token, ok := c.GetReqHeaders()["Authorization"]
 		if !ok {
 			return ErrUnauthorized()
 		}
 		claims, err := ValidateToken(token)
+		if errors.Is(err, ErrTokenExpired) {
+			return NewError(http.StatusUnauthorized, "Token expired")
+		}
 		if err != nil {
 			return ErrUnauthorized()
 		}
-		// check token expiration
-		expiration := claims["expiration"].(float64)
-		if int64(expiration) < time.Now().Unix() {
-			return NewError(http.StatusUnauthorized, "Token expired")
+		if revokedTokens.isRevoked(claims.ID) || checkedSessions.isRevoked(c.UserContext(), sessionStore, claims) {
+			return NewError(http.StatusUnauthorized, "Token revoked")
 		}
-		userID := claims["id"].(string)
-		user, err := userStore.GetUserById(c.Context(), userID)
+		user, err := userStore.GetUserById(c.UserContext(), claims.Subject)
 		if err != nil {
 			return ErrUnauthorized()
 		}
+		if issuedBefore(claims, user.PasswordChangedAt) {
+			return NewError(http.StatusUnauthorized, "Token revoked")
+		}
 		user.Password = ""
 		c.Context().SetUserValue("user", user)
+		c.Context().SetUserValue("claims", claims)
 
 		return c.Next()
 	}
 }
 
-func ValidateToken(tokenStr string) (jwt.MapClaims, error) {
-	token, err := jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
-		// Don't forget to validate the alg is what you expect:
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			fmt.Println("Unexpected signing method:", token.Header["alg"])
-			return nil, fmt.Errorf("Unauthorized")
-		}
+// issuedBefore reports whether the token may have been issued before t. The
+// iat claim is in seconds, so tokens of the same second as t are rejected
+// too: a login right after a password reset needs to be repeated a second
+// later, but no token issued before the reset survives it.
+func issuedBefore(claims *AccessClaims, t *time.Time) bool {
+	return t != nil && (claims.IssuedAt == nil || claims.IssuedAt.Unix() <= t.Unix())
+}
 
-		// hmacSampleSecret is a []byte containing your secret, e.g. []byte("my_secret_key")
-		secret := os.Getenv("JWT_SECRET")
-		return []byte(secret), nil
-	})
-	if err != nil {
+// ValidateToken checks the signature and expiration of an access token.
+// Tokens without the standard exp, jti and sub claims are rejected.
+func ValidateToken(tokenStr string) (*AccessClaims, error) {
+	claims := &AccessClaims{}
+	token, err := jwt.ParseWithClaims(tokenStr, claims, func(token *jwt.Token) (interface{}, error) {
+		return []byte(os.Getenv("JWT_SECRET")), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithIssuedAt())
+	if errors.Is(err, jwt.ErrTokenExpired) {
+		return nil, ErrTokenExpired
+	}
+	if err != nil || !token.Valid {
 		return nil, fmt.Errorf("Unauthorized")
 	}
-
-	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-		return claims, nil
-	} else {
-		fmt.Println(err)
+	if claims.ExpiresAt == nil || claims.ID == "" || claims.Subject == "" {
 		return nil, fmt.Errorf("Unauthorized")
 	}
+	return claims, nil
+}
+
+// CreateUserToken issues an access token that isn't tied to a session, as
+// used by scripts and tests.
+func CreateUserToken(user *types.User) (string, error) {
+	token, _, err := createAccessToken(user, "", time.Now())
+	return token, err
+}
+
+// createAccessToken issues an access token of the session, returning when
+// it expires.
+func createAccessToken(user *types.User, sessionID string, now time.Time) (string, time.Time, error) {
+	id, err := newTokenID()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	expiresAt := now.Add(accessTokenTTL)
+	claims := &AccessClaims{
+		Email:     user.Email,
+		SessionID: sessionID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        id,
+			Subject:   user.ID.Hex(),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		},
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	ts, err := token.SignedString([]byte(os.Getenv("JWT_SECRET")))
+	return ts, expiresAt, err
+}
+
+func newTokenID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
 }
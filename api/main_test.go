@@ -0,0 +1,14 @@
+package api
+
+import (
+	"os"
+	"testing"
+
+	"github.com/xV0lk/hotel-reservations/types"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestMain(m *testing.M) {
+	types.BcryptCost = bcrypt.MinCost
+	os.Exit(m.Run())
+}
@@ -0,0 +1,38 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/xV0lk/hotel-reservations/db"
+	"github.com/xV0lk/hotel-reservations/types"
+)
+
+// parseListQuery reads the pagination params and the typed filters of a list
+// endpoint from the query string, validating both.
+func parseListQuery(c *fiber.Ctx, query types.ListQuery, sortFields []string) (types.PageParams, error) {
+	var page types.PageParams
+	if err := c.QueryParser(&page); err != nil {
+		return page, ErrBadRequest()
+	}
+	if err := c.QueryParser(query); err != nil {
+		return page, ErrBadRequest()
+	}
+	errors := page.Validate(sortFields)
+	for k, v := range query.Validate() {
+		errors[k] = v
+	}
+	if len(errors) != 0 {
+		return page, NewMapError(http.StatusBadRequest, errors)
+	}
+	return page, nil
+}
+
+// listError maps the errors of the paginated store methods
+func listError(err error) error {
+	if errors.Is(err, db.ErrInvalidCursor) {
+		return NewError(http.StatusBadRequest, err.Error())
+	}
+	return ErrInternal()
+}
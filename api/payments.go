@@ -0,0 +1,197 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/xV0lk/hotel-reservations/db"
+	"github.com/xV0lk/hotel-reservations/payments"
+	"github.com/xV0lk/hotel-reservations/types"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// payer charges bookings through the gateway, recording every transaction
+// in the payment store.
+type payer struct {
+	store   *db.Store
+	gateway payments.Gateway
+}
+
+func ErrPaymentDeclined() Error {
+	return NewError(http.StatusPaymentRequired, "The payment was declined")
+}
+
+// payBookings authorizes the deposit of every booking, then runs reserve
+// and captures the deposits once it succeeded. Nothing is reserved when a
+// payment is declined, and the authorizations are voided when reserve fails.
+// Paid bookings are confirmed. When a deposit can't be captured all the
+// bookings are cancelled: the deposits already captured are refunded and
+// the other authorizations voided. What is compensated follows what the
+// gateway did, even when it couldn't be recorded.
+func (p payer) payBookings(ctx context.Context, plan types.RatePlan, token string, bookings []*types.Booking, reserve func() error) error {
+	authorizations := make([]string, len(bookings))
+	voidAll := func() {
+		for i, auth := range authorizations {
+			if auth != "" {
+				p.void(ctx, bookings[i], auth)
+			}
+		}
+	}
+	for i, booking := range bookings {
+		if booking.ID.IsZero() {
+			booking.ID = primitive.NewObjectID()
+		}
+		if plan.Deposit(booking.Price) == 0 {
+			continue
+		}
+		auth, err := p.authorize(ctx, booking, token, plan.Deposit(booking.Price))
+		authorizations[i] = auth
+		if err != nil {
+			voidAll()
+			if errors.Is(err, payments.ErrDeclined) {
+				return ErrPaymentDeclined()
+			}
+			return ErrInternal()
+		}
+	}
+	if err := reserve(); err != nil {
+		voidAll()
+		return err
+	}
+	now := time.Now()
+	charges := make([]string, len(bookings))
+	for i, booking := range bookings {
+		if authorizations[i] != "" {
+			charge, err := p.capture(ctx, booking, authorizations[i], plan.Deposit(booking.Price))
+			charges[i] = charge
+			if err != nil {
+				p.abandon(ctx, plan, bookings, authorizations, charges)
+				return NewError(http.StatusBadGateway, "The payment couldn't be completed")
+			}
+		}
+		change := types.StatusChange{Status: types.BookingConfirmed, At: now}
+		confirmed, err := p.store.Booking.TransitionBooking(ctx, booking.ID.Hex(), change)
+		if err != nil {
+			p.abandon(ctx, plan, bookings, authorizations, charges)
+			return ErrInternal()
+		}
+		*booking = *confirmed
+	}
+	return nil
+}
+
+// abandon cancels the reserved bookings whose payment couldn't be completed,
+// voiding the authorizations that weren't captured and refunding the
+// charges of the ones that were. Refunds that fail are left pending for
+// RetryRefunds.
+func (p payer) abandon(ctx context.Context, plan types.RatePlan, bookings []*types.Booking, authorizations, charges []string) {
+	now := time.Now()
+	for i, booking := range bookings {
+		cancellation := &types.Cancellation{At: now}
+		if charges[i] != "" {
+			cancellation.Refund = plan.Deposit(booking.Price)
+		} else if authorizations[i] != "" {
+			p.void(ctx, booking, authorizations[i])
+		}
+		cancelled, err := p.store.Booking.CancelBooking(ctx, booking.ID.Hex(), cancellation)
+		if err != nil {
+			continue
+		}
+		p.refundCharge(ctx, cancelled, charges[i])
+	}
+}
+
+// refundBooking gives the refund of the cancelled booking back to the guest
+// and records it was made. A refund already made since the cancellation
+// isn't made again, so it can be retried safely.
+func (p payer) refundBooking(ctx context.Context, booking *types.Booking) (*types.Booking, error) {
+	return p.refundCharge(ctx, booking, "")
+}
+
+// refundCharge is refundBooking making the refund against charge, or
+// against the charge of the recorded capture when it is empty.
+func (p payer) refundCharge(ctx context.Context, booking *types.Booking, charge string) (*types.Booking, error) {
+	cancellation := booking.Cancellation
+	if cancellation == nil || cancellation.Refund == 0 || cancellation.RefundedAt != nil {
+		return booking, nil
+	}
+	paid, err := p.store.Payment.GetPayments(ctx, booking.ID)
+	if err != nil {
+		return nil, err
+	}
+	if !types.RefundedSince(paid, cancellation.At) {
+		if charge == "" {
+			charge = types.SummarizePayments(paid).Charge
+		}
+		// once the gateway refunded, the refund is done even if it
+		// couldn't be recorded
+		if ref, err := p.refund(ctx, booking, charge, cancellation.Refund); ref == "" {
+			return nil, err
+		}
+	}
+	return p.store.Booking.SetRefunded(ctx, booking.ID.Hex(), time.Now())
+}
+
+// RetryRefunds makes the refunds of cancelled bookings that failed before.
+// It returns the number of refunds made; the ones failing again stay
+// pending.
+func RetryRefunds(ctx context.Context, store *db.Store, gateway payments.Gateway) (int, error) {
+	bookings, err := store.Booking.FilterBookings(ctx, types.CreatePendingRefundsFilter())
+	if err != nil {
+		return 0, err
+	}
+	p := payer{store: store, gateway: gateway}
+	refunded := 0
+	for _, booking := range bookings {
+		if _, err := p.refundBooking(ctx, booking); err == nil {
+			refunded++
+		}
+	}
+	return refunded, nil
+}
+
+// The gateway operations below return the reference of the transaction
+// whenever the gateway made it, together with the store's error when it
+// couldn't be recorded. On a gateway error the reference is empty.
+
+func (p payer) authorize(ctx context.Context, booking *types.Booking, token string, amount int) (string, error) {
+	ref, err := p.gateway.Authorize(ctx, token, amount, booking.Charge().Currency)
+	return p.record(ctx, booking, types.PaymentAuthorize, amount, ref, err)
+}
+
+func (p payer) capture(ctx context.Context, booking *types.Booking, authorization string, amount int) (string, error) {
+	ref, err := p.gateway.Capture(ctx, authorization, amount)
+	return p.record(ctx, booking, types.PaymentCapture, amount, ref, err)
+}
+
+func (p payer) refund(ctx context.Context, booking *types.Booking, charge string, amount int) (string, error) {
+	ref, err := p.gateway.Refund(ctx, charge, amount)
+	return p.record(ctx, booking, types.PaymentRefund, amount, ref, err)
+}
+
+func (p payer) void(ctx context.Context, booking *types.Booking, authorization string) (string, error) {
+	ref, err := p.gateway.Void(ctx, authorization)
+	return p.record(ctx, booking, types.PaymentVoid, 0, ref, err)
+}
+
+// record stores the result of a gateway transaction. It returns the
+// transaction's reference and error, or the reference and the store's error
+// when a successful transaction couldn't be recorded.
+func (p payer) record(ctx context.Context, booking *types.Booking, kind types.PaymentKind, amount int, ref string, txErr error) (string, error) {
+	payment := &types.Payment{
+		BookingID: booking.ID,
+		Kind:      kind,
+		Amount:    amount,
+		Currency:  booking.Charge().Currency,
+		Reference: ref,
+		CreatedAt: time.Now(),
+	}
+	if txErr != nil {
+		payment.Error = txErr.Error()
+		p.store.Payment.InsertPayment(ctx, payment)
+		return "", txErr
+	}
+	return ref, p.store.Payment.InsertPayment(ctx, payment)
+}
@@ -0,0 +1,381 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/xV0lk/hotel-reservations/db"
+	"github.com/xV0lk/hotel-reservations/db/fixtures"
+	"github.com/xV0lk/hotel-reservations/payments"
+	"github.com/xV0lk/hotel-reservations/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestBookingPayments(t *testing.T) {
+	db := setup(t)
+	defer db.Drop(t)
+
+	user := fixtures.AddUser(db.Store, "test", "user", false)
+	userToken, _ := CreateUserToken(user)
+	hotel := fixtures.AddHotel(db.Store, "test hotel", "test address", 4, 2)
+	update := bson.M{"$set": bson.M{"ratePlan": types.RatePlan{DepositPercent: 25}}}
+	if _, err := db.Store.Hotel.Update(context.TODO(), bson.M{"_id": hotel.ID}, update); err != nil {
+		t.Fatal(err)
+	}
+	room := fixtures.AddRoom(db.Store, types.Double, 100, hotel.ID)
+
+	app := fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+	gateway := payments.NewFakeGateway()
+	roomHandler := NewRoomHandler(db.Store, gateway)
+	bookingHandler := NewBookingHandler(db.Store, gateway)
+	api := app.Group("/", JWTAuth(db.Store.User, db.Store.Session))
+	api.Post("/room/:id/book", roomHandler.HandleBookRoom)
+	api.Delete("/booking/:id", bookingHandler.HandleCancelBooking)
+	api.Get("/booking/:id/payments", bookingHandler.HandleGetPayments)
+
+	do := func(method, path string, body any) *http.Response {
+		b, _ := json.Marshal(body)
+		req := httptest.NewRequest(method, path, bytes.NewReader(b))
+		req.Header.Add("Content-Type", "application/json")
+		req.Header.Add("Authorization", userToken)
+		res, _ := app.Test(req, testTimeout)
+		return res
+	}
+	body := types.BookingBody{
+		FromDate:     time.Now().AddDate(0, 0, 3),
+		UntilDate:    time.Now().AddDate(0, 0, 5),
+		NumPeople:    2,
+		PaymentToken: payments.DeclinedToken,
+	}
+	path := fmt.Sprintf("/room/%s/book", room.ID.Hex())
+	if res := do(http.MethodPost, path, body); res.StatusCode != http.StatusPaymentRequired {
+		t.Fatalf("expected a declined payment, got status %d", res.StatusCode)
+	}
+
+	body.PaymentToken = "tok_visa"
+	res := do(http.MethodPost, path, body)
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("expected the room to be free after the declined payment, got status %d", res.StatusCode)
+	}
+	var booking types.Booking
+	if err := json.NewDecoder(res.Body).Decode(&booking); err != nil {
+		t.Fatal(err)
+	}
+	if booking.Status != types.BookingConfirmed {
+		t.Fatalf("expected the paid booking to be confirmed, got %s", booking.Status)
+	}
+	if res := do(http.MethodDelete, "/booking/"+booking.ID.Hex(), nil); res.StatusCode != http.StatusOK {
+		t.Fatalf("expected the booking to be cancelled, got status %d", res.StatusCode)
+	}
+
+	res = do(http.MethodGet, fmt.Sprintf("/booking/%s/payments", booking.ID.Hex()), nil)
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, res.StatusCode)
+	}
+	var paid []*types.Payment
+	if err := json.NewDecoder(res.Body).Decode(&paid); err != nil {
+		t.Fatal(err)
+	}
+	deposit := booking.Price / 4
+	expected := []types.Payment{
+		{Kind: types.PaymentAuthorize, Amount: deposit},
+		{Kind: types.PaymentCapture, Amount: deposit},
+		{Kind: types.PaymentRefund, Amount: deposit},
+	}
+	if len(paid) != len(expected) {
+		t.Fatalf("expected %d payments, got %d", len(expected), len(paid))
+	}
+	for i, p := range paid {
+		if p.Kind != expected[i].Kind || p.Amount != expected[i].Amount || !p.Succeeded() {
+			t.Errorf("expected payment %d to be a %s of %d, got a %s of %d (%s)", i, expected[i].Kind, expected[i].Amount, p.Kind, p.Amount, p.Error)
+		}
+	}
+}
+
+// failingGateway is a fake gateway whose captures fail once captures of them
+// were made and whose refunds fail while failRefunds is set. It keeps the
+// authorizations it voided and the amount it refunded.
+type failingGateway struct {
+	*payments.FakeGateway
+	captures    int
+	failRefunds bool
+	voided      []string
+	refunded    int
+}
+
+func (g *failingGateway) Capture(ctx context.Context, authorization string, amount int) (string, error) {
+	if g.captures == 0 {
+		return "", errors.New("gateway unavailable")
+	}
+	g.captures--
+	return g.FakeGateway.Capture(ctx, authorization, amount)
+}
+
+func (g *failingGateway) Refund(ctx context.Context, charge string, amount int) (string, error) {
+	if g.failRefunds {
+		return "", errors.New("gateway unavailable")
+	}
+	ref, err := g.FakeGateway.Refund(ctx, charge, amount)
+	if err == nil {
+		g.refunded += amount
+	}
+	return ref, err
+}
+
+func (g *failingGateway) Void(ctx context.Context, authorization string) (string, error) {
+	ref, err := g.FakeGateway.Void(ctx, authorization)
+	if err == nil {
+		g.voided = append(g.voided, authorization)
+	}
+	return ref, err
+}
+
+// failingPayments is a payment store that can't record payments of kind
+type failingPayments struct {
+	db.PaymentStore
+	kind types.PaymentKind
+}
+
+func (s *failingPayments) InsertPayment(ctx context.Context, payment *types.Payment) error {
+	if payment.Kind == s.kind {
+		return errors.New("store unavailable")
+	}
+	return s.PaymentStore.InsertPayment(ctx, payment)
+}
+
+func TestUnrecordedPayments(t *testing.T) {
+	testdb := setup(t)
+	defer testdb.Drop(t)
+
+	user := fixtures.AddUser(testdb.Store, "test", "user", false)
+	userToken, _ := CreateUserToken(user)
+	hotel := fixtures.AddHotel(testdb.Store, "test hotel", "test address", 4, 2)
+	update := bson.M{"$set": bson.M{"ratePlan": types.RatePlan{DepositPercent: 25}}}
+	if _, err := testdb.Store.Hotel.Update(context.TODO(), bson.M{"_id": hotel.ID}, update); err != nil {
+		t.Fatal(err)
+	}
+	from := time.Now().AddDate(0, 0, 3)
+	body := types.BookingBody{FromDate: from, UntilDate: from.AddDate(0, 0, 2), NumPeople: 1, PaymentToken: "tok_visa"}
+
+	tests := []struct {
+		name   string
+		kind   types.PaymentKind
+		status int
+		voids  int
+		refund bool
+	}{
+		// the authorization is voided although it wasn't recorded
+		{"unrecorded authorization", types.PaymentAuthorize, http.StatusInternalServerError, 1, false},
+		// the deposit is refunded rather than its authorization voided
+		{"unrecorded capture", types.PaymentCapture, http.StatusBadGateway, 0, true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			store := *testdb.Store
+			store.Payment = &failingPayments{PaymentStore: testdb.Store.Payment, kind: tc.kind}
+			gateway := &failingGateway{FakeGateway: payments.NewFakeGateway(), captures: 1}
+			room := fixtures.AddRoom(testdb.Store, types.Single, 100, hotel.ID)
+
+			app := fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+			roomHandler := NewRoomHandler(&store, gateway)
+			app.Post("/room/:id/book", JWTAuth(store.User, store.Session), roomHandler.HandleBookRoom)
+			b, _ := json.Marshal(body)
+			req := httptest.NewRequest(http.MethodPost, "/room/"+room.ID.Hex()+"/book", bytes.NewReader(b))
+			req.Header.Add("Content-Type", "application/json")
+			req.Header.Add("Authorization", userToken)
+			res, _ := app.Test(req, testTimeout)
+			if res.StatusCode != tc.status {
+				t.Fatalf("expected status %d, got %d", tc.status, res.StatusCode)
+			}
+			if len(gateway.voided) != tc.voids {
+				t.Errorf("expected %d voided authorizations, got %d", tc.voids, len(gateway.voided))
+			}
+			bookings, err := store.Booking.FilterBookings(context.TODO(), bson.M{"roomID": room.ID})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !tc.refund {
+				if gateway.refunded != 0 || len(bookings) != 0 {
+					t.Errorf("expected nothing refunded nor booked, got %d refunded and %d bookings", gateway.refunded, len(bookings))
+				}
+				return
+			}
+			if len(bookings) != 1 {
+				t.Fatalf("expected the booking, got %d", len(bookings))
+			}
+			deposit := bookings[0].Price / 4
+			if gateway.refunded != deposit {
+				t.Errorf("expected the deposit of %d to be refunded, got %d", deposit, gateway.refunded)
+			}
+			cancellation := bookings[0].Cancellation
+			if bookings[0].Status != types.BookingCancelled || cancellation == nil || cancellation.Refund != deposit || cancellation.RefundedAt == nil {
+				t.Errorf("expected the booking to be cancelled and refunded, got %s %+v", bookings[0].Status, cancellation)
+			}
+		})
+	}
+}
+
+func TestPaymentFailures(t *testing.T) {
+	db := setup(t)
+	defer db.Drop(t)
+
+	user := fixtures.AddUser(db.Store, "test", "user", false)
+	userToken, _ := CreateUserToken(user)
+	hotel := fixtures.AddHotel(db.Store, "test hotel", "test address", 4, 2)
+	update := bson.M{"$set": bson.M{"ratePlan": types.RatePlan{DepositPercent: 25}}}
+	if _, err := db.Store.Hotel.Update(context.TODO(), bson.M{"_id": hotel.ID}, update); err != nil {
+		t.Fatal(err)
+	}
+	single := fixtures.AddRoom(db.Store, types.Single, 100, hotel.ID)
+	double := fixtures.AddRoom(db.Store, types.Double, 150, hotel.ID)
+
+	app := fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+	gateway := &failingGateway{FakeGateway: payments.NewFakeGateway(), captures: 1}
+	groupHandler := NewGroupHandler(db.Store, gateway)
+	bookingHandler := NewBookingHandler(db.Store, gateway)
+	api := app.Group("/", JWTAuth(db.Store.User, db.Store.Session))
+	api.Post("/group", groupHandler.HandlePostGroup)
+	api.Delete("/booking/:id", bookingHandler.HandleCancelBooking)
+
+	do := func(method, path string, body any) *http.Response {
+		b, _ := json.Marshal(body)
+		req := httptest.NewRequest(method, path, bytes.NewReader(b))
+		req.Header.Add("Content-Type", "application/json")
+		req.Header.Add("Authorization", userToken)
+		res, _ := app.Test(req, testTimeout)
+		return res
+	}
+	kinds := func(booking *types.Booking) []types.PaymentKind {
+		paid, err := db.Store.Payment.GetPayments(context.TODO(), booking.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		kinds := []types.PaymentKind{}
+		for _, p := range paid {
+			if p.Succeeded() {
+				kinds = append(kinds, p.Kind)
+			}
+		}
+		return kinds
+	}
+	from := time.Now().AddDate(0, 0, 3)
+	body := types.GroupBookingBody{
+		FromDate:  from,
+		UntilDate: from.AddDate(0, 0, 2),
+		Rooms: []types.GroupRoom{
+			{RoomID: single.ID.Hex(), NumPeople: 1},
+			{RoomID: double.ID.Hex(), NumPeople: 2},
+		},
+		PaymentToken: "tok_visa",
+	}
+
+	// The second capture fails: the first deposit is refunded, the second
+	// authorization voided and both bookings cancelled
+	if res := do(http.MethodPost, "/group", body); res.StatusCode != http.StatusBadGateway {
+		t.Fatalf("expected status %d, got %d", http.StatusBadGateway, res.StatusCode)
+	}
+	bookings, err := db.Store.Booking.FilterBookings(context.TODO(), bson.M{"userID": user.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bookings) != 2 {
+		t.Fatalf("expected 2 bookings, got %d", len(bookings))
+	}
+	expected := map[primitive.ObjectID][]types.PaymentKind{
+		single.ID: {types.PaymentAuthorize, types.PaymentCapture, types.PaymentRefund},
+		double.ID: {types.PaymentAuthorize, types.PaymentVoid},
+	}
+	for _, booking := range bookings {
+		if booking.Status != types.BookingCancelled {
+			t.Errorf("expected the booking of room %s to be cancelled, got %s", booking.RoomID.Hex(), booking.Status)
+		}
+		if got := kinds(booking); !reflect.DeepEqual(got, expected[booking.RoomID]) {
+			t.Errorf("expected the payments of room %s to be %v, got %v", booking.RoomID.Hex(), expected[booking.RoomID], got)
+		}
+	}
+
+	// The rooms were released
+	gateway.captures = 2
+	res := do(http.MethodPost, "/group", body)
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("expected the rooms to be free again, got status %d", res.StatusCode)
+	}
+	var group types.GroupBooking
+	if err := json.NewDecoder(res.Body).Decode(&group); err != nil {
+		t.Fatal(err)
+	}
+
+	// A failed refund leaves the booking cancelled with its refund pending
+	gateway.failRefunds = true
+	res = do(http.MethodDelete, "/booking/"+group.BookingIDs[0].Hex(), nil)
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, res.StatusCode)
+	}
+	var cancelled types.Booking
+	if err := json.NewDecoder(res.Body).Decode(&cancelled); err != nil {
+		t.Fatal(err)
+	}
+	deposit := cancelled.Price / 4
+	if cancelled.Cancellation.Refund != deposit || cancelled.Cancellation.RefundedAt != nil {
+		t.Fatalf("expected a pending refund of %d, got %+v", deposit, cancelled.Cancellation)
+	}
+	if n, err := RetryRefunds(context.TODO(), db.Store, gateway); err != nil || n != 0 {
+		t.Fatalf("expected the refund to fail again, got %d (%v)", n, err)
+	}
+	gateway.failRefunds = false
+	if n, err := RetryRefunds(context.TODO(), db.Store, gateway); err != nil || n != 1 {
+		t.Fatalf("expected the refund to be made, got %d (%v)", n, err)
+	}
+	if n, err := RetryRefunds(context.TODO(), db.Store, gateway); err != nil || n != 0 {
+		t.Fatalf("expected no refund left, got %d (%v)", n, err)
+	}
+	refunded, err := db.Store.Booking.GetBookingById(context.TODO(), cancelled.ID.Hex())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refunded.Cancellation.RefundedAt == nil {
+		t.Error("expected the refund to be recorded")
+	}
+	paid, err := db.Store.Payment.GetPayments(context.TODO(), cancelled.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary := types.SummarizePayments(paid); summary.Refunded != deposit {
+		t.Errorf("expected %d to be refunded once, got %d", deposit, summary.Refunded)
+	}
+
+	// Nothing is refunded of non refundable bookings, whatever was paid
+	update = bson.M{"$set": bson.M{"cancellation": types.CancellationPolicy{NonRefundable: true}}}
+	if _, err := db.Store.Hotel.Update(context.TODO(), bson.M{"_id": hotel.ID}, update); err != nil {
+		t.Fatal(err)
+	}
+	gateway.captures = 1
+	body.Rooms = body.Rooms[:1]
+	if res := do(http.MethodPost, "/group", body); res.StatusCode != http.StatusCreated {
+		t.Fatalf("expected status %d, got %d", http.StatusCreated, res.StatusCode)
+	}
+	bookings, err = db.Store.Booking.FilterBookings(context.TODO(), bson.M{"userID": user.ID, "status": types.BookingConfirmed, "roomID": single.ID})
+	if err != nil || len(bookings) != 1 {
+		t.Fatalf("expected the non refundable booking, got %d (%v)", len(bookings), err)
+	}
+	res = do(http.MethodDelete, "/booking/"+bookings[0].ID.Hex(), nil)
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, res.StatusCode)
+	}
+	if err := json.NewDecoder(res.Body).Decode(&cancelled); err != nil {
+		t.Fatal(err)
+	}
+	if cancelled.Cancellation.Refund != 0 || cancelled.Cancellation.Penalty != cancelled.Price {
+		t.Errorf("expected no refund and a penalty of %d, got %+v", cancelled.Price, cancelled.Cancellation)
+	}
+}
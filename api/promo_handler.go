@@ -0,0 +1,157 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/xV0lk/hotel-reservations/db"
+	"github.com/xV0lk/hotel-reservations/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type PromoHandler struct {
+	store *db.Store
+}
+
+func NewPromoHandler(store *db.Store) *PromoHandler {
+	return &PromoHandler{
+		store: store,
+	}
+}
+
+func (h *PromoHandler) HandleGetPromos(c *fiber.Ctx) error {
+	var query types.PromoQuery
+	page, err := parseListQuery(c, &query, types.PromoSortFields)
+	if err != nil {
+		return err
+	}
+	promos, err := h.store.Promo.ListPromos(c.UserContext(), query.CreateFilter(), page)
+	if err != nil {
+		return listError(err)
+	}
+	return c.JSON(promos)
+}
+
+// HandleGetPromo returns the promo code with the bookings made with it
+func (h *PromoHandler) HandleGetPromo(c *fiber.Ctx) error {
+	promo, err := h.store.Promo.GetPromoByCode(c.UserContext(), types.NormalizePromoCode(c.Params("code")))
+	if err != nil {
+		return ErrNotFound()
+	}
+	bookings, err := h.store.Booking.FilterBookings(c.UserContext(), bson.M{"promo.id": promo.ID})
+	if err != nil {
+		return ErrInternal()
+	}
+	return c.JSON(types.PromoUsage{Promo: promo, Bookings: bookings})
+}
+
+func (h *PromoHandler) HandlePostPromo(c *fiber.Ctx) error {
+	var params types.PromoParams
+	if err := c.BodyParser(&params); err != nil {
+		return ErrBadRequest()
+	}
+	if errors := params.Validate(); len(errors) != 0 {
+		return NewMapError(http.StatusBadRequest, errors)
+	}
+	if err := h.checkHotel(c.UserContext(), params); err != nil {
+		return err
+	}
+	promo := types.NewPromoFromParams(params, time.Now())
+	if err := h.store.Promo.InsertPromo(c.UserContext(), promo); err != nil {
+		return promoInsertError(err)
+	}
+	return c.Status(http.StatusCreated).JSON(promo)
+}
+
+// HandlePostVouchers creates a batch of single use codes sharing the same
+// discount and prefix.
+func (h *PromoHandler) HandlePostVouchers(c *fiber.Ctx) error {
+	var params types.VoucherParams
+	if err := c.BodyParser(&params); err != nil {
+		return ErrBadRequest()
+	}
+	if errors := params.Validate(); len(errors) != 0 {
+		return NewMapError(http.StatusBadRequest, errors)
+	}
+	if err := h.checkHotel(c.UserContext(), params.PromoParams); err != nil {
+		return err
+	}
+	vouchers, err := types.NewVouchers(params, time.Now())
+	if err != nil {
+		return ErrInternal()
+	}
+	if err := h.store.Promo.InsertManyPromos(c.UserContext(), vouchers); err != nil {
+		return promoInsertError(err)
+	}
+	return c.Status(http.StatusCreated).JSON(vouchers)
+}
+
+// checkHotel checks the hotel of the codes of a single hotel exists, and
+// that their fixed discounts are in the currency it charges in.
+func (h *PromoHandler) checkHotel(ctx context.Context, params types.PromoParams) error {
+	if params.HotelID == "" {
+		return nil
+	}
+	hotel, err := h.store.Hotel.GetHotelById(ctx, params.HotelID)
+	if err != nil {
+		return NewMapError(http.StatusBadRequest, map[string]string{"hotelId": "unknown hotel"})
+	}
+	if params.Kind == types.AmountDiscount && params.Currency != hotel.CurrencyCode() {
+		return NewMapError(http.StatusBadRequest, map[string]string{"currency": fmt.Sprintf("the hotel charges in %s", hotel.CurrencyCode())})
+	}
+	return nil
+}
+
+func promoInsertError(err error) error {
+	if mongo.IsDuplicateKeyError(err) {
+		return NewError(http.StatusConflict, "The promo code already exists")
+	}
+	return ErrInternal()
+}
+
+// bookingPromo looks up the promo code entered for a booking and checks it
+// can be used for it. Problems with the code are returned keyed by
+// promoCode, to be reported along the other errors of the booking body.
+// There is no promo without a code.
+func bookingPromo(ctx context.Context, store *db.Store, code string, use types.PromoUse) (*types.PromoCode, map[string]string, error) {
+	if code == "" {
+		return nil, nil, nil
+	}
+	promo, err := store.Promo.GetPromoByCode(ctx, types.NormalizePromoCode(code))
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, map[string]string{"promoCode": fmt.Sprintf("unknown promo code '%s'", code)}, nil
+	}
+	if err != nil {
+		return nil, nil, ErrInternal()
+	}
+	if errors := promo.Check(use); len(errors) != 0 {
+		return nil, errors, nil
+	}
+	return promo, nil, nil
+}
+
+// redeemingPromo wraps reserve to redeem the promo code of the booking
+// first, giving the use back when the booking can't be reserved.
+func redeemingPromo(ctx context.Context, store *db.Store, booking *types.Booking, reserve func() error) func() error {
+	if booking.Promo == nil {
+		return reserve
+	}
+	return func() error {
+		if err := store.Promo.RedeemPromo(ctx, booking.Promo.ID, booking.UserID); err != nil {
+			if errors.Is(err, db.ErrPromoUsedUp) {
+				return NewError(http.StatusConflict, "The promo code has been used up")
+			}
+			return ErrInternal()
+		}
+		if err := reserve(); err != nil {
+			store.Promo.ReleasePromo(ctx, booking.Promo.ID, booking.UserID)
+			return err
+		}
+		return nil
+	}
+}
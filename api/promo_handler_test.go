@@ -0,0 +1,276 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/xV0lk/hotel-reservations/db/fixtures"
+	"github.com/xV0lk/hotel-reservations/payments"
+	"github.com/xV0lk/hotel-reservations/types"
+)
+
+func TestAdminPromos(t *testing.T) {
+	db := setup(t)
+	defer db.Drop(t)
+
+	admin := fixtures.AddUser(db.Store, "test", "admin", true)
+	adminToken, _ := CreateUserToken(admin)
+	user := fixtures.AddUser(db.Store, "test", "user", false)
+	userToken, _ := CreateUserToken(user)
+	hotel := fixtures.AddHotelWithCurrency(db.Store, "Yokai Inn", "Japan", "JPY", 4.2, 2)
+
+	app := fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+	promoHandler := NewPromoHandler(db.Store)
+	adminApi := app.Group("/admin", JWTAuth(db.Store.User, db.Store.Session), AdminAuth)
+	adminApi.Post("/promo", promoHandler.HandlePostPromo)
+	adminApi.Post("/promo/vouchers", promoHandler.HandlePostVouchers)
+	adminApi.Get("/promo", promoHandler.HandleGetPromos)
+
+	percent := types.Discount{Kind: types.PercentDiscount, Value: 10}
+	yen := types.Discount{Kind: types.AmountDiscount, Value: 1000, Currency: "JPY"}
+	tests := []struct {
+		name   string
+		path   string
+		token  string
+		body   any
+		status int
+	}{
+		{"create code", "/admin/promo", adminToken, types.PromoParams{Code: "summer", Discount: percent}, http.StatusCreated},
+		{"create code as user", "/admin/promo", userToken, types.PromoParams{Code: "winter", Discount: percent}, http.StatusForbidden},
+		{"create existing code", "/admin/promo", adminToken, types.PromoParams{Code: "SUMMER", Discount: percent}, http.StatusConflict},
+		{"create invalid code", "/admin/promo", adminToken, types.PromoParams{Code: "a b", Discount: types.Discount{Kind: "free"}, MaxUses: -1}, http.StatusBadRequest},
+		{"create hotel code", "/admin/promo", adminToken, types.PromoParams{Code: "yokai", Discount: yen, HotelID: hotel.ID.Hex()}, http.StatusCreated},
+		{"create hotel code in another currency", "/admin/promo", adminToken, types.PromoParams{Code: "yokai-usd", Discount: types.Discount{Kind: types.AmountDiscount, Value: 10, Currency: "USD"}, HotelID: hotel.ID.Hex()}, http.StatusBadRequest},
+		{"create code of unknown hotel", "/admin/promo", adminToken, types.PromoParams{Code: "ghost", Discount: percent, HotelID: "000000000000000000000001"}, http.StatusBadRequest},
+		{"create vouchers", "/admin/promo/vouchers", adminToken, types.VoucherParams{PromoParams: types.PromoParams{Code: "gift", Discount: percent}, Count: 3}, http.StatusCreated},
+		{"create too many vouchers", "/admin/promo/vouchers", adminToken, types.VoucherParams{PromoParams: types.PromoParams{Code: "gift", Discount: percent}, Count: 10000}, http.StatusBadRequest},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			b, _ := json.Marshal(tc.body)
+			req := httptest.NewRequest(http.MethodPost, tc.path, bytes.NewReader(b))
+			req.Header.Add("Content-Type", "application/json")
+			req.Header.Add("Authorization", tc.token)
+			res, _ := app.Test(req, testTimeout)
+			if res.StatusCode != tc.status {
+				t.Fatalf("expected status %d, got %d", tc.status, res.StatusCode)
+			}
+		})
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/promo?code=gift", nil)
+	req.Header.Add("Authorization", adminToken)
+	res, _ := app.Test(req, testTimeout)
+	var page types.Page[types.PromoCode]
+	if err := json.NewDecoder(res.Body).Decode(&page); err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Data) != 3 {
+		t.Fatalf("expected 3 vouchers, got %d", len(page.Data))
+	}
+	for _, voucher := range page.Data {
+		if !strings.HasPrefix(voucher.Code, "GIFT-") || voucher.MaxUses != 1 {
+			t.Errorf("expected a single use GIFT- voucher, got %s with %d uses", voucher.Code, voucher.MaxUses)
+		}
+	}
+}
+
+func TestBookWithPromo(t *testing.T) {
+	db := setup(t)
+	defer db.Drop(t)
+
+	admin := fixtures.AddUser(db.Store, "test", "admin", true)
+	adminToken, _ := CreateUserToken(admin)
+	user := fixtures.AddUser(db.Store, "test", "user", false)
+	userToken, _ := CreateUserToken(user)
+	other := fixtures.AddUser(db.Store, "other", "user", false)
+	otherToken, _ := CreateUserToken(other)
+	hotel := fixtures.AddHotel(db.Store, "test hotel", "test address", 4, 2)
+	room := fixtures.AddRoom(db.Store, types.Double, 100, hotel.ID)
+	otherHotel := fixtures.AddHotel(db.Store, "other hotel", "test address", 4, 2)
+	otherRoom := fixtures.AddRoom(db.Store, types.Double, 100, otherHotel.ID)
+
+	ctx := context.TODO()
+	now := time.Now()
+	promos := []*types.PromoCode{
+		{Code: "TENOFF", Discount: types.Discount{Kind: types.PercentDiscount, Value: 10}, MaxUsesPerUser: 1},
+		{Code: "LONGSTAY", Discount: types.Discount{Kind: types.AmountDiscount, Value: 50, Currency: "USD"}, MinNights: 5},
+		{Code: "HOTEL", Discount: types.Discount{Kind: types.PercentDiscount, Value: 50}, HotelID: hotel.ID},
+		{Code: "EXPIRED", Discount: types.Discount{Kind: types.PercentDiscount, Value: 50}, ValidUntil: now.AddDate(0, 0, -1)},
+		{Code: "VOUCHER", Discount: types.Discount{Kind: types.AmountDiscount, Value: 30, Currency: "USD"}, MaxUses: 1},
+	}
+	for _, p := range promos {
+		if err := db.Store.Promo.InsertPromo(ctx, p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	app := fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+	gateway := payments.NewFakeGateway()
+	roomHandler := NewRoomHandler(db.Store, gateway)
+	bookingHandler := NewBookingHandler(db.Store, gateway)
+	promoHandler := NewPromoHandler(db.Store)
+	api := app.Group("/", JWTAuth(db.Store.User, db.Store.Session))
+	api.Post("/room/:id/book", roomHandler.HandleBookRoom)
+	api.Post("/room/:id/quote", roomHandler.HandleQuoteRoom)
+	api.Post("/room/:id/hold", roomHandler.HandleHoldRoom)
+	api.Post("/booking/:id/book", bookingHandler.HandleBookHold)
+	api.Delete("/booking/:id", bookingHandler.HandleCancelBooking)
+	api.Patch("/booking/:id", bookingHandler.HandlePatchBooking)
+	app.Get("/admin/promo/:code", JWTAuth(db.Store.User, db.Store.Session), AdminAuth, promoHandler.HandleGetPromo)
+
+	do := func(method, path, token string, body any) *http.Response {
+		b, _ := json.Marshal(body)
+		req := httptest.NewRequest(method, path, bytes.NewReader(b))
+		req.Header.Add("Content-Type", "application/json")
+		req.Header.Add("Authorization", token)
+		res, _ := app.Test(req, testTimeout)
+		return res
+	}
+	// every booking gets its own 2 nights, so only the promo code matters
+	day := 1
+	body := func(code string) types.BookingBody {
+		from := now.AddDate(0, 0, day)
+		day += 3
+		return types.BookingBody{FromDate: from, UntilDate: from.AddDate(0, 0, 2), NumPeople: 2, PromoCode: code}
+	}
+	book := func(r *types.Room, token string, b types.BookingBody) (*http.Response, *types.Booking) {
+		res := do(http.MethodPost, fmt.Sprintf("/room/%s/book", r.ID.Hex()), token, b)
+		var booking types.Booking
+		if res.StatusCode == http.StatusCreated {
+			if err := json.NewDecoder(res.Body).Decode(&booking); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return res, &booking
+	}
+
+	res, booking := book(room, userToken, body("tenoff"))
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("expected status %d, got %d", http.StatusCreated, res.StatusCode)
+	}
+	if booking.Price != 180 || booking.Promo == nil || booking.Promo.Code != "TENOFF" || booking.Promo.Amount != 20 {
+		t.Errorf("expected a booking of 180 discounted 20 by TENOFF, got %d with %+v", booking.Price, booking.Promo)
+	}
+
+	tests := []struct {
+		name  string
+		room  *types.Room
+		token string
+		code  string
+	}{
+		{"unknown code", room, userToken, "NOPE"},
+		{"per user limit", room, userToken, "TENOFF"},
+		{"minimum nights", room, userToken, "LONGSTAY"},
+		{"other hotel", otherRoom, userToken, "HOTEL"},
+		{"expired", room, userToken, "EXPIRED"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			res, _ := book(tc.room, tc.token, body(tc.code))
+			if res.StatusCode != http.StatusBadRequest {
+				t.Fatalf("expected status %d, got %d", http.StatusBadRequest, res.StatusCode)
+			}
+			var resp map[string]map[string]string
+			if err := json.NewDecoder(res.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp["error"]["promoCode"] == "" {
+				t.Errorf("expected a promoCode error, got %v", resp)
+			}
+		})
+	}
+	if res, _ := book(room, otherToken, body("TENOFF")); res.StatusCode != http.StatusCreated {
+		t.Errorf("expected other users to use TENOFF, got status %d", res.StatusCode)
+	}
+
+	// quotes keep the discount of their code
+	b := body("HOTEL")
+	var quote types.QuoteResponse
+	if err := json.NewDecoder(do(http.MethodPost, fmt.Sprintf("/room/%s/quote", room.ID.Hex()), userToken, b).Body).Decode(&quote); err != nil {
+		t.Fatal(err)
+	}
+	if !quote.Valid || quote.Discount != 100 || quote.Total != 100 {
+		t.Fatalf("expected a valid quote of 100 discounted 100, got %+v", quote)
+	}
+	b.QuoteID = quote.ID
+	if res, booking := book(room, userToken, b); res.StatusCode != http.StatusCreated || booking.Price != 100 {
+		t.Errorf("expected the quoted booking of 100, got status %d and %d", res.StatusCode, booking.Price)
+	}
+
+	// the voucher is used up while its hold waits for payment
+	res = do(http.MethodPost, fmt.Sprintf("/room/%s/hold", room.ID.Hex()), userToken, body("voucher"))
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("expected status %d, got %d", http.StatusCreated, res.StatusCode)
+	}
+	var hold types.Booking
+	if err := json.NewDecoder(res.Body).Decode(&hold); err != nil {
+		t.Fatal(err)
+	}
+	if res, _ := book(room, otherToken, body("VOUCHER")); res.StatusCode != http.StatusCreated {
+		t.Fatalf("expected the voucher to be used, got status %d", res.StatusCode)
+	}
+	if res := do(http.MethodPost, fmt.Sprintf("/booking/%s/book", hold.ID.Hex()), userToken, nil); res.StatusCode != http.StatusConflict {
+		t.Errorf("expected status %d for a used up voucher, got %d", http.StatusConflict, res.StatusCode)
+	}
+	// the hold never redeemed the voucher, so cancelling it gives nothing back
+	if res := do(http.MethodDelete, "/booking/"+hold.ID.Hex(), userToken, nil); res.StatusCode != http.StatusOK {
+		t.Fatalf("expected the hold to be cancelled, got status %d", res.StatusCode)
+	}
+
+	var usage types.PromoUsage
+	if err := json.NewDecoder(do(http.MethodGet, "/admin/promo/voucher", adminToken, nil).Body).Decode(&usage); err != nil {
+		t.Fatal(err)
+	}
+	if usage.Promo.Uses != 1 || len(usage.Bookings) != 2 {
+		t.Errorf("expected 1 use and 2 bookings with the voucher, got %d and %d", usage.Promo.Uses, len(usage.Bookings))
+	}
+
+	// cancelled bookings give the use of their code back
+	if res := do(http.MethodDelete, "/booking/"+booking.ID.Hex(), userToken, nil); res.StatusCode != http.StatusOK {
+		t.Fatalf("expected the booking to be cancelled, got status %d", res.StatusCode)
+	}
+	if res, _ := book(room, userToken, body("TENOFF")); res.StatusCode != http.StatusCreated {
+		t.Errorf("expected TENOFF to be used again after cancelling, got status %d", res.StatusCode)
+	}
+
+	// changed bookings keep the discount only while the stay meets the code
+	from := now.AddDate(0, 0, day)
+	long := types.BookingBody{FromDate: from, UntilDate: from.AddDate(0, 0, 5), NumPeople: 2, PromoCode: "LONGSTAY"}
+	res, booking = book(room, userToken, long)
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("expected status %d, got %d", http.StatusCreated, res.StatusCode)
+	}
+	shorter := from.AddDate(0, 0, 2)
+	res = do(http.MethodPatch, "/booking/"+booking.ID.Hex(), userToken, types.UpdateBookingParams{UntilDate: &shorter})
+	if res.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected status %d for a stay too short for LONGSTAY, got %d", http.StatusBadRequest, res.StatusCode)
+	}
+	var resp map[string]map[string]string
+	if err := json.NewDecoder(res.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp["error"]["promoCode"] == "" {
+		t.Errorf("expected a promoCode error, got %v", resp)
+	}
+	longer := from.AddDate(0, 0, 6)
+	res = do(http.MethodPatch, "/booking/"+booking.ID.Hex(), userToken, types.UpdateBookingParams{UntilDate: &longer})
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, res.StatusCode)
+	}
+	var changed types.Booking
+	if err := json.NewDecoder(res.Body).Decode(&changed); err != nil {
+		t.Fatal(err)
+	}
+	if changed.Price != 550 || changed.Promo == nil || changed.Promo.Amount != 50 {
+		t.Errorf("expected 6 nights of 550 discounted 50 by LONGSTAY, got %d with %+v", changed.Price, changed.Promo)
+	}
+}
@@ -0,0 +1,66 @@
+package api
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/xV0lk/hotel-reservations/types"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const quoteTTL = 15 * time.Minute
+
+// QuoteClaims is the signed content of a quote. The quote ID handed to the
+// guest is the token itself, so quotes need no storage.
+type QuoteClaims struct {
+	RoomID    primitive.ObjectID `json:"roomID"`
+	UserID    primitive.ObjectID `json:"userID"`
+	FromDate  time.Time          `json:"fromDate"`
+	UntilDate time.Time          `json:"untilDate"`
+	NumPeople int                `json:"numPeople"`
+	Nightly   []types.NightPrice `json:"nightly"`
+	PromoCode string             `json:"promoCode,omitempty"`
+	Discount  int                `json:"discount,omitempty"`
+	Taxes     []types.TaxLine    `json:"taxes,omitempty"`
+	Total     int                `json:"total"`
+	jwt.RegisteredClaims
+}
+
+// Matches reports whether the quote was issued for this booking
+func (q *QuoteClaims) Matches(userID, roomID primitive.ObjectID, b types.BookingBody) bool {
+	return q.UserID == userID &&
+		q.RoomID == roomID &&
+		q.FromDate.Equal(b.FromDate) &&
+		q.UntilDate.Equal(b.UntilDate) &&
+		q.NumPeople == b.NumPeople &&
+		q.PromoCode == types.NormalizePromoCode(b.PromoCode)
+}
+
+func CreateQuoteToken(claims *QuoteClaims, now time.Time) (string, error) {
+	claims.IssuedAt = jwt.NewNumericDate(now)
+	claims.ExpiresAt = jwt.NewNumericDate(now.Add(quoteTTL))
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString(quoteSecret())
+}
+
+func ValidateQuoteToken(tokenStr string) (*QuoteClaims, error) {
+	claims := &QuoteClaims{}
+	token, err := jwt.ParseWithClaims(tokenStr, claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
+		}
+		return quoteSecret(), nil
+	})
+	if err != nil || !token.Valid {
+		return nil, fmt.Errorf("invalid or expired quote")
+	}
+	return claims, nil
+}
+
+// quoteSecret derives the quote signing key from the JWT secret, so a quote
+// can never be accepted as an auth token or the other way around.
+func quoteSecret() []byte {
+	return []byte("quote:" + os.Getenv("JWT_SECRET"))
+}
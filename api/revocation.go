@@ -0,0 +1,133 @@
+package api
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/xV0lk/hotel-reservations/db"
+	"github.com/xV0lk/hotel-reservations/types"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// revocationList holds the ids of the access tokens revoked before they
+// expire. JWTAuth checks it on every request, so it is kept in memory and
+// only read from the session store when the API starts. Tokens revoked by
+// another instance are still rejected through their session, see
+// sessionCache.
+type revocationList struct {
+	mu     sync.RWMutex
+	tokens map[string]time.Time
+}
+
+var revokedTokens = &revocationList{tokens: map[string]time.Time{}}
+
+// add revokes the token until it expires, forgetting the expired ones
+func (l *revocationList) add(id string, expiresAt time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	for token, exp := range l.tokens {
+		if !exp.After(now) {
+			delete(l.tokens, token)
+		}
+	}
+	l.tokens[id] = expiresAt
+}
+
+func (l *revocationList) isRevoked(id string) bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	expiresAt, ok := l.tokens[id]
+	return ok && time.Now().Before(expiresAt)
+}
+
+// sessionCheckTTL is how long JWTAuth trusts what it read of a session. A
+// session revoked by another instance is noticed at most this late.
+const sessionCheckTTL = 30 * time.Second
+
+// sessionCache remembers whether the sessions of recent access tokens were
+// revoked, so JWTAuth doesn't read the session on every request.
+type sessionCache struct {
+	mu       sync.Mutex
+	sessions map[string]checkedSession
+}
+
+type checkedSession struct {
+	revoked   bool
+	checkedAt time.Time
+}
+
+var checkedSessions = &sessionCache{sessions: map[string]checkedSession{}}
+
+// set records whether the session is revoked, forgetting the stale entries
+func (c *sessionCache) set(id string, revoked bool, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for session, checked := range c.sessions {
+		if now.Sub(checked.checkedAt) >= sessionCheckTTL {
+			delete(c.sessions, session)
+		}
+	}
+	c.sessions[id] = checkedSession{revoked: revoked, checkedAt: now}
+}
+
+// isRevoked reports whether the session of the access token was revoked,
+// reading it from the store when it wasn't checked recently. Tokens without
+// a session are never revoked through it.
+func (c *sessionCache) isRevoked(ctx context.Context, store db.SessionStore, claims *AccessClaims) bool {
+	if claims.SessionID == "" {
+		return false
+	}
+	now := time.Now()
+	c.mu.Lock()
+	checked, ok := c.sessions[claims.SessionID]
+	c.mu.Unlock()
+	if ok && now.Sub(checked.checkedAt) < sessionCheckTTL {
+		return checked.revoked
+	}
+	id, err := primitive.ObjectIDFromHex(claims.SessionID)
+	if err != nil {
+		return true
+	}
+	session, err := store.GetSessionById(ctx, id)
+	if err != nil {
+		return true
+	}
+	c.set(claims.SessionID, session.RevokedAt != nil, now)
+	return session.RevokedAt != nil
+}
+
+// LoadRevokedTokens reads the access tokens revoked by earlier runs of the
+// API that haven't expired yet. It must run before requests are served.
+func LoadRevokedTokens(ctx context.Context, store db.SessionStore) error {
+	tokens, err := store.GetRevokedTokens(ctx, time.Now())
+	if err != nil {
+		return err
+	}
+	for _, t := range tokens {
+		revokedTokens.add(t.TokenID, t.ExpiresAt)
+	}
+	return nil
+}
+
+// revokeSession ends the session, rejecting all its access tokens right away
+// on this instance and within sessionCheckTTL on the others.
+func revokeSession(ctx context.Context, store db.SessionStore, id primitive.ObjectID, now time.Time) error {
+	if err := store.RevokeSession(ctx, id, now); err != nil {
+		return err
+	}
+	checkedSessions.set(id.Hex(), true, now)
+	return nil
+}
+
+// revokeToken stores the revocation of the access token before JWTAuth
+// starts rejecting it.
+func revokeToken(ctx context.Context, store db.SessionStore, claims *AccessClaims) error {
+	token := &types.RevokedToken{TokenID: claims.ID, ExpiresAt: claims.ExpiresAt.Time}
+	if err := store.RevokeToken(ctx, token); err != nil {
+		return err
+	}
+	revokedTokens.add(token.TokenID, token.ExpiresAt)
+	return nil
+}
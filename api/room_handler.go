@@ -1,79 +1,244 @@
 package api
 
 import (
+	"context"
+	"errors"
 	"net/http"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
-	"github.com/valyala/fasthttp"
 	"github.com/xV0lk/hotel-reservations/db"
+	"github.com/xV0lk/hotel-reservations/payments"
+	"github.com/xV0lk/hotel-reservations/pricing"
 	"github.com/xV0lk/hotel-reservations/types"
 	iutils "github.com/xV0lk/hotel-reservations/utils"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
+// holdTTL is how long a hold keeps the room before it expires
+const holdTTL = 10 * time.Minute
+
 type RoomHandler struct {
 	store *db.Store
+	payer payer
 }
 
-func NewRoomHandler(store *db.Store) *RoomHandler {
+func NewRoomHandler(store *db.Store, gateway payments.Gateway) *RoomHandler {
 	return &RoomHandler{
 		store: store,
+		payer: payer{store: store, gateway: gateway},
 	}
 }
 
 func (h *RoomHandler) HandleGetRooms(c *fiber.Ctx) error {
-	rooms, err := h.store.Room.GetRooms(c.Context(), bson.M{})
+	var query types.RoomQuery
+	page, err := parseListQuery(c, &query, types.RoomSortFields)
 	if err != nil {
-		return ErrInternal()
+		return err
+	}
+	rooms, err := h.store.Room.ListRooms(c.UserContext(), query.CreateFilter(), page)
+	if err != nil {
+		return listError(err)
 	}
 	return c.JSON(rooms)
 }
 
+// HandleBookRoom books the room, charging the deposit of the hotel's rate
+// plan. The booking is confirmed once it is paid.
 func (h *RoomHandler) HandleBookRoom(c *fiber.Ctx) error {
-	roomId := c.Params("id")
 	var reqBody types.BookingBody
-	err := c.BodyParser(&reqBody)
-	if err != nil {
+	if err := c.BodyParser(&reqBody); err != nil {
 		return ErrBadRequest()
 	}
-	room, err := h.store.Room.GetRoomById(c.Context(), roomId)
+	booking, hotel, err := h.newBooking(c, reqBody)
 	if err != nil {
-		return ErrNotFound()
+		return err
+	}
+	booking.SetStatus(types.BookingPending, time.Now())
+	reserve := redeemingPromo(c.UserContext(), h.store, booking, func() error {
+		return h.reserve(c.UserContext(), booking)
+	})
+	if err := h.payer.payBookings(c.UserContext(), hotel.RatePlan, reqBody.PaymentToken, []*types.Booking{booking}, reserve); err != nil {
+		return err
 	}
-	if errors := reqBody.Validate(room); len(errors) != 0 {
+	return c.Status(http.StatusCreated).JSON(booking)
+}
+
+// HandleHoldRoom holds the room for a short time while the guest pays. The
+// hold blocks the room like a booking until it is booked or expires.
+func (h *RoomHandler) HandleHoldRoom(c *fiber.Ctx) error {
+	var reqBody types.BookingBody
+	if err := c.BodyParser(&reqBody); err != nil {
 		return ErrBadRequest()
 	}
-	// Check if the room is available
-	ra, err := h.isRoomAvailable(c.Context(), reqBody, room.ID)
+	booking, _, err := h.newBooking(c, reqBody)
 	if err != nil {
-		return ErrInternal()
+		return err
 	}
-	if !ra {
-		return NewError(http.StatusBadRequest, "This room is not available for the time selected")
+	now := time.Now()
+	expiresAt := now.Add(holdTTL)
+	booking.SetStatus(types.BookingHeld, now)
+	booking.ExpiresAt = &expiresAt
+	if err := h.reserve(c.UserContext(), booking); err != nil {
+		return err
+	}
+	return c.Status(http.StatusCreated).JSON(booking)
+}
+
+// newBooking validates and prices the booking of the request body, using
+// the quoted price when it has a valid quote id. It also returns the hotel
+// of the room. The promo code of the body is only redeemed once the booking
+// is reserved.
+func (h *RoomHandler) newBooking(c *fiber.Ctx, reqBody types.BookingBody) (*types.Booking, *types.Hotel, error) {
+	room, err := h.store.Room.GetRoomById(c.UserContext(), c.Params("id"))
+	if err != nil {
+		return nil, nil, ErrNotFound()
 	}
 	// Get user
 	user, ok := c.Context().UserValue("user").(*types.User)
 	if !ok {
-		return ErrInternal()
+		return nil, nil, ErrInternal()
+	}
+	hotel, err := h.store.Hotel.GetHotelById(c.UserContext(), room.HotelId.Hex())
+	if err != nil {
+		return nil, nil, ErrInternal()
+	}
+	vErrors := reqBody.Validate(room)
+	promo, pErrors, err := bookingPromo(c.UserContext(), h.store, reqBody.PromoCode, reqBody.PromoUse(user.ID, hotel, time.Now()))
+	if err != nil {
+		return nil, nil, err
+	}
+	for k, v := range pErrors {
+		vErrors[k] = v
+	}
+	if len(vErrors) != 0 {
+		return nil, nil, NewMapError(http.StatusBadRequest, vErrors)
+	}
+	// Check if the room is available
+	ra, err := h.isRoomAvailable(c.UserContext(), reqBody, room.ID)
+	if err != nil {
+		return nil, nil, ErrInternal()
+	}
+	if !ra {
+		return nil, nil, ErrRoomUnavailable()
+	}
+	var quote *types.PriceQuote
+	if reqBody.QuoteID != "" {
+		claims, err := ValidateQuoteToken(reqBody.QuoteID)
+		if err != nil || !claims.Matches(user.ID, room.ID, reqBody) {
+			return nil, nil, NewError(http.StatusBadRequest, "The quote is invalid or has expired")
+		}
+		quote = &types.PriceQuote{Nights: claims.Nightly, Discount: claims.Discount, Taxes: claims.Taxes, Total: claims.Total}
+	} else {
+		quote = pricing.DiscountedQuote(hotel, room, reqBody.FromDate, reqBody.UntilDate, reqBody.NumPeople, promo.GetDiscount())
 	}
-	tPrice := room.BasePrice * iutils.Dbd(reqBody.FromDate, reqBody.UntilDate)
 
-	cBook := types.Booking{
+	return &types.Booking{
 		UserID:    user.ID,
 		RoomID:    room.ID,
 		FromDate:  reqBody.FromDate,
 		UntilDate: reqBody.UntilDate,
 		NumPeople: reqBody.NumPeople,
-		Price:     tPrice,
-		Cancelled: false,
+		Price:     quote.Total,
+		Currency:  hotel.CurrencyCode(),
+		Nightly:   quote.Nights,
+		Taxes:     quote.Taxes,
+		Promo:     promo.Apply(quote.Discount),
+		Policy:    &hotel.Cancellation,
+	}, hotel, nil
+}
+
+func (h *RoomHandler) reserve(ctx context.Context, booking *types.Booking) error {
+	if err := releaseExpiredHolds(ctx, h.store); err != nil {
+		return ErrInternal()
 	}
-	h.store.Booking.InsertBooking(c.Context(), &cBook)
-	return c.Status(http.StatusCreated).JSON(cBook)
+	if err := h.store.Booking.ReserveBooking(ctx, booking); err != nil {
+		if errors.Is(err, db.ErrRoomUnavailable) {
+			return ErrRoomUnavailable()
+		}
+		return ErrInternal()
+	}
+	return nil
+}
+
+// HandleQuoteRoom prices a booking without creating it. Valid quotes for an
+// available room get a signed, time limited id the guest can book with. With
+// ?currency= the total is also shown in that currency, though bookings are
+// always charged in the currency of the hotel.
+func (h *RoomHandler) HandleQuoteRoom(c *fiber.Ctx) error {
+	var reqBody types.BookingBody
+	if err := c.BodyParser(&reqBody); err != nil {
+		return ErrBadRequest()
+	}
+	convert, err := currencyConverter(c.UserContext(), h.store, c.Query("currency"))
+	if err != nil {
+		return err
+	}
+	room, err := h.store.Room.GetRoomById(c.UserContext(), c.Params("id"))
+	if err != nil {
+		return ErrNotFound()
+	}
+	hotel, err := h.store.Hotel.GetHotelById(c.UserContext(), room.HotelId.Hex())
+	if err != nil {
+		return ErrInternal()
+	}
+	user, err := iutils.GetAuthUser(c)
+	if err != nil {
+		return ErrInternal()
+	}
+	resp := types.QuoteResponse{
+		Errors: reqBody.Validate(room),
+	}
+	promo, pErrors, err := bookingPromo(c.UserContext(), h.store, reqBody.PromoCode, reqBody.PromoUse(user.ID, hotel, time.Now()))
+	if err != nil {
+		return err
+	}
+	for k, v := range pErrors {
+		resp.Errors[k] = v
+	}
+	resp.Valid = len(resp.Errors) == 0
+	if resp.Available, err = h.isRoomAvailable(c.UserContext(), reqBody, room.ID); err != nil {
+		return ErrInternal()
+	}
+	quote := pricing.DiscountedQuote(hotel, room, reqBody.FromDate, reqBody.UntilDate, reqBody.NumPeople, promo.GetDiscount())
+	resp.Nightly = quote.Nights
+	resp.Subtotal = quote.Subtotal
+	resp.Discount = quote.Discount
+	resp.Taxes = quote.Taxes
+	resp.Total = quote.Total
+	resp.Currency = hotel.CurrencyCode()
+	if convert != nil {
+		if resp.Display, err = convert(types.Money{Amount: quote.Total, Currency: resp.Currency}); err != nil {
+			return err
+		}
+	}
+	if resp.Valid && resp.Available {
+		now := time.Now()
+		resp.ID, err = CreateQuoteToken(&QuoteClaims{
+			RoomID:    room.ID,
+			UserID:    user.ID,
+			FromDate:  reqBody.FromDate,
+			UntilDate: reqBody.UntilDate,
+			NumPeople: reqBody.NumPeople,
+			Nightly:   quote.Nights,
+			PromoCode: types.NormalizePromoCode(reqBody.PromoCode),
+			Discount:  quote.Discount,
+			Taxes:     quote.Taxes,
+			Total:     quote.Total,
+		}, now)
+		if err != nil {
+			return ErrInternal()
+		}
+		expiresAt := now.Add(quoteTTL)
+		resp.ExpiresAt = &expiresAt
+	}
+	return c.JSON(resp)
 }
 
-func (h *RoomHandler) isRoomAvailable(ctx *fasthttp.RequestCtx, b types.BookingBody, rId primitive.ObjectID) (bool, error) {
-	avFilter := b.CreateAvailabilityFilter(rId)
+func (h *RoomHandler) isRoomAvailable(ctx context.Context, b types.BookingBody, rId primitive.ObjectID) (bool, error) {
+	avFilter := b.CreateAvailabilityFilter(rId, time.Now())
 	cb, err := h.store.Booking.FilterBookings(ctx, avFilter)
 	if err != nil {
 		return false, ErrInternal()
@@ -81,3 +246,150 @@ func (h *RoomHandler) isRoomAvailable(ctx *fasthttp.RequestCtx, b types.BookingB
 	available := len(cb) == 0
 	return available, nil
 }
+
+// HandleGetCalendar returns the status and price of every night of the room
+// in the requested range.
+func (h *RoomHandler) HandleGetCalendar(c *fiber.Ctx) error {
+	var query types.CalendarQuery
+	if err := c.QueryParser(&query); err != nil {
+		return ErrBadRequest()
+	}
+	if errors := query.Validate(); len(errors) != 0 {
+		return NewMapError(http.StatusBadRequest, errors)
+	}
+	room, err := h.store.Room.GetRoomById(c.UserContext(), c.Params("id"))
+	if err != nil {
+		return ErrNotFound()
+	}
+	hotel, err := h.store.Hotel.GetHotelById(c.UserContext(), room.HotelId.Hex())
+	if err != nil {
+		return ErrInternal()
+	}
+	bookings, err := h.store.Booking.FilterBookings(c.UserContext(), query.BookingBody().CreateAvailabilityFilter(room.ID, time.Now()))
+	if err != nil {
+		return ErrInternal()
+	}
+	price := func(night time.Time) int {
+		return pricing.NightlyRate(hotel.RatePlan, room, night)
+	}
+	return c.JSON(types.NewRoomCalendar(room, bookings, query, time.Now(), price))
+}
+
+func (h *RoomHandler) HandlePostRoom(c *fiber.Ctx) error {
+	var params types.NewRoomParams
+	if err := c.BodyParser(&params); err != nil {
+		return ErrBadRequest()
+	}
+	if errors := params.Validate(); len(errors) != 0 {
+		return NewMapError(http.StatusBadRequest, errors)
+	}
+	hotel, err := h.store.Hotel.GetHotelById(c.UserContext(), c.Params("id"))
+	if err != nil {
+		return ErrNotFound()
+	}
+	if err := h.checkRoomNumber(c.UserContext(), hotel.ID, primitive.NilObjectID, params.Number); err != nil {
+		return err
+	}
+	room := types.NewRoomFromParams(&params, hotel.ID)
+	if err := h.store.Room.InsertRoom(c.UserContext(), room); err != nil {
+		return ErrInternal()
+	}
+	return c.Status(http.StatusCreated).JSON(room)
+}
+
+// HandlePutRoom replaces every editable property of the room
+func (h *RoomHandler) HandlePutRoom(c *fiber.Ctx) error {
+	var params types.NewRoomParams
+	if err := c.BodyParser(&params); err != nil {
+		return ErrBadRequest()
+	}
+	if errors := params.Validate(); len(errors) != 0 {
+		return NewMapError(http.StatusBadRequest, errors)
+	}
+	return h.updateRoom(c, params.ToBson())
+}
+
+// HandlePatchRoom updates only the properties present in the body
+func (h *RoomHandler) HandlePatchRoom(c *fiber.Ctx) error {
+	var (
+		jsonData map[string]any
+		params   types.UpdateRoomParams
+	)
+	if err := c.BodyParser(&params); err != nil {
+		return ErrBadRequest()
+	}
+	if err := c.BodyParser(&jsonData); err != nil {
+		return ErrBadRequest()
+	}
+	if err := params.CheckBody(jsonData); err != nil {
+		return NewError(http.StatusBadRequest, err.Error())
+	}
+	if errors := params.Validate(); len(errors) != 0 {
+		return NewMapError(http.StatusBadRequest, errors)
+	}
+	return h.updateRoom(c, params.ToBson())
+}
+
+// HandleRetireRoom takes the room out of inventory. Existing bookings are
+// kept, but the room can't be booked anymore.
+func (h *RoomHandler) HandleRetireRoom(c *fiber.Ctx) error {
+	room, err := h.store.Room.UpdateRoom(c.UserContext(), c.Params("id"), bson.M{"$set": bson.M{"retired": true}})
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return ErrNotFound()
+		}
+		return ErrInternal()
+	}
+	return c.JSON(room)
+}
+
+// HandleDeleteRoom deletes a room that has no upcoming or ongoing bookings
+func (h *RoomHandler) HandleDeleteRoom(c *fiber.Ctx) error {
+	var id = c.Params("id")
+	room, err := h.store.Room.GetRoomById(c.UserContext(), id)
+	if err != nil {
+		return ErrNotFound()
+	}
+	bookings, err := h.store.Booking.FilterBookings(c.UserContext(), room.CreateActiveBookingsFilter(time.Now()))
+	if err != nil {
+		return ErrInternal()
+	}
+	if len(bookings) != 0 {
+		return NewError(http.StatusConflict, "This room has upcoming bookings, retire it instead")
+	}
+	if err := h.store.Room.DeleteRoom(c.UserContext(), id); err != nil {
+		return ErrInternal()
+	}
+	return c.JSON(fiber.Map{"message": "Room deleted successfully!"})
+}
+
+func (h *RoomHandler) updateRoom(c *fiber.Ctx, values bson.M) error {
+	var id = c.Params("id")
+	room, err := h.store.Room.GetRoomById(c.UserContext(), id)
+	if err != nil {
+		return ErrNotFound()
+	}
+	if number, ok := values["number"].(string); ok {
+		if err := h.checkRoomNumber(c.UserContext(), room.HotelId, room.ID, number); err != nil {
+			return err
+		}
+	}
+	updated, err := h.store.Room.UpdateRoom(c.UserContext(), id, bson.M{"$set": values})
+	if err != nil {
+		return ErrInternal()
+	}
+	return c.JSON(updated)
+}
+
+// checkRoomNumber makes sure no other room of the hotel uses the number
+func (h *RoomHandler) checkRoomNumber(ctx context.Context, hotelId, roomId primitive.ObjectID, number string) error {
+	filter := bson.M{"hotelId": hotelId, "number": number, "_id": bson.M{"$ne": roomId}}
+	rooms, err := h.store.Room.GetRooms(ctx, filter)
+	if err != nil {
+		return ErrInternal()
+	}
+	if len(rooms) != 0 {
+		return NewMapError(http.StatusBadRequest, map[string]string{"number": "room number already exists in this hotel"})
+	}
+	return nil
+}
@@ -0,0 +1,550 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/xV0lk/hotel-reservations/db/fixtures"
+	"github.com/xV0lk/hotel-reservations/payments"
+	"github.com/xV0lk/hotel-reservations/types"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestConcurrentBookRoom(t *testing.T) {
+	t.Run("memstore", func(t *testing.T) { testConcurrentBookRoom(t, setup(t)) })
+	// the nights are locked by the unique index of MongoDB itself
+	t.Run("mongo", func(t *testing.T) { testConcurrentBookRoom(t, setupMongo(t)) })
+}
+
+func testConcurrentBookRoom(t *testing.T, db *testdb) {
+	defer db.Drop(t)
+
+	user := fixtures.AddUser(db.Store, "test", "user", false)
+	userToken, _ := CreateUserToken(user)
+	hotel := fixtures.AddHotel(db.Store, "test hotel", "test address", 4, 2)
+	room := fixtures.AddRoom(db.Store, types.Double, 100, hotel.ID)
+
+	app := fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+	roomHandler := NewRoomHandler(db.Store, payments.NewFakeGateway())
+	api := app.Group("/", JWTAuth(db.Store.User, db.Store.Session))
+	api.Post("/room/:id/book", roomHandler.HandleBookRoom)
+
+	body, _ := json.Marshal(types.BookingBody{
+		FromDate:  time.Now().AddDate(0, 0, 1),
+		UntilDate: time.Now().AddDate(0, 0, 4),
+		NumPeople: 2,
+	})
+
+	const attempts = 20
+	var (
+		wg       sync.WaitGroup
+		statuses = make(chan int, attempts)
+	)
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/room/%s/book", room.ID.Hex()), bytes.NewReader(body))
+			req.Header.Add("Content-Type", "application/json")
+			req.Header.Add("Authorization", userToken)
+			res, err := app.Test(req, -1)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			statuses <- res.StatusCode
+		}()
+	}
+	wg.Wait()
+	close(statuses)
+
+	created := 0
+	for status := range statuses {
+		switch status {
+		case http.StatusCreated:
+			created++
+		case http.StatusConflict:
+		default:
+			t.Errorf("expected status %d or %d, got %d", http.StatusCreated, http.StatusConflict, status)
+		}
+	}
+	if created != 1 {
+		t.Fatalf("expected exactly 1 booking to be created, got %d", created)
+	}
+}
+
+func TestBookRoomWithoutNights(t *testing.T) {
+	db := setup(t)
+	defer db.Drop(t)
+
+	user := fixtures.AddUser(db.Store, "test", "user", false)
+	userToken, _ := CreateUserToken(user)
+	hotel := fixtures.AddHotel(db.Store, "test hotel", "test address", 4, 2)
+	room := fixtures.AddRoom(db.Store, types.Double, 100, hotel.ID)
+
+	app := fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+	roomHandler := NewRoomHandler(db.Store, payments.NewFakeGateway())
+	app.Post("/room/:id/book", JWTAuth(db.Store.User, db.Store.Session), roomHandler.HandleBookRoom)
+
+	from := time.Now().AddDate(0, 0, 1).UTC().Truncate(24 * time.Hour).Add(10 * time.Hour)
+	for name, until := range map[string]time.Time{
+		"same dates":   from,
+		"a few hours":  from.Add(3 * time.Hour),
+		"ends earlier": from.AddDate(0, 0, -1).Add(time.Hour),
+	} {
+		body, _ := json.Marshal(types.BookingBody{FromDate: from, UntilDate: until, NumPeople: 1})
+		req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/room/%s/book", room.ID.Hex()), bytes.NewReader(body))
+		req.Header.Add("Content-Type", "application/json")
+		req.Header.Add("Authorization", userToken)
+		res, _ := app.Test(req, testTimeout)
+		if res.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: expected status %d, got %d", name, http.StatusBadRequest, res.StatusCode)
+		}
+	}
+}
+
+func TestBookRoomNightsOfStay(t *testing.T) {
+	db := setup(t)
+	defer db.Drop(t)
+
+	user := fixtures.AddUser(db.Store, "test", "user", false)
+	userToken, _ := CreateUserToken(user)
+	hotel := fixtures.AddHotel(db.Store, "test hotel", "test address", 4, 2)
+	room := fixtures.AddRoom(db.Store, types.Double, 100, hotel.ID)
+
+	app := fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+	roomHandler := NewRoomHandler(db.Store, payments.NewFakeGateway())
+	app.Post("/room/:id/book", JWTAuth(db.Store.User, db.Store.Session), roomHandler.HandleBookRoom)
+	book := func(from, until time.Time) int {
+		body, _ := json.Marshal(types.BookingBody{FromDate: from, UntilDate: until, NumPeople: 1})
+		req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/room/%s/book", room.ID.Hex()), bytes.NewReader(body))
+		req.Header.Add("Content-Type", "application/json")
+		req.Header.Add("Authorization", userToken)
+		res, _ := app.Test(req, testTimeout)
+		return res.StatusCode
+	}
+
+	// Arriving late and leaving early still takes both nights in between
+	day := time.Now().AddDate(0, 0, 2).UTC().Truncate(24 * time.Hour)
+	if status := book(day.Add(23*time.Hour), day.AddDate(0, 0, 2).Add(time.Hour)); status != http.StatusCreated {
+		t.Fatalf("expected status %d, got %d", http.StatusCreated, status)
+	}
+	if status := book(day.AddDate(0, 0, 1).Add(14*time.Hour), day.AddDate(0, 0, 2).Add(11*time.Hour)); status != http.StatusConflict {
+		t.Fatalf("expected the second night to be taken, got status %d", status)
+	}
+}
+
+func TestAdminRooms(t *testing.T) {
+	db := setup(t)
+	defer db.Drop(t)
+
+	admin := fixtures.AddUser(db.Store, "test", "admin", true)
+	adminToken, _ := CreateUserToken(admin)
+	hotel := fixtures.AddHotel(db.Store, "test hotel", "test address", 4, 2)
+	booked := fixtures.AddRoom(db.Store, types.Double, 100, hotel.ID)
+	fixtures.AddBooking(db.Store, admin.ID, booked, time.Now().AddDate(0, 0, 1), time.Now().AddDate(0, 0, 3), 1)
+
+	app := fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+	roomHandler := NewRoomHandler(db.Store, payments.NewFakeGateway())
+	api := app.Group("/", JWTAuth(db.Store.User, db.Store.Session))
+	adminApi := api.Group("/admin", AdminAuth)
+	api.Post("/room/:id/book", roomHandler.HandleBookRoom)
+	adminApi.Post("/hotel/:id/room", roomHandler.HandlePostRoom)
+	adminApi.Patch("/room/:id", roomHandler.HandlePatchRoom)
+	adminApi.Post("/room/:id/retire", roomHandler.HandleRetireRoom)
+	adminApi.Delete("/room/:id", roomHandler.HandleDeleteRoom)
+
+	do := func(method, path string, body any) *http.Response {
+		b, _ := json.Marshal(body)
+		req := httptest.NewRequest(method, path, bytes.NewReader(b))
+		req.Header.Add("Content-Type", "application/json")
+		req.Header.Add("Authorization", adminToken)
+		res, _ := app.Test(req, testTimeout)
+		return res
+	}
+
+	newRoom := types.NewRoomParams{
+		Type:         types.Double,
+		BasePrice:    120,
+		MaxOccupancy: 2,
+		Beds:         []types.Bed{{Size: "queen", Count: 1}},
+		Number:       "201",
+		Floor:        2,
+	}
+	res := do(http.MethodPost, fmt.Sprintf("/admin/hotel/%s/room", hotel.ID.Hex()), newRoom)
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("expected status %d, got %d", http.StatusCreated, res.StatusCode)
+	}
+	var room types.Room
+	if err := json.NewDecoder(res.Body).Decode(&room); err != nil {
+		t.Fatal(err)
+	}
+
+	book := types.BookingBody{
+		FromDate:  time.Now().AddDate(0, 0, 1),
+		UntilDate: time.Now().AddDate(0, 0, 2),
+		NumPeople: 3,
+	}
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   any
+		status int
+	}{
+		{"duplicated room number", http.MethodPost, fmt.Sprintf("/admin/hotel/%s/room", hotel.ID.Hex()), newRoom, http.StatusBadRequest},
+		{"invalid room", http.MethodPost, fmt.Sprintf("/admin/hotel/%s/room", hotel.ID.Hex()), types.NewRoomParams{Type: 9}, http.StatusBadRequest},
+		{"book over stored capacity", http.MethodPost, fmt.Sprintf("/room/%s/book", room.ID.Hex()), book, http.StatusBadRequest},
+		{"raise capacity", http.MethodPatch, fmt.Sprintf("/admin/room/%s", room.ID.Hex()), map[string]any{"maxOccupancy": 3}, http.StatusOK},
+		{"retire room", http.MethodPost, fmt.Sprintf("/admin/room/%s/retire", room.ID.Hex()), nil, http.StatusOK},
+		{"book retired room", http.MethodPost, fmt.Sprintf("/room/%s/book", room.ID.Hex()), book, http.StatusBadRequest},
+		{"delete booked room", http.MethodDelete, fmt.Sprintf("/admin/room/%s", booked.ID.Hex()), nil, http.StatusConflict},
+		{"delete room", http.MethodDelete, fmt.Sprintf("/admin/room/%s", room.ID.Hex()), nil, http.StatusOK},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			res := do(tc.method, tc.path, tc.body)
+			if res.StatusCode != tc.status {
+				t.Fatalf("expected status %d, got %d", tc.status, res.StatusCode)
+			}
+		})
+	}
+
+	h, err := db.Store.Hotel.GetHotelById(context.TODO(), hotel.ID.Hex())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range h.Rooms {
+		if id == room.ID {
+			t.Errorf("expected room %s to be removed from the hotel", room.ID.Hex())
+		}
+	}
+}
+
+func TestRoomCalendar(t *testing.T) {
+	db := setup(t)
+	defer db.Drop(t)
+
+	admin := fixtures.AddUser(db.Store, "test", "admin", true)
+	adminToken, _ := CreateUserToken(admin)
+	hotel := fixtures.AddHotel(db.Store, "test hotel", "test address", 4, 2)
+	room := fixtures.AddRoom(db.Store, types.Double, 100, hotel.ID)
+	now := time.Now().UTC()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	fixtures.AddBooking(db.Store, admin.ID, room, today.AddDate(0, 0, 2), today.AddDate(0, 0, 4), 2)
+
+	app := fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+	roomHandler := NewRoomHandler(db.Store, payments.NewFakeGateway())
+	hotelHandler := NewHotelHandler(db.Store)
+	api := app.Group("/", JWTAuth(db.Store.User, db.Store.Session))
+	api.Get("/room/:id/calendar", roomHandler.HandleGetCalendar)
+	api.Get("/hotel/:id/calendar", AdminAuth, hotelHandler.HandleGetCalendar)
+
+	get := func(path string, out any) int {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Add("Authorization", adminToken)
+		res, _ := app.Test(req, testTimeout)
+		if res.StatusCode == http.StatusOK {
+			if err := json.NewDecoder(res.Body).Decode(out); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return res.StatusCode
+	}
+	dates := fmt.Sprintf("from=%s&until=%s", today.AddDate(0, 0, -1).Format("2006-01-02"), today.AddDate(0, 0, 5).Format("2006-01-02"))
+
+	var calendar types.RoomCalendar
+	if status := get(fmt.Sprintf("/room/%s/calendar?%s", room.ID.Hex(), dates), &calendar); status != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, status)
+	}
+	expected := []types.NightStatus{
+		types.NightBlocked,
+		types.NightFree,
+		types.NightFree,
+		types.NightBooked,
+		types.NightBooked,
+		types.NightFree,
+	}
+	if len(calendar.Nights) != len(expected) {
+		t.Fatalf("expected %d nights, got %d", len(expected), len(calendar.Nights))
+	}
+	for i, night := range calendar.Nights {
+		if night.Status != expected[i] {
+			t.Errorf("expected night %s to be %s, got %s", night.Date, expected[i], night.Status)
+		}
+		if night.Price != room.BasePrice {
+			t.Errorf("expected night %s to cost %d, got %d", night.Date, room.BasePrice, night.Price)
+		}
+	}
+
+	var grid types.HotelCalendar
+	if status := get(fmt.Sprintf("/hotel/%s/calendar?%s", hotel.ID.Hex(), dates), &grid); status != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, status)
+	}
+	if len(grid.Rooms) != 5 {
+		t.Errorf("expected 5 rooms in the hotel calendar, got %d", len(grid.Rooms))
+	}
+
+	if status := get(fmt.Sprintf("/room/%s/calendar?from=%s", room.ID.Hex(), today.Format("2006-01-02")), nil); status != http.StatusBadRequest {
+		t.Errorf("expected status %d, got %d", http.StatusBadRequest, status)
+	}
+}
+
+func TestQuoteRoom(t *testing.T) {
+	db := setup(t)
+	defer db.Drop(t)
+
+	user := fixtures.AddUser(db.Store, "test", "user", false)
+	userToken, _ := CreateUserToken(user)
+	other := fixtures.AddUser(db.Store, "other", "user", false)
+	otherToken, _ := CreateUserToken(other)
+	hotel := fixtures.AddHotel(db.Store, "test hotel", "test address", 4, 2)
+	room := fixtures.AddRoom(db.Store, types.Double, 100, hotel.ID)
+
+	app := fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+	roomHandler := NewRoomHandler(db.Store, payments.NewFakeGateway())
+	api := app.Group("/", JWTAuth(db.Store.User, db.Store.Session))
+	api.Post("/room/:id/quote", roomHandler.HandleQuoteRoom)
+	api.Post("/room/:id/book", roomHandler.HandleBookRoom)
+
+	post := func(path, token string, body any) *http.Response {
+		b, _ := json.Marshal(body)
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(b))
+		req.Header.Add("Content-Type", "application/json")
+		req.Header.Add("Authorization", token)
+		res, _ := app.Test(req, testTimeout)
+		return res
+	}
+	body := types.BookingBody{
+		FromDate:  time.Now().AddDate(0, 0, 1),
+		UntilDate: time.Now().AddDate(0, 0, 3),
+		NumPeople: 4,
+	}
+
+	res := post(fmt.Sprintf("/room/%s/quote", room.ID.Hex()), userToken, body)
+	var invalid types.QuoteResponse
+	if err := json.NewDecoder(res.Body).Decode(&invalid); err != nil {
+		t.Fatal(err)
+	}
+	if invalid.Valid || invalid.ID != "" || invalid.Errors["capacity"] == "" {
+		t.Fatalf("expected an invalid quote without id, got %+v", invalid)
+	}
+
+	body.NumPeople = 2
+	res = post(fmt.Sprintf("/room/%s/quote", room.ID.Hex()), userToken, body)
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, res.StatusCode)
+	}
+	var quote types.QuoteResponse
+	if err := json.NewDecoder(res.Body).Decode(&quote); err != nil {
+		t.Fatal(err)
+	}
+	if !quote.Valid || !quote.Available || quote.ID == "" {
+		t.Fatalf("expected a valid quote with id, got %+v", quote)
+	}
+	if quote.Total != 200 || len(quote.Nightly) != 2 {
+		t.Fatalf("expected 2 nights for 200, got %d nights for %d", len(quote.Nightly), quote.Total)
+	}
+
+	// prices go up after the quote was issued
+	plan := types.RatePlan{Seasons: []types.SeasonRule{{From: "2000-01-01", Until: "2100-01-01", Multiplier: 2}}}
+	if _, err := db.Store.Hotel.Update(context.TODO(), bson.M{"_id": hotel.ID}, bson.M{"$set": bson.M{"ratePlan": plan}}); err != nil {
+		t.Fatal(err)
+	}
+
+	tampered := body
+	tampered.QuoteID = quote.ID
+	tampered.NumPeople = 1
+	if res := post(fmt.Sprintf("/room/%s/book", room.ID.Hex()), userToken, tampered); res.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected quote for other guests to be rejected, got %d", res.StatusCode)
+	}
+	body.QuoteID = quote.ID
+	if res := post(fmt.Sprintf("/room/%s/book", room.ID.Hex()), otherToken, body); res.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected quote of another user to be rejected, got %d", res.StatusCode)
+	}
+	body.QuoteID = quote.ID + "x"
+	if res := post(fmt.Sprintf("/room/%s/book", room.ID.Hex()), userToken, body); res.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected tampered quote to be rejected, got %d", res.StatusCode)
+	}
+
+	body.QuoteID = quote.ID
+	res = post(fmt.Sprintf("/room/%s/book", room.ID.Hex()), userToken, body)
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("expected status %d, got %d", http.StatusCreated, res.StatusCode)
+	}
+	var booking types.Booking
+	if err := json.NewDecoder(res.Body).Decode(&booking); err != nil {
+		t.Fatal(err)
+	}
+	if booking.Price != quote.Total {
+		t.Errorf("expected the quoted price %d, got %d", quote.Total, booking.Price)
+	}
+}
+
+func TestRoomTaxes(t *testing.T) {
+	db := setup(t)
+	defer db.Drop(t)
+
+	user := fixtures.AddUser(db.Store, "test", "user", false)
+	userToken, _ := CreateUserToken(user)
+	hotel := fixtures.AddHotel(db.Store, "test hotel", "test address", 4, 2)
+	taxes := types.TaxRules{CityTax: 2, VATPercent: 10, Fees: []types.Fee{{Name: "Cleaning", Amount: 30}}}
+	if _, err := db.Store.Hotel.Update(context.TODO(), bson.M{"_id": hotel.ID}, bson.M{"$set": bson.M{"taxes": taxes}}); err != nil {
+		t.Fatal(err)
+	}
+	room := fixtures.AddRoom(db.Store, types.Double, 100, hotel.ID)
+
+	app := fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+	roomHandler := NewRoomHandler(db.Store, payments.NewFakeGateway())
+	api := app.Group("/", JWTAuth(db.Store.User, db.Store.Session))
+	api.Post("/room/:id/quote", roomHandler.HandleQuoteRoom)
+	api.Post("/room/:id/book", roomHandler.HandleBookRoom)
+
+	post := func(path string, body any) *http.Response {
+		b, _ := json.Marshal(body)
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(b))
+		req.Header.Add("Content-Type", "application/json")
+		req.Header.Add("Authorization", userToken)
+		res, _ := app.Test(req, testTimeout)
+		return res
+	}
+	body := types.BookingBody{
+		FromDate:  time.Now().AddDate(0, 0, 1),
+		UntilDate: time.Now().AddDate(0, 0, 3),
+		NumPeople: 2,
+	}
+	// 200 for the nights, 30 cleaning, 2*2*2 city tax and 10% VAT of 230
+	const total = 261
+
+	var quote types.QuoteResponse
+	if err := json.NewDecoder(post(fmt.Sprintf("/room/%s/quote", room.ID.Hex()), body).Body).Decode(&quote); err != nil {
+		t.Fatal(err)
+	}
+	if quote.Subtotal != 200 || len(quote.Taxes) != 3 || quote.Total != total {
+		t.Fatalf("expected 200 plus 3 tax lines for %d, got %d plus %+v for %d", total, quote.Subtotal, quote.Taxes, quote.Total)
+	}
+
+	res := post(fmt.Sprintf("/room/%s/book", room.ID.Hex()), body)
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("expected status %d, got %d", http.StatusCreated, res.StatusCode)
+	}
+	var booking types.Booking
+	if err := json.NewDecoder(res.Body).Decode(&booking); err != nil {
+		t.Fatal(err)
+	}
+	if booking.Price != total || len(booking.Taxes) != 3 {
+		t.Errorf("expected a booking of %d with 3 tax lines, got %d with %+v", total, booking.Price, booking.Taxes)
+	}
+
+	from := body.UntilDate.AddDate(0, 0, 1)
+	fixture := fixtures.AddBooking(db.Store, user.ID, room, from, from.AddDate(0, 0, 2), 2)
+	if fixture.Price != total || len(fixture.Taxes) != 3 {
+		t.Errorf("expected fixture bookings to be taxed the same, got %d with %+v", fixture.Price, fixture.Taxes)
+	}
+}
+
+func TestHoldRoom(t *testing.T) {
+	db := setup(t)
+	defer db.Drop(t)
+
+	user := fixtures.AddUser(db.Store, "test", "user", false)
+	userToken, _ := CreateUserToken(user)
+	hotel := fixtures.AddHotel(db.Store, "test hotel", "test address", 4, 2)
+	room := fixtures.AddRoom(db.Store, types.Double, 100, hotel.ID)
+
+	app := fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+	gateway := payments.NewFakeGateway()
+	roomHandler := NewRoomHandler(db.Store, gateway)
+	bookingHandler := NewBookingHandler(db.Store, gateway)
+	api := app.Group("/", JWTAuth(db.Store.User, db.Store.Session))
+	api.Post("/room/:id/book", roomHandler.HandleBookRoom)
+	api.Post("/room/:id/hold", roomHandler.HandleHoldRoom)
+	api.Post("/booking/:id/book", bookingHandler.HandleBookHold)
+
+	do := func(path string, body any) *http.Response {
+		b, _ := json.Marshal(body)
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(b))
+		req.Header.Add("Content-Type", "application/json")
+		req.Header.Add("Authorization", userToken)
+		res, _ := app.Test(req, testTimeout)
+		return res
+	}
+	hold := func(body types.BookingBody) *types.Booking {
+		res := do(fmt.Sprintf("/room/%s/hold", room.ID.Hex()), body)
+		if res.StatusCode != http.StatusCreated {
+			t.Fatalf("expected status %d, got %d", http.StatusCreated, res.StatusCode)
+		}
+		var booking types.Booking
+		if err := json.NewDecoder(res.Body).Decode(&booking); err != nil {
+			t.Fatal(err)
+		}
+		if booking.Status != types.BookingHeld || booking.ExpiresAt == nil {
+			t.Fatalf("expected a held booking with an expiry, got %s", booking.Status)
+		}
+		return &booking
+	}
+
+	first := types.BookingBody{
+		FromDate:  time.Now().AddDate(0, 0, 1),
+		UntilDate: time.Now().AddDate(0, 0, 3),
+		NumPeople: 2,
+	}
+	held := hold(first)
+	if res := do(fmt.Sprintf("/room/%s/book", room.ID.Hex()), first); res.StatusCode != http.StatusConflict {
+		t.Fatalf("expected held nights to be unavailable, got status %d", res.StatusCode)
+	}
+	if res := do(fmt.Sprintf("/booking/%s/book", held.ID.Hex()), nil); res.StatusCode != http.StatusOK {
+		t.Fatalf("expected the hold to be booked, got status %d", res.StatusCode)
+	}
+
+	second := types.BookingBody{
+		FromDate:  time.Now().AddDate(0, 0, 5),
+		UntilDate: time.Now().AddDate(0, 0, 7),
+		NumPeople: 2,
+	}
+	expiring := hold(second)
+	expired, err := db.Store.Booking.ExpireHolds(context.TODO(), time.Now().Add(holdTTL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expired != 1 {
+		t.Fatalf("expected 1 hold to expire, got %d", expired)
+	}
+	if res := do(fmt.Sprintf("/booking/%s/book", expiring.ID.Hex()), nil); res.StatusCode != http.StatusConflict {
+		t.Fatalf("expected the expired hold not to be booked, got status %d", res.StatusCode)
+	}
+	if res := do(fmt.Sprintf("/room/%s/book", room.ID.Hex()), second); res.StatusCode != http.StatusCreated {
+		t.Fatalf("expected the expired nights to be released, got status %d", res.StatusCode)
+	}
+
+	// holds that ran out don't block their nights before they are swept
+	third := types.BookingBody{
+		FromDate:  time.Now().AddDate(0, 0, 9),
+		UntilDate: time.Now().AddDate(0, 0, 11),
+		NumPeople: 2,
+	}
+	ranOut := time.Now().Add(-time.Minute)
+	unswept := &types.Booking{RoomID: room.ID, FromDate: third.FromDate, UntilDate: third.UntilDate, ExpiresAt: &ranOut}
+	unswept.SetStatus(types.BookingHeld, time.Now().Add(-holdTTL))
+	if err := db.Store.Booking.ReserveBooking(context.TODO(), unswept); err != nil {
+		t.Fatal(err)
+	}
+	conflicts, err := db.Store.Booking.FilterBookings(context.TODO(), third.CreateAvailabilityFilter(room.ID, time.Now()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(conflicts) != 0 {
+		t.Fatalf("expected the unswept hold not to conflict, got %d conflicts", len(conflicts))
+	}
+	if res := do(fmt.Sprintf("/room/%s/book", room.ID.Hex()), third); res.StatusCode != http.StatusCreated {
+		t.Fatalf("expected the nights of the unswept hold to be booked, got status %d", res.StatusCode)
+	}
+}
@@ -2,45 +2,54 @@ package api
 
 import (
 	"net/http"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/xV0lk/hotel-reservations/db"
+	"github.com/xV0lk/hotel-reservations/mail"
 	"github.com/xV0lk/hotel-reservations/types"
 	"go.mongodb.org/mongo-driver/mongo"
 )
 
 type UserHandler struct {
-	userStore db.UserStore
+	store  *db.Store
+	mailer mail.Mailer
 }
 
-func NewUserHandler(userStore db.UserStore) *UserHandler {
+func NewUserHandler(store *db.Store, mailer mail.Mailer) *UserHandler {
 	return &UserHandler{
-		userStore: userStore,
+		store:  store,
+		mailer: mailer,
 	}
 }
 
-// Get all users
+// Get a single user with the id
 func (h *UserHandler) HandleGetUser(c *fiber.Ctx) error {
 	var id = c.Params("id")
-	user, err := h.userStore.GetUserById(c.Context(), id)
+	user, err := h.store.User.GetUserById(c.UserContext(), id)
 	if err != nil {
 		return ErrNotFound()
 	}
 	return c.JSON(user)
 }
 
-// Get a single user with the id
+// Get a page of users matching the query filters
 func (h *UserHandler) HandleGetUsers(c *fiber.Ctx) error {
-	users, err := h.userStore.GetUsers(c.Context())
+	var query types.UserQuery
+	page, err := parseListQuery(c, &query, types.UserSortFields)
 	if err != nil {
-		return ErrInternal()
+		return err
+	}
+	users, err := h.store.User.ListUsers(c.UserContext(), query.CreateFilter(), page)
+	if err != nil {
+		return listError(err)
 	}
 	return c.JSON(users)
 }
 
 func (h *UserHandler) HandleDeleteUser(c *fiber.Ctx) error {
 	var id = c.Params("id")
-	err := h.userStore.DeleteUser(c.Context(), id)
+	err := h.store.User.DeleteUser(c.UserContext(), id)
 	if err != nil {
 		return ErrBadRequest()
 	}
@@ -48,6 +57,8 @@ func (h *UserHandler) HandleDeleteUser(c *fiber.Ctx) error {
 	return c.JSON(fiber.Map{"message": "User deleted successfully!"})
 }
 
+// HandlePostUser creates a user on behalf of an admin, who can make it an
+// admin or mark its email as verified
 func (h *UserHandler) HandlePostUser(c *fiber.Ctx) error {
 	var newUser *types.NewUserParams
 	if err := c.BodyParser(&newUser); err != nil {
@@ -56,20 +67,52 @@ func (h *UserHandler) HandlePostUser(c *fiber.Ctx) error {
 	if errors := newUser.Validate(); len(errors) != 0 {
 		return NewMapError(http.StatusBadRequest, errors)
 	}
-	if err := validateAdminCreation(c, newUser); err != nil {
-		return NewError(http.StatusTeapot, err.Error())
+	iUser, ok := c.Context().UserValue("user").(*types.User)
+	if !ok {
+		return ErrInternal()
 	}
+	if err := validateAdminCreation(iUser, newUser); err != nil {
+		return err
+	}
+	return h.createUser(c, newUser)
+}
+
+// HandleRegister signs a new guest up. Anyone can register, so the account
+// can't be an admin nor start verified.
+func (h *UserHandler) HandleRegister(c *fiber.Ctx) error {
+	var newUser types.NewUserParams
+	if err := c.BodyParser(&newUser); err != nil {
+		return ErrBadRequest()
+	}
+	if errors := newUser.Validate(); len(errors) != 0 {
+		return NewMapError(http.StatusBadRequest, errors)
+	}
+	if err := validateAdminCreation(nil, &newUser); err != nil {
+		return err
+	}
+	return h.createUser(c, &newUser)
+}
+
+func (h *UserHandler) createUser(c *fiber.Ctx, newUser *types.NewUserParams) error {
 	user, err := types.NewUserFromParams(newUser)
 	if err != nil {
 		return ErrInternal()
 	}
-	err = h.userStore.InsertUser(c.Context(), user)
+	err = h.store.User.InsertUser(c.UserContext(), user)
 	if err != nil {
 		if mongo.IsDuplicateKeyError(err) {
 			return NewError(http.StatusBadRequest, "Email already exists")
 		}
 		return ErrBadRequest()
 	}
+	if !user.EmailVerified {
+		// the user is removed so they can register again if the
+		// verification can't be sent
+		if err := sendVerification(c.UserContext(), h.store, h.mailer, user); err != nil {
+			h.store.User.DeleteUser(c.UserContext(), user.ID.Hex())
+			return ErrInternal()
+		}
+	}
 	return c.Status(http.StatusCreated).JSON(user)
 }
 
@@ -92,20 +135,99 @@ func (h *UserHandler) HandlePutUser(c *fiber.Ctx) error {
 	if errors := updateUser.Validate(); len(errors) != 0 {
 		return NewMapError(http.StatusBadRequest, errors)
 	}
-	updated, err := h.userStore.UpdateUser(c.Context(), id, updateUser)
+	updated, err := h.store.User.UpdateUser(c.UserContext(), id, updateUser)
 	if err != nil {
 		return ErrInternal()
 	}
 	return c.JSON(updated)
 }
 
-func validateAdminCreation(c *fiber.Ctx, nu *types.NewUserParams) error {
-	// Get user
-	iUser, ok := c.Context().UserValue("user").(*types.User)
+// HandleGetMe returns the logged in user
+func (h *UserHandler) HandleGetMe(c *fiber.Ctx) error {
+	user, ok := c.Context().UserValue("user").(*types.User)
+	if !ok {
+		return ErrInternal()
+	}
+	return c.JSON(user)
+}
+
+// HandlePatchMe updates the profile of the logged in user. Changing the
+// password ends every session of the user, as a password reset does, so
+// they have to log in again.
+func (h *UserHandler) HandlePatchMe(c *fiber.Ctx) error {
+	user, ok := c.Context().UserValue("user").(*types.User)
 	if !ok {
 		return ErrInternal()
 	}
-	if !iUser.IsAdmin && nu.IsAdmin {
+	var params types.UpdateMeParams
+	if err := c.BodyParser(&params); err != nil {
+		return ErrBadRequest()
+	}
+	if errors := params.Validate(); len(errors) != 0 {
+		return NewMapError(http.StatusBadRequest, errors)
+	}
+	id := user.ID.Hex()
+	if params.Password != "" {
+		stored, err := h.store.User.GetUserById(c.UserContext(), id)
+		if err != nil {
+			return ErrInternal()
+		}
+		if !types.IsValidPassword(params.CurrentPassword, stored.Password) {
+			return NewMapError(http.StatusBadRequest, map[string]string{"currentPassword": "the current password is incorrect"})
+		}
+		hash, err := types.HashPassword(params.Password)
+		if err != nil {
+			return ErrInternal()
+		}
+		now := time.Now()
+		if err := h.store.User.UpdatePassword(c.UserContext(), id, hash, now); err != nil {
+			return ErrInternal()
+		}
+		if err := h.store.Session.RevokeUserSessions(c.UserContext(), user.ID, now); err != nil {
+			return ErrInternal()
+		}
+	}
+	if len(params.ToBson()) == 0 {
+		updated, err := h.store.User.GetUserById(c.UserContext(), id)
+		if err != nil {
+			return ErrInternal()
+		}
+		return c.JSON(updated)
+	}
+	updated, err := h.store.User.UpdateUser(c.UserContext(), id, &params.UpdateUserParams)
+	if err != nil {
+		return ErrInternal()
+	}
+	return c.JSON(updated)
+}
+
+// HandleDeleteMe deletes the account of the logged in user, ending their
+// sessions
+func (h *UserHandler) HandleDeleteMe(c *fiber.Ctx) error {
+	user, ok := c.Context().UserValue("user").(*types.User)
+	if !ok {
+		return ErrInternal()
+	}
+	claims, ok := c.Context().UserValue("claims").(*AccessClaims)
+	if !ok {
+		return ErrInternal()
+	}
+	if err := h.store.User.DeleteUser(c.UserContext(), user.ID.Hex()); err != nil {
+		return ErrInternal()
+	}
+	if err := h.store.Session.RevokeUserSessions(c.UserContext(), user.ID, time.Now()); err != nil {
+		return ErrInternal()
+	}
+	if err := revokeToken(c.UserContext(), h.store.Session, claims); err != nil {
+		return ErrInternal()
+	}
+	return c.JSON(fiber.Map{"message": "User deleted successfully!"})
+}
+
+// validateAdminCreation checks that only admins create admins or users with
+// a verified email. creator is nil for users registering themselves.
+func validateAdminCreation(creator *types.User, nu *types.NewUserParams) error {
+	if (creator == nil || !creator.IsAdmin) && (nu.IsAdmin || nu.Verified) {
 		return ErrForbidden()
 	}
 	return nil
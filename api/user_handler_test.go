@@ -2,10 +2,14 @@ package api
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"regexp"
 	"testing"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/xV0lk/hotel-reservations/db"
@@ -18,8 +22,8 @@ func TestPostUser(t *testing.T) {
 	defer tdb.Drop(t)
 
 	app := fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
-	userHandler := NewUserHandler(tdb.Store.User)
-	api := app.Group("/", JWTAuth(tdb.Store.User))
+	userHandler := NewUserHandler(tdb.Store, newOutbox(t))
+	api := app.Group("/", JWTAuth(tdb.Store.User, tdb.Store.Session))
 	api.Post("/", userHandler.HandlePostUser)
 
 	for _, tc := range userTests {
@@ -37,7 +41,7 @@ func (tc testCase[userTest]) testUser(t *testing.T, app *fiber.App, store *db.St
 	req := httptest.NewRequest("POST", "/", bytes.NewReader(b))
 	req.Header.Add("Content-Type", "application/json")
 	req.Header.Add("Authorization", userToken)
-	res, _ := app.Test(req)
+	res, _ := app.Test(req, testTimeout)
 	if res.StatusCode != tc.status {
 		t.Fatalf("expected status %d, got %d", tc.status, res.StatusCode)
 	}
@@ -108,3 +112,185 @@ var userTests = []testCase[userTest]{
 		},
 	},
 }
+
+func TestEmailVerification(t *testing.T) {
+	tdb := setup(t)
+	defer tdb.Drop(t)
+	admin := fixtures.AddUser(tdb.Store, "admin", "user", true)
+	adminToken, _ := CreateUserToken(admin)
+	outbox := newOutbox(t)
+
+	app := fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+	userHandler := NewUserHandler(tdb.Store, outbox)
+	authHandler := NewAuthHandler(tdb.Store, outbox)
+	app.Post("/user", JWTAuth(tdb.Store.User, tdb.Store.Session), userHandler.HandlePostUser)
+	app.Get("/api/auth/verify", authHandler.HandleVerifyEmail)
+	app.Post("/auth/verify/resend", JWTAuth(tdb.Store.User, tdb.Store.Session), authHandler.HandleResendVerification)
+	app.Post("/book", JWTAuth(tdb.Store.User, tdb.Store.Session), VerifiedAuth, func(c *fiber.Ctx) error {
+		return c.SendStatus(http.StatusOK)
+	})
+
+	request := func(method, path, token string, body any) *http.Response {
+		b, _ := json.Marshal(body)
+		req := httptest.NewRequest(method, path, bytes.NewReader(b))
+		req.Header.Add("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Add("Authorization", token)
+		}
+		res, _ := app.Test(req, testTimeout)
+		return res
+	}
+	expectStatus := func(name string, res *http.Response, status int) {
+		t.Helper()
+		if res.StatusCode != status {
+			t.Fatalf("%s: expected status %d, got %d", name, status, res.StatusCode)
+		}
+	}
+	lastLink := func(count int) string {
+		t.Helper()
+		msgs, err := outbox.Messages()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(msgs) != count {
+			t.Fatalf("expected %d emails, got %d", count, len(msgs))
+		}
+		link := regexp.MustCompile(`https?://\S+`).FindString(msgs[count-1].Body)
+		u, err := url.Parse(link)
+		if err != nil || link == "" {
+			t.Fatalf("expected a verification link in %q", msgs[count-1].Body)
+		}
+		return u.RequestURI()
+	}
+
+	params := types.NewUserParams{FirstName: "New", LastName: "Guest", Email: "new@guest.com", Password: "N3w_guest!"}
+	res := request(http.MethodPost, "/user", adminToken, params)
+	expectStatus("register", res, http.StatusCreated)
+	var user types.User
+	if err := json.NewDecoder(res.Body).Decode(&user); err != nil {
+		t.Fatal(err)
+	}
+	if user.EmailVerified {
+		t.Fatal("expected the new user to be unverified")
+	}
+	first := lastLink(1)
+	userToken, _ := CreateUserToken(&user)
+	expectStatus("unverified booking", request(http.MethodPost, "/book", userToken, nil), http.StatusForbidden)
+
+	expectStatus("resend", request(http.MethodPost, "/auth/verify/resend", userToken, nil), http.StatusOK)
+	second := lastLink(2)
+	expectStatus("forged token", request(http.MethodGet, "/api/auth/verify?token=forged", "", nil), http.StatusBadRequest)
+	expectStatus("verify", request(http.MethodGet, second, "", nil), http.StatusOK)
+	expectStatus("reused link", request(http.MethodGet, second, "", nil), http.StatusBadRequest)
+	// the first link still works until it expires, without harm
+	expectStatus("first link", request(http.MethodGet, first, "", nil), http.StatusOK)
+	expectStatus("verified booking", request(http.MethodPost, "/book", userToken, nil), http.StatusOK)
+	expectStatus("resend verified", request(http.MethodPost, "/auth/verify/resend", userToken, nil), http.StatusConflict)
+
+	// only admins create pre-verified users, without any email
+	params = types.NewUserParams{FirstName: "Pre", LastName: "Verified", Email: "pre@verified.com", Password: "Pr3_verified!", Verified: true}
+	expectStatus("pre-verified by a user", request(http.MethodPost, "/user", userToken, params), http.StatusForbidden)
+	res = request(http.MethodPost, "/user", adminToken, params)
+	expectStatus("pre-verified by an admin", res, http.StatusCreated)
+	if err := json.NewDecoder(res.Body).Decode(&user); err != nil {
+		t.Fatal(err)
+	}
+	if !user.EmailVerified {
+		t.Error("expected the user to be verified")
+	}
+	lastLink(2)
+}
+
+func TestRegisterAndMe(t *testing.T) {
+	tdb := setup(t)
+	defer tdb.Drop(t)
+	if err := tdb.Store.User.IndexEmail(context.TODO()); err != nil {
+		t.Fatal(err)
+	}
+	outbox := newOutbox(t)
+
+	app := fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+	userHandler := NewUserHandler(tdb.Store, outbox)
+	app.Post("/register", userHandler.HandleRegister)
+	me := app.Group("/me", JWTAuth(tdb.Store.User, tdb.Store.Session))
+	me.Get("/", userHandler.HandleGetMe)
+	me.Patch("/", userHandler.HandlePatchMe)
+	me.Delete("/", userHandler.HandleDeleteMe)
+
+	request := func(method, path, token string, body any) *http.Response {
+		b, _ := json.Marshal(body)
+		req := httptest.NewRequest(method, path, bytes.NewReader(b))
+		req.Header.Add("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Add("Authorization", token)
+		}
+		res, _ := app.Test(req, testTimeout)
+		return res
+	}
+	decodeUser := func(name string, res *http.Response, status int) *types.User {
+		t.Helper()
+		if res.StatusCode != status {
+			t.Fatalf("%s: expected status %d, got %d", name, status, res.StatusCode)
+		}
+		var user types.User
+		if err := json.NewDecoder(res.Body).Decode(&user); err != nil {
+			t.Fatal(err)
+		}
+		return &user
+	}
+	expectStatus := func(name string, res *http.Response, status int) {
+		t.Helper()
+		if res.StatusCode != status {
+			t.Fatalf("%s: expected status %d, got %d", name, status, res.StatusCode)
+		}
+	}
+
+	params := types.NewUserParams{FirstName: "New", LastName: "Guest", Email: "new@guest.com", Password: "N3w_guest!"}
+	admin, verified := params, params
+	admin.IsAdmin = true
+	verified.Verified = true
+	expectStatus("admin", request(http.MethodPost, "/register", "", admin), http.StatusForbidden)
+	expectStatus("pre-verified", request(http.MethodPost, "/register", "", verified), http.StatusForbidden)
+	expectStatus("invalid", request(http.MethodPost, "/register", "", types.NewUserParams{Email: "new"}), http.StatusBadRequest)
+	user := decodeUser("register", request(http.MethodPost, "/register", "", params), http.StatusCreated)
+	if user.IsAdmin || user.EmailVerified {
+		t.Fatalf("expected an unverified guest, got %+v", user)
+	}
+	if msgs, _ := outbox.Messages(); len(msgs) != 1 {
+		t.Fatalf("expected a verification email, got %d", len(msgs))
+	}
+	expectStatus("duplicate", request(http.MethodPost, "/register", "", params), http.StatusBadRequest)
+
+	token, _ := CreateUserToken(user)
+	if got := decodeUser("get me", request(http.MethodGet, "/me", token, nil), http.StatusOK); got.ID != user.ID {
+		t.Errorf("expected user %s, got %s", user.ID.Hex(), got.ID.Hex())
+	}
+	expectStatus("empty patch", request(http.MethodPatch, "/me", token, fiber.Map{}), http.StatusBadRequest)
+	if got := decodeUser("patch me", request(http.MethodPatch, "/me", token, fiber.Map{"firstName": "Renamed"}), http.StatusOK); got.FirstName != "Renamed" || got.LastName != "Guest" {
+		t.Errorf("expected the first name to change, got %+v", got)
+	}
+
+	newPassword := "An0ther_pass!"
+	expectStatus("missing current password", request(http.MethodPatch, "/me", token, fiber.Map{"password": newPassword}), http.StatusBadRequest)
+	expectStatus("wrong current password", request(http.MethodPatch, "/me", token, fiber.Map{"password": newPassword, "currentPassword": "Wr0ng_pass!"}), http.StatusBadRequest)
+	expectStatus("weak password", request(http.MethodPatch, "/me", token, fiber.Map{"password": "weak", "currentPassword": params.Password}), http.StatusBadRequest)
+	decodeUser("change password", request(http.MethodPatch, "/me", token, fiber.Map{"password": newPassword, "currentPassword": params.Password}), http.StatusOK)
+	stored, err := tdb.Store.User.GetUserById(context.TODO(), user.ID.Hex())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !types.IsValidPassword(newPassword, stored.Password) {
+		t.Error("expected the password to change")
+	}
+
+	expectStatus("token before password change", request(http.MethodGet, "/me", token, nil), http.StatusUnauthorized)
+
+	// tokens are only accepted from the second after the password change
+	time.Sleep(time.Until(stored.PasswordChangedAt.Truncate(time.Second).Add(time.Second)))
+	token, _ = CreateUserToken(user)
+	expectStatus("delete me", request(http.MethodDelete, "/me", token, nil), http.StatusOK)
+	expectStatus("deleted", request(http.MethodGet, "/me", token, nil), http.StatusUnauthorized)
+	if _, err := tdb.Store.User.GetUserById(context.TODO(), user.ID.Hex()); err == nil {
+		t.Error("expected the user to be deleted")
+	}
+}
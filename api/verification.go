@@ -0,0 +1,76 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/xV0lk/hotel-reservations/db"
+	"github.com/xV0lk/hotel-reservations/mail"
+	"github.com/xV0lk/hotel-reservations/types"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// emailVerificationTTL is how long the link mailed to verify an
+	// email address works, a new one can be asked for afterwards
+	emailVerificationTTL = 24 * time.Hour
+	defaultAppURL        = "http://localhost:3000"
+)
+
+func ErrEmailNotVerified() Error {
+	return NewError(http.StatusForbidden, "Verify your email address before booking")
+}
+
+// VerifiedAuth only lets through the users who verified their email
+func VerifiedAuth(c *fiber.Ctx) error {
+	user, ok := c.Context().UserValue("user").(*types.User)
+	if !ok {
+		return ErrInternal()
+	}
+	if !user.EmailVerified {
+		return ErrEmailNotVerified()
+	}
+	return c.Next()
+}
+
+// sendVerification mails the user a link to verify their email address
+func sendVerification(ctx context.Context, store *db.Store, mailer mail.Mailer, user *types.User) error {
+	now := time.Now()
+	verification := &types.EmailVerification{
+		ID:        primitive.NewObjectID(),
+		UserID:    user.ID,
+		CreatedAt: now,
+		ExpiresAt: now.Add(emailVerificationTTL),
+	}
+	token, hash, err := types.NewVerificationToken(verification.ID)
+	if err != nil {
+		return err
+	}
+	verification.TokenHash = hash
+	if err := store.EmailVerification.InsertEmailVerification(ctx, verification); err != nil {
+		return err
+	}
+	return mailer.Send(ctx, mail.Message{
+		To:      user.Email,
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf("Hi %s,\n\n"+
+			"Follow this link in the next %d hours to verify your email address:\n\n%s\n\n"+
+			"You will be able to book once it is verified.\n",
+			user.FirstName, int(emailVerificationTTL.Hours()), verificationURL(token)),
+	})
+}
+
+// verificationURL is the link of the verify endpoint on APP_URL
+func verificationURL(token string) string {
+	base := os.Getenv("APP_URL")
+	if base == "" {
+		base = defaultAppURL
+	}
+	return strings.TrimRight(base, "/") + "/api/auth/verify?token=" + url.QueryEscape(token)
+}
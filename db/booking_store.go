@@ -2,60 +2,327 @@ package db
 
 import (
 	"context"
-	"fmt"
+	"errors"
+	"time"
 
-	"github.com/valyala/fasthttp"
 	"github.com/xV0lk/hotel-reservations/types"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-const bookingColl = "bookings"
+const (
+	bookingColl   = "bookings"
+	roomNightColl = "roomNights"
+	groupColl     = "groups"
+)
+
+// ErrRoomUnavailable is returned by ReserveBooking when at least one of the
+// requested nights is already taken by another booking.
+var ErrRoomUnavailable = errors.New("room is not available for the selected dates")
+
+// ErrInvalidTransition is returned by TransitionBooking when the booking
+// can't move from its current status to the requested one.
+var ErrInvalidTransition = errors.New("invalid booking status transition")
 
 type BookingStore interface {
+	IndexRoomNights(ctx context.Context) error
 	InsertBooking(ctx context.Context, booking *types.Booking) error
-	FilterBookings(ctx *fasthttp.RequestCtx, filter bson.M) ([]*types.Booking, error)
-	GetBookings(ctx *fasthttp.RequestCtx) ([]*types.Booking, error)
-	GetBookingById(ctx *fasthttp.RequestCtx, id string) (*types.Booking, error)
-	CancelBooking(ctx *fasthttp.RequestCtx, id string) (*types.Booking, error)
+	ReserveBooking(ctx context.Context, booking *types.Booking) error
+	ReserveGroup(ctx context.Context, group *types.GroupBooking, bookings []*types.Booking) error
+	GetGroupById(ctx context.Context, id string) (*types.GroupBooking, error)
+	ReserveInventory(ctx context.Context, booking *types.Booking, rooms []primitive.ObjectID) error
+	AssignRoom(ctx context.Context, id string, roomID primitive.ObjectID) (*types.Booking, error)
+	CountRoomNights(ctx context.Context, rooms []primitive.ObjectID, nights []string) (map[string]int, error)
+	FilterBookings(ctx context.Context, filter bson.M) ([]*types.Booking, error)
+	ListBookings(ctx context.Context, filter bson.M, page types.PageParams) (*types.Page[types.Booking], error)
+	GetBookingById(ctx context.Context, id string) (*types.Booking, error)
+	TransitionBooking(ctx context.Context, id string, change types.StatusChange) (*types.Booking, error)
+	CancelBooking(ctx context.Context, id string, cancellation *types.Cancellation) (*types.Booking, error)
+	SetRefunded(ctx context.Context, id string, at time.Time) (*types.Booking, error)
+	ModifyBooking(ctx context.Context, booking *types.Booking, change types.BookingChange) (*types.Booking, error)
+	IssueInvoiceNumber(ctx context.Context, id string, hotelID primitive.ObjectID) (*types.Booking, error)
+	ExpireHolds(ctx context.Context, now time.Time) (int, error)
+	MigrateBookings(ctx context.Context) (int, error)
 }
 
 type MongoBookingStore struct {
 	client *mongo.Client
 	coll   *mongo.Collection
+	nights *mongo.Collection
+	groups *mongo.Collection
+	promos *mongo.Collection
+	hotels *mongo.Collection
+}
+
+// roomNight locks a single night of a room for a booking. The unique index on
+// (roomID, night) is what makes ReserveBooking race-free.
+type roomNight struct {
+	RoomID    primitive.ObjectID `bson:"roomID"`
+	Night     string             `bson:"night"`
+	BookingID primitive.ObjectID `bson:"bookingID"`
 }
 
 func NewMongoBookingStore(client *mongo.Client, dbname string) *MongoBookingStore {
 	return &MongoBookingStore{
 		client: client,
 		coll:   client.Database(dbname).Collection(bookingColl),
+		nights: client.Database(dbname).Collection(roomNightColl),
+		groups: client.Database(dbname).Collection(groupColl),
+		promos: client.Database(dbname).Collection(promoColl),
+		hotels: client.Database(dbname).Collection(hotelColl),
 	}
 }
 
-func (s *MongoBookingStore) InsertBooking(ctx context.Context, booking *types.Booking) error {
-	result, err := s.coll.InsertOne(ctx, booking)
+func (s *MongoBookingStore) IndexRoomNights(ctx context.Context) error {
+	_, err := s.nights.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "roomID", Value: 1}, {Key: "night", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	return err
+}
+
+// ReserveBooking inserts the booking only if none of its nights are already
+// locked for the room. The nights are claimed first; if any of them is taken
+// the partial claim is rolled back and ErrRoomUnavailable is returned.
+// Bookings without an id are given a new one.
+func (s *MongoBookingStore) ReserveBooking(ctx context.Context, booking *types.Booking) error {
+	if booking.ID.IsZero() {
+		booking.ID = primitive.NewObjectID()
+	}
+	var documents []interface{}
+	for _, night := range booking.Nights() {
+		documents = append(documents, roomNight{
+			RoomID:    booking.RoomID,
+			Night:     night,
+			BookingID: booking.ID,
+		})
+	}
+	if len(documents) == 0 {
+		return ErrRoomUnavailable
+	}
+	if _, err := s.nights.InsertMany(ctx, documents); err != nil {
+		s.releaseNights(ctx, booking.ID)
+		if mongo.IsDuplicateKeyError(err) {
+			return ErrRoomUnavailable
+		}
+		return err
+	}
+	if _, err := s.coll.InsertOne(ctx, booking); err != nil {
+		s.releaseNights(ctx, booking.ID)
+		return err
+	}
+	return nil
+}
+
+// ReserveGroup reserves all the bookings of the group or none of them. The
+// nights of every booking are claimed together, and released again if any
+// of them is taken.
+func (s *MongoBookingStore) ReserveGroup(ctx context.Context, group *types.GroupBooking, bookings []*types.Booking) error {
+	group.ID = primitive.NewObjectID()
+	group.BookingIDs = []primitive.ObjectID{}
+	var documents []interface{}
+	for _, booking := range bookings {
+		if booking.ID.IsZero() {
+			booking.ID = primitive.NewObjectID()
+		}
+		booking.GroupID = group.ID
+		group.BookingIDs = append(group.BookingIDs, booking.ID)
+		for _, night := range booking.Nights() {
+			documents = append(documents, roomNight{
+				RoomID:    booking.RoomID,
+				Night:     night,
+				BookingID: booking.ID,
+			})
+		}
+	}
+	if len(documents) == 0 {
+		return ErrRoomUnavailable
+	}
+	release := func() {
+		s.nights.DeleteMany(ctx, bson.M{"bookingID": bson.M{"$in": group.BookingIDs}})
+	}
+	if _, err := s.nights.InsertMany(ctx, documents); err != nil {
+		release()
+		if mongo.IsDuplicateKeyError(err) {
+			return ErrRoomUnavailable
+		}
+		return err
+	}
+	var docs []interface{}
+	for _, booking := range bookings {
+		docs = append(docs, booking)
+	}
+	if _, err := s.coll.InsertMany(ctx, docs); err != nil {
+		s.coll.DeleteMany(ctx, bson.M{"groupID": group.ID})
+		release()
+		return err
+	}
+	if _, err := s.groups.InsertOne(ctx, group); err != nil {
+		s.coll.DeleteMany(ctx, bson.M{"groupID": group.ID})
+		release()
+		return err
+	}
+	group.Bookings = bookings
+	return nil
+}
+
+// GetGroupById returns the group together with its bookings
+func (s *MongoBookingStore) GetGroupById(ctx context.Context, id string) (*types.GroupBooking, error) {
+	var group *types.GroupBooking
+	oId, _ := primitive.ObjectIDFromHex(id)
+	if err := s.groups.FindOne(ctx, bson.M{"_id": oId}).Decode(&group); err != nil {
+		return nil, err
+	}
+	bookings, err := s.FilterBookings(ctx, bson.M{"groupID": group.ID})
 	if err != nil {
+		return nil, err
+	}
+	group.Bookings = bookings
+	return group, nil
+}
+
+// ReserveInventory reserves a room type booking on the first of the rooms
+// free for the whole stay, which are tried in order. Guests aren't moved
+// between rooms, so the booking fails when no single room is free on all of
+// its nights, even if some room is free on each of them.
+func (s *MongoBookingStore) ReserveInventory(ctx context.Context, booking *types.Booking, rooms []primitive.ObjectID) error {
+	if booking.ID.IsZero() {
+		booking.ID = primitive.NewObjectID()
+	}
+	booking.AllocatedRoom = primitive.NilObjectID
+	nights := booking.Nights()
+	if len(nights) == 0 || len(rooms) == 0 {
+		return ErrRoomUnavailable
+	}
+	claim := func(roomID primitive.ObjectID) (bool, error) {
+		var documents []interface{}
+		for _, night := range nights {
+			documents = append(documents, roomNight{RoomID: roomID, Night: night, BookingID: booking.ID})
+		}
+		if _, err := s.nights.InsertMany(ctx, documents); err != nil {
+			s.nights.DeleteMany(ctx, bson.M{"bookingID": booking.ID, "roomID": roomID})
+			if mongo.IsDuplicateKeyError(err) {
+				return false, nil
+			}
+			return false, err
+		}
+		return true, nil
+	}
+	if err := AllocateRoom(booking, rooms, claim); err != nil {
+		s.releaseNights(ctx, booking.ID)
+		return err
+	}
+	if _, err := s.coll.InsertOne(ctx, booking); err != nil {
+		s.releaseNights(ctx, booking.ID)
 		return err
 	}
-	booking.ID = result.InsertedID.(primitive.ObjectID)
 	return nil
 }
 
-func (s *MongoBookingStore) GetBookings(ctx *fasthttp.RequestCtx) ([]*types.Booking, error) {
-	var bookings []*types.Booking
-	cursor, err := s.coll.Find(ctx, bson.M{})
+// AssignRoom moves all the nights of the booking to the room, claiming the
+// ones it doesn't hold there yet. It is used both to assign a room to room
+// type bookings and to move bookings to another room of the same type.
+func (s *MongoBookingStore) AssignRoom(ctx context.Context, id string, roomID primitive.ObjectID) (*types.Booking, error) {
+	booking, err := s.GetBookingById(ctx, id)
 	if err != nil {
 		return nil, err
 	}
-	if err := cursor.All(ctx, &bookings); err != nil {
+	if !booking.Status.IsActive() {
+		return nil, ErrInvalidTransition
+	}
+	held, err := s.lockedNights(ctx, bson.M{"bookingID": booking.ID, "roomID": roomID})
+	if err != nil {
 		return nil, err
 	}
-	return bookings, nil
+	claimed := UnheldNights(booking, held)
+	var documents []interface{}
+	for _, night := range claimed {
+		documents = append(documents, roomNight{RoomID: roomID, Night: night, BookingID: booking.ID})
+	}
+	assigned := *booking
+	assigned.RoomID = roomID
+	release := func() {
+		s.nights.DeleteMany(ctx, ClaimedNightsFilter(&assigned, claimed))
+	}
+	if len(documents) != 0 {
+		if _, err := s.nights.InsertMany(ctx, documents); err != nil {
+			release()
+			if mongo.IsDuplicateKeyError(err) {
+				return nil, ErrRoomUnavailable
+			}
+			return nil, err
+		}
+	}
+	filter := bson.M{"_id": booking.ID, "status": bson.M{"$in": types.ActiveBookingStatuses}}
+	update := bson.M{
+		"$set":   bson.M{"roomID": roomID},
+		"$unset": bson.M{"allocatedRoom": ""},
+	}
+	result, err := s.coll.UpdateOne(ctx, filter, update)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	if result.MatchedCount == 0 {
+		release()
+		return nil, ErrInvalidTransition
+	}
+	if _, err := s.nights.DeleteMany(ctx, StaleNightsFilter(&assigned)); err != nil {
+		return nil, err
+	}
+	return s.GetBookingById(ctx, id)
+}
+
+// CountRoomNights returns, for every night, how many of the rooms are taken.
+// The night locks cover every active booking, MigrateBookings locks the
+// nights of the ones made before bookings locked them.
+func (s *MongoBookingStore) CountRoomNights(ctx context.Context, rooms []primitive.ObjectID, nights []string) (map[string]int, error) {
+	locks, err := s.lockedNights(ctx, bson.M{"roomID": bson.M{"$in": rooms}, "night": bson.M{"$in": nights}})
+	if err != nil {
+		return nil, err
+	}
+	return CountNights(locks), nil
+}
+
+// lockedNights returns the nights of the locks matching the filter
+func (s *MongoBookingStore) lockedNights(ctx context.Context, filter bson.M) ([]string, error) {
+	var locks []roomNight
+	cursor, err := s.nights.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	if err := cursor.All(ctx, &locks); err != nil {
+		return nil, err
+	}
+	nights := []string{}
+	for _, lock := range locks {
+		nights = append(nights, lock.Night)
+	}
+	return nights, nil
+}
+
+func (s *MongoBookingStore) releaseNights(ctx context.Context, bookingID primitive.ObjectID) error {
+	_, err := s.nights.DeleteMany(ctx, bson.M{"bookingID": bookingID})
+	return err
+}
+
+func (s *MongoBookingStore) InsertBooking(ctx context.Context, booking *types.Booking) error {
+	result, err := s.coll.InsertOne(ctx, booking)
+	if err != nil {
+		return err
+	}
+	booking.ID = result.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+func (s *MongoBookingStore) ListBookings(ctx context.Context, filter bson.M, page types.PageParams) (*types.Page[types.Booking], error) {
+	return findPage[types.Booking](ctx, s.coll, filter, page)
 }
 
-func (s *MongoBookingStore) FilterBookings(ctx *fasthttp.RequestCtx, filter bson.M) ([]*types.Booking, error) {
+func (s *MongoBookingStore) FilterBookings(ctx context.Context, filter bson.M) ([]*types.Booking, error) {
 	var bookings []*types.Booking
 	cursor, err := s.coll.Find(ctx, filter)
 	if err != nil {
@@ -67,7 +334,7 @@ func (s *MongoBookingStore) FilterBookings(ctx *fasthttp.RequestCtx, filter bson
 	return bookings, nil
 }
 
-func (s *MongoBookingStore) GetBookingById(ctx *fasthttp.RequestCtx, id string) (*types.Booking, error) {
+func (s *MongoBookingStore) GetBookingById(ctx context.Context, id string) (*types.Booking, error) {
 	var booking *types.Booking
 	oId, _ := primitive.ObjectIDFromHex(id)
 	if err := s.coll.FindOne(ctx, bson.M{"_id": oId}).Decode(&booking); err != nil {
@@ -76,20 +343,359 @@ func (s *MongoBookingStore) GetBookingById(ctx *fasthttp.RequestCtx, id string)
 	return booking, nil
 }
 
-func (s *MongoBookingStore) CancelBooking(ctx *fasthttp.RequestCtx, id string) (*types.Booking, error) {
+// TransitionBooking moves the booking to change.Status if the transition is
+// allowed from its current status. The check and the update are a single
+// write, so concurrent transitions can't both succeed. Nights of bookings
+// that no longer hold their room are released, and so is the promo code of
+// cancelled bookings.
+func (s *MongoBookingStore) TransitionBooking(ctx context.Context, id string, change types.StatusChange) (*types.Booking, error) {
+	return s.transition(ctx, id, change, bson.M{})
+}
+
+// CancelBooking moves the booking to the status matching the cancellation,
+// storing its refund and penalty.
+func (s *MongoBookingStore) CancelBooking(ctx context.Context, id string, cancellation *types.Cancellation) (*types.Booking, error) {
+	change := types.StatusChange{Status: cancellation.Status(), At: cancellation.At}
+	return s.transition(ctx, id, change, bson.M{"cancellation": cancellation})
+}
+
+// SetRefunded records that the refund of the cancelled booking was made
+func (s *MongoBookingStore) SetRefunded(ctx context.Context, id string, at time.Time) (*types.Booking, error) {
+	filter, update := SetRefundedUpdate(id, at)
+	if _, err := s.coll.UpdateOne(ctx, filter, update); err != nil {
+		return nil, err
+	}
+	return s.GetBookingById(ctx, id)
+}
+
+// SetRefundedUpdate returns the filter and update recording the refund of
+// the cancelled booking, which only match while it is pending.
+func SetRefundedUpdate(id string, at time.Time) (bson.M, bson.M) {
 	objectId, _ := primitive.ObjectIDFromHex(id)
-	filter := bson.M{"_id": objectId}
-	update := bson.M{"$set": bson.M{"cancelled": true}}
+	filter := bson.M{"_id": objectId, "cancellation": bson.M{"$exists": true}, "cancellation.refundedAt": bson.M{"$exists": false}}
+	return filter, bson.M{"$set": bson.M{"cancellation.refundedAt": at}}
+}
+
+func (s *MongoBookingStore) transition(ctx context.Context, id string, change types.StatusChange, values bson.M) (*types.Booking, error) {
+	objectId, _ := primitive.ObjectIDFromHex(id)
+	filter := bson.M{"_id": objectId, "status": bson.M{"$in": types.TransitionSources(change.Status)}}
+	values["status"] = change.Status
+	update := bson.M{
+		"$set":  values,
+		"$push": bson.M{"history": change},
+	}
 	result, err := s.coll.UpdateOne(ctx, filter, update)
 	if err != nil {
 		return nil, err
 	}
 	if result.MatchedCount == 0 {
-		return nil, fmt.Errorf("no booking with id %s", id)
+		if _, err := s.GetBookingById(ctx, id); err != nil {
+			return nil, err
+		}
+		return nil, ErrInvalidTransition
+	}
+	if !change.Status.IsActive() {
+		if err := s.releaseNights(ctx, objectId); err != nil {
+			return nil, err
+		}
 	}
-	if updated, err := s.GetBookingById(ctx, id); err != nil {
+	booking, err := s.GetBookingById(ctx, id)
+	if err != nil {
 		return nil, err
-	} else {
-		return updated, nil
 	}
+	if booking.ReleasesPromo() {
+		if _, err := s.promos.UpdateOne(ctx, bson.M{"_id": booking.Promo.ID}, ReleasePromoUpdate(booking.UserID)); err != nil {
+			return nil, err
+		}
+	}
+	return booking, nil
+}
+
+// ModifyBooking moves the booking to the room, dates, guests and price of
+// booking, recording change. The nights the booking doesn't hold yet are
+// claimed first, so it can't take nights of another booking; the ones it
+// doesn't need anymore are released afterwards. Only pending and confirmed
+// bookings can be modified, otherwise ErrInvalidTransition is returned.
+func (s *MongoBookingStore) ModifyBooking(ctx context.Context, booking *types.Booking, change types.BookingChange) (*types.Booking, error) {
+	current, err := s.GetBookingById(ctx, booking.ID.Hex())
+	if err != nil {
+		return nil, err
+	}
+	claimed := NewNights(current, booking)
+	var documents []interface{}
+	for _, night := range claimed {
+		documents = append(documents, roomNight{
+			RoomID:    booking.RoomID,
+			Night:     night,
+			BookingID: booking.ID,
+		})
+	}
+	release := func() {
+		s.nights.DeleteMany(ctx, ClaimedNightsFilter(booking, claimed))
+	}
+	if len(documents) != 0 {
+		if _, err := s.nights.InsertMany(ctx, documents); err != nil {
+			release()
+			if mongo.IsDuplicateKeyError(err) {
+				return nil, ErrRoomUnavailable
+			}
+			return nil, err
+		}
+	}
+	filter, update := ModifyBookingUpdate(booking, change)
+	result, err := s.coll.UpdateOne(ctx, filter, update)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	if result.MatchedCount == 0 {
+		release()
+		return nil, ErrInvalidTransition
+	}
+	if _, err := s.nights.DeleteMany(ctx, StaleNightsFilter(booking)); err != nil {
+		return nil, err
+	}
+	return s.GetBookingById(ctx, booking.ID.Hex())
+}
+
+// IssueInvoiceNumber gives the booking the next invoice number of the hotel
+// unless it already has one, and returns the booking with its number. The
+// counter of the hotel is only advanced from the value just read, recording
+// the booking it was advanced for, so no number is issued twice. The booking
+// is given its number afterwards, by this or the next request, so no number
+// is skipped either.
+func (s *MongoBookingStore) IssueInvoiceNumber(ctx context.Context, id string, hotelID primitive.ObjectID) (*types.Booking, error) {
+	for {
+		booking, err := s.GetBookingById(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if booking.InvoiceNumber != 0 {
+			return booking, nil
+		}
+		var counter InvoiceCounter
+		opts := options.FindOne().SetProjection(bson.M{"invoiceCounter": 1, "invoiceBookingID": 1})
+		if err := s.hotels.FindOne(ctx, bson.M{"_id": hotelID}, opts).Decode(&counter); err != nil {
+			return nil, err
+		}
+		if !counter.BookingID.IsZero() {
+			filter, update := InvoiceNumberUpdate(counter)
+			if _, err := s.coll.UpdateOne(ctx, filter, update); err != nil {
+				return nil, err
+			}
+			if counter.BookingID == booking.ID {
+				continue
+			}
+		}
+		filter, update := AdvanceInvoiceCounterUpdate(hotelID, counter, booking.ID)
+		if _, err := s.hotels.UpdateOne(ctx, filter, update); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// InvoiceNumberUpdate returns the filter and update giving the booking of
+// the counter its number, unless it already has one.
+func InvoiceNumberUpdate(counter InvoiceCounter) (bson.M, bson.M) {
+	filter := bson.M{"_id": counter.BookingID, "invoiceNumber": bson.M{"$exists": false}}
+	return filter, bson.M{"$set": bson.M{"invoiceNumber": counter.InvoiceCounter}}
+}
+
+// AdvanceInvoiceCounterUpdate returns the filter and update issuing the next
+// invoice number of the hotel for the booking, which only match while the
+// counter is still at the value read.
+func AdvanceInvoiceCounterUpdate(hotelID primitive.ObjectID, counter InvoiceCounter, bookingID primitive.ObjectID) (bson.M, bson.M) {
+	filter := bson.M{"_id": hotelID, "invoiceCounter": counter.InvoiceCounter}
+	if counter.InvoiceCounter == 0 {
+		filter["invoiceCounter"] = bson.M{"$exists": false}
+	}
+	update := bson.M{"$set": bson.M{
+		"invoiceCounter":   counter.InvoiceCounter + 1,
+		"invoiceBookingID": bookingID,
+	}}
+	return filter, update
+}
+
+// ExpireHolds releases the holds that ran out at the given time. Holds
+// converted into bookings meanwhile are skipped. It returns the number of
+// expired holds.
+func (s *MongoBookingStore) ExpireHolds(ctx context.Context, now time.Time) (int, error) {
+	holds, err := s.FilterBookings(ctx, types.CreateExpiredHoldsFilter(now))
+	if err != nil {
+		return 0, err
+	}
+	expired := 0
+	for _, hold := range holds {
+		change := types.StatusChange{Status: types.BookingExpired, At: now}
+		if _, err := s.TransitionBooking(ctx, hold.ID.Hex(), change); err != nil {
+			if errors.Is(err, ErrInvalidTransition) {
+				continue
+			}
+			return expired, err
+		}
+		expired++
+	}
+	return expired, nil
+}
+
+// MigrateBookings replaces the cancelled flag of bookings created before
+// they had a status. Cancelled bookings become cancelled, the rest confirmed.
+// The amounts of bookings and groups stored before they had a currency are
+// converted to minor units of the default one. It then locks the nights of
+// the active bookings made before nights were locked, so new bookings can't
+// take them. It returns the number of migrated bookings and groups and is
+// safe to run again.
+func (s *MongoBookingStore) MigrateBookings(ctx context.Context) (int, error) {
+	migrated := 0
+	for cancelled, status := range types.LegacyBookingStatus {
+		filter := bson.M{"cancelled": cancelled, "status": bson.M{"$exists": false}}
+		update := bson.M{
+			"$set":   bson.M{"status": status},
+			"$unset": bson.M{"cancelled": ""},
+		}
+		result, err := s.coll.UpdateMany(ctx, filter, update)
+		if err != nil {
+			return migrated, err
+		}
+		migrated += int(result.ModifiedCount)
+	}
+	n, err := migrateCurrencies[types.Booking](ctx, s.coll)
+	migrated += n
+	if err != nil {
+		return migrated, err
+	}
+	n, err = migrateCurrencies[types.GroupBooking](ctx, s.groups)
+	migrated += n
+	if err != nil {
+		return migrated, err
+	}
+	bookings, err := s.FilterBookings(ctx, UnlockedBookingsFilter(time.Now()))
+	if err != nil {
+		return migrated, err
+	}
+	for _, booking := range bookings {
+		held, err := s.lockedNights(ctx, bson.M{"bookingID": booking.ID, "roomID": booking.RoomID})
+		if err != nil {
+			return migrated, err
+		}
+		missing := UnheldNights(booking, held)
+		for _, night := range missing {
+			// a night another booking locked meanwhile stays with it
+			lock := roomNight{RoomID: booking.RoomID, Night: night, BookingID: booking.ID}
+			if _, err := s.nights.InsertOne(ctx, lock); err != nil && !mongo.IsDuplicateKeyError(err) {
+				return migrated, err
+			}
+		}
+		if len(missing) != 0 {
+			migrated++
+		}
+	}
+	return migrated, nil
+}
+
+// UnlockedBookingsFilter matches the bookings that may hold a room without
+// locking its nights: the active bookings of a room that haven't ended.
+func UnlockedBookingsFilter(now time.Time) bson.M {
+	return bson.M{
+		"status":    bson.M{"$in": types.ActiveBookingStatuses},
+		"roomID":    bson.M{"$exists": true},
+		"untilDate": bson.M{"$gt": now},
+	}
+}
+
+// NewNights returns the nights of updated that current doesn't hold yet
+func NewNights(current, updated *types.Booking) []string {
+	held := map[string]bool{}
+	if current.RoomID == updated.RoomID {
+		for _, night := range current.Nights() {
+			held[night] = true
+		}
+	}
+	nights := []string{}
+	for _, night := range updated.Nights() {
+		if !held[night] {
+			nights = append(nights, night)
+		}
+	}
+	return nights
+}
+
+// ClaimedNightsFilter matches the given nights claimed for the booking's room
+func ClaimedNightsFilter(booking *types.Booking, nights []string) bson.M {
+	return bson.M{"bookingID": booking.ID, "roomID": booking.RoomID, "night": bson.M{"$in": nights}}
+}
+
+// StaleNightsFilter matches the nights held by the booking that are not part
+// of its current room and dates
+func StaleNightsFilter(booking *types.Booking) bson.M {
+	return bson.M{
+		"bookingID": booking.ID,
+		"$or": []bson.M{
+			{"roomID": bson.M{"$ne": booking.RoomID}},
+			{"night": bson.M{"$nin": booking.Nights()}},
+		},
+	}
+}
+
+// ModifyBookingUpdate returns the filter and update applying the values of
+// booking to its stored document, if it can still be modified.
+func ModifyBookingUpdate(booking *types.Booking, change types.BookingChange) (bson.M, bson.M) {
+	filter := bson.M{"_id": booking.ID, "status": bson.M{"$in": types.ModifiableBookingStatuses}}
+	set := bson.M{
+		"roomID":    booking.RoomID,
+		"fromDate":  booking.FromDate,
+		"untilDate": booking.UntilDate,
+		"numPeople": booking.NumPeople,
+		"price":     booking.Price,
+		"nightly":   booking.Nightly,
+		"taxes":     booking.Taxes,
+	}
+	if booking.Promo != nil {
+		set["promo"] = booking.Promo
+	}
+	update := bson.M{
+		"$set":  set,
+		"$push": bson.M{"changes": change},
+	}
+	return filter, update
+}
+
+// AllocateRoom claims the nights of the booking on the first of the rooms
+// claim succeeds for, recording it as the booking's AllocatedRoom. claim
+// must give back the nights it took when one of them is already taken.
+func AllocateRoom(booking *types.Booking, rooms []primitive.ObjectID, claim func(primitive.ObjectID) (bool, error)) error {
+	for _, roomID := range rooms {
+		ok, err := claim(roomID)
+		if err != nil {
+			return err
+		}
+		if ok {
+			booking.AllocatedRoom = roomID
+			return nil
+		}
+	}
+	return ErrRoomUnavailable
+}
+
+// UnheldNights returns the nights of the booking missing from held
+func UnheldNights(booking *types.Booking, held []string) []string {
+	holds := map[string]bool{}
+	for _, night := range held {
+		holds[night] = true
+	}
+	nights := []string{}
+	for _, night := range booking.Nights() {
+		if !holds[night] {
+			nights = append(nights, night)
+		}
+	}
+	return nights
+}
+
+func CountNights(nights []string) map[string]int {
+	count := map[string]int{}
+	for _, night := range nights {
+		count[night]++
+	}
+	return count
 }
@@ -0,0 +1,72 @@
+package db
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// LegacyAmounts are the documents that may have been stored before amounts
+// had a currency, when they were whole units of types.DefaultCurrency
+type LegacyAmounts[T any] interface {
+	*T
+	MigrateCurrency() bson.M
+}
+
+// LegacyAmountsFilter matches the documents stored without a currency
+func LegacyAmountsFilter() bson.M {
+	return bson.M{"currency": bson.M{"$exists": false}}
+}
+
+// MigrateCurrencyUpdate returns the filter and update converting the amounts
+// of the legacy document doc to minor units. Only the converted amounts and
+// the currency are set, so the rest of the document written meanwhile is
+// kept, and the filter skips the document when it was migrated meanwhile.
+func MigrateCurrencyUpdate[T any, PT LegacyAmounts[T]](doc bson.M) (bson.M, bson.M, error) {
+	b, err := bson.Marshal(doc)
+	if err != nil {
+		return nil, nil, err
+	}
+	var v T
+	if err := bson.Unmarshal(b, &v); err != nil {
+		return nil, nil, err
+	}
+	set := PT(&v).MigrateCurrency()
+	if set == nil {
+		return nil, nil, nil
+	}
+	filter := LegacyAmountsFilter()
+	filter["_id"] = doc["_id"]
+	return filter, bson.M{"$set": set}, nil
+}
+
+// migrateCurrencies converts the amounts of the documents of coll stored
+// without a currency to minor units. It returns the number of documents
+// migrated.
+func migrateCurrencies[T any, PT LegacyAmounts[T]](ctx context.Context, coll *mongo.Collection) (int, error) {
+	cursor, err := coll.Find(ctx, LegacyAmountsFilter())
+	if err != nil {
+		return 0, err
+	}
+	docs := []bson.M{}
+	if err := cursor.All(ctx, &docs); err != nil {
+		return 0, err
+	}
+	migrated := 0
+	for _, doc := range docs {
+		filter, update, err := MigrateCurrencyUpdate[T, PT](doc)
+		if err != nil {
+			return migrated, err
+		}
+		if update == nil {
+			continue
+		}
+		result, err := coll.UpdateOne(ctx, filter, update)
+		if err != nil {
+			return migrated, err
+		}
+		migrated += int(result.ModifiedCount)
+	}
+	return migrated, nil
+}
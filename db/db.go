@@ -3,6 +3,8 @@ package db
 import (
 	"regexp"
 	"strings"
+
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 const (
@@ -15,6 +17,34 @@ type Store struct {
 	Hotel   HotelStore
 	Room    RoomStore
 	Booking BookingStore
+	Payment PaymentStore
+	// ExchangeRate holds the table used to show prices in other currencies
+	ExchangeRate ExchangeRateStore
+	Promo        PromoStore
+	Session      SessionStore
+	// PasswordReset holds the tokens mailed to users who forgot their password
+	PasswordReset PasswordResetStore
+	// EmailVerification holds the tokens mailed to verify new accounts
+	EmailVerification EmailVerificationStore
+}
+
+// NewMongoStore wires every Mongo store against the given database. It only
+// needs a mongo client, so it can be used by the API server as well as by
+// scripts or background workers.
+func NewMongoStore(client *mongo.Client, dbname string) *Store {
+	hotelStore := NewMongoHotelStore(client, dbname)
+	return &Store{
+		User:              NewMongoUserStore(client, dbname),
+		Hotel:             hotelStore,
+		Room:              NewMongoRoomStore(client, hotelStore, dbname),
+		Booking:           NewMongoBookingStore(client, dbname),
+		Payment:           NewMongoPaymentStore(client, dbname),
+		ExchangeRate:      NewMongoExchangeRateStore(client, dbname),
+		Promo:             NewMongoPromoStore(client, dbname),
+		Session:           NewMongoSessionStore(client, dbname),
+		PasswordReset:     NewMongoPasswordResetStore(client, dbname),
+		EmailVerification: NewMongoEmailVerificationStore(client, dbname),
+	}
 }
 
 func FormatMongoE(e error) string {
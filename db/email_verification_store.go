@@ -0,0 +1,59 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/xV0lk/hotel-reservations/types"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const emailVerificationColl = "emailVerifications"
+
+// ErrVerificationInvalid is returned when using an email verification token
+// that is unknown, expired or was already used.
+var ErrVerificationInvalid = errors.New("the verification token is invalid or has expired")
+
+type EmailVerificationStore interface {
+	InsertEmailVerification(ctx context.Context, verification *types.EmailVerification) error
+	// UseEmailVerification marks the verification with the token hash as
+	// used, returning it, if it was unused and not expired at now.
+	UseEmailVerification(ctx context.Context, id primitive.ObjectID, hash string, now time.Time) (*types.EmailVerification, error)
+}
+
+type MongoEmailVerificationStore struct {
+	client *mongo.Client
+	coll   *mongo.Collection
+}
+
+func NewMongoEmailVerificationStore(client *mongo.Client, dbname string) *MongoEmailVerificationStore {
+	return &MongoEmailVerificationStore{
+		client: client,
+		coll:   client.Database(dbname).Collection(emailVerificationColl),
+	}
+}
+
+func (s *MongoEmailVerificationStore) InsertEmailVerification(ctx context.Context, verification *types.EmailVerification) error {
+	result, err := s.coll.InsertOne(ctx, verification)
+	if err != nil {
+		return err
+	}
+	verification.ID = result.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+func (s *MongoEmailVerificationStore) UseEmailVerification(ctx context.Context, id primitive.ObjectID, hash string, now time.Time) (*types.EmailVerification, error) {
+	filter, update := UseTokenUpdate(id, hash, now)
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	var verification types.EmailVerification
+	if err := s.coll.FindOneAndUpdate(ctx, filter, update, opts).Decode(&verification); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrVerificationInvalid
+		}
+		return nil, err
+	}
+	return &verification, nil
+}
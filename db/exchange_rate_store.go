@@ -0,0 +1,62 @@
+package db
+
+import (
+	"context"
+
+	"github.com/xV0lk/hotel-reservations/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	exchangeRateColl = "exchangeRates"
+	// exchangeRatesID is the id of the single document holding the table
+	exchangeRatesID = "current"
+)
+
+type ExchangeRateStore interface {
+	GetExchangeRates(ctx context.Context) (*types.ExchangeRates, error)
+	PutExchangeRates(ctx context.Context, rates *types.ExchangeRates) error
+}
+
+type MongoExchangeRateStore struct {
+	client *mongo.Client
+	coll   *mongo.Collection
+}
+
+func NewMongoExchangeRateStore(client *mongo.Client, dbname string) *MongoExchangeRateStore {
+	return &MongoExchangeRateStore{
+		client: client,
+		coll:   client.Database(dbname).Collection(exchangeRateColl),
+	}
+}
+
+// GetExchangeRates returns the exchange rate table. Until one is put, the
+// table is empty and prices can only be shown in their own currency.
+func (s *MongoExchangeRateStore) GetExchangeRates(ctx context.Context) (*types.ExchangeRates, error) {
+	var rates types.ExchangeRates
+	err := s.coll.FindOne(ctx, bson.M{"_id": exchangeRatesID}).Decode(&rates)
+	if err == mongo.ErrNoDocuments {
+		return NewExchangeRates(), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &rates, nil
+}
+
+// PutExchangeRates replaces the exchange rate table
+func (s *MongoExchangeRateStore) PutExchangeRates(ctx context.Context, rates *types.ExchangeRates) error {
+	opts := options.Replace().SetUpsert(true)
+	_, err := s.coll.ReplaceOne(ctx, bson.M{"_id": exchangeRatesID}, rates, opts)
+	return err
+}
+
+// NewExchangeRates returns the empty exchange rate table
+func NewExchangeRates() *types.ExchangeRates {
+	return &types.ExchangeRates{
+		Base:  types.DefaultCurrency,
+		Rates: map[string]float64{},
+	}
+}
@@ -7,8 +7,8 @@ import (
 	"time"
 
 	"github.com/xV0lk/hotel-reservations/db"
+	"github.com/xV0lk/hotel-reservations/pricing"
 	"github.com/xV0lk/hotel-reservations/types"
-	iutils "github.com/xV0lk/hotel-reservations/utils"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
@@ -19,6 +19,7 @@ func AddUser(store *db.Store, n, l string, a bool) *types.User {
 		Email:     fmt.Sprintf("%s@%s.com", n, l),
 		Password:  fmt.Sprintf("%s_%s_P4$$", n, l),
 		IsAdmin:   a,
+		Verified:  true,
 	}
 	if errors := newUser.Validate(); len(errors) != 0 {
 		log.Fatal(errors)
@@ -36,9 +37,10 @@ func AddUser(store *db.Store, n, l string, a bool) *types.User {
 func AddRoom(store *db.Store, t types.RoomType, p int, h primitive.ObjectID) *types.Room {
 	ctx := context.Background()
 	room := &types.Room{
-		Type:      t,
-		BasePrice: p,
-		HotelId:   h,
+		Type:         t,
+		BasePrice:    p,
+		HotelId:      h,
+		MaxOccupancy: types.DefaultOccupancy[t],
 	}
 	if err := store.Room.InsertRoom(ctx, room); err != nil {
 		log.Fatal(err)
@@ -54,29 +56,59 @@ func AddERoom(store *db.Store, r types.Room) {
 }
 
 func AddHotel(store *db.Store, name, location string, rating float64, priceCategory int) *types.Hotel {
+	return AddHotelWithCurrency(store, name, location, types.DefaultCurrency, rating, priceCategory)
+}
+
+// SampleExchangeRates are the rates the fixtures price the rooms of hotels
+// charging in other currencies with
+func SampleExchangeRates() *types.ExchangeRates {
+	return &types.ExchangeRates{
+		Base:      types.DefaultCurrency,
+		Rates:     map[string]float64{"EUR": 0.92, "GBP": 0.79, "JPY": 150, "RON": 4.6},
+		UpdatedAt: time.Now(),
+	}
+}
+
+// AddHotelWithCurrency adds a hotel charging in currency, with one room of
+// every type priced in it. Rooms cost the same as in a hotel of the same
+// price category charging in dollars.
+func AddHotelWithCurrency(store *db.Store, name, location, currency string, rating float64, priceCategory int) *types.Hotel {
 	ctx := context.Background()
+	price := func(dollars int) int {
+		usd := types.Money{Amount: dollars * types.MinorUnits(types.DefaultCurrency), Currency: types.DefaultCurrency}
+		converted, err := SampleExchangeRates().Convert(usd, currency)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return converted.Amount
+	}
 	hotel := &types.Hotel{
 		Name:     name,
 		Location: location,
 		Rating:   rating,
+		Currency: currency,
 		Rooms:    []primitive.ObjectID{},
 	}
 	rooms := []types.Room{
 		{
-			Type:      types.Single,
-			BasePrice: (priceCategory * 50) - 1,
+			Type:         types.Single,
+			BasePrice:    price(priceCategory*50 - 1),
+			MaxOccupancy: types.DefaultOccupancy[types.Single],
 		},
 		{
-			Type:      types.Double,
-			BasePrice: (priceCategory * 80) - 1,
+			Type:         types.Double,
+			BasePrice:    price(priceCategory*80 - 1),
+			MaxOccupancy: types.DefaultOccupancy[types.Double],
 		},
 		{
-			Type:      types.SeaSide,
-			BasePrice: (priceCategory * 110) - 1,
+			Type:         types.SeaSide,
+			BasePrice:    price(priceCategory*110 - 1),
+			MaxOccupancy: types.DefaultOccupancy[types.SeaSide],
 		},
 		{
-			Type:      types.Deluxe,
-			BasePrice: (priceCategory * 140) - 1,
+			Type:         types.Deluxe,
+			BasePrice:    price(priceCategory*140 - 1),
+			MaxOccupancy: types.DefaultOccupancy[types.Deluxe],
 		},
 	}
 	if err := store.Hotel.InsertHotel(ctx, hotel); err != nil {
@@ -91,15 +123,25 @@ func AddHotel(store *db.Store, name, location string, rating float64, priceCateg
 
 func AddBooking(store *db.Store, user primitive.ObjectID, room *types.Room, from, till time.Time, guests int) *types.Booking {
 	ctx := context.Background()
+	hotel, err := store.Hotel.GetHotelById(ctx, room.HotelId.Hex())
+	if err != nil {
+		log.Fatal(err)
+	}
+	quote := pricing.HotelQuote(hotel, room, from, till, guests)
 	booking := &types.Booking{
 		UserID:    user,
 		RoomID:    room.ID,
 		FromDate:  from,
 		UntilDate: till,
 		NumPeople: guests,
-		Price:     room.BasePrice * iutils.Dbd(from, till),
+		Price:     quote.Total,
+		Currency:  hotel.CurrencyCode(),
+		Nightly:   quote.Nights,
+		Taxes:     quote.Taxes,
+		Policy:    &hotel.Cancellation,
 	}
-	if err := store.Booking.InsertBooking(ctx, booking); err != nil {
+	booking.SetStatus(types.BookingConfirmed, time.Now())
+	if err := store.Booking.ReserveBooking(ctx, booking); err != nil {
 		log.Fatal(err)
 	}
 	return booking
@@ -3,7 +3,6 @@ package db
 import (
 	"context"
 
-	"github.com/valyala/fasthttp"
 	"github.com/xV0lk/hotel-reservations/types"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -15,9 +14,11 @@ const hotelColl = "hotels"
 type HotelStore interface {
 	InsertHotel(ctx context.Context, hotel *types.Hotel) error
 	Update(ctx context.Context, filter, update bson.M) (*types.Hotel, error)
+	DeleteHotel(ctx context.Context, id string) error
 	GetHotelById(ctx context.Context, id string) (*types.Hotel, error)
-	GetHotels(ctx *fasthttp.RequestCtx) ([]*types.Hotel, error)
-	GetHotelBookings(ctx *fasthttp.RequestCtx, id string) ([]*types.HotelBookings, error)
+	ListHotels(ctx context.Context, filter bson.M, page types.PageParams) (*types.Page[types.Hotel], error)
+	GetHotelBookings(ctx context.Context, id string) ([]*types.HotelBookings, error)
+	MigrateHotels(ctx context.Context) (int, error)
 }
 
 type MongoHotelStore struct {
@@ -57,6 +58,18 @@ func (s *MongoHotelStore) Update(ctx context.Context, filter, update bson.M) (*t
 	return hotel, nil
 }
 
+func (s *MongoHotelStore) DeleteHotel(ctx context.Context, id string) error {
+	objectId, _ := primitive.ObjectIDFromHex(id)
+	result, err := s.coll.DeleteOne(ctx, bson.M{"_id": objectId})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
 func (s *MongoHotelStore) GetHotelById(ctx context.Context, id string) (*types.Hotel, error) {
 	var hotel types.Hotel
 	objectId, _ := primitive.ObjectIDFromHex(id)
@@ -66,19 +79,18 @@ func (s *MongoHotelStore) GetHotelById(ctx context.Context, id string) (*types.H
 	return &hotel, nil
 }
 
-func (s *MongoHotelStore) GetHotels(ctx *fasthttp.RequestCtx) ([]*types.Hotel, error) {
-	var hotels []*types.Hotel
-	cursor, err := s.coll.Find(ctx, bson.M{})
-	if err != nil {
-		return nil, err
-	}
-	if err := cursor.All(ctx, &hotels); err != nil {
-		return nil, err
-	}
-	return hotels, nil
+func (s *MongoHotelStore) ListHotels(ctx context.Context, filter bson.M, page types.PageParams) (*types.Page[types.Hotel], error) {
+	return findPage[types.Hotel](ctx, s.coll, filter, page)
 }
 
-func (s *MongoHotelStore) GetHotelBookings(ctx *fasthttp.RequestCtx, id string) (hotels []*types.HotelBookings, err error) {
+// MigrateHotels gives hotels stored before they had a currency the default
+// one, converting their fees and taxes to minor units. It returns the number
+// of hotels updated.
+func (s *MongoHotelStore) MigrateHotels(ctx context.Context) (int, error) {
+	return migrateCurrencies[types.Hotel](ctx, s.coll)
+}
+
+func (s *MongoHotelStore) GetHotelBookings(ctx context.Context, id string) (hotels []*types.HotelBookings, err error) {
 	objectId, _ := primitive.ObjectIDFromHex(id)
 	if _, err := s.GetHotelById(ctx, id); err != nil {
 		return nil, err
@@ -104,3 +116,10 @@ func (s *MongoHotelStore) GetHotelBookings(ctx *fasthttp.RequestCtx, id string)
 	}
 	return hotels, nil
 }
+
+// InvoiceCounter is the last invoice number issued by a hotel and the
+// booking it was issued for, kept in the hotel document.
+type InvoiceCounter struct {
+	InvoiceCounter int                `bson:"invoiceCounter"`
+	BookingID      primitive.ObjectID `bson:"invoiceBookingID,omitempty"`
+}
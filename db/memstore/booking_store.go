@@ -0,0 +1,483 @@
+package memstore
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/xV0lk/hotel-reservations/db"
+	"github.com/xV0lk/hotel-reservations/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	bookingColl   = "bookings"
+	roomNightColl = "roomNights"
+	groupColl     = "groups"
+)
+
+type BookingStore struct {
+	coll   *collection
+	nights *collection
+	groups *collection
+	promos *collection
+	hotels *collection
+}
+
+type roomNight struct {
+	RoomID    primitive.ObjectID `bson:"roomID"`
+	Night     string             `bson:"night"`
+	BookingID primitive.ObjectID `bson:"bookingID"`
+}
+
+func NewBookingStore(d *DB) *BookingStore {
+	return &BookingStore{
+		coll:   d.collection(bookingColl),
+		nights: d.collection(roomNightColl),
+		groups: d.collection(groupColl),
+		promos: d.collection(promoColl),
+		hotels: d.collection(hotelColl),
+	}
+}
+
+func (s *BookingStore) IndexRoomNights(ctx context.Context) error {
+	s.nights.index("roomID", "night")
+	return nil
+}
+
+func (s *BookingStore) InsertBooking(ctx context.Context, booking *types.Booking) error {
+	id, err := s.coll.insertOne(booking)
+	if err != nil {
+		return err
+	}
+	booking.ID = id
+	return nil
+}
+
+// ReserveBooking claims the booking's room nights the same way the Mongo
+// store does, relying on the unique (roomID, night) index.
+func (s *BookingStore) ReserveBooking(ctx context.Context, booking *types.Booking) error {
+	if booking.ID.IsZero() {
+		booking.ID = primitive.NewObjectID()
+	}
+	var documents []any
+	for _, night := range booking.Nights() {
+		documents = append(documents, roomNight{
+			RoomID:    booking.RoomID,
+			Night:     night,
+			BookingID: booking.ID,
+		})
+	}
+	if len(documents) == 0 {
+		return db.ErrRoomUnavailable
+	}
+	if _, err := s.nights.insertMany(documents); err != nil {
+		s.releaseNights(booking.ID)
+		if mongo.IsDuplicateKeyError(err) {
+			return db.ErrRoomUnavailable
+		}
+		return err
+	}
+	if _, err := s.coll.insertOne(booking); err != nil {
+		s.releaseNights(booking.ID)
+		return err
+	}
+	return nil
+}
+
+func (s *BookingStore) ReserveGroup(ctx context.Context, group *types.GroupBooking, bookings []*types.Booking) error {
+	group.ID = primitive.NewObjectID()
+	group.BookingIDs = []primitive.ObjectID{}
+	var documents []any
+	for _, booking := range bookings {
+		if booking.ID.IsZero() {
+			booking.ID = primitive.NewObjectID()
+		}
+		booking.GroupID = group.ID
+		group.BookingIDs = append(group.BookingIDs, booking.ID)
+		for _, night := range booking.Nights() {
+			documents = append(documents, roomNight{
+				RoomID:    booking.RoomID,
+				Night:     night,
+				BookingID: booking.ID,
+			})
+		}
+	}
+	if len(documents) == 0 {
+		return db.ErrRoomUnavailable
+	}
+	release := func() {
+		s.nights.delete(bson.M{"bookingID": bson.M{"$in": group.BookingIDs}}, true)
+	}
+	if _, err := s.nights.insertMany(documents); err != nil {
+		release()
+		if mongo.IsDuplicateKeyError(err) {
+			return db.ErrRoomUnavailable
+		}
+		return err
+	}
+	var docs []any
+	for _, booking := range bookings {
+		docs = append(docs, booking)
+	}
+	if _, err := s.coll.insertMany(docs); err != nil {
+		s.coll.delete(bson.M{"groupID": group.ID}, true)
+		release()
+		return err
+	}
+	if _, err := s.groups.insertOne(group); err != nil {
+		s.coll.delete(bson.M{"groupID": group.ID}, true)
+		release()
+		return err
+	}
+	group.Bookings = bookings
+	return nil
+}
+
+func (s *BookingStore) GetGroupById(ctx context.Context, id string) (*types.GroupBooking, error) {
+	oId, _ := primitive.ObjectIDFromHex(id)
+	doc, err := s.groups.findOne(bson.M{"_id": oId})
+	if err != nil {
+		return nil, err
+	}
+	group, err := decode[types.GroupBooking](doc)
+	if err != nil {
+		return nil, err
+	}
+	if group.Bookings, err = s.FilterBookings(ctx, bson.M{"groupID": group.ID}); err != nil {
+		return nil, err
+	}
+	return group, nil
+}
+
+func (s *BookingStore) ReserveInventory(ctx context.Context, booking *types.Booking, rooms []primitive.ObjectID) error {
+	if booking.ID.IsZero() {
+		booking.ID = primitive.NewObjectID()
+	}
+	booking.AllocatedRoom = primitive.NilObjectID
+	nights := booking.Nights()
+	if len(nights) == 0 || len(rooms) == 0 {
+		return db.ErrRoomUnavailable
+	}
+	claim := func(roomID primitive.ObjectID) (bool, error) {
+		var documents []any
+		for _, night := range nights {
+			documents = append(documents, roomNight{RoomID: roomID, Night: night, BookingID: booking.ID})
+		}
+		if _, err := s.nights.insertMany(documents); err != nil {
+			s.nights.delete(bson.M{"bookingID": booking.ID, "roomID": roomID}, true)
+			if mongo.IsDuplicateKeyError(err) {
+				return false, nil
+			}
+			return false, err
+		}
+		return true, nil
+	}
+	if err := db.AllocateRoom(booking, rooms, claim); err != nil {
+		s.releaseNights(booking.ID)
+		return err
+	}
+	if _, err := s.coll.insertOne(booking); err != nil {
+		s.releaseNights(booking.ID)
+		return err
+	}
+	return nil
+}
+
+func (s *BookingStore) AssignRoom(ctx context.Context, id string, roomID primitive.ObjectID) (*types.Booking, error) {
+	booking, err := s.GetBookingById(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if !booking.Status.IsActive() {
+		return nil, db.ErrInvalidTransition
+	}
+	held, err := s.lockedNights(bson.M{"bookingID": booking.ID, "roomID": roomID})
+	if err != nil {
+		return nil, err
+	}
+	claimed := db.UnheldNights(booking, held)
+	var documents []any
+	for _, night := range claimed {
+		documents = append(documents, roomNight{RoomID: roomID, Night: night, BookingID: booking.ID})
+	}
+	assigned := *booking
+	assigned.RoomID = roomID
+	release := func() {
+		s.nights.delete(db.ClaimedNightsFilter(&assigned, claimed), true)
+	}
+	if len(documents) != 0 {
+		if _, err := s.nights.insertMany(documents); err != nil {
+			release()
+			if mongo.IsDuplicateKeyError(err) {
+				return nil, db.ErrRoomUnavailable
+			}
+			return nil, err
+		}
+	}
+	filter := bson.M{"_id": booking.ID, "status": bson.M{"$in": types.ActiveBookingStatuses}}
+	update := bson.M{
+		"$set":   bson.M{"roomID": roomID},
+		"$unset": bson.M{"allocatedRoom": ""},
+	}
+	matched, err := s.coll.update(filter, update, false)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	if matched == 0 {
+		release()
+		return nil, db.ErrInvalidTransition
+	}
+	if _, err := s.nights.delete(db.StaleNightsFilter(&assigned), true); err != nil {
+		return nil, err
+	}
+	return s.GetBookingById(ctx, id)
+}
+
+func (s *BookingStore) CountRoomNights(ctx context.Context, rooms []primitive.ObjectID, nights []string) (map[string]int, error) {
+	locks, err := s.lockedNights(bson.M{"roomID": bson.M{"$in": rooms}, "night": bson.M{"$in": nights}})
+	if err != nil {
+		return nil, err
+	}
+	return db.CountNights(locks), nil
+}
+
+func (s *BookingStore) lockedNights(filter bson.M) ([]string, error) {
+	docs, err := s.nights.find(filter)
+	if err != nil {
+		return nil, err
+	}
+	locks, err := decodeAll[roomNight](docs)
+	if err != nil {
+		return nil, err
+	}
+	nights := []string{}
+	for _, lock := range locks {
+		nights = append(nights, lock.Night)
+	}
+	return nights, nil
+}
+
+func (s *BookingStore) releaseNights(bookingID primitive.ObjectID) error {
+	_, err := s.nights.delete(bson.M{"bookingID": bookingID}, true)
+	return err
+}
+
+func (s *BookingStore) ListBookings(ctx context.Context, filter bson.M, page types.PageParams) (*types.Page[types.Booking], error) {
+	return findPage[types.Booking](s.coll, filter, page)
+}
+
+func (s *BookingStore) FilterBookings(ctx context.Context, filter bson.M) ([]*types.Booking, error) {
+	docs, err := s.coll.find(filter)
+	if err != nil {
+		return nil, err
+	}
+	return decodeAll[types.Booking](docs)
+}
+
+func (s *BookingStore) GetBookingById(ctx context.Context, id string) (*types.Booking, error) {
+	oId, _ := primitive.ObjectIDFromHex(id)
+	doc, err := s.coll.findOne(bson.M{"_id": oId})
+	if err != nil {
+		return nil, err
+	}
+	return decode[types.Booking](doc)
+}
+
+func (s *BookingStore) TransitionBooking(ctx context.Context, id string, change types.StatusChange) (*types.Booking, error) {
+	return s.transition(ctx, id, change, bson.M{})
+}
+
+// CancelBooking moves the booking to the status matching the cancellation,
+// storing its refund and penalty.
+func (s *BookingStore) CancelBooking(ctx context.Context, id string, cancellation *types.Cancellation) (*types.Booking, error) {
+	change := types.StatusChange{Status: cancellation.Status(), At: cancellation.At}
+	return s.transition(ctx, id, change, bson.M{"cancellation": cancellation})
+}
+
+func (s *BookingStore) SetRefunded(ctx context.Context, id string, at time.Time) (*types.Booking, error) {
+	filter, update := db.SetRefundedUpdate(id, at)
+	if _, err := s.coll.update(filter, update, false); err != nil {
+		return nil, err
+	}
+	return s.GetBookingById(ctx, id)
+}
+
+func (s *BookingStore) transition(ctx context.Context, id string, change types.StatusChange, values bson.M) (*types.Booking, error) {
+	objectId, _ := primitive.ObjectIDFromHex(id)
+	filter := bson.M{"_id": objectId, "status": bson.M{"$in": types.TransitionSources(change.Status)}}
+	values["status"] = change.Status
+	update := bson.M{
+		"$set":  values,
+		"$push": bson.M{"history": change},
+	}
+	matched, err := s.coll.update(filter, update, false)
+	if err != nil {
+		return nil, err
+	}
+	if matched == 0 {
+		if _, err := s.GetBookingById(ctx, id); err != nil {
+			return nil, err
+		}
+		return nil, db.ErrInvalidTransition
+	}
+	if !change.Status.IsActive() {
+		if err := s.releaseNights(objectId); err != nil {
+			return nil, err
+		}
+	}
+	booking, err := s.GetBookingById(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if booking.ReleasesPromo() {
+		if _, err := s.promos.update(bson.M{"_id": booking.Promo.ID}, db.ReleasePromoUpdate(booking.UserID), false); err != nil {
+			return nil, err
+		}
+	}
+	return booking, nil
+}
+
+func (s *BookingStore) ModifyBooking(ctx context.Context, booking *types.Booking, change types.BookingChange) (*types.Booking, error) {
+	current, err := s.GetBookingById(ctx, booking.ID.Hex())
+	if err != nil {
+		return nil, err
+	}
+	claimed := db.NewNights(current, booking)
+	var documents []any
+	for _, night := range claimed {
+		documents = append(documents, roomNight{
+			RoomID:    booking.RoomID,
+			Night:     night,
+			BookingID: booking.ID,
+		})
+	}
+	release := func() {
+		s.nights.delete(db.ClaimedNightsFilter(booking, claimed), true)
+	}
+	if len(documents) != 0 {
+		if _, err := s.nights.insertMany(documents); err != nil {
+			release()
+			if mongo.IsDuplicateKeyError(err) {
+				return nil, db.ErrRoomUnavailable
+			}
+			return nil, err
+		}
+	}
+	filter, update := db.ModifyBookingUpdate(booking, change)
+	matched, err := s.coll.update(filter, update, false)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	if matched == 0 {
+		release()
+		return nil, db.ErrInvalidTransition
+	}
+	if _, err := s.nights.delete(db.StaleNightsFilter(booking), true); err != nil {
+		return nil, err
+	}
+	return s.GetBookingById(ctx, booking.ID.Hex())
+}
+
+func (s *BookingStore) IssueInvoiceNumber(ctx context.Context, id string, hotelID primitive.ObjectID) (*types.Booking, error) {
+	for {
+		booking, err := s.GetBookingById(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if booking.InvoiceNumber != 0 {
+			return booking, nil
+		}
+		doc, err := s.hotels.findOne(bson.M{"_id": hotelID})
+		if err != nil {
+			return nil, err
+		}
+		counter, err := decode[db.InvoiceCounter](doc)
+		if err != nil {
+			return nil, err
+		}
+		if !counter.BookingID.IsZero() {
+			filter, update := db.InvoiceNumberUpdate(*counter)
+			if _, err := s.coll.update(filter, update, false); err != nil {
+				return nil, err
+			}
+			if counter.BookingID == booking.ID {
+				continue
+			}
+		}
+		filter, update := db.AdvanceInvoiceCounterUpdate(hotelID, *counter, booking.ID)
+		if _, err := s.hotels.update(filter, update, false); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (s *BookingStore) ExpireHolds(ctx context.Context, now time.Time) (int, error) {
+	holds, err := s.FilterBookings(ctx, types.CreateExpiredHoldsFilter(now))
+	if err != nil {
+		return 0, err
+	}
+	expired := 0
+	for _, hold := range holds {
+		change := types.StatusChange{Status: types.BookingExpired, At: now}
+		if _, err := s.TransitionBooking(ctx, hold.ID.Hex(), change); err != nil {
+			if errors.Is(err, db.ErrInvalidTransition) {
+				continue
+			}
+			return expired, err
+		}
+		expired++
+	}
+	return expired, nil
+}
+
+func (s *BookingStore) MigrateBookings(ctx context.Context) (int, error) {
+	migrated := 0
+	for cancelled, status := range types.LegacyBookingStatus {
+		filter := bson.M{"cancelled": cancelled, "status": bson.M{"$exists": false}}
+		update := bson.M{
+			"$set":   bson.M{"status": status},
+			"$unset": bson.M{"cancelled": ""},
+		}
+		n, err := s.coll.update(filter, update, true)
+		if err != nil {
+			return migrated, err
+		}
+		migrated += n
+	}
+	n, err := migrateCurrencies[types.Booking](s.coll)
+	migrated += n
+	if err != nil {
+		return migrated, err
+	}
+	n, err = migrateCurrencies[types.GroupBooking](s.groups)
+	migrated += n
+	if err != nil {
+		return migrated, err
+	}
+	bookings, err := s.FilterBookings(ctx, db.UnlockedBookingsFilter(time.Now()))
+	if err != nil {
+		return migrated, err
+	}
+	for _, booking := range bookings {
+		held, err := s.lockedNights(bson.M{"bookingID": booking.ID, "roomID": booking.RoomID})
+		if err != nil {
+			return migrated, err
+		}
+		missing := db.UnheldNights(booking, held)
+		for _, night := range missing {
+			lock := roomNight{RoomID: booking.RoomID, Night: night, BookingID: booking.ID}
+			if _, err := s.nights.insertOne(lock); err != nil && !mongo.IsDuplicateKeyError(err) {
+				return migrated, err
+			}
+		}
+		if len(missing) != 0 {
+			migrated++
+		}
+	}
+	return migrated, nil
+}
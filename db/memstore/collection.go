@@ -0,0 +1,750 @@
+package memstore
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xV0lk/hotel-reservations/db"
+	"github.com/xV0lk/hotel-reservations/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// collection is a thread-safe list of bson documents. Documents are stored in
+// their marshalled form so that field names, omitempty tags and value types
+// behave the same as they do in MongoDB.
+type collection struct {
+	mu      sync.RWMutex
+	docs    []bson.M
+	indexes [][]string
+}
+
+func newCollection() *collection {
+	return &collection{}
+}
+
+// index adds a unique index over the given keys
+func (c *collection) index(keys ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.indexes = append(c.indexes, keys)
+}
+
+func (c *collection) drop() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.docs = nil
+}
+
+func (c *collection) insertOne(v any) (primitive.ObjectID, error) {
+	ids, err := c.insertMany([]any{v})
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	return ids[0], nil
+}
+
+// insertMany inserts the documents in order and stops at the first failure,
+// returning the ids inserted so far.
+func (c *collection) insertMany(vs []any) ([]primitive.ObjectID, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ids := []primitive.ObjectID{}
+	for _, v := range vs {
+		doc, err := toDoc(v)
+		if err != nil {
+			return ids, err
+		}
+		if _, ok := doc["_id"]; !ok {
+			doc["_id"] = primitive.NewObjectID()
+		}
+		if err := c.checkUnique(doc, -1); err != nil {
+			return ids, err
+		}
+		c.docs = append(c.docs, doc)
+		ids = append(ids, doc["_id"].(primitive.ObjectID))
+	}
+	return ids, nil
+}
+
+func (c *collection) find(filter bson.M) ([]bson.M, error) {
+	f, err := toDoc(filter)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	docs := []bson.M{}
+	for _, doc := range c.docs {
+		ok, err := matches(doc, f)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			docs = append(docs, doc)
+		}
+	}
+	return docs, nil
+}
+
+// findSorted returns at most limit matching documents ordered by field and
+// then by _id, the same way a Find with a sort and limit option does.
+func (c *collection) findSorted(filter bson.M, field string, desc bool, limit int) ([]bson.M, error) {
+	docs, err := c.find(filter)
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(docs, func(i, j int) bool {
+		n := compareSort(docs[i][field], docs[j][field])
+		if n == 0 {
+			n = compareSort(docs[i]["_id"], docs[j]["_id"])
+		}
+		if desc {
+			return n > 0
+		}
+		return n < 0
+	})
+	if limit > 0 && len(docs) > limit {
+		docs = docs[:limit]
+	}
+	return docs, nil
+}
+
+func (c *collection) findOne(filter bson.M) (bson.M, error) {
+	docs, err := c.find(filter)
+	if err != nil {
+		return nil, err
+	}
+	if len(docs) == 0 {
+		return nil, mongo.ErrNoDocuments
+	}
+	return docs[0], nil
+}
+
+// update applies the update document to the first matching document, or to
+// every matching document when many is true. It returns the matched count.
+func (c *collection) update(filter, update bson.M, many bool) (int, error) {
+	f, err := toDoc(filter)
+	if err != nil {
+		return 0, err
+	}
+	u, err := toDoc(update)
+	if err != nil {
+		return 0, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	matched := 0
+	for i, doc := range c.docs {
+		ok, err := matches(doc, f)
+		if err != nil {
+			return matched, err
+		}
+		if !ok {
+			continue
+		}
+		updated, err := applyUpdate(doc, u)
+		if err != nil {
+			return matched, err
+		}
+		if err := c.checkUnique(updated, i); err != nil {
+			return matched, err
+		}
+		c.docs[i] = updated
+		matched++
+		if !many {
+			break
+		}
+	}
+	return matched, nil
+}
+
+func (c *collection) delete(filter bson.M, many bool) (int, error) {
+	f, err := toDoc(filter)
+	if err != nil {
+		return 0, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	kept := []bson.M{}
+	deleted := 0
+	for _, doc := range c.docs {
+		if many || deleted == 0 {
+			ok, err := matches(doc, f)
+			if err != nil {
+				return 0, err
+			}
+			if ok {
+				deleted++
+				continue
+			}
+		}
+		kept = append(kept, doc)
+	}
+	c.docs = kept
+	return deleted, nil
+}
+
+// checkUnique returns a duplicate key write exception when doc collides with
+// any document other than the one at position skip.
+func (c *collection) checkUnique(doc bson.M, skip int) error {
+	for _, keys := range c.indexes {
+		for i, other := range c.docs {
+			if i == skip {
+				continue
+			}
+			same := true
+			for _, k := range keys {
+				a, _ := lookup(doc, k)
+				b, _ := lookup(other, k)
+				if !equal(a, b) {
+					same = false
+					break
+				}
+			}
+			if same {
+				return mongo.WriteException{WriteErrors: []mongo.WriteError{{
+					Code:    11000,
+					Message: fmt.Sprintf("E11000 duplicate key error dup key: { %s }", strings.Join(keys, ", ")),
+				}}}
+			}
+		}
+	}
+	return nil
+}
+
+// toDoc round trips v through bson so it has the same shape as a document
+// read back from MongoDB.
+func toDoc(v any) (bson.M, error) {
+	b, err := bson.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var doc bson.M
+	if err := bson.Unmarshal(b, &doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+func decode[T any](doc bson.M) (*T, error) {
+	b, err := bson.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	var v T
+	if err := bson.Unmarshal(b, &v); err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+func findPage[T any](c *collection, filter bson.M, page types.PageParams) (*types.Page[T], error) {
+	filter, err := db.PageFilter(filter, page)
+	if err != nil {
+		return nil, err
+	}
+	field, desc := page.SortField()
+	docs, err := c.findSorted(filter, field, desc, page.Limit+1)
+	if err != nil {
+		return nil, err
+	}
+	return db.NewPage[T](docs, page)
+}
+
+func decodeAll[T any](docs []bson.M) ([]*T, error) {
+	var vs []*T
+	for _, doc := range docs {
+		v, err := decode[T](doc)
+		if err != nil {
+			return nil, err
+		}
+		vs = append(vs, v)
+	}
+	return vs, nil
+}
+
+func lookup(doc bson.M, path string) (any, bool) {
+	var cur any = doc
+	for _, key := range strings.Split(path, ".") {
+		m, ok := cur.(bson.M)
+		if !ok {
+			return nil, false
+		}
+		if cur, ok = m[key]; !ok {
+			return nil, false
+		}
+	}
+	return cur, true
+}
+
+// matches reports whether doc matches filter. Filters using operators
+// memstore doesn't implement return an error, as MongoDB does for unknown
+// operators, rather than matching nothing.
+func matches(doc, filter bson.M) (bool, error) {
+	for key, cond := range filter {
+		switch key {
+		case "$and", "$or", "$nor":
+			filters, err := subFilters(key, cond)
+			if err != nil {
+				return false, err
+			}
+			found := 0
+			for _, f := range filters {
+				ok, err := matches(doc, f)
+				if err != nil {
+					return false, err
+				}
+				if ok {
+					found++
+				}
+			}
+			switch {
+			case key == "$and" && found != len(filters),
+				key == "$or" && found == 0,
+				key == "$nor" && found != 0:
+				return false, nil
+			}
+		case "$expr":
+			v, err := eval(doc, cond)
+			if err != nil {
+				return false, err
+			}
+			if !truthy(v) {
+				return false, nil
+			}
+		default:
+			if strings.HasPrefix(key, "$") {
+				return false, fmt.Errorf("memstore: unsupported query operator %s", key)
+			}
+			val, exists := lookup(doc, key)
+			ok, err := matchValue(val, exists, cond)
+			if err != nil {
+				return false, err
+			}
+			if !ok {
+				return false, nil
+			}
+		}
+	}
+	return true, nil
+}
+
+func subFilters(op string, cond any) ([]bson.M, error) {
+	arr, ok := cond.(bson.A)
+	if !ok {
+		return nil, fmt.Errorf("memstore: %s needs an array of filters", op)
+	}
+	filters := make([]bson.M, 0, len(arr))
+	for _, f := range arr {
+		m, ok := f.(bson.M)
+		if !ok {
+			return nil, fmt.Errorf("memstore: %s needs an array of filters", op)
+		}
+		filters = append(filters, m)
+	}
+	return filters, nil
+}
+
+func matchRegex(val, pattern, options any) bool {
+	s, ok := val.(string)
+	if !ok {
+		return false
+	}
+	p, _ := pattern.(string)
+	if o, _ := options.(string); o != "" {
+		p = fmt.Sprintf("(?%s)%s", o, p)
+	}
+	re, err := regexp.Compile(p)
+	if err != nil {
+		return false
+	}
+	return re.MatchString(s)
+}
+
+func isOperatorDoc(v any) (bson.M, bool) {
+	m, ok := v.(bson.M)
+	if !ok || len(m) == 0 {
+		return nil, false
+	}
+	for k := range m {
+		if !strings.HasPrefix(k, "$") {
+			return nil, false
+		}
+	}
+	return m, true
+}
+
+func matchValue(val any, exists bool, cond any) (bool, error) {
+	ops, ok := isOperatorDoc(cond)
+	if !ok {
+		return matchEq(val, cond), nil
+	}
+	for op, arg := range ops {
+		var ok bool
+		switch op {
+		case "$eq":
+			ok = matchEq(val, arg)
+		case "$ne":
+			ok = !matchEq(val, arg)
+		case "$gt", "$gte", "$lt", "$lte":
+			ok = exists && matchCmp(val, op, arg)
+		case "$in":
+			in, _ := arg.(bson.A)
+			for _, a := range in {
+				if matchEq(val, a) {
+					ok = true
+					break
+				}
+			}
+		case "$nin":
+			ok = true
+			nin, _ := arg.(bson.A)
+			for _, a := range nin {
+				if matchEq(val, a) {
+					ok = false
+					break
+				}
+			}
+		case "$exists":
+			ok = exists == truthy(arg)
+		case "$regex":
+			ok = matchRegex(val, arg, ops["$options"])
+		case "$options":
+			ok = true
+		default:
+			return false, fmt.Errorf("memstore: unsupported query operator %s", op)
+		}
+		if !ok {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// matchEq follows the MongoDB rule that an array field matches a value when
+// any of its elements does.
+func matchEq(val, cond any) bool {
+	if arr, ok := val.(bson.A); ok {
+		if _, ok := cond.(bson.A); !ok {
+			for _, v := range arr {
+				if equal(v, cond) {
+					return true
+				}
+			}
+			return false
+		}
+	}
+	return equal(val, cond)
+}
+
+func matchCmp(val any, op string, arg any) bool {
+	if arr, ok := val.(bson.A); ok {
+		for _, v := range arr {
+			if matchCmp(v, op, arg) {
+				return true
+			}
+		}
+		return false
+	}
+	n, ok := compare(val, arg)
+	if !ok {
+		return false
+	}
+	switch op {
+	case "$gt":
+		return n > 0
+	case "$gte":
+		return n >= 0
+	case "$lt":
+		return n < 0
+	default:
+		return n <= 0
+	}
+}
+
+func equal(a, b any) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	if n, ok := compare(a, b); ok {
+		return n == 0
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func toFloat(v any) (float64, bool) {
+	switch n := v.(type) {
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
+
+// compare orders two scalar bson values of the same kind. The second return
+// value is false when the values can't be compared.
+func compare(a, b any) (int, bool) {
+	if x, ok := toFloat(a); ok {
+		y, ok := toFloat(b)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case x < y:
+			return -1, true
+		case x > y:
+			return 1, true
+		}
+		return 0, true
+	}
+	switch x := a.(type) {
+	case string:
+		y, ok := b.(string)
+		if !ok {
+			return 0, false
+		}
+		return strings.Compare(x, y), true
+	case bool:
+		y, ok := b.(bool)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case x == y:
+			return 0, true
+		case !x:
+			return -1, true
+		}
+		return 1, true
+	case primitive.DateTime:
+		y, ok := b.(primitive.DateTime)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case x < y:
+			return -1, true
+		case x > y:
+			return 1, true
+		}
+		return 0, true
+	case primitive.ObjectID:
+		y, ok := b.(primitive.ObjectID)
+		if !ok {
+			return 0, false
+		}
+		return strings.Compare(x.Hex(), y.Hex()), true
+	}
+	return 0, false
+}
+
+// compareSort orders missing values before any other value, like MongoDB
+// does for null.
+func compareSort(a, b any) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return -1
+	case b == nil:
+		return 1
+	}
+	n, _ := compare(a, b)
+	return n
+}
+
+func truthy(v any) bool {
+	switch x := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return x
+	}
+	if n, ok := toFloat(v); ok {
+		return n != 0
+	}
+	return true
+}
+
+// eval evaluates an aggregation expression as used inside $expr
+func eval(doc bson.M, expr any) (any, error) {
+	switch e := expr.(type) {
+	case string:
+		if strings.HasPrefix(e, "$") {
+			v, _ := lookup(doc, strings.TrimPrefix(e, "$"))
+			return v, nil
+		}
+		return e, nil
+	case bson.A:
+		vs := bson.A{}
+		for _, v := range e {
+			ev, err := eval(doc, v)
+			if err != nil {
+				return nil, err
+			}
+			vs = append(vs, ev)
+		}
+		return vs, nil
+	case bson.M:
+		if len(e) != 1 {
+			return e, nil
+		}
+		for op, arg := range e {
+			return evalOp(doc, op, arg)
+		}
+	}
+	return expr, nil
+}
+
+func evalOp(doc bson.M, op string, arg any) (any, error) {
+	v, err := eval(doc, arg)
+	if err != nil {
+		return nil, err
+	}
+	args, _ := v.(bson.A)
+	arity := map[string]int{"$not": 1, "$eq": 2, "$ne": 2, "$gt": 2, "$gte": 2, "$lt": 2, "$lte": 2, "$in": 2}
+	if n, ok := arity[op]; ok && len(args) != n {
+		return nil, fmt.Errorf("memstore: %s takes %d arguments", op, n)
+	}
+	switch op {
+	case "$and":
+		for _, a := range args {
+			if !truthy(a) {
+				return false, nil
+			}
+		}
+		return true, nil
+	case "$or":
+		for _, a := range args {
+			if truthy(a) {
+				return true, nil
+			}
+		}
+		return false, nil
+	case "$not":
+		return !truthy(args[0]), nil
+	case "$eq":
+		return equal(args[0], args[1]), nil
+	case "$ne":
+		return !equal(args[0], args[1]), nil
+	case "$gt", "$gte", "$lt", "$lte":
+		return matchCmp(args[0], op, args[1]), nil
+	case "$in":
+		in, ok := args[1].(bson.A)
+		if !ok {
+			return nil, fmt.Errorf("memstore: $in needs an array")
+		}
+		for _, a := range in {
+			if equal(args[0], a) {
+				return true, nil
+			}
+		}
+		return false, nil
+	case "$year", "$month", "$dayOfMonth":
+		dt, ok := v.(primitive.DateTime)
+		if !ok {
+			return nil, nil
+		}
+		t := dt.Time().UTC()
+		switch op {
+		case "$year":
+			return int32(t.Year()), nil
+		case "$month":
+			return int32(t.Month()), nil
+		}
+		return int32(t.Day()), nil
+	}
+	return nil, fmt.Errorf("memstore: unsupported expression operator %s", op)
+}
+
+func applyUpdate(doc, update bson.M) (bson.M, error) {
+	updated, err := toDoc(doc)
+	if err != nil {
+		return nil, err
+	}
+	for op, fields := range update {
+		fm, ok := fields.(bson.M)
+		if !ok {
+			return nil, fmt.Errorf("memstore: invalid update for %s", op)
+		}
+		for path, v := range fm {
+			switch op {
+			case "$set":
+				setPath(updated, path, v)
+			case "$unset":
+				unsetPath(updated, path)
+			case "$inc":
+				cur, _ := lookup(updated, path)
+				x, _ := toFloat(cur)
+				y, _ := toFloat(v)
+				if _, isFloat := v.(float64); isFloat {
+					setPath(updated, path, x+y)
+				} else {
+					setPath(updated, path, int64(x+y))
+				}
+			case "$push":
+				cur, _ := lookup(updated, path)
+				arr, _ := cur.(bson.A)
+				setPath(updated, path, append(arr, v))
+			case "$pull":
+				cur, _ := lookup(updated, path)
+				arr, _ := cur.(bson.A)
+				kept := bson.A{}
+				for _, item := range arr {
+					ok, err := matchValue(item, true, v)
+					if err != nil {
+						return nil, err
+					}
+					if !ok {
+						kept = append(kept, item)
+					}
+				}
+				setPath(updated, path, kept)
+			case "$currentDate":
+				setPath(updated, path, primitive.NewDateTimeFromTime(time.Now()))
+			default:
+				return nil, fmt.Errorf("memstore: unsupported update operator %s", op)
+			}
+		}
+	}
+	return updated, nil
+}
+
+func setPath(doc bson.M, path string, v any) {
+	keys := strings.Split(path, ".")
+	cur := doc
+	for _, key := range keys[:len(keys)-1] {
+		next, ok := cur[key].(bson.M)
+		if !ok {
+			next = bson.M{}
+			cur[key] = next
+		}
+		cur = next
+	}
+	cur[keys[len(keys)-1]] = v
+}
+
+func unsetPath(doc bson.M, path string) {
+	keys := strings.Split(path, ".")
+	cur := doc
+	for _, key := range keys[:len(keys)-1] {
+		next, ok := cur[key].(bson.M)
+		if !ok {
+			return
+		}
+		cur = next
+	}
+	delete(cur, keys[len(keys)-1])
+}
@@ -0,0 +1,30 @@
+package memstore
+
+import (
+	"github.com/xV0lk/hotel-reservations/db"
+)
+
+// migrateCurrencies converts the amounts of the documents of c stored
+// without a currency to minor units, like the Mongo stores do.
+func migrateCurrencies[T any, PT db.LegacyAmounts[T]](c *collection) (int, error) {
+	docs, err := c.find(db.LegacyAmountsFilter())
+	if err != nil {
+		return 0, err
+	}
+	migrated := 0
+	for _, doc := range docs {
+		filter, update, err := db.MigrateCurrencyUpdate[T, PT](doc)
+		if err != nil {
+			return migrated, err
+		}
+		if update == nil {
+			continue
+		}
+		n, err := c.update(filter, update, false)
+		if err != nil {
+			return migrated, err
+		}
+		migrated += n
+	}
+	return migrated, nil
+}
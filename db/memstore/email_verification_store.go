@@ -0,0 +1,48 @@
+package memstore
+
+import (
+	"context"
+	"time"
+
+	"github.com/xV0lk/hotel-reservations/db"
+	"github.com/xV0lk/hotel-reservations/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const emailVerificationColl = "emailVerifications"
+
+type EmailVerificationStore struct {
+	coll *collection
+}
+
+func NewEmailVerificationStore(d *DB) *EmailVerificationStore {
+	return &EmailVerificationStore{
+		coll: d.collection(emailVerificationColl),
+	}
+}
+
+func (s *EmailVerificationStore) InsertEmailVerification(ctx context.Context, verification *types.EmailVerification) error {
+	id, err := s.coll.insertOne(verification)
+	if err != nil {
+		return err
+	}
+	verification.ID = id
+	return nil
+}
+
+func (s *EmailVerificationStore) UseEmailVerification(ctx context.Context, id primitive.ObjectID, hash string, now time.Time) (*types.EmailVerification, error) {
+	filter, update := db.UseTokenUpdate(id, hash, now)
+	matched, err := s.coll.update(filter, update, false)
+	if err != nil {
+		return nil, err
+	}
+	if matched == 0 {
+		return nil, db.ErrVerificationInvalid
+	}
+	doc, err := s.coll.findOne(bson.M{"_id": id})
+	if err != nil {
+		return nil, err
+	}
+	return decode[types.EmailVerification](doc)
+}
@@ -0,0 +1,41 @@
+package memstore
+
+import (
+	"context"
+
+	"github.com/xV0lk/hotel-reservations/db"
+	"github.com/xV0lk/hotel-reservations/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const exchangeRateColl = "exchangeRates"
+
+type ExchangeRateStore struct {
+	coll *collection
+}
+
+func NewExchangeRateStore(d *DB) *ExchangeRateStore {
+	return &ExchangeRateStore{
+		coll: d.collection(exchangeRateColl),
+	}
+}
+
+func (s *ExchangeRateStore) GetExchangeRates(ctx context.Context) (*types.ExchangeRates, error) {
+	doc, err := s.coll.findOne(bson.M{})
+	if err == mongo.ErrNoDocuments {
+		return db.NewExchangeRates(), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return decode[types.ExchangeRates](doc)
+}
+
+func (s *ExchangeRateStore) PutExchangeRates(ctx context.Context, rates *types.ExchangeRates) error {
+	if _, err := s.coll.delete(bson.M{}, true); err != nil {
+		return err
+	}
+	_, err := s.coll.insertOne(rates)
+	return err
+}
@@ -0,0 +1,103 @@
+package memstore
+
+import (
+	"context"
+
+	"github.com/xV0lk/hotel-reservations/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const hotelColl = "hotels"
+
+type HotelStore struct {
+	coll     *collection
+	bookings *collection
+}
+
+func NewHotelStore(d *DB) *HotelStore {
+	return &HotelStore{
+		coll:     d.collection(hotelColl),
+		bookings: d.collection(bookingColl),
+	}
+}
+
+func (s *HotelStore) InsertHotel(ctx context.Context, hotel *types.Hotel) error {
+	id, err := s.coll.insertOne(hotel)
+	if err != nil {
+		return err
+	}
+	hotel.ID = id
+	return nil
+}
+
+func (s *HotelStore) Update(ctx context.Context, filter, update bson.M) (*types.Hotel, error) {
+	matched, err := s.coll.update(filter, update, false)
+	if err != nil {
+		return nil, err
+	}
+	if matched == 0 {
+		return nil, mongo.ErrNoDocuments
+	}
+	hotelId := filter["_id"].(primitive.ObjectID).Hex()
+	return s.GetHotelById(ctx, hotelId)
+}
+
+func (s *HotelStore) DeleteHotel(ctx context.Context, id string) error {
+	objectId, _ := primitive.ObjectIDFromHex(id)
+	deleted, err := s.coll.delete(bson.M{"_id": objectId}, false)
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+func (s *HotelStore) GetHotelById(ctx context.Context, id string) (*types.Hotel, error) {
+	objectId, _ := primitive.ObjectIDFromHex(id)
+	doc, err := s.coll.findOne(bson.M{"_id": objectId})
+	if err != nil {
+		return nil, err
+	}
+	return decode[types.Hotel](doc)
+}
+
+func (s *HotelStore) ListHotels(ctx context.Context, filter bson.M, page types.PageParams) (*types.Page[types.Hotel], error) {
+	return findPage[types.Hotel](s.coll, filter, page)
+}
+
+// GetHotelBookings mirrors the $lookup done by the Mongo store, joining every
+// booking whose roomID is in the hotel's rooms array.
+func (s *HotelStore) MigrateHotels(ctx context.Context) (int, error) {
+	return migrateCurrencies[types.Hotel](s.coll)
+}
+
+func (s *HotelStore) GetHotelBookings(ctx context.Context, id string) ([]*types.HotelBookings, error) {
+	if _, err := s.GetHotelById(ctx, id); err != nil {
+		return nil, err
+	}
+	filter := bson.M{}
+	if id != "" {
+		objectId, _ := primitive.ObjectIDFromHex(id)
+		filter["_id"] = objectId
+	}
+	docs, err := s.coll.find(filter)
+	if err != nil {
+		return nil, err
+	}
+	for _, doc := range docs {
+		rooms, _ := doc["rooms"].(bson.A)
+		if rooms == nil {
+			rooms = bson.A{}
+		}
+		bookings, err := s.bookings.find(bson.M{"roomID": bson.M{"$in": rooms}})
+		if err != nil {
+			return nil, err
+		}
+		doc["bookings"] = bookings
+	}
+	return decodeAll[types.HotelBookings](docs)
+}
@@ -0,0 +1,73 @@
+// Package memstore provides thread-safe in-memory implementations of the
+// store interfaces in the db package. They are meant for tests and for running
+// the API offline, and mirror the behaviour of the Mongo stores.
+package memstore
+
+import (
+	"sync"
+
+	"github.com/xV0lk/hotel-reservations/db"
+)
+
+// DB holds the in-memory collections shared by the stores, playing the role
+// of a mongo.Client.
+type DB struct {
+	mu    sync.Mutex
+	colls map[string]*collection
+}
+
+func New() *DB {
+	return &DB{
+		colls: map[string]*collection{},
+	}
+}
+
+// NewStore returns a db.Store backed entirely by d
+func NewStore(d *DB) *db.Store {
+	hotelStore := NewHotelStore(d)
+	return &db.Store{
+		User:              NewUserStore(d),
+		Hotel:             hotelStore,
+		Room:              NewRoomStore(d, hotelStore),
+		Booking:           NewBookingStore(d),
+		Payment:           NewPaymentStore(d),
+		ExchangeRate:      NewExchangeRateStore(d),
+		Promo:             NewPromoStore(d),
+		Session:           NewSessionStore(d),
+		PasswordReset:     NewPasswordResetStore(d),
+		EmailVerification: NewEmailVerificationStore(d),
+	}
+}
+
+// Drop empties every collection, keeping their indexes
+func (d *DB) Drop() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, c := range d.colls {
+		c.drop()
+	}
+}
+
+func (d *DB) collection(name string) *collection {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	c, ok := d.colls[name]
+	if !ok {
+		c = newCollection()
+		d.colls[name] = c
+	}
+	return c
+}
+
+var (
+	_ db.UserStore              = (*UserStore)(nil)
+	_ db.HotelStore             = (*HotelStore)(nil)
+	_ db.RoomStore              = (*RoomStore)(nil)
+	_ db.BookingStore           = (*BookingStore)(nil)
+	_ db.PaymentStore           = (*PaymentStore)(nil)
+	_ db.ExchangeRateStore      = (*ExchangeRateStore)(nil)
+	_ db.PromoStore             = (*PromoStore)(nil)
+	_ db.SessionStore           = (*SessionStore)(nil)
+	_ db.PasswordResetStore     = (*PasswordResetStore)(nil)
+	_ db.EmailVerificationStore = (*EmailVerificationStore)(nil)
+)
@@ -0,0 +1,301 @@
+package memstore
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/xV0lk/hotel-reservations/db"
+	"github.com/xV0lk/hotel-reservations/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestUniqueEmail(t *testing.T) {
+	store := NewStore(New())
+	ctx := context.Background()
+	store.User.IndexEmail(ctx)
+	if err := store.User.InsertUser(ctx, &types.User{Email: "test@user.com"}); err != nil {
+		t.Fatal(err)
+	}
+	err := store.User.InsertUser(ctx, &types.User{Email: "test@user.com"})
+	if !mongo.IsDuplicateKeyError(err) {
+		t.Fatalf("expected duplicate key error, got %v", err)
+	}
+}
+
+func TestBookingFilters(t *testing.T) {
+	store := NewStore(New())
+	ctx := context.Background()
+	hotel := &types.Hotel{Name: "test hotel"}
+	if err := store.Hotel.InsertHotel(ctx, hotel); err != nil {
+		t.Fatal(err)
+	}
+	room := &types.Room{Type: types.Double, BasePrice: 100, HotelId: hotel.ID}
+	if err := store.Room.InsertRoom(ctx, room); err != nil {
+		t.Fatal(err)
+	}
+	from := time.Date(2030, time.March, 10, 12, 0, 0, 0, time.UTC)
+	booking := &types.Booking{
+		RoomID:    room.ID,
+		FromDate:  from,
+		UntilDate: from.AddDate(0, 0, 3),
+		NumPeople: 2,
+		Status:    types.BookingConfirmed,
+	}
+	if err := store.Booking.InsertBooking(ctx, booking); err != nil {
+		t.Fatal(err)
+	}
+
+	h, err := store.Hotel.GetHotelById(ctx, hotel.ID.Hex())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(h.Rooms) != 1 || h.Rooms[0] != room.ID {
+		t.Fatalf("expected hotel rooms to be [%s], got %v", room.ID.Hex(), h.Rooms)
+	}
+
+	tests := []struct {
+		name     string
+		body     types.BookingBody
+		expected int
+	}{
+		{"overlapping start", types.BookingBody{FromDate: from.AddDate(0, 0, -1), UntilDate: from.AddDate(0, 0, 1)}, 1},
+		{"contained", types.BookingBody{FromDate: from.AddDate(0, 0, 1), UntilDate: from.AddDate(0, 0, 2)}, 1},
+		{"before", types.BookingBody{FromDate: from.AddDate(0, 0, -3), UntilDate: from}, 0},
+		{"after", types.BookingBody{FromDate: from.AddDate(0, 0, 3), UntilDate: from.AddDate(0, 0, 5)}, 0},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			bookings, err := store.Booking.FilterBookings(ctx, tc.body.CreateAvailabilityFilter(room.ID, time.Now()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(bookings) != tc.expected {
+				t.Errorf("expected %d bookings, got %d", tc.expected, len(bookings))
+			}
+		})
+	}
+
+	month := types.BookingFilter{Month: 3, Year: 2030}
+	bookings, err := store.Booking.FilterBookings(ctx, month.CreateMonthFilter())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bookings) != 1 {
+		t.Errorf("expected 1 booking in the month, got %d", len(bookings))
+	}
+}
+
+func TestUnsupportedOperator(t *testing.T) {
+	store := NewStore(New())
+	ctx := context.Background()
+	if err := store.User.InsertUser(ctx, &types.User{Email: "test@user.com"}); err != nil {
+		t.Fatal(err)
+	}
+	filters := []bson.M{
+		{"email": bson.M{"$elemMatch": bson.M{"$eq": "test@user.com"}}},
+		{"$where": "this.email"},
+		{"$expr": bson.M{"$size": "$email"}},
+		{"$or": "email"},
+	}
+	for _, filter := range filters {
+		if _, err := store.User.GetUser(ctx, filter); err == nil || errors.Is(err, mongo.ErrNoDocuments) {
+			t.Errorf("expected an error for %v, got %v", filter, err)
+		}
+	}
+}
+
+func TestPageMissingSortField(t *testing.T) {
+	store := NewStore(New())
+	ctx := context.Background()
+	hotel := &types.Hotel{Name: "test hotel"}
+	if err := store.Hotel.InsertHotel(ctx, hotel); err != nil {
+		t.Fatal(err)
+	}
+	// rooms created before they had a number don't store one
+	for _, number := range []string{"", "101", "", "102", ""} {
+		room := &types.Room{Type: types.Double, BasePrice: 100, HotelId: hotel.ID, Number: number}
+		if err := store.Room.InsertRoom(ctx, room); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, sort := range []string{"number", "-number"} {
+		page := types.PageParams{Limit: 2, Sort: sort}
+		seen := map[primitive.ObjectID]bool{}
+		numbers := []string{}
+		for {
+			result, err := store.Room.ListRooms(ctx, bson.M{}, page)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, room := range result.Data {
+				seen[room.ID] = true
+				numbers = append(numbers, room.Number)
+			}
+			if result.Page.Next == "" {
+				break
+			}
+			page.Cursor = result.Page.Next
+		}
+		if len(seen) != 5 {
+			t.Errorf("expected to page through 5 rooms sorting by %s, got %d: %q", sort, len(seen), numbers)
+		}
+	}
+}
+
+func TestMigrateBookingLocks(t *testing.T) {
+	store := NewStore(New())
+	ctx := context.Background()
+	store.Booking.IndexRoomNights(ctx)
+	from := time.Now().AddDate(0, 0, 1)
+	roomID := primitive.NewObjectID()
+	// bookings made before nights were locked only exist in the bookings
+	legacy := &types.Booking{RoomID: roomID, FromDate: from, UntilDate: from.AddDate(0, 0, 2), Currency: types.DefaultCurrency, Status: types.BookingConfirmed}
+	if err := store.Booking.InsertBooking(ctx, legacy); err != nil {
+		t.Fatal(err)
+	}
+	migrated, err := store.Booking.MigrateBookings(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if migrated != 1 {
+		t.Fatalf("expected 1 migrated booking, got %d", migrated)
+	}
+	overlap := &types.Booking{RoomID: roomID, FromDate: from.AddDate(0, 0, 1), UntilDate: from.AddDate(0, 0, 3), Status: types.BookingConfirmed}
+	if err := store.Booking.ReserveBooking(ctx, overlap); !errors.Is(err, db.ErrRoomUnavailable) {
+		t.Fatalf("expected %v, got %v", db.ErrRoomUnavailable, err)
+	}
+	if migrated, err := store.Booking.MigrateBookings(ctx); err != nil || migrated != 0 {
+		t.Fatalf("expected running the migration again to do nothing, got %d, %v", migrated, err)
+	}
+}
+
+func TestMigrateCurrencies(t *testing.T) {
+	store := NewStore(New())
+	ctx := context.Background()
+	// amounts stored before they had a currency are whole dollars
+	hotel := &types.Hotel{Name: "test hotel", Taxes: types.TaxRules{CityTax: 2, Fees: []types.Fee{{Name: "Cleaning", Amount: 15}}}}
+	if err := store.Hotel.InsertHotel(ctx, hotel); err != nil {
+		t.Fatal(err)
+	}
+	booking := &types.Booking{
+		Price:        230,
+		Nightly:      []types.NightPrice{{Date: "2030-03-10", Base: 100, Total: 100}, {Date: "2030-03-11", Base: 100, Weekday: 10, Total: 110}},
+		Taxes:        []types.TaxLine{{Name: types.CityTaxLine, Amount: 20}},
+		Cancellation: &types.Cancellation{Refund: 30, Penalty: 200},
+		Status:       types.BookingCancelledWithFee,
+	}
+	if err := store.Booking.InsertBooking(ctx, booking); err != nil {
+		t.Fatal(err)
+	}
+	current := &types.Booking{Price: 23000, Currency: "EUR", Status: types.BookingConfirmed}
+	if err := store.Booking.InsertBooking(ctx, current); err != nil {
+		t.Fatal(err)
+	}
+
+	// the update sets only the converted amounts, so a status written while
+	// the migration runs is not reverted
+	doc, err := toDoc(booking)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, update, err := db.MigrateCurrencyUpdate[types.Booking](doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if set := update["$set"].(bson.M); set["status"] != nil || set["cancellation.refund"] != 3000 {
+		t.Errorf("expected only the amounts and currency to be set, got %v", set)
+	}
+
+	if migrated, err := store.Hotel.MigrateHotels(ctx); err != nil || migrated != 1 {
+		t.Fatalf("expected 1 migrated hotel, got %d, %v", migrated, err)
+	}
+	if migrated, err := store.Booking.MigrateBookings(ctx); err != nil || migrated != 1 {
+		t.Fatalf("expected 1 migrated booking, got %d, %v", migrated, err)
+	}
+	hotel, err = store.Hotel.GetHotelById(ctx, hotel.ID.Hex())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hotel.Currency != types.DefaultCurrency || hotel.Taxes.CityTax != 200 || hotel.Taxes.Fees[0].Amount != 1500 {
+		t.Errorf("expected the hotel amounts in cents, got %s %+v", hotel.Currency, hotel.Taxes)
+	}
+	migrated, err := store.Booking.GetBookingById(ctx, booking.ID.Hex())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if migrated.Currency != types.DefaultCurrency || migrated.Price != 23000 || migrated.Nightly[1].Weekday != 1000 ||
+		migrated.Nightly[1].Total != 11000 || migrated.Taxes[0].Amount != 2000 || migrated.Cancellation.Refund != 3000 {
+		t.Errorf("expected the booking amounts in cents, got %+v", migrated)
+	}
+	if migrated.Status != types.BookingCancelledWithFee || len(migrated.Nightly) != 2 {
+		t.Errorf("expected the rest of the booking to be kept, got %+v", migrated)
+	}
+	unchanged, err := store.Booking.GetBookingById(ctx, current.ID.Hex())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unchanged.Price != 23000 || unchanged.Currency != "EUR" {
+		t.Errorf("expected bookings with a currency to be left as they are, got %d %s", unchanged.Price, unchanged.Currency)
+	}
+	if migrated, err := store.Booking.MigrateBookings(ctx); err != nil || migrated != 0 {
+		t.Fatalf("expected running the migration again to do nothing, got %d, %v", migrated, err)
+	}
+}
+
+func TestIssueInvoiceNumber(t *testing.T) {
+	store := NewStore(New())
+	ctx := context.Background()
+	hotel := &types.Hotel{Name: "test hotel"}
+	if err := store.Hotel.InsertHotel(ctx, hotel); err != nil {
+		t.Fatal(err)
+	}
+	bookings := []*types.Booking{}
+	for i := 0; i < 3; i++ {
+		booking := &types.Booking{HotelID: hotel.ID, Status: types.BookingConfirmed}
+		if err := store.Booking.InsertBooking(ctx, booking); err != nil {
+			t.Fatal(err)
+		}
+		bookings = append(bookings, booking)
+	}
+	issue := func(booking *types.Booking) int {
+		issued, err := store.Booking.IssueInvoiceNumber(ctx, booking.ID.Hex(), hotel.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return issued.InvoiceNumber
+	}
+
+	// concurrent requests for the same booking issue a single number
+	numbers := make(chan int, 5)
+	for i := 0; i < cap(numbers); i++ {
+		go func() {
+			issued, err := store.Booking.IssueInvoiceNumber(ctx, bookings[0].ID.Hex(), hotel.ID)
+			if err != nil {
+				numbers <- 0
+				return
+			}
+			numbers <- issued.InvoiceNumber
+		}()
+	}
+	for i := 0; i < cap(numbers); i++ {
+		if n := <-numbers; n != 1 {
+			t.Fatalf("expected invoice number 1, got %d", n)
+		}
+	}
+
+	// a number issued for a booking that wasn't given it yet isn't skipped
+	filter, update := db.AdvanceInvoiceCounterUpdate(hotel.ID, db.InvoiceCounter{InvoiceCounter: 1}, bookings[1].ID)
+	if _, err := store.Hotel.Update(ctx, filter, update); err != nil {
+		t.Fatal(err)
+	}
+	if n := issue(bookings[2]); n != 3 {
+		t.Errorf("expected invoice number 3, got %d", n)
+	}
+	if n := issue(bookings[1]); n != 2 {
+		t.Errorf("expected the pending invoice number 2, got %d", n)
+	}
+}
@@ -0,0 +1,54 @@
+package memstore
+
+import (
+	"context"
+	"time"
+
+	"github.com/xV0lk/hotel-reservations/db"
+	"github.com/xV0lk/hotel-reservations/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const passwordResetColl = "passwordResets"
+
+type PasswordResetStore struct {
+	coll *collection
+}
+
+func NewPasswordResetStore(d *DB) *PasswordResetStore {
+	return &PasswordResetStore{
+		coll: d.collection(passwordResetColl),
+	}
+}
+
+func (s *PasswordResetStore) InsertPasswordReset(ctx context.Context, reset *types.PasswordReset) error {
+	id, err := s.coll.insertOne(reset)
+	if err != nil {
+		return err
+	}
+	reset.ID = id
+	return nil
+}
+
+func (s *PasswordResetStore) UsePasswordReset(ctx context.Context, id primitive.ObjectID, hash string, now time.Time) (*types.PasswordReset, error) {
+	filter, update := db.UseTokenUpdate(id, hash, now)
+	matched, err := s.coll.update(filter, update, false)
+	if err != nil {
+		return nil, err
+	}
+	if matched == 0 {
+		return nil, db.ErrResetInvalid
+	}
+	doc, err := s.coll.findOne(bson.M{"_id": id})
+	if err != nil {
+		return nil, err
+	}
+	return decode[types.PasswordReset](doc)
+}
+
+func (s *PasswordResetStore) RevokeUserResets(ctx context.Context, userID primitive.ObjectID, at time.Time) error {
+	filter, update := db.RevokeUserResetsUpdate(userID, at)
+	_, err := s.coll.update(filter, update, true)
+	return err
+}
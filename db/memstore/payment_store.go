@@ -0,0 +1,42 @@
+package memstore
+
+import (
+	"context"
+
+	"github.com/xV0lk/hotel-reservations/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const paymentColl = "payments"
+
+type PaymentStore struct {
+	coll *collection
+}
+
+func NewPaymentStore(d *DB) *PaymentStore {
+	return &PaymentStore{
+		coll: d.collection(paymentColl),
+	}
+}
+
+func (s *PaymentStore) InsertPayment(ctx context.Context, payment *types.Payment) error {
+	id, err := s.coll.insertOne(payment)
+	if err != nil {
+		return err
+	}
+	payment.ID = id
+	return nil
+}
+
+func (s *PaymentStore) GetPayments(ctx context.Context, bookingID primitive.ObjectID) ([]*types.Payment, error) {
+	docs, err := s.coll.findSorted(bson.M{"bookingID": bookingID}, "createdAt", false, 0)
+	if err != nil {
+		return nil, err
+	}
+	return decodeAll[types.Payment](docs)
+}
+
+func (s *PaymentStore) MigratePayments(ctx context.Context) (int, error) {
+	return migrateCurrencies[types.Payment](s.coll)
+}
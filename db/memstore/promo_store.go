@@ -0,0 +1,77 @@
+package memstore
+
+import (
+	"context"
+
+	"github.com/xV0lk/hotel-reservations/db"
+	"github.com/xV0lk/hotel-reservations/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const promoColl = "promos"
+
+type PromoStore struct {
+	coll *collection
+}
+
+func NewPromoStore(d *DB) *PromoStore {
+	return &PromoStore{
+		coll: d.collection(promoColl),
+	}
+}
+
+func (s *PromoStore) IndexCode(ctx context.Context) error {
+	s.coll.index("code")
+	return nil
+}
+
+func (s *PromoStore) InsertPromo(ctx context.Context, promo *types.PromoCode) error {
+	id, err := s.coll.insertOne(promo)
+	if err != nil {
+		return err
+	}
+	promo.ID = id
+	return nil
+}
+
+func (s *PromoStore) InsertManyPromos(ctx context.Context, promos []*types.PromoCode) error {
+	docs := make([]any, len(promos))
+	for i, p := range promos {
+		docs[i] = p
+	}
+	ids, err := s.coll.insertMany(docs)
+	for i, id := range ids {
+		promos[i].ID = id
+	}
+	return err
+}
+
+func (s *PromoStore) GetPromoByCode(ctx context.Context, code string) (*types.PromoCode, error) {
+	doc, err := s.coll.findOne(bson.M{"code": code})
+	if err != nil {
+		return nil, err
+	}
+	return decode[types.PromoCode](doc)
+}
+
+func (s *PromoStore) ListPromos(ctx context.Context, filter bson.M, page types.PageParams) (*types.Page[types.PromoCode], error) {
+	return findPage[types.PromoCode](s.coll, filter, page)
+}
+
+func (s *PromoStore) RedeemPromo(ctx context.Context, id, userID primitive.ObjectID) error {
+	filter, update := db.RedeemPromoUpdate(id, userID)
+	matched, err := s.coll.update(filter, update, false)
+	if err != nil {
+		return err
+	}
+	if matched == 0 {
+		return db.ErrPromoUsedUp
+	}
+	return nil
+}
+
+func (s *PromoStore) ReleasePromo(ctx context.Context, id, userID primitive.ObjectID) error {
+	_, err := s.coll.update(bson.M{"_id": id}, db.ReleasePromoUpdate(userID), false)
+	return err
+}
@@ -0,0 +1,191 @@
+package memstore
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/xV0lk/hotel-reservations/db"
+	"github.com/xV0lk/hotel-reservations/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const roomColl = "rooms"
+
+type RoomStore struct {
+	coll     *collection
+	hotels   *collection
+	bookings *collection
+
+	db.HotelStore
+}
+
+func NewRoomStore(d *DB, hotelStore db.HotelStore) *RoomStore {
+	return &RoomStore{
+		coll:       d.collection(roomColl),
+		hotels:     d.collection(hotelColl),
+		bookings:   d.collection(bookingColl),
+		HotelStore: hotelStore,
+	}
+}
+
+func (s *RoomStore) InsertRoom(ctx context.Context, room *types.Room) error {
+	hotel, err := s.HotelStore.GetHotelById(ctx, room.HotelId.Hex())
+	if err != nil {
+		return err
+	}
+	room.Currency = hotel.CurrencyCode()
+	id, err := s.coll.insertOne(room)
+	if err != nil {
+		return err
+	}
+	room.ID = id
+
+	// add room to hotel
+	filter := bson.M{"_id": room.HotelId}
+	update := bson.M{"$push": bson.M{"rooms": room.ID}}
+	_, err = s.HotelStore.Update(ctx, filter, update)
+	return err
+}
+
+func (s *RoomStore) InsertManyRooms(ctx context.Context, rooms []types.Room, hId primitive.ObjectID) error {
+	hotel, err := s.HotelStore.GetHotelById(ctx, hId.Hex())
+	if err != nil {
+		return err
+	}
+	var documents []any
+	for _, room := range rooms {
+		room.HotelId = hId
+		room.Currency = hotel.CurrencyCode()
+		documents = append(documents, room)
+	}
+	ids, err := s.coll.insertMany(documents)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		filter := bson.M{"_id": hId}
+		update := bson.M{"$push": bson.M{"rooms": id}}
+		if _, err = s.HotelStore.Update(ctx, filter, update); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *RoomStore) GetRooms(ctx context.Context, filter bson.M) ([]*types.Room, error) {
+	docs, err := s.coll.find(filter)
+	if err != nil {
+		return nil, err
+	}
+	return decodeAll[types.Room](docs)
+}
+
+func (s *RoomStore) ListRooms(ctx context.Context, filter bson.M, page types.PageParams) (*types.Page[types.Room], error) {
+	return findPage[types.Room](s.coll, filter, page)
+}
+
+func (s *RoomStore) GetRoomById(ctx context.Context, id string) (*types.Room, error) {
+	objectId, _ := primitive.ObjectIDFromHex(id)
+	doc, err := s.coll.findOne(bson.M{"_id": objectId})
+	if err != nil {
+		return nil, err
+	}
+	return decode[types.Room](doc)
+}
+
+func (s *RoomStore) DeleteRooms(ctx context.Context, filter bson.M) error {
+	_, err := s.coll.delete(filter, true)
+	return err
+}
+
+func (s *RoomStore) UpdateRoom(ctx context.Context, id string, update bson.M) (*types.Room, error) {
+	objectId, _ := primitive.ObjectIDFromHex(id)
+	matched, err := s.coll.update(bson.M{"_id": objectId}, update, false)
+	if err != nil {
+		return nil, err
+	}
+	if matched == 0 {
+		return nil, mongo.ErrNoDocuments
+	}
+	return s.GetRoomById(ctx, id)
+}
+
+func (s *RoomStore) DeleteRoom(ctx context.Context, id string) error {
+	room, err := s.GetRoomById(ctx, id)
+	if err != nil {
+		return err
+	}
+	if _, err := s.coll.delete(bson.M{"_id": room.ID}, false); err != nil {
+		return err
+	}
+	filter := bson.M{"_id": room.HotelId}
+	update := bson.M{"$pull": bson.M{"rooms": room.ID}}
+	_, err = s.HotelStore.Update(ctx, filter, update)
+	return err
+}
+
+func (s *RoomStore) MigrateRooms(ctx context.Context) (int, error) {
+	migrated := 0
+	for roomType, occupancy := range types.DefaultOccupancy {
+		filter := bson.M{"type": roomType, "maxOccupancy": bson.M{"$exists": false}}
+		update := bson.M{"$set": bson.M{"maxOccupancy": occupancy}}
+		matched, err := s.coll.update(filter, update, true)
+		if err != nil {
+			return migrated, err
+		}
+		migrated += matched
+	}
+	n, err := migrateCurrencies[types.Room](s.coll)
+	return migrated + n, err
+}
+
+// SearchAvailability computes in memory what the Mongo store does with a
+// single aggregation.
+func (s *RoomStore) SearchAvailability(ctx context.Context, q types.AvailabilityQuery) ([]*types.HotelAvailability, error) {
+	rooms, err := s.coll.findSorted(q.CreateRoomFilter(), "basePrice", false, 0)
+	if err != nil {
+		return nil, err
+	}
+	hotels := []*types.HotelAvailability{}
+	byHotel := map[primitive.ObjectID]*types.HotelAvailability{}
+	for _, doc := range rooms {
+		room, err := decode[types.Room](doc)
+		if err != nil {
+			return nil, err
+		}
+		conflicts, err := s.bookings.find(q.BookingBody().CreateAvailabilityFilter(room.ID, time.Now()))
+		if err != nil {
+			return nil, err
+		}
+		if len(conflicts) != 0 {
+			continue
+		}
+		availability, ok := byHotel[room.HotelId]
+		if !ok {
+			filter := q.CreateHotelFilter()
+			filter["_id"] = room.HotelId
+			hotelDocs, err := s.hotels.find(filter)
+			if err != nil {
+				return nil, err
+			}
+			if len(hotelDocs) == 0 {
+				continue
+			}
+			hotel, err := decode[types.Hotel](hotelDocs[0])
+			if err != nil {
+				return nil, err
+			}
+			availability = &types.HotelAvailability{Hotel: *hotel}
+			byHotel[room.HotelId] = availability
+			hotels = append(hotels, availability)
+		}
+		availability.Rooms = append(availability.Rooms, &types.AvailableRoom{Room: *room})
+	}
+	sort.SliceStable(hotels, func(i, j int) bool {
+		return hotels[i].Hotel.Name < hotels[j].Hotel.Name
+	})
+	return hotels, nil
+}
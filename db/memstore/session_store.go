@@ -0,0 +1,97 @@
+package memstore
+
+import (
+	"context"
+	"time"
+
+	"github.com/xV0lk/hotel-reservations/db"
+	"github.com/xV0lk/hotel-reservations/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	sessionColl      = "sessions"
+	revokedTokenColl = "revokedTokens"
+)
+
+type SessionStore struct {
+	coll    *collection
+	revoked *collection
+}
+
+func NewSessionStore(d *DB) *SessionStore {
+	return &SessionStore{
+		coll:    d.collection(sessionColl),
+		revoked: d.collection(revokedTokenColl),
+	}
+}
+
+// IndexExpiration is a no-op: expired tokens are filtered out when read
+func (s *SessionStore) IndexExpiration(ctx context.Context) error {
+	return nil
+}
+
+func (s *SessionStore) InsertSession(ctx context.Context, session *types.Session) error {
+	id, err := s.coll.insertOne(session)
+	if err != nil {
+		return err
+	}
+	session.ID = id
+	return nil
+}
+
+func (s *SessionStore) GetSessionById(ctx context.Context, id primitive.ObjectID) (*types.Session, error) {
+	doc, err := s.coll.findOne(bson.M{"_id": id})
+	if err != nil {
+		return nil, err
+	}
+	return decode[types.Session](doc)
+}
+
+func (s *SessionStore) RotateSession(ctx context.Context, id primitive.ObjectID, oldHash, newHash string, expiresAt time.Time) error {
+	filter, update := db.RotateSessionUpdate(id, oldHash, newHash, expiresAt)
+	matched, err := s.coll.update(filter, update, false)
+	if err != nil {
+		return err
+	}
+	if matched == 0 {
+		return db.ErrSessionRotated
+	}
+	return nil
+}
+
+func (s *SessionStore) RevokeSession(ctx context.Context, id primitive.ObjectID, at time.Time) error {
+	filter, update := db.RevokeSessionUpdate(id, at)
+	_, err := s.coll.update(filter, update, false)
+	return err
+}
+
+func (s *SessionStore) RevokeUserSessions(ctx context.Context, userID primitive.ObjectID, at time.Time) error {
+	filter, update := db.RevokeUserSessionsUpdate(userID, at)
+	_, err := s.coll.update(filter, update, true)
+	return err
+}
+
+func (s *SessionStore) RevokeToken(ctx context.Context, token *types.RevokedToken) error {
+	if _, err := s.revoked.delete(bson.M{"tokenID": token.TokenID}, false); err != nil {
+		return err
+	}
+	_, err := s.revoked.insertOne(token)
+	return err
+}
+
+func (s *SessionStore) GetRevokedTokens(ctx context.Context, now time.Time) ([]*types.RevokedToken, error) {
+	docs, err := s.revoked.find(bson.M{"expiresAt": bson.M{"$gt": now}})
+	if err != nil {
+		return nil, err
+	}
+	tokens, err := decodeAll[types.RevokedToken](docs)
+	if err != nil {
+		return nil, err
+	}
+	if tokens == nil {
+		tokens = []*types.RevokedToken{}
+	}
+	return tokens, nil
+}
@@ -0,0 +1,109 @@
+package memstore
+
+import (
+	"context"
+	"time"
+
+	"github.com/xV0lk/hotel-reservations/db"
+	"github.com/xV0lk/hotel-reservations/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const userColl = "users"
+
+type UserStore struct {
+	coll *collection
+}
+
+func NewUserStore(d *DB) *UserStore {
+	return &UserStore{
+		coll: d.collection(userColl),
+	}
+}
+
+func (s *UserStore) IndexEmail(ctx context.Context) error {
+	s.coll.index("email")
+	return nil
+}
+
+func (s *UserStore) Drop(ctx context.Context) error {
+	s.coll.drop()
+	return nil
+}
+
+func (s *UserStore) GetUserById(ctx context.Context, id string) (*types.User, error) {
+	objectId, _ := primitive.ObjectIDFromHex(id)
+	return s.GetUser(ctx, bson.M{"_id": objectId})
+}
+
+func (s *UserStore) GetUser(ctx context.Context, filter bson.M) (*types.User, error) {
+	doc, err := s.coll.findOne(filter)
+	if err != nil {
+		return nil, err
+	}
+	return decode[types.User](doc)
+}
+
+func (s *UserStore) ListUsers(ctx context.Context, filter bson.M, page types.PageParams) (*types.Page[types.User], error) {
+	return findPage[types.User](s.coll, filter, page)
+}
+
+func (s *UserStore) InsertUser(ctx context.Context, user *types.User) error {
+	id, err := s.coll.insertOne(user)
+	if err != nil {
+		return err
+	}
+	user.ID = id
+	return nil
+}
+
+func (s *UserStore) DeleteUser(ctx context.Context, id string) error {
+	objectId, _ := primitive.ObjectIDFromHex(id)
+	_, err := s.coll.delete(bson.M{"_id": objectId}, false)
+	return err
+}
+
+func (s *UserStore) UpdateUser(ctx context.Context, id string, updateUser *types.UpdateUserParams) (*types.User, error) {
+	objectId, _ := primitive.ObjectIDFromHex(id)
+	filter := bson.M{"_id": objectId}
+	update := bson.M{"$set": updateUser.ToBson()}
+	matched, err := s.coll.update(filter, update, false)
+	if err != nil {
+		return nil, err
+	}
+	if matched == 0 {
+		return nil, mongo.ErrNoDocuments
+	}
+	return s.GetUserById(ctx, id)
+}
+
+func (s *UserStore) UpdatePassword(ctx context.Context, id string, hash string, changedAt time.Time) error {
+	objectId, _ := primitive.ObjectIDFromHex(id)
+	matched, err := s.coll.update(bson.M{"_id": objectId}, db.UpdatePasswordUpdate(hash, changedAt), false)
+	if err != nil {
+		return err
+	}
+	if matched == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+func (s *UserStore) VerifyEmail(ctx context.Context, id string) error {
+	objectId, _ := primitive.ObjectIDFromHex(id)
+	matched, err := s.coll.update(bson.M{"_id": objectId}, bson.M{"$set": bson.M{"emailVerified": true}}, false)
+	if err != nil {
+		return err
+	}
+	if matched == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+func (s *UserStore) MigrateUsers(ctx context.Context) (int, error) {
+	filter, update := db.MigrateUsersUpdate()
+	return s.coll.update(filter, update, true)
+}
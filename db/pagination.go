@@ -0,0 +1,153 @@
+package db
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+
+	"github.com/xV0lk/hotel-reservations/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var ErrInvalidCursor = errors.New("invalid page cursor")
+
+// cursor is the position after the last document of a page. It is encoded as
+// base64 bson so clients can only pass it back as is.
+type cursor struct {
+	Sort  string             `bson:"s"`
+	Value any                `bson:"v"`
+	ID    primitive.ObjectID `bson:"id"`
+}
+
+func encodeCursor(c cursor) (string, error) {
+	b, err := bson.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func decodeCursor(s string) (*cursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var c cursor
+	if err := bson.Unmarshal(b, &c); err != nil {
+		return nil, ErrInvalidCursor
+	}
+	return &c, nil
+}
+
+// PageFilter adds the keyset condition of the page cursor to filter, so only
+// documents after the cursor in the page sort order are matched.
+func PageFilter(filter bson.M, page types.PageParams) (bson.M, error) {
+	if page.Cursor == "" {
+		return filter, nil
+	}
+	c, err := decodeCursor(page.Cursor)
+	if err != nil {
+		return nil, err
+	}
+	if c.Sort != page.Sort {
+		return nil, ErrInvalidCursor
+	}
+	field, desc := page.SortField()
+	op := "$gt"
+	if desc {
+		op = "$lt"
+	}
+	after := bson.M{"_id": bson.M{op: c.ID}}
+	if field != "_id" {
+		after = afterValue(field, op, c)
+	}
+	if len(filter) == 0 {
+		return after, nil
+	}
+	return bson.M{"$and": []bson.M{filter, after}}, nil
+}
+
+// afterValue matches the documents after the cursor sorting by field.
+// Documents missing the field sort as null, before any other value, and
+// comparison operators never match them, so they get their own condition.
+func afterValue(field, op string, c *cursor) bson.M {
+	same := bson.M{field: c.Value, "_id": bson.M{op: c.ID}}
+	switch {
+	case c.Value == nil && op == "$gt":
+		return bson.M{"$or": []bson.M{{field: bson.M{"$ne": nil}}, same}}
+	case c.Value == nil:
+		return same
+	case op == "$gt":
+		return bson.M{"$or": []bson.M{{field: bson.M{op: c.Value}}, same}}
+	default:
+		return bson.M{"$or": []bson.M{{field: bson.M{op: c.Value}}, {field: nil}, same}}
+	}
+}
+
+// NewPage builds the page envelope from documents fetched with one more than
+// the page limit, using the extra one to know whether there's a next page.
+func NewPage[T any](docs []bson.M, page types.PageParams) (*types.Page[T], error) {
+	result := &types.Page[T]{
+		Data: []*T{},
+		Page: types.PageMeta{Limit: page.Limit, Sort: page.Sort},
+	}
+	hasNext := len(docs) > page.Limit
+	if hasNext {
+		docs = docs[:page.Limit]
+	}
+	for _, doc := range docs {
+		b, err := bson.Marshal(doc)
+		if err != nil {
+			return nil, err
+		}
+		var v T
+		if err := bson.Unmarshal(b, &v); err != nil {
+			return nil, err
+		}
+		result.Data = append(result.Data, &v)
+	}
+	result.Page.Count = len(result.Data)
+	if hasNext {
+		last := docs[len(docs)-1]
+		field, _ := page.SortField()
+		next, err := encodeCursor(cursor{
+			Sort:  page.Sort,
+			Value: last[field],
+			ID:    last["_id"].(primitive.ObjectID),
+		})
+		if err != nil {
+			return nil, err
+		}
+		result.Page.Next = next
+	}
+	return result, nil
+}
+
+func findPage[T any](ctx context.Context, coll *mongo.Collection, filter bson.M, page types.PageParams) (*types.Page[T], error) {
+	filter, err := PageFilter(filter, page)
+	if err != nil {
+		return nil, err
+	}
+	field, desc := page.SortField()
+	order := 1
+	if desc {
+		order = -1
+	}
+	sort := bson.D{{Key: field, Value: order}}
+	if field != "_id" {
+		sort = append(sort, bson.E{Key: "_id", Value: order})
+	}
+	opts := options.Find().SetSort(sort).SetLimit(int64(page.Limit + 1))
+	cur, err := coll.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	var docs []bson.M
+	if err := cur.All(ctx, &docs); err != nil {
+		return nil, err
+	}
+	return NewPage[T](docs, page)
+}
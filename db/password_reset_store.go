@@ -0,0 +1,88 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/xV0lk/hotel-reservations/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const passwordResetColl = "passwordResets"
+
+// ErrResetInvalid is returned when using a password reset token that is
+// unknown, expired or was already used.
+var ErrResetInvalid = errors.New("the password reset token is invalid or has expired")
+
+type PasswordResetStore interface {
+	InsertPasswordReset(ctx context.Context, reset *types.PasswordReset) error
+	// UsePasswordReset marks the reset with the token hash as used,
+	// returning it, if it was unused and not expired at now.
+	UsePasswordReset(ctx context.Context, id primitive.ObjectID, hash string, now time.Time) (*types.PasswordReset, error)
+	// RevokeUserResets marks every unused reset of the user as used
+	RevokeUserResets(ctx context.Context, userID primitive.ObjectID, at time.Time) error
+}
+
+type MongoPasswordResetStore struct {
+	client *mongo.Client
+	coll   *mongo.Collection
+}
+
+func NewMongoPasswordResetStore(client *mongo.Client, dbname string) *MongoPasswordResetStore {
+	return &MongoPasswordResetStore{
+		client: client,
+		coll:   client.Database(dbname).Collection(passwordResetColl),
+	}
+}
+
+func (s *MongoPasswordResetStore) InsertPasswordReset(ctx context.Context, reset *types.PasswordReset) error {
+	result, err := s.coll.InsertOne(ctx, reset)
+	if err != nil {
+		return err
+	}
+	reset.ID = result.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+func (s *MongoPasswordResetStore) UsePasswordReset(ctx context.Context, id primitive.ObjectID, hash string, now time.Time) (*types.PasswordReset, error) {
+	filter, update := UseTokenUpdate(id, hash, now)
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	var reset types.PasswordReset
+	if err := s.coll.FindOneAndUpdate(ctx, filter, update, opts).Decode(&reset); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrResetInvalid
+		}
+		return nil, err
+	}
+	return &reset, nil
+}
+
+func (s *MongoPasswordResetStore) RevokeUserResets(ctx context.Context, userID primitive.ObjectID, at time.Time) error {
+	filter, update := RevokeUserResetsUpdate(userID, at)
+	_, err := s.coll.UpdateMany(ctx, filter, update)
+	return err
+}
+
+// UseTokenUpdate returns the filter and update marking a single use token,
+// a password reset or an email verification, as used. They only match while
+// it is unused, unexpired and has hash.
+func UseTokenUpdate(id primitive.ObjectID, hash string, now time.Time) (bson.M, bson.M) {
+	filter := bson.M{
+		"_id":       id,
+		"tokenHash": hash,
+		"usedAt":    bson.M{"$exists": false},
+		"expiresAt": bson.M{"$gt": now},
+	}
+	update := bson.M{"$set": bson.M{"usedAt": now}}
+	return filter, update
+}
+
+func RevokeUserResetsUpdate(userID primitive.ObjectID, at time.Time) (bson.M, bson.M) {
+	filter := bson.M{"userID": userID, "usedAt": bson.M{"$exists": false}}
+	update := bson.M{"$set": bson.M{"usedAt": at}}
+	return filter, update
+}
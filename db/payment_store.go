@@ -0,0 +1,61 @@
+package db
+
+import (
+	"context"
+
+	"github.com/xV0lk/hotel-reservations/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const paymentColl = "payments"
+
+type PaymentStore interface {
+	InsertPayment(ctx context.Context, payment *types.Payment) error
+	GetPayments(ctx context.Context, bookingID primitive.ObjectID) ([]*types.Payment, error)
+	MigratePayments(ctx context.Context) (int, error)
+}
+
+type MongoPaymentStore struct {
+	client *mongo.Client
+	coll   *mongo.Collection
+}
+
+func NewMongoPaymentStore(client *mongo.Client, dbname string) *MongoPaymentStore {
+	return &MongoPaymentStore{
+		client: client,
+		coll:   client.Database(dbname).Collection(paymentColl),
+	}
+}
+
+func (s *MongoPaymentStore) InsertPayment(ctx context.Context, payment *types.Payment) error {
+	result, err := s.coll.InsertOne(ctx, payment)
+	if err != nil {
+		return err
+	}
+	payment.ID = result.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+// GetPayments returns the payments of the booking in the order they were made
+func (s *MongoPaymentStore) GetPayments(ctx context.Context, bookingID primitive.ObjectID) ([]*types.Payment, error) {
+	payments := []*types.Payment{}
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}, {Key: "_id", Value: 1}})
+	cursor, err := s.coll.Find(ctx, bson.M{"bookingID": bookingID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := cursor.All(ctx, &payments); err != nil {
+		return nil, err
+	}
+	return payments, nil
+}
+
+// MigratePayments converts the amount of payments recorded before they had a
+// currency to minor units of the default one. It returns the number of
+// payments updated.
+func (s *MongoPaymentStore) MigratePayments(ctx context.Context) (int, error) {
+	return migrateCurrencies[types.Payment](ctx, s.coll)
+}
@@ -0,0 +1,128 @@
+package db
+
+import (
+	"context"
+	"errors"
+
+	"github.com/xV0lk/hotel-reservations/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const promoColl = "promos"
+
+var ErrPromoUsedUp = errors.New("the promo code has been used up")
+
+type PromoStore interface {
+	IndexCode(ctx context.Context) error
+	InsertPromo(ctx context.Context, promo *types.PromoCode) error
+	InsertManyPromos(ctx context.Context, promos []*types.PromoCode) error
+	GetPromoByCode(ctx context.Context, code string) (*types.PromoCode, error)
+	ListPromos(ctx context.Context, filter bson.M, page types.PageParams) (*types.Page[types.PromoCode], error)
+	// RedeemPromo counts a use of the promo by the user, failing with
+	// ErrPromoUsedUp once it reached one of its usage limits.
+	RedeemPromo(ctx context.Context, id, userID primitive.ObjectID) error
+	// ReleasePromo gives back a use of the promo by the user
+	ReleasePromo(ctx context.Context, id, userID primitive.ObjectID) error
+}
+
+type MongoPromoStore struct {
+	client *mongo.Client
+	coll   *mongo.Collection
+}
+
+func NewMongoPromoStore(client *mongo.Client, dbname string) *MongoPromoStore {
+	return &MongoPromoStore{
+		client: client,
+		coll:   client.Database(dbname).Collection(promoColl),
+	}
+}
+
+func (s *MongoPromoStore) IndexCode(ctx context.Context) error {
+	_, err := s.coll.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.M{"code": 1},
+		Options: options.Index().SetUnique(true),
+	})
+	return err
+}
+
+func (s *MongoPromoStore) InsertPromo(ctx context.Context, promo *types.PromoCode) error {
+	result, err := s.coll.InsertOne(ctx, promo)
+	if err != nil {
+		return err
+	}
+	promo.ID = result.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+func (s *MongoPromoStore) InsertManyPromos(ctx context.Context, promos []*types.PromoCode) error {
+	docs := make([]interface{}, len(promos))
+	for i, p := range promos {
+		docs[i] = p
+	}
+	result, err := s.coll.InsertMany(ctx, docs)
+	if err != nil {
+		return err
+	}
+	for i, id := range result.InsertedIDs {
+		promos[i].ID = id.(primitive.ObjectID)
+	}
+	return nil
+}
+
+func (s *MongoPromoStore) GetPromoByCode(ctx context.Context, code string) (*types.PromoCode, error) {
+	var promo types.PromoCode
+	if err := s.coll.FindOne(ctx, bson.M{"code": code}).Decode(&promo); err != nil {
+		return nil, err
+	}
+	return &promo, nil
+}
+
+func (s *MongoPromoStore) ListPromos(ctx context.Context, filter bson.M, page types.PageParams) (*types.Page[types.PromoCode], error) {
+	return findPage[types.PromoCode](ctx, s.coll, filter, page)
+}
+
+func (s *MongoPromoStore) RedeemPromo(ctx context.Context, id, userID primitive.ObjectID) error {
+	filter, update := RedeemPromoUpdate(id, userID)
+	result, err := s.coll.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrPromoUsedUp
+	}
+	return nil
+}
+
+func (s *MongoPromoStore) ReleasePromo(ctx context.Context, id, userID primitive.ObjectID) error {
+	_, err := s.coll.UpdateOne(ctx, bson.M{"_id": id}, ReleasePromoUpdate(userID))
+	return err
+}
+
+// RedeemPromoUpdate returns the filter and update counting a use of the
+// promo by the user, which only match while it is under its usage limits.
+func RedeemPromoUpdate(id, userID primitive.ObjectID) (bson.M, bson.M) {
+	userUses := "usesByUser." + userID.Hex()
+	filter := bson.M{
+		"_id": id,
+		"$and": []bson.M{
+			{"$or": []bson.M{
+				{"maxUses": 0},
+				{"$expr": bson.M{"$lt": bson.A{"$uses", "$maxUses"}}},
+			}},
+			{"$or": []bson.M{
+				{"maxUsesPerUser": 0},
+				{userUses: bson.M{"$exists": false}},
+				{"$expr": bson.M{"$lt": bson.A{"$" + userUses, "$maxUsesPerUser"}}},
+			}},
+		},
+	}
+	update := bson.M{"$inc": bson.M{"uses": 1, userUses: 1}}
+	return filter, update
+}
+
+func ReleasePromoUpdate(userID primitive.ObjectID) bson.M {
+	return bson.M{"$inc": bson.M{"uses": -1, "usesByUser." + userID.Hex(): -1}}
+}
@@ -3,8 +3,8 @@ package db
 import (
 	"context"
 	"log"
+	"time"
 
-	"github.com/valyala/fasthttp"
 	"github.com/xV0lk/hotel-reservations/types"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -16,8 +16,14 @@ const roomColl = "rooms"
 type RoomStore interface {
 	InsertRoom(ctx context.Context, room *types.Room) error
 	InsertManyRooms(ctx context.Context, rooms []types.Room, hId primitive.ObjectID) error
-	GetRooms(ctx *fasthttp.RequestCtx, filter bson.M) ([]*types.Room, error)
-	GetRoomById(ctx *fasthttp.RequestCtx, id string) (*types.Room, error)
+	GetRooms(ctx context.Context, filter bson.M) ([]*types.Room, error)
+	ListRooms(ctx context.Context, filter bson.M, page types.PageParams) (*types.Page[types.Room], error)
+	GetRoomById(ctx context.Context, id string) (*types.Room, error)
+	UpdateRoom(ctx context.Context, id string, update bson.M) (*types.Room, error)
+	DeleteRoom(ctx context.Context, id string) error
+	DeleteRooms(ctx context.Context, filter bson.M) error
+	MigrateRooms(ctx context.Context) (int, error)
+	SearchAvailability(ctx context.Context, q types.AvailabilityQuery) ([]*types.HotelAvailability, error)
 }
 
 type MongoRoomStore struct {
@@ -35,7 +41,14 @@ func NewMongoRoomStore(client *mongo.Client, hotelStore HotelStore, dbname strin
 	}
 }
 
+// InsertRoom adds the room to its hotel. Rooms are priced in the currency of
+// their hotel.
 func (s *MongoRoomStore) InsertRoom(ctx context.Context, room *types.Room) error {
+	hotel, err := s.HotelStore.GetHotelById(ctx, room.HotelId.Hex())
+	if err != nil {
+		return err
+	}
+	room.Currency = hotel.CurrencyCode()
 	result, err := s.coll.InsertOne(ctx, room)
 	if err != nil {
 		return err
@@ -53,9 +66,14 @@ func (s *MongoRoomStore) InsertRoom(ctx context.Context, room *types.Room) error
 }
 
 func (s *MongoRoomStore) InsertManyRooms(ctx context.Context, rooms []types.Room, hId primitive.ObjectID) error {
+	hotel, err := s.HotelStore.GetHotelById(ctx, hId.Hex())
+	if err != nil {
+		return err
+	}
 	var documents []interface{}
 	for _, room := range rooms {
 		room.HotelId = hId
+		room.Currency = hotel.CurrencyCode()
 		documents = append(documents, room)
 	}
 	iRooms, err := s.coll.InsertMany(ctx, documents)
@@ -72,7 +90,7 @@ func (s *MongoRoomStore) InsertManyRooms(ctx context.Context, rooms []types.Room
 	return nil
 }
 
-func (s *MongoRoomStore) GetRooms(ctx *fasthttp.RequestCtx, filter bson.M) ([]*types.Room, error) {
+func (s *MongoRoomStore) GetRooms(ctx context.Context, filter bson.M) ([]*types.Room, error) {
 	var rooms []*types.Room
 	cursor, err := s.coll.Find(ctx, filter)
 	if err != nil {
@@ -84,7 +102,11 @@ func (s *MongoRoomStore) GetRooms(ctx *fasthttp.RequestCtx, filter bson.M) ([]*t
 	return rooms, nil
 }
 
-func (s *MongoRoomStore) GetRoomById(ctx *fasthttp.RequestCtx, id string) (*types.Room, error) {
+func (s *MongoRoomStore) ListRooms(ctx context.Context, filter bson.M, page types.PageParams) (*types.Page[types.Room], error) {
+	return findPage[types.Room](ctx, s.coll, filter, page)
+}
+
+func (s *MongoRoomStore) GetRoomById(ctx context.Context, id string) (*types.Room, error) {
 	var room types.Room
 	objectId, _ := primitive.ObjectIDFromHex(id)
 	if err := s.coll.FindOne(ctx, bson.M{"_id": objectId}).Decode(&room); err != nil {
@@ -92,3 +114,105 @@ func (s *MongoRoomStore) GetRoomById(ctx *fasthttp.RequestCtx, id string) (*type
 	}
 	return &room, nil
 }
+
+func (s *MongoRoomStore) DeleteRooms(ctx context.Context, filter bson.M) error {
+	_, err := s.coll.DeleteMany(ctx, filter)
+	return err
+}
+
+func (s *MongoRoomStore) UpdateRoom(ctx context.Context, id string, update bson.M) (*types.Room, error) {
+	objectId, _ := primitive.ObjectIDFromHex(id)
+	result, err := s.coll.UpdateOne(ctx, bson.M{"_id": objectId}, update)
+	if err != nil {
+		return nil, err
+	}
+	if result.MatchedCount == 0 {
+		return nil, mongo.ErrNoDocuments
+	}
+	return s.GetRoomById(ctx, id)
+}
+
+// DeleteRoom deletes the room and removes it from its hotel's rooms array
+func (s *MongoRoomStore) DeleteRoom(ctx context.Context, id string) error {
+	room, err := s.GetRoomById(ctx, id)
+	if err != nil {
+		return err
+	}
+	if _, err := s.coll.DeleteOne(ctx, bson.M{"_id": room.ID}); err != nil {
+		return err
+	}
+	filter := bson.M{"_id": room.HotelId}
+	update := bson.M{"$pull": bson.M{"rooms": room.ID}}
+	_, err = s.HotelStore.Update(ctx, filter, update)
+	return err
+}
+
+// MigrateRooms backfills maxOccupancy on rooms stored before it existed,
+// using the default capacity of their type, and converts the price of rooms
+// stored before they had a currency to minor units of the default one. It
+// returns the number of updates made.
+func (s *MongoRoomStore) MigrateRooms(ctx context.Context) (int, error) {
+	migrated := 0
+	for roomType, occupancy := range types.DefaultOccupancy {
+		filter := bson.M{"type": roomType, "maxOccupancy": bson.M{"$exists": false}}
+		update := bson.M{"$set": bson.M{"maxOccupancy": occupancy}}
+		result, err := s.coll.UpdateMany(ctx, filter, update)
+		if err != nil {
+			return migrated, err
+		}
+		migrated += int(result.ModifiedCount)
+	}
+	n, err := migrateCurrencies[types.Room](ctx, s.coll)
+	return migrated + n, err
+}
+
+// SearchAvailability returns, grouped by hotel, the rooms matching the query
+// that have no active booking overlapping the searched dates. It runs as a
+// single aggregation over the rooms collection.
+func (s *MongoRoomStore) SearchAvailability(ctx context.Context, q types.AvailabilityQuery) ([]*types.HotelAvailability, error) {
+	overlap := q.BookingBody().CreateOverlapFilter(time.Now())
+	overlap["$expr"] = bson.M{"$or": bson.A{
+		bson.M{"$eq": bson.A{"$roomID", "$$roomId"}},
+		bson.M{"$eq": bson.A{"$allocatedRoom", "$$roomId"}},
+	}}
+	hotelMatch := bson.M{}
+	for k, v := range q.CreateHotelFilter() {
+		hotelMatch["hotel."+k] = v
+	}
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: q.CreateRoomFilter()}},
+		{{Key: "$lookup", Value: bson.M{
+			"from":     bookingColl,
+			"let":      bson.M{"roomId": "$_id"},
+			"pipeline": bson.A{bson.M{"$match": overlap}, bson.M{"$limit": 1}},
+			"as":       "conflicts",
+		}}},
+		{{Key: "$match", Value: bson.M{"conflicts": bson.M{"$size": 0}}}},
+		{{Key: "$project", Value: bson.M{"conflicts": 0}}},
+		{{Key: "$lookup", Value: bson.M{
+			"from":         hotelColl,
+			"localField":   "hotelId",
+			"foreignField": "_id",
+			"as":           "hotel",
+		}}},
+		{{Key: "$unwind", Value: "$hotel"}},
+		{{Key: "$match", Value: hotelMatch}},
+		{{Key: "$sort", Value: bson.D{{Key: "basePrice", Value: 1}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":   "$hotelId",
+			"hotel": bson.M{"$first": "$hotel"},
+			"rooms": bson.M{"$push": "$$ROOT"},
+		}}},
+		{{Key: "$project", Value: bson.M{"rooms.hotel": 0}}},
+		{{Key: "$sort", Value: bson.D{{Key: "hotel.name", Value: 1}}}},
+	}
+	cursor, err := s.coll.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	hotels := []*types.HotelAvailability{}
+	if err := cursor.All(ctx, &hotels); err != nil {
+		return nil, err
+	}
+	return hotels, nil
+}
@@ -0,0 +1,145 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/xV0lk/hotel-reservations/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	sessionColl      = "sessions"
+	revokedTokenColl = "revokedTokens"
+)
+
+// ErrSessionRotated is returned when rotating the refresh token of a session
+// that was revoked or rotated by someone else in the meantime.
+var ErrSessionRotated = errors.New("the session was already refreshed or revoked")
+
+type SessionStore interface {
+	// IndexExpiration lets the database drop the revoked tokens once
+	// they expire
+	IndexExpiration(ctx context.Context) error
+	InsertSession(ctx context.Context, session *types.Session) error
+	GetSessionById(ctx context.Context, id primitive.ObjectID) (*types.Session, error)
+	// RotateSession replaces the refresh token hash of the active session,
+	// if it is still oldHash.
+	RotateSession(ctx context.Context, id primitive.ObjectID, oldHash, newHash string, expiresAt time.Time) error
+	RevokeSession(ctx context.Context, id primitive.ObjectID, at time.Time) error
+	// RevokeUserSessions revokes every active session of the user
+	RevokeUserSessions(ctx context.Context, userID primitive.ObjectID, at time.Time) error
+	RevokeToken(ctx context.Context, token *types.RevokedToken) error
+	// GetRevokedTokens returns the revoked tokens that haven't expired at now
+	GetRevokedTokens(ctx context.Context, now time.Time) ([]*types.RevokedToken, error)
+}
+
+type MongoSessionStore struct {
+	client  *mongo.Client
+	coll    *mongo.Collection
+	revoked *mongo.Collection
+}
+
+func NewMongoSessionStore(client *mongo.Client, dbname string) *MongoSessionStore {
+	return &MongoSessionStore{
+		client:  client,
+		coll:    client.Database(dbname).Collection(sessionColl),
+		revoked: client.Database(dbname).Collection(revokedTokenColl),
+	}
+}
+
+func (s *MongoSessionStore) IndexExpiration(ctx context.Context) error {
+	_, err := s.revoked.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.M{"expiresAt": 1},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	return err
+}
+
+func (s *MongoSessionStore) InsertSession(ctx context.Context, session *types.Session) error {
+	result, err := s.coll.InsertOne(ctx, session)
+	if err != nil {
+		return err
+	}
+	session.ID = result.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+func (s *MongoSessionStore) GetSessionById(ctx context.Context, id primitive.ObjectID) (*types.Session, error) {
+	var session types.Session
+	if err := s.coll.FindOne(ctx, bson.M{"_id": id}).Decode(&session); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+func (s *MongoSessionStore) RotateSession(ctx context.Context, id primitive.ObjectID, oldHash, newHash string, expiresAt time.Time) error {
+	filter, update := RotateSessionUpdate(id, oldHash, newHash, expiresAt)
+	result, err := s.coll.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrSessionRotated
+	}
+	return nil
+}
+
+func (s *MongoSessionStore) RevokeSession(ctx context.Context, id primitive.ObjectID, at time.Time) error {
+	filter, update := RevokeSessionUpdate(id, at)
+	_, err := s.coll.UpdateOne(ctx, filter, update)
+	return err
+}
+
+func (s *MongoSessionStore) RevokeUserSessions(ctx context.Context, userID primitive.ObjectID, at time.Time) error {
+	filter, update := RevokeUserSessionsUpdate(userID, at)
+	_, err := s.coll.UpdateMany(ctx, filter, update)
+	return err
+}
+
+func (s *MongoSessionStore) RevokeToken(ctx context.Context, token *types.RevokedToken) error {
+	opts := options.Replace().SetUpsert(true)
+	_, err := s.revoked.ReplaceOne(ctx, bson.M{"tokenID": token.TokenID}, token, opts)
+	return err
+}
+
+func (s *MongoSessionStore) GetRevokedTokens(ctx context.Context, now time.Time) ([]*types.RevokedToken, error) {
+	tokens := []*types.RevokedToken{}
+	cursor, err := s.revoked.Find(ctx, bson.M{"expiresAt": bson.M{"$gt": now}})
+	if err != nil {
+		return nil, err
+	}
+	if err := cursor.All(ctx, &tokens); err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// RotateSessionUpdate returns the filter and update replacing the refresh
+// token hash of the session, which only match while it is active and
+// still has oldHash.
+func RotateSessionUpdate(id primitive.ObjectID, oldHash, newHash string, expiresAt time.Time) (bson.M, bson.M) {
+	filter := bson.M{
+		"_id":       id,
+		"tokenHash": oldHash,
+		"revokedAt": bson.M{"$exists": false},
+	}
+	update := bson.M{"$set": bson.M{"tokenHash": newHash, "expiresAt": expiresAt}}
+	return filter, update
+}
+
+func RevokeSessionUpdate(id primitive.ObjectID, at time.Time) (bson.M, bson.M) {
+	filter := bson.M{"_id": id, "revokedAt": bson.M{"$exists": false}}
+	update := bson.M{"$set": bson.M{"revokedAt": at}}
+	return filter, update
+}
+
+func RevokeUserSessionsUpdate(userID primitive.ObjectID, at time.Time) (bson.M, bson.M) {
+	filter := bson.M{"userID": userID, "revokedAt": bson.M{"$exists": false}}
+	update := bson.M{"$set": bson.M{"revokedAt": at}}
+	return filter, update
+}
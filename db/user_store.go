@@ -3,8 +3,8 @@ package db
 import (
 	"context"
 	"fmt"
+	"time"
 
-	"github.com/valyala/fasthttp"
 	"github.com/xV0lk/hotel-reservations/types"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -19,12 +19,17 @@ type Dropper interface {
 }
 type UserStore interface {
 	IndexEmail(ctx context.Context) error
-	GetUserById(ctx *fasthttp.RequestCtx, id string) (*types.User, error)
-	GetUsers(ctx *fasthttp.RequestCtx) ([]*types.User, error)
-	GetUser(ctx *fasthttp.RequestCtx, filter bson.M) (*types.User, error)
+	GetUserById(ctx context.Context, id string) (*types.User, error)
+	ListUsers(ctx context.Context, filter bson.M, page types.PageParams) (*types.Page[types.User], error)
+	GetUser(ctx context.Context, filter bson.M) (*types.User, error)
 	InsertUser(ctx context.Context, user *types.User) error
-	DeleteUser(ctx *fasthttp.RequestCtx, id string) error
-	UpdateUser(ctx *fasthttp.RequestCtx, id string, updateUser *types.UpdateUserParams) (*types.User, error)
+	DeleteUser(ctx context.Context, id string) error
+	UpdateUser(ctx context.Context, id string, updateUser *types.UpdateUserParams) (*types.User, error)
+	// UpdatePassword replaces the password hash of the user, recording when
+	// it changed
+	UpdatePassword(ctx context.Context, id string, hash string, changedAt time.Time) error
+	VerifyEmail(ctx context.Context, id string) error
+	MigrateUsers(ctx context.Context) (int, error)
 
 	Dropper
 }
@@ -54,7 +59,7 @@ func (s *MongoUserStore) Drop(ctx context.Context) error {
 	return s.coll.Drop(ctx)
 }
 
-func (s *MongoUserStore) GetUserById(ctx *fasthttp.RequestCtx, id string) (*types.User, error) {
+func (s *MongoUserStore) GetUserById(ctx context.Context, id string) (*types.User, error) {
 	var user types.User
 	objectId, _ := primitive.ObjectIDFromHex(id)
 	if err := s.coll.FindOne(ctx, bson.M{"_id": objectId}).Decode(&user); err != nil {
@@ -63,7 +68,7 @@ func (s *MongoUserStore) GetUserById(ctx *fasthttp.RequestCtx, id string) (*type
 	return &user, nil
 }
 
-func (s *MongoUserStore) GetUser(ctx *fasthttp.RequestCtx, filter bson.M) (*types.User, error) {
+func (s *MongoUserStore) GetUser(ctx context.Context, filter bson.M) (*types.User, error) {
 	var user types.User
 	if err := s.coll.FindOne(ctx, filter).Decode(&user); err != nil {
 		return nil, err
@@ -71,16 +76,8 @@ func (s *MongoUserStore) GetUser(ctx *fasthttp.RequestCtx, filter bson.M) (*type
 	return &user, nil
 }
 
-func (s *MongoUserStore) GetUsers(ctx *fasthttp.RequestCtx) ([]*types.User, error) {
-	var users []*types.User
-	cursor, err := s.coll.Find(ctx, bson.M{})
-	if err != nil {
-		return nil, err
-	}
-	if err := cursor.All(ctx, &users); err != nil {
-		return nil, err
-	}
-	return users, nil
+func (s *MongoUserStore) ListUsers(ctx context.Context, filter bson.M, page types.PageParams) (*types.Page[types.User], error) {
+	return findPage[types.User](ctx, s.coll, filter, page)
 }
 
 func (s *MongoUserStore) InsertUser(ctx context.Context, user *types.User) error {
@@ -92,7 +89,7 @@ func (s *MongoUserStore) InsertUser(ctx context.Context, user *types.User) error
 	return nil
 }
 
-func (s *MongoUserStore) DeleteUser(ctx *fasthttp.RequestCtx, id string) error {
+func (s *MongoUserStore) DeleteUser(ctx context.Context, id string) error {
 	objectId, _ := primitive.ObjectIDFromHex(id)
 	_, err := s.coll.DeleteOne(ctx, bson.M{"_id": objectId})
 	if err != nil {
@@ -101,7 +98,7 @@ func (s *MongoUserStore) DeleteUser(ctx *fasthttp.RequestCtx, id string) error {
 	return nil
 }
 
-func (s *MongoUserStore) UpdateUser(ctx *fasthttp.RequestCtx, id string, updateUser *types.UpdateUserParams) (*types.User, error) {
+func (s *MongoUserStore) UpdateUser(ctx context.Context, id string, updateUser *types.UpdateUserParams) (*types.User, error) {
 	objectId, _ := primitive.ObjectIDFromHex(id)
 	filter := bson.M{"_id": objectId}
 	// update := bson.M{"$set": updateUser}
@@ -119,3 +116,48 @@ func (s *MongoUserStore) UpdateUser(ctx *fasthttp.RequestCtx, id string, updateU
 		return updated, nil
 	}
 }
+
+func (s *MongoUserStore) UpdatePassword(ctx context.Context, id string, hash string, changedAt time.Time) error {
+	objectId, _ := primitive.ObjectIDFromHex(id)
+	result, err := s.coll.UpdateOne(ctx, bson.M{"_id": objectId}, UpdatePasswordUpdate(hash, changedAt))
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+func UpdatePasswordUpdate(hash string, changedAt time.Time) bson.M {
+	return bson.M{"$set": bson.M{"password": hash, "passwordChangedAt": changedAt}}
+}
+
+func (s *MongoUserStore) VerifyEmail(ctx context.Context, id string) error {
+	objectId, _ := primitive.ObjectIDFromHex(id)
+	result, err := s.coll.UpdateOne(ctx, bson.M{"_id": objectId}, bson.M{"$set": bson.M{"emailVerified": true}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+// MigrateUsers marks the users registered before emails were verified as
+// verified, so they can keep booking. It returns the number of users updated.
+func (s *MongoUserStore) MigrateUsers(ctx context.Context) (int, error) {
+	filter, update := MigrateUsersUpdate()
+	result, err := s.coll.UpdateMany(ctx, filter, update)
+	if err != nil {
+		return 0, err
+	}
+	return int(result.ModifiedCount), nil
+}
+
+func MigrateUsersUpdate() (bson.M, bson.M) {
+	filter := bson.M{"emailVerified": bson.M{"$exists": false}}
+	update := bson.M{"$set": bson.M{"emailVerified": true}}
+	return filter, update
+}
@@ -0,0 +1,34 @@
+// Package invoices renders the invoices of bookings as HTML or plain text
+// documents.
+package invoices
+
+import (
+	"embed"
+	htmltemplate "html/template"
+	"io"
+	texttemplate "text/template"
+
+	"github.com/xV0lk/hotel-reservations/types"
+)
+
+//go:embed templates
+var files embed.FS
+
+// funcs are the helpers available to the templates. amount formats an
+// amount in minor units with the decimals of the currency.
+var funcs = map[string]any{
+	"amount": types.FormatAmount,
+}
+
+var (
+	htmlTemplate = htmltemplate.Must(htmltemplate.New("invoice.html").Funcs(funcs).ParseFS(files, "templates/invoice.html"))
+	textTemplate = texttemplate.Must(texttemplate.New("invoice.txt").Funcs(funcs).ParseFS(files, "templates/invoice.txt"))
+)
+
+func RenderHTML(w io.Writer, invoice *types.Invoice) error {
+	return htmlTemplate.Execute(w, invoice)
+}
+
+func RenderText(w io.Writer, invoice *types.Invoice) error {
+	return textTemplate.Execute(w, invoice)
+}
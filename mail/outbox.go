@@ -0,0 +1,91 @@
+package mail
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	netmail "net/mail"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const outboxFrom = "outbox@localhost"
+
+// Outbox is a Mailer writing every message to a file of its directory
+// instead of sending it, for tests and local development. The files are
+// named after the time they were written, so they list in order.
+type Outbox struct {
+	mu  sync.Mutex
+	dir string
+	n   int
+}
+
+func NewOutbox(dir string) (*Outbox, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &Outbox{dir: dir}, nil
+}
+
+func (o *Outbox) Send(ctx context.Context, msg Message) error {
+	now := time.Now()
+	b, err := msg.format(outboxFrom, now)
+	if err != nil {
+		return err
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.n++
+	name := fmt.Sprintf("%s-%06d.eml", now.UTC().Format("20060102T150405.000000000"), o.n)
+	return os.WriteFile(filepath.Join(o.dir, name), b, 0o644)
+}
+
+// Messages reads back the messages of the outbox, oldest first
+func (o *Outbox) Messages() ([]Message, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	names, err := filepath.Glob(filepath.Join(o.dir, "*.eml"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	msgs := []Message{}
+	for _, name := range names {
+		msg, err := readMessage(name)
+		if err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs, nil
+}
+
+func readMessage(name string) (Message, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return Message{}, err
+	}
+	defer f.Close()
+	m, err := netmail.ReadMessage(f)
+	if err != nil {
+		return Message{}, err
+	}
+	body, err := io.ReadAll(m.Body)
+	if err != nil {
+		return Message{}, err
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(m.Header.Get("Subject"))
+	if err != nil {
+		return Message{}, err
+	}
+	return Message{
+		To:      m.Header.Get("To"),
+		Subject: subject,
+		Body:    strings.ReplaceAll(string(body), "\r\n", "\n"),
+	}, nil
+}
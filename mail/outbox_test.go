@@ -0,0 +1,41 @@
+package mail
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestOutbox(t *testing.T) {
+	ctx := context.Background()
+	o, err := NewOutbox(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sent := []Message{
+		{To: "guest@example.com", Subject: "Réservation", Body: "Hello\nworld\n"},
+		{To: "other@example.com", Subject: "Second", Body: "Bye"},
+	}
+	for _, msg := range sent {
+		if err := o.Send(ctx, msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	injected := Message{To: "guest@example.com\r\nBcc: everyone@example.com", Subject: "Hi"}
+	if err := o.Send(ctx, injected); !errors.Is(err, ErrInvalidHeader) {
+		t.Fatalf("expected %v, got %v", ErrInvalidHeader, err)
+	}
+
+	msgs, err := o.Messages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != len(sent) {
+		t.Fatalf("expected %d messages, got %d", len(sent), len(msgs))
+	}
+	for i, msg := range msgs {
+		if msg != sent[i] {
+			t.Errorf("expected %+v, got %+v", sent[i], msg)
+		}
+	}
+}
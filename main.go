@@ -5,19 +5,32 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"os"
+	"strconv"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/logger"
 	"github.com/joho/godotenv"
 	"github.com/xV0lk/hotel-reservations/api"
 	"github.com/xV0lk/hotel-reservations/db"
+	"github.com/xV0lk/hotel-reservations/mail"
+	"github.com/xV0lk/hotel-reservations/payments"
+	"github.com/xV0lk/hotel-reservations/types"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 const (
-	dbUri    = "mongodb://localhost:27017"
-	userColl = "users"
+	dbUri          = "mongodb://localhost:27017"
+	userColl       = "users"
+	requestTimeout = 10 * time.Second
+	// holdSweepInterval is how often expired holds are released
+	holdSweepInterval = 30 * time.Second
+	// refundRetryInterval is how often failed refunds are made again
+	refundRetryInterval = 5 * time.Minute
+	defaultSMTPPort     = 587
+	defaultOutbox       = "outbox"
 )
 
 var fconfig = fiber.Config{
@@ -41,46 +54,97 @@ func main() {
 	// Initialize handlers
 	var (
 		// stores
-		userStore    = db.NewMongoUserStore(client, db.DBNAME)
-		hotelStore   = db.NewMongoHotelStore(client, db.DBNAME)
-		roomStore    = db.NewMongoRoomStore(client, hotelStore, db.DBNAME)
-		bookingStore = db.NewMongoBookingStore(client, db.DBNAME)
-		store        = &db.Store{
-			User:    userStore,
-			Hotel:   hotelStore,
-			Room:    roomStore,
-			Booking: bookingStore,
-		}
+		store = db.NewMongoStore(client, db.DBNAME)
+		// There is no real payment provider yet, so every environment
+		// charges through the fake one
+		gateway = payments.NewFakeGateway()
+		mailer  = newMailer()
 		// handlers
-		userHandler    = api.NewUserHandler(userStore)
+		userHandler    = api.NewUserHandler(store, mailer)
 		hotelHandler   = api.NewHotelHandler(store)
-		authHandler    = api.NewAuthHandler(userStore)
-		roomHandler    = api.NewRoomHandler(store)
-		bookingHandler = api.NewBookingHandler(store)
+		authHandler    = api.NewAuthHandler(store, mailer)
+		roomHandler    = api.NewRoomHandler(store, gateway)
+		bookingHandler = api.NewBookingHandler(store, gateway)
+		availHandler   = api.NewAvailabilityHandler(store)
+		rateHandler    = api.NewExchangeRateHandler(store)
+		promoHandler   = api.NewPromoHandler(store)
+		groupHandler   = api.NewGroupHandler(store, gateway)
+		invHandler     = api.NewInventoryHandler(store, gateway)
 		// connection
 		port = flag.String("port", ":3000", "port to run the server on")
 		app  = fiber.New(fconfig)
-		// apiV1 = app.Group("/api/v1")
-		apiV1 = app.Group("/api/v1", api.JWTAuth(userStore))
-		admin = apiV1.Group("/admin", api.AdminAuth)
 	)
 
 	// Add loggin middleware
 	app.Use(logger.New())
+	// Cancel store calls of requests that take too long
+	app.Use(api.RequestTimeout(requestTimeout))
+
+	var (
+		// apiV1 = app.Group("/api/v1")
+		apiV1 = app.Group("/api/v1", api.JWTAuth(store.User, store.Session))
+		admin = apiV1.Group("/admin", api.AdminAuth)
+	)
 
 	// Create unique email index
 	store.User.IndexEmail(context.Background())
+	// Create unique room night index used to reserve bookings atomically
+	store.Booking.IndexRoomNights(context.Background())
+	// Create unique promo code index
+	store.Promo.IndexCode(context.Background())
+	// Let mongo drop revoked access tokens once they expire
+	store.Session.IndexExpiration(context.Background())
+	if err := api.LoadRevokedTokens(context.Background(), store.Session); err != nil {
+		log.Fatal(err)
+	}
+	// Users registered before emails were verified keep booking
+	if _, err := store.User.MigrateUsers(context.Background()); err != nil {
+		log.Fatal(err)
+	}
+	// Amounts stored before they had a currency were whole dollars, convert
+	// them to cents
+	if _, err := store.Hotel.MigrateHotels(context.Background()); err != nil {
+		log.Fatal(err)
+	}
+	// Backfill the capacity of rooms created before it was stored per room
+	if _, err := store.Room.MigrateRooms(context.Background()); err != nil {
+		log.Fatal(err)
+	}
+	// Give bookings stored with the cancelled flag a status and lock the
+	// nights of the bookings made before nights were locked
+	if _, err := store.Booking.MigrateBookings(context.Background()); err != nil {
+		log.Fatal(err)
+	}
+	if _, err := store.Payment.MigratePayments(context.Background()); err != nil {
+		log.Fatal(err)
+	}
+
+	// Release the holds that ran out in the background
+	go sweepHolds(store.Booking, holdSweepInterval)
+	// Make the refunds the gateway failed in the background
+	go retryRefunds(store, gateway, refundRetryInterval)
 
 	app.Get("/", handleHome)
 	// Auth
 	app.Post("/api/auth", authHandler.HandleAuthenticate)
+	app.Post("/api/auth/register", userHandler.HandleRegister)
+	app.Post("/api/auth/refresh", authHandler.HandleRefresh)
+	app.Post("/api/auth/logout", api.JWTAuth(store.User, store.Session), authHandler.HandleLogout)
+	app.Post("/api/auth/forgot", authHandler.HandleForgotPassword)
+	app.Post("/api/auth/reset", authHandler.HandleResetPassword)
+	app.Get("/api/auth/verify", authHandler.HandleVerifyEmail)
+	app.Post("/api/auth/verify/resend", api.JWTAuth(store.User, store.Session), authHandler.HandleResendVerification)
 
-	// user handlers
-	apiV1.Get("/user", userHandler.HandleGetUsers)
-	apiV1.Get("/user/:id", userHandler.HandleGetUser)
-	apiV1.Post("/user", userHandler.HandlePostUser)
-	apiV1.Delete("/user/:id", userHandler.HandleDeleteUser)
-	apiV1.Put("/user/:id", userHandler.HandlePutUser)
+	// user handlers, users manage their own account through /me and the
+	// rest of the accounts are left to admins
+	apiV1.Get("/me", userHandler.HandleGetMe)
+	apiV1.Patch("/me", userHandler.HandlePatchMe)
+	apiV1.Delete("/me", userHandler.HandleDeleteMe)
+	apiV1.Get("/user", api.AdminAuth, userHandler.HandleGetUsers)
+	apiV1.Get("/user/:id", api.AdminAuth, userHandler.HandleGetUser)
+	apiV1.Post("/user", api.AdminAuth, userHandler.HandlePostUser)
+	apiV1.Delete("/user/:id", api.AdminAuth, userHandler.HandleDeleteUser)
+	apiV1.Put("/user/:id", api.AdminAuth, userHandler.HandlePutUser)
 	// apiV1.Post("/login", userHandler.HandleLogin)
 
 	// hotel handlers
@@ -89,16 +153,59 @@ func main() {
 	apiV1.Get("/hotel/:id", hotelHandler.HandleGetHotel)
 	apiV1.Get("/hotel/:id/rooms", hotelHandler.HandleGetRooms)
 	apiV1.Get("/hotel/:id/bookings", hotelHandler.HandleGetBookingsById)
+	admin.Get("/hotel/:id/calendar", hotelHandler.HandleGetCalendar)
+	admin.Post("/hotel", hotelHandler.HandlePostHotel)
+	admin.Put("/hotel/:id", hotelHandler.HandlePutHotel)
+	admin.Patch("/hotel/:id", hotelHandler.HandlePatchHotel)
+	admin.Delete("/hotel/:id", hotelHandler.HandleDeleteHotel)
+	admin.Put("/hotel/:id/rates", hotelHandler.HandlePutRates)
+	admin.Put("/hotel/:id/cancellation", hotelHandler.HandlePutCancellation)
+	admin.Put("/hotel/:id/taxes", hotelHandler.HandlePutTaxes)
+	admin.Put("/exchange-rates", rateHandler.HandlePutExchangeRates)
+
+	// promo code handlers
+	admin.Get("/promo", promoHandler.HandleGetPromos)
+	admin.Get("/promo/:code", promoHandler.HandleGetPromo)
+	admin.Post("/promo", promoHandler.HandlePostPromo)
+	admin.Post("/promo/vouchers", promoHandler.HandlePostVouchers)
 
 	// room handlers
-	apiV1.Post("/room/:id/book", roomHandler.HandleBookRoom)
+	apiV1.Post("/room/:id/book", api.VerifiedAuth, roomHandler.HandleBookRoom)
+	apiV1.Post("/room/:id/quote", roomHandler.HandleQuoteRoom)
+	apiV1.Post("/room/:id/hold", api.VerifiedAuth, roomHandler.HandleHoldRoom)
 	apiV1.Get("/room", roomHandler.HandleGetRooms)
+	apiV1.Get("/room/:id/calendar", roomHandler.HandleGetCalendar)
+	admin.Post("/hotel/:id/room", roomHandler.HandlePostRoom)
+	admin.Put("/room/:id", roomHandler.HandlePutRoom)
+	admin.Patch("/room/:id", roomHandler.HandlePatchRoom)
+	admin.Post("/room/:id/retire", roomHandler.HandleRetireRoom)
+	admin.Delete("/room/:id", roomHandler.HandleDeleteRoom)
+
+	// availability handlers
+	apiV1.Get("/availability", availHandler.HandleSearch)
+	apiV1.Get("/exchange-rates", rateHandler.HandleGetExchangeRates)
+	apiV1.Get("/hotel/:id/inventory", invHandler.HandleGetInventory)
+	apiV1.Post("/hotel/:id/book", api.VerifiedAuth, invHandler.HandleBookRoomType)
 
 	// booking Handlers
 	admin.Get("/booking", bookingHandler.HandleGetBookings)
 	apiV1.Get("/booking/month", bookingHandler.HandleMonthBookings)
 	apiV1.Get("/booking/:id", bookingHandler.HandleGetBooking)
+	apiV1.Patch("/booking/:id", bookingHandler.HandlePatchBooking)
+	apiV1.Post("/booking/:id/book", api.VerifiedAuth, bookingHandler.HandleBookHold)
+	apiV1.Get("/booking/:id/payments", bookingHandler.HandleGetPayments)
+	apiV1.Get("/booking/:id/invoice", bookingHandler.HandleGetInvoice)
 	apiV1.Delete("/booking/:id", bookingHandler.HandleCancelBooking)
+	admin.Post("/booking/:id/confirm", bookingHandler.HandleTransition(types.BookingConfirmed))
+	admin.Post("/booking/:id/check-in", bookingHandler.HandleTransition(types.BookingCheckedIn))
+	admin.Post("/booking/:id/check-out", bookingHandler.HandleTransition(types.BookingCheckedOut))
+	admin.Post("/booking/:id/no-show", bookingHandler.HandleTransition(types.BookingNoShow))
+	admin.Post("/booking/:id/assign", bookingHandler.HandleAssignRoom)
+
+	// group booking handlers
+	apiV1.Post("/group", api.VerifiedAuth, groupHandler.HandlePostGroup)
+	apiV1.Get("/group/:id", groupHandler.HandleGetGroup)
+	apiV1.Delete("/group/:id", groupHandler.HandleCancelGroup)
 
 	app.Listen(*port)
 }
@@ -107,3 +214,59 @@ func main() {
 func handleHome(c *fiber.Ctx) error {
 	return c.JSON(map[string]string{"message": "Server is working!"})
 }
+
+// newMailer sends the emails through the SMTP server of SMTP_HOST when it is
+// set, otherwise they are written to the MAIL_OUTBOX directory.
+func newMailer() mail.Mailer {
+	host := os.Getenv("SMTP_HOST")
+	if host == "" {
+		dir := os.Getenv("MAIL_OUTBOX")
+		if dir == "" {
+			dir = defaultOutbox
+		}
+		outbox, err := mail.NewOutbox(dir)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("SMTP_HOST isn't set, writing emails to %s", dir)
+		return outbox
+	}
+	port := defaultSMTPPort
+	if p := os.Getenv("SMTP_PORT"); p != "" {
+		var err error
+		if port, err = strconv.Atoi(p); err != nil {
+			log.Fatalf("invalid SMTP_PORT %q", p)
+		}
+	}
+	from := os.Getenv("MAIL_FROM")
+	if from == "" {
+		log.Fatal("MAIL_FROM must be set to send emails through SMTP")
+	}
+	return mail.NewSMTPMailer(host, port, os.Getenv("SMTP_USERNAME"), os.Getenv("SMTP_PASSWORD"), from)
+}
+
+// sweepHolds releases the expired holds every interval
+func sweepHolds(store db.BookingStore, interval time.Duration) {
+	for range time.Tick(interval) {
+		ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+		if n, err := store.ExpireHolds(ctx, time.Now()); err != nil {
+			log.Printf("expiring holds: %v", err)
+		} else if n != 0 {
+			log.Printf("expired %d holds", n)
+		}
+		cancel()
+	}
+}
+
+// retryRefunds makes the pending refunds every interval
+func retryRefunds(store *db.Store, gateway payments.Gateway, interval time.Duration) {
+	for range time.Tick(interval) {
+		ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+		if n, err := api.RetryRefunds(ctx, store, gateway); err != nil {
+			log.Printf("retrying refunds: %v", err)
+		} else if n != 0 {
+			log.Printf("made %d pending refunds", n)
+		}
+		cancel()
+	}
+}
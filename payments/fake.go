@@ -0,0 +1,99 @@
+package payments
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// DeclinedToken is the token the fake gateway always declines
+const DeclinedToken = "tok_declined"
+
+// FakeGateway is a deterministic in-memory Gateway for tests and local
+// development. It approves every token but DeclinedToken, and enforces the
+// same rules a real provider would: captures can't exceed the authorized
+// amount, refunds can't exceed the captured one, and captured authorizations
+// can't be voided.
+type FakeGateway struct {
+	mu      sync.Mutex
+	n       int
+	auths   map[string]*fakeAuth
+	charges map[string]*fakeCharge
+}
+
+type fakeAuth struct {
+	amount int
+	done   bool
+}
+
+type fakeCharge struct {
+	amount   int
+	refunded int
+}
+
+func NewFakeGateway() *FakeGateway {
+	return &FakeGateway{
+		auths:   map[string]*fakeAuth{},
+		charges: map[string]*fakeCharge{},
+	}
+}
+
+func (g *FakeGateway) Authorize(ctx context.Context, token string, amount int, currency string) (string, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if token == DeclinedToken {
+		return "", ErrDeclined
+	}
+	if amount <= 0 {
+		return "", fmt.Errorf("can't authorize %d", amount)
+	}
+	ref := g.ref("auth")
+	g.auths[ref] = &fakeAuth{amount: amount}
+	return ref, nil
+}
+
+func (g *FakeGateway) Capture(ctx context.Context, authorization string, amount int) (string, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	auth, ok := g.auths[authorization]
+	if !ok || auth.done {
+		return "", fmt.Errorf("authorization %s can't be captured", authorization)
+	}
+	if amount <= 0 || amount > auth.amount {
+		return "", fmt.Errorf("can't capture %d of %d", amount, auth.amount)
+	}
+	auth.done = true
+	ref := g.ref("charge")
+	g.charges[ref] = &fakeCharge{amount: amount}
+	return ref, nil
+}
+
+func (g *FakeGateway) Refund(ctx context.Context, charge string, amount int) (string, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	c, ok := g.charges[charge]
+	if !ok {
+		return "", fmt.Errorf("unknown charge %s", charge)
+	}
+	if amount <= 0 || c.refunded+amount > c.amount {
+		return "", fmt.Errorf("can't refund %d of the %d left", amount, c.amount-c.refunded)
+	}
+	c.refunded += amount
+	return g.ref("refund"), nil
+}
+
+func (g *FakeGateway) Void(ctx context.Context, authorization string) (string, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	auth, ok := g.auths[authorization]
+	if !ok || auth.done {
+		return "", fmt.Errorf("authorization %s can't be voided", authorization)
+	}
+	auth.done = true
+	return g.ref("void"), nil
+}
+
+func (g *FakeGateway) ref(kind string) string {
+	g.n++
+	return fmt.Sprintf("fake_%s_%d", kind, g.n)
+}
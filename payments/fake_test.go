@@ -0,0 +1,44 @@
+package payments
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestFakeGateway(t *testing.T) {
+	ctx := context.Background()
+	g := NewFakeGateway()
+
+	if _, err := g.Authorize(ctx, DeclinedToken, 100, "USD"); !errors.Is(err, ErrDeclined) {
+		t.Fatalf("expected %v, got %v", ErrDeclined, err)
+	}
+	auth, err := g.Authorize(ctx, "tok_visa", 100, "USD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := g.Capture(ctx, auth, 150); err == nil {
+		t.Fatal("expected capturing more than authorized to fail")
+	}
+	charge, err := g.Capture(ctx, auth, 80)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := g.Void(ctx, auth); err == nil {
+		t.Fatal("expected voiding a captured authorization to fail")
+	}
+	if _, err := g.Refund(ctx, charge, 50); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := g.Refund(ctx, charge, 50); err == nil {
+		t.Fatal("expected refunding more than captured to fail")
+	}
+
+	other, _ := g.Authorize(ctx, "tok_visa", 100, "USD")
+	if _, err := g.Void(ctx, other); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := g.Capture(ctx, other, 100); err == nil {
+		t.Fatal("expected capturing a voided authorization to fail")
+	}
+}
@@ -0,0 +1,25 @@
+// Package payments charges guests for their bookings through a payment
+// Gateway. Amounts are in the minor unit of the currency of the booking.
+package payments
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrDeclined is returned when the gateway refuses to authorize a payment
+var ErrDeclined = errors.New("payment declined")
+
+// Gateway is a payment provider. Every operation returns the reference the
+// provider gives to the transaction.
+type Gateway interface {
+	// Authorize holds amount of the ISO 4217 currency on the payment method
+	// identified by token. The rest of the operations use its currency.
+	Authorize(ctx context.Context, token string, amount int, currency string) (string, error)
+	// Capture charges amount of an authorization, releasing the rest
+	Capture(ctx context.Context, authorization string, amount int) (string, error)
+	// Refund gives back amount of a captured charge
+	Refund(ctx context.Context, charge string, amount int) (string, error)
+	// Void releases an authorization that wasn't captured
+	Void(ctx context.Context, authorization string) (string, error)
+}
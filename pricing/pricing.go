@@ -0,0 +1,131 @@
+// Package pricing computes the price of a stay from the rate plan of the
+// hotel. It is the single place prices are computed, for searches as well as
+// for the price stored on bookings.
+package pricing
+
+import (
+	"math"
+	"time"
+
+	"github.com/xV0lk/hotel-reservations/types"
+)
+
+const dateLayout = "2006-01-02"
+
+// Quote returns the itemized price of every night of a stay of guests in the
+// room between from and until.
+func Quote(plan types.RatePlan, room *types.Room, from, until time.Time, guests int) *types.PriceQuote {
+	quote := &types.PriceQuote{Nights: []types.NightPrice{}}
+	nights := types.Booking{FromDate: from, UntilDate: until}.Nights()
+	for _, date := range nights {
+		night, _ := time.Parse(dateLayout, date)
+		price := nightPrice(plan, room, night, guests, len(nights))
+		quote.Nights = append(quote.Nights, price)
+		quote.Subtotal += price.Total
+	}
+	quote.Total = quote.Subtotal
+	return quote
+}
+
+// HotelQuote prices the stay like Quote with the rate plan of the hotel, and
+// adds the hotel's taxes and fees on top.
+func HotelQuote(hotel *types.Hotel, room *types.Room, from, until time.Time, guests int) *types.PriceQuote {
+	return DiscountedQuote(hotel, room, from, until, guests, nil)
+}
+
+// DiscountedQuote prices the stay like HotelQuote, taking the discount off
+// the rooms before the taxes are computed. A nil discount takes nothing off.
+func DiscountedQuote(hotel *types.Hotel, room *types.Room, from, until time.Time, guests int, discount *types.Discount) *types.PriceQuote {
+	quote := Quote(hotel.RatePlan, room, from, until, guests)
+	if discount != nil {
+		quote.Discount = DiscountAmount(*discount, quote.Subtotal)
+	}
+	discounted := quote.Subtotal - quote.Discount
+	quote.Taxes = Taxes(hotel.Taxes, discounted, len(quote.Nights), guests)
+	quote.Total = discounted + types.TaxTotal(quote.Taxes)
+	return quote
+}
+
+// DiscountAmount returns what the discount takes off subtotal, which never
+// goes below zero.
+func DiscountAmount(discount types.Discount, subtotal int) int {
+	amount := discount.Value
+	if discount.Kind == types.PercentDiscount {
+		amount = percent(subtotal, discount.Value)
+	}
+	if amount > subtotal {
+		return subtotal
+	}
+	return amount
+}
+
+// Taxes returns the line items of the taxes and fees of a stay of guests for
+// nights, whose rooms cost subtotal. Taxes amounting to nothing are left out.
+func Taxes(rules types.TaxRules, subtotal, nights, guests int) []types.TaxLine {
+	lines := []types.TaxLine{}
+	taxable := subtotal
+	for _, f := range rules.Fees {
+		lines = append(lines, types.TaxLine{Name: f.Name, Amount: f.Amount})
+		taxable += f.Amount
+	}
+	if cityTax := rules.CityTax * guests * nights; cityTax > 0 {
+		lines = append(lines, types.TaxLine{Name: types.CityTaxLine, Amount: cityTax})
+	}
+	if vat := percent(taxable, rules.VATPercent); vat > 0 {
+		lines = append(lines, types.TaxLine{Name: types.VATLine, Amount: vat})
+	}
+	return lines
+}
+
+// NightlyRate returns the price of a single night for one guest, as shown in
+// availability calendars.
+func NightlyRate(plan types.RatePlan, room *types.Room, night time.Time) int {
+	return nightPrice(plan, room, night, 1, 1).Total
+}
+
+func nightPrice(plan types.RatePlan, room *types.Room, night time.Time, guests, stayNights int) types.NightPrice {
+	price := types.NightPrice{
+		Date: night.Format(dateLayout),
+		Base: room.BasePrice,
+	}
+	date := price.Date
+	for _, s := range plan.Seasons {
+		if room.Type.AppliesTo(s.RoomType) && s.From <= date && date <= s.Until {
+			price.Season = round(float64(room.BasePrice) * (s.Multiplier - 1))
+			break
+		}
+	}
+	rate := price.Base + price.Season
+	for _, w := range plan.Weekdays {
+		if room.Type.AppliesTo(w.RoomType) && w.Weekday == night.Weekday() {
+			price.Weekday = percent(rate, w.Percent)
+			break
+		}
+	}
+	for _, e := range plan.ExtraPerson {
+		if room.Type.AppliesTo(e.RoomType) {
+			if extra := guests - e.BaseOccupancy; extra > 0 {
+				price.ExtraPerson = extra * e.Fee
+			}
+			break
+		}
+	}
+	subtotal := rate + price.Weekday + price.ExtraPerson
+	best := 0
+	for _, d := range plan.LengthOfStay {
+		if room.Type.AppliesTo(d.RoomType) && d.MinNights <= stayNights && d.MinNights > best {
+			best = d.MinNights
+			price.Discount = percent(subtotal, d.Percent)
+		}
+	}
+	price.Total = subtotal - price.Discount
+	return price
+}
+
+func percent(amount, pct int) int {
+	return round(float64(amount) * float64(pct) / 100)
+}
+
+func round(f float64) int {
+	return int(math.Round(f))
+}
@@ -0,0 +1,139 @@
+package pricing
+
+import (
+	"testing"
+	"time"
+
+	"github.com/xV0lk/hotel-reservations/types"
+)
+
+func TestQuote(t *testing.T) {
+	room := &types.Room{Type: types.Double, BasePrice: 100}
+	plan := types.RatePlan{
+		Seasons: []types.SeasonRule{
+			{From: "2030-12-20", Until: "2030-12-31", Multiplier: 1.5},
+			{RoomType: types.Single, From: "2030-01-01", Until: "2030-12-31", Multiplier: 3},
+		},
+		Weekdays: []types.WeekdayRule{
+			{Weekday: time.Saturday, Percent: 20},
+		},
+		LengthOfStay: []types.StayDiscount{
+			{MinNights: 3, Percent: 10},
+			{MinNights: 7, Percent: 25},
+		},
+		ExtraPerson: []types.ExtraPersonFee{
+			{BaseOccupancy: 2, Fee: 15},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		from     time.Time
+		nights   int
+		guests   int
+		expected []int
+	}{
+		{"plain weekdays", date("2030-03-05"), 2, 2, []int{100, 100}},
+		{"saturday surcharge", date("2030-03-08"), 2, 1, []int{100, 120}},
+		{"extra person", date("2030-03-05"), 1, 3, []int{115}},
+		{"high season", date("2030-12-19"), 2, 2, []int{100, 150}},
+		{"length of stay discount", date("2030-03-04"), 3, 2, []int{90, 90, 90}},
+		{"longest applicable discount", date("2030-03-03"), 7, 2, []int{75, 75, 75, 75, 75, 75, 90}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			quote := Quote(plan, room, tc.from, tc.from.AddDate(0, 0, tc.nights), tc.guests)
+			if len(quote.Nights) != len(tc.expected) {
+				t.Fatalf("expected %d nights, got %d", len(tc.expected), len(quote.Nights))
+			}
+			total := 0
+			for i, night := range quote.Nights {
+				if night.Total != tc.expected[i] {
+					t.Errorf("expected night %s to cost %d, got %d", night.Date, tc.expected[i], night.Total)
+				}
+				total += tc.expected[i]
+			}
+			if quote.Total != total {
+				t.Errorf("expected total %d, got %d", total, quote.Total)
+			}
+		})
+	}
+}
+
+func TestHotelQuote(t *testing.T) {
+	room := &types.Room{Type: types.Double, BasePrice: 100}
+	from := date("2030-03-05")
+
+	tests := []struct {
+		name     string
+		taxes    types.TaxRules
+		guests   int
+		expected []types.TaxLine
+	}{
+		{"no taxes", types.TaxRules{}, 2, []types.TaxLine{}},
+		{"city tax per guest and night", types.TaxRules{CityTax: 3}, 2, []types.TaxLine{{Name: types.CityTaxLine, Amount: 12}}},
+		{"vat", types.TaxRules{VATPercent: 10}, 2, []types.TaxLine{{Name: types.VATLine, Amount: 20}}},
+		{
+			"vat applies to fees but not city tax",
+			types.TaxRules{CityTax: 3, VATPercent: 10, Fees: []types.Fee{{Name: "Cleaning", Amount: 30}}},
+			1,
+			[]types.TaxLine{{Name: "Cleaning", Amount: 30}, {Name: types.CityTaxLine, Amount: 6}, {Name: types.VATLine, Amount: 23}},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			hotel := &types.Hotel{Taxes: tc.taxes}
+			quote := HotelQuote(hotel, room, from, from.AddDate(0, 0, 2), tc.guests)
+			if quote.Subtotal != 200 {
+				t.Fatalf("expected subtotal 200, got %d", quote.Subtotal)
+			}
+			if len(quote.Taxes) != len(tc.expected) {
+				t.Fatalf("expected %d tax lines, got %+v", len(tc.expected), quote.Taxes)
+			}
+			total := quote.Subtotal
+			for i, line := range quote.Taxes {
+				if line != tc.expected[i] {
+					t.Errorf("expected tax line %+v, got %+v", tc.expected[i], line)
+				}
+				total += tc.expected[i].Amount
+			}
+			if quote.Total != total {
+				t.Errorf("expected total %d, got %d", total, quote.Total)
+			}
+		})
+	}
+}
+
+func date(s string) time.Time {
+	t, _ := time.Parse(dateLayout, s)
+	return t
+}
+
+func TestDiscountedQuote(t *testing.T) {
+	room := &types.Room{Type: types.Double, BasePrice: 100}
+	hotel := &types.Hotel{Taxes: types.TaxRules{VATPercent: 10}}
+	from := date("2030-03-05")
+
+	tests := []struct {
+		name     string
+		discount *types.Discount
+		expected int
+		total    int
+	}{
+		{"no discount", nil, 0, 220},
+		{"percent", &types.Discount{Kind: types.PercentDiscount, Value: 25}, 50, 165},
+		{"amount", &types.Discount{Kind: types.AmountDiscount, Value: 30, Currency: "USD"}, 30, 187},
+		{"amount above the subtotal", &types.Discount{Kind: types.AmountDiscount, Value: 500, Currency: "USD"}, 200, 0},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			quote := DiscountedQuote(hotel, room, from, from.AddDate(0, 0, 2), 2, tc.discount)
+			if quote.Subtotal != 200 || quote.Discount != tc.expected {
+				t.Fatalf("expected subtotal 200 discounted by %d, got %d discounted by %d", tc.expected, quote.Subtotal, quote.Discount)
+			}
+			if quote.Total != tc.total {
+				t.Errorf("expected the taxes to apply to the discounted price for a total of %d, got %d", tc.total, quote.Total)
+			}
+		})
+	}
+}
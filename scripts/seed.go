@@ -28,12 +28,12 @@ func main() {
 		log.Fatal(err)
 	}
 
-	hotelStore := db.NewMongoHotelStore(client, db.DBNAME)
-	store := &db.Store{
-		Hotel:   hotelStore,
-		User:    db.NewMongoUserStore(client, db.DBNAME),
-		Booking: db.NewMongoBookingStore(client, db.DBNAME),
-		Room:    db.NewMongoRoomStore(client, hotelStore, db.DBNAME),
+	store := db.NewMongoStore(client, db.DBNAME)
+	if err := store.Booking.IndexRoomNights(ctx); err != nil {
+		log.Fatal(err)
+	}
+	if err := store.Promo.IndexCode(ctx); err != nil {
+		log.Fatal(err)
 	}
 	admin := fixtures.AddUser(store, "Jorge", "Rojas", true)
 	adminToken, _ := api.CreateUserToken(admin)
@@ -41,12 +41,16 @@ func main() {
 	user := fixtures.AddUser(store, "John", "Doe", false)
 	userToken, _ := api.CreateUserToken(user)
 	fmt.Printf("-------------------------\nuser: %s\n", userToken)
-	hotel := fixtures.AddHotel(store, "The Coffin", "Transylvania", 3.5, 1)
-	room := fixtures.AddRoom(store, types.Double, 155, hotel.ID)
+	hotel := fixtures.AddHotelWithCurrency(store, "The Coffin", "Transylvania", "RON", 3.5, 1)
+	room := fixtures.AddRoom(store, types.Double, 15500, hotel.ID)
 	booking := fixtures.AddBooking(store, admin.ID, room, time.Now(), time.Now().AddDate(0, 0, 5), 2)
 	fixtures.AddBooking(store, user.ID, room, time.Now().AddDate(0, 0, 6), time.Now().AddDate(0, 0, 7), 1)
 	bookingH, _ := json.MarshalIndent(booking, "", "  ")
 	fmt.Printf("-------------------------\nbooking: %s\n", string(bookingH))
-	fixtures.AddHotel(store, "Yokai Inn", "Japan", 4.2, 2)
-	fixtures.AddHotel(store, "Sherlock hideout", "London", 4.9, 3)
+	fixtures.AddHotelWithCurrency(store, "Yokai Inn", "Japan", "JPY", 4.2, 2)
+	fixtures.AddHotelWithCurrency(store, "Sherlock hideout", "London", "GBP", 4.9, 3)
+	// sample rates, to be replaced by an admin with current ones
+	if err := store.ExchangeRate.PutExchangeRates(ctx, fixtures.SampleExchangeRates()); err != nil {
+		log.Fatal(err)
+	}
 }
@@ -0,0 +1,106 @@
+package types
+
+import (
+	"regexp"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// AvailabilityQuery are the query parameters of the availability search.
+// From and Until are YYYY-MM-DD dates, Until being the checkout day.
+type AvailabilityQuery struct {
+	From     string   `query:"from"`
+	Until    string   `query:"until"`
+	Guests   int      `query:"guests"`
+	Location string   `query:"location"`
+	Type     RoomType `query:"type"`
+	Currency string   `query:"currency"`
+}
+
+// AvailableRoom is a room free for the whole searched range. TotalPrice is
+// in the currency of the room, DisplayPrice in the one the guest asked for.
+type AvailableRoom struct {
+	Room         `bson:",inline"`
+	TotalPrice   int    `bson:"-" json:"totalPrice"`
+	DisplayPrice *Money `bson:"-" json:"displayPrice,omitempty"`
+}
+
+type HotelAvailability struct {
+	Hotel Hotel            `bson:"hotel" json:"hotel"`
+	Rooms []*AvailableRoom `bson:"rooms" json:"rooms"`
+}
+
+func (q AvailabilityQuery) Validate() map[string]string {
+	errors := map[string]string{}
+	from, fErr := parseQueryDate(q.From)
+	if fErr != nil {
+		errors["from"] = fErr.Error()
+	} else if from.IsZero() {
+		errors["from"] = "from date is required"
+	}
+	until, uErr := parseQueryDate(q.Until)
+	if uErr != nil {
+		errors["until"] = uErr.Error()
+	} else if until.IsZero() {
+		errors["until"] = "until date is required"
+	}
+	if _, ok := errors["from"]; !ok {
+		if _, ok := errors["until"]; !ok {
+			// the dates are days, so a search can start today
+			if from.Before(startOfDay(time.Now())) {
+				errors["date"] = "can't search dates before today"
+			} else if !until.After(from) {
+				errors["date"] = "end date must be after start date"
+			}
+		}
+	}
+	if q.Guests < 0 {
+		errors["guests"] = "guests can't be negative"
+	}
+	if _, ok := DefaultOccupancy[q.Type]; q.Type != 0 && !ok {
+		errors["type"] = "unknown room type"
+	}
+	if q.Currency != "" {
+		if err := validateCurrency(q.Currency); err != nil {
+			errors["currency"] = err.Error()
+		}
+	}
+	return errors
+}
+
+// BookingBody returns the booking the search is checking availability for
+func (q AvailabilityQuery) BookingBody() BookingBody {
+	from, _ := parseQueryDate(q.From)
+	until, _ := parseQueryDate(q.Until)
+	guests := q.Guests
+	if guests == 0 {
+		guests = 1
+	}
+	return BookingBody{
+		FromDate:  from,
+		UntilDate: until,
+		NumPeople: guests,
+	}
+}
+
+// CreateRoomFilter matches the bookable rooms with enough capacity
+func (q AvailabilityQuery) CreateRoomFilter() bson.M {
+	filter := bson.M{
+		"retired":      bson.M{"$ne": true},
+		"maxOccupancy": bson.M{"$gte": q.BookingBody().NumPeople},
+	}
+	if q.Type != 0 {
+		filter["type"] = q.Type
+	}
+	return filter
+}
+
+// CreateHotelFilter matches the hotels in the searched location
+func (q AvailabilityQuery) CreateHotelFilter() bson.M {
+	filter := bson.M{}
+	if q.Location != "" {
+		filter["location"] = bson.M{"$regex": regexp.QuoteMeta(q.Location), "$options": "i"}
+	}
+	return filter
+}
@@ -1,6 +1,7 @@
 package types
 
 import (
+	"errors"
 	"fmt"
 	"strings"
 	"time"
@@ -9,21 +10,66 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+const nightLayout = "2006-01-02"
+
+// Booking is the stay of a guest. Price and the rest of its amounts are in
+// minor units of Currency, the currency of the hotel.
 type Booking struct {
 	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
 	UserID    primitive.ObjectID `bson:"userID,omitempty" json:"userID,omitempty"`
 	RoomID    primitive.ObjectID `bson:"roomID,omitempty" json:"roomID,omitempty"`
+	GroupID   primitive.ObjectID `bson:"groupID,omitempty" json:"groupID,omitempty"`
+	HotelID   primitive.ObjectID `bson:"hotelID,omitempty" json:"hotelID,omitempty"`
+	RoomType  RoomType           `bson:"roomType,omitempty" json:"roomType,omitempty"`
 	FromDate  time.Time          `bson:"fromDate,omitempty" json:"fromDate,omitempty"`
 	UntilDate time.Time          `bson:"untilDate,omitempty" json:"untilDate,omitempty"`
 	Price     int                `bson:"price,omitempty" json:"price,omitempty"`
+	Currency  string             `bson:"currency,omitempty" json:"currency,omitempty"`
 	NumPeople int                `bson:"numPeople,omitempty" json:"numPeople,omitempty"`
-	Cancelled bool               `bson:"cancelled" json:"cancelled"`
+	Status    BookingStatus      `bson:"status" json:"status"`
+	History   []StatusChange     `bson:"history,omitempty" json:"history,omitempty"`
+	Nightly   []NightPrice       `bson:"nightly,omitempty" json:"nightly,omitempty"`
+	Taxes     []TaxLine          `bson:"taxes,omitempty" json:"taxes,omitempty"`
+	Promo     *AppliedPromo      `bson:"promo,omitempty" json:"promo,omitempty"`
+	Changes   []BookingChange    `bson:"changes,omitempty" json:"changes,omitempty"`
+	ExpiresAt *time.Time         `bson:"expiresAt,omitempty" json:"expiresAt,omitempty"`
+	// AllocatedRoom is the room taking the nights of a room type booking
+	// until it is assigned a room.
+	AllocatedRoom primitive.ObjectID `bson:"allocatedRoom,omitempty" json:"allocatedRoom,omitempty"`
+	// Policy is the cancellation policy of the hotel when the booking was
+	// made. Bookings made before it was recorded follow the hotel's policy.
+	Policy *CancellationPolicy `bson:"policy,omitempty" json:"policy,omitempty"`
+	// Cancellation holds the refund and penalty of cancelled bookings
+	Cancellation *Cancellation `bson:"cancellation,omitempty" json:"cancellation,omitempty"`
+	// InvoiceNumber is set the first time the invoice of the booking is issued
+	InvoiceNumber int `bson:"invoiceNumber,omitempty" json:"invoiceNumber,omitempty"`
+}
+
+// Nights returns the nights covered by the booking as YYYY-MM-DD strings,
+// one per UTC calendar day from the day of FromDate until the day before
+// UntilDate, whatever the time of day of both.
+func (b Booking) Nights() []string {
+	nights := []string{}
+	end := startOfDay(b.UntilDate)
+	for night := startOfDay(b.FromDate); night.Before(end); night = night.AddDate(0, 0, 1) {
+		nights = append(nights, night.Format(nightLayout))
+	}
+	return nights
+}
+
+// Charge returns the price of the booking in the currency it is charged in
+func (b *Booking) Charge() Money {
+	return Money{Amount: b.Price, Currency: currencyOrDefault(b.Currency)}
 }
 
 type BookingBody struct {
 	FromDate  time.Time `json:"fromDate"`
 	UntilDate time.Time `json:"untilDate"`
 	NumPeople int       `json:"numPeople"`
+	QuoteID   string    `json:"quoteId,omitempty"`
+	// PaymentToken identifies the guest's payment method at the gateway
+	PaymentToken string `json:"paymentToken,omitempty"`
+	PromoCode    string `json:"promoCode,omitempty"`
 }
 
 type BookingFilter struct {
@@ -41,7 +87,9 @@ func (b BookingBody) Validate(r *Room) map[string]string {
 	if err := validateDate(b); len(err) != 0 {
 		errors["date"] = strings.Join(err, ", ")
 	}
-	// Availability Validation
+	if r.Retired {
+		errors["room"] = "this room is no longer available for booking"
+	}
 
 	return errors
 }
@@ -55,33 +103,32 @@ func validateDate(b BookingBody) []string {
 	if now.After(b.UntilDate) {
 		errors = append(errors, "can't use a date before today as ending date")
 	}
-	if b.FromDate.After(b.UntilDate) {
+	if !b.UntilDate.After(b.FromDate) {
 		errors = append(errors, "end date must be after start date")
+	} else if len((Booking{FromDate: b.FromDate, UntilDate: b.UntilDate}).Nights()) == 0 {
+		errors = append(errors, "a booking must last at least one night")
 	}
 	return errors
 }
 
+// startOfDay returns the UTC midnight starting the day of t, the time
+// YYYY-MM-DD dates are parsed as
+func startOfDay(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
 func validateCapacity(p int, r *Room) error {
-	switch r.Type {
-	case Single:
-		if p > 1 {
-			return fmt.Errorf("single room can only accommodate 1 person, but got %d", p)
-		}
-	case Double:
-		if p > 3 {
-			return fmt.Errorf("double room can only accommodate 3 people, but got %d", p)
-		}
-	case SeaSide:
-		if p > 3 {
-			return fmt.Errorf("sea-side room can only accommodate 3 people, but got %d", p)
-		}
-	case Deluxe:
-		if p > 4 {
-			return fmt.Errorf("deluxe room can only accommodate 4 people, but got %d", p)
-		}
-	default:
+	capacity := r.Capacity()
+	if capacity == 0 {
 		return fmt.Errorf("unknown room type: %d", r.Type)
 	}
+	if p < 1 {
+		return fmt.Errorf("a booking needs at least 1 person, but got %d", p)
+	}
+	if p > capacity {
+		return fmt.Errorf("%s room can only accommodate %d people, but got %d", r.Type, capacity, p)
+	}
 	return nil
 }
 
@@ -101,17 +148,46 @@ func (b BookingFilter) CreateMonthFilter() bson.M {
 	}
 
 	return bson.M{
-		"cancelled": false,
+		"status": activeStatusFilter(),
 		"$expr": bson.M{
 			"$or": bson.A{fromDateConditions, untilDateConditions},
 		},
 	}
 }
 
-func (b BookingBody) CreateAvailabilityFilter(rId primitive.ObjectID) bson.M {
+func (b BookingBody) CreateAvailabilityFilter(rId primitive.ObjectID, now time.Time) bson.M {
+	return b.CreateRoomsAvailabilityFilter([]primitive.ObjectID{rId}, now)
+}
+
+// CreateRoomsAvailabilityFilter matches the active bookings holding any of
+// the rooms that overlap the dates of the booking body.
+func (b BookingBody) CreateRoomsAvailabilityFilter(rooms []primitive.ObjectID, now time.Time) bson.M {
+	filter := b.CreateOverlapFilter(now)
+	filter["$and"] = []bson.M{holdsRoomsFilter(rooms)}
+	return filter
+}
+
+// holdsRoomsFilter matches the bookings of any of the rooms, including room
+// type bookings the rooms are allocated to.
+func holdsRoomsFilter(rooms []primitive.ObjectID) bson.M {
+	if rooms == nil {
+		rooms = []primitive.ObjectID{}
+	}
 	return bson.M{
-		"roomID":    rId,
-		"cancelled": false,
+		"$or": []bson.M{
+			{"roomID": bson.M{"$in": rooms}},
+			{"allocatedRoom": bson.M{"$in": rooms}},
+		},
+	}
+}
+
+// CreateOverlapFilter matches the active bookings of any room that overlap
+// the dates of the booking body. Holds that ran out at the given time don't
+// count, even if they weren't swept yet.
+func (b BookingBody) CreateOverlapFilter(now time.Time) bson.M {
+	return bson.M{
+		"status": activeStatusFilter(),
+		"$nor":   []bson.M{CreateExpiredHoldsFilter(now)},
 		"$or": []bson.M{
 			{"fromDate": bson.M{"$gte": b.FromDate, "$lt": b.UntilDate}},
 			{"untilDate": bson.M{"$gt": b.FromDate, "$lte": b.UntilDate}},
@@ -119,3 +195,155 @@ func (b BookingBody) CreateAvailabilityFilter(rId primitive.ObjectID) bson.M {
 		},
 	}
 }
+
+// BookingQuery are the filters accepted by the booking list endpoint. From
+// and Until are inclusive YYYY-MM-DD dates matching the bookings that
+// overlap them.
+type BookingQuery struct {
+	UserID string `query:"userId"`
+	RoomID string `query:"roomId"`
+	From   string `query:"from"`
+	Until  string `query:"until"`
+	Status string `query:"status"`
+}
+
+func (q BookingQuery) Validate() map[string]string {
+	errors := map[string]string{}
+	if q.UserID != "" && !primitive.IsValidObjectID(q.UserID) {
+		errors["userId"] = "invalid user id"
+	}
+	if q.RoomID != "" && !primitive.IsValidObjectID(q.RoomID) {
+		errors["roomId"] = "invalid room id"
+	}
+	if q.Status != "" && !BookingStatus(q.Status).IsValid() {
+		errors["status"] = fmt.Sprintf("unknown booking status '%s'", q.Status)
+	}
+	from, fErr := parseQueryDate(q.From)
+	if fErr != nil {
+		errors["from"] = fErr.Error()
+	}
+	until, uErr := parseQueryDate(q.Until)
+	if uErr != nil {
+		errors["until"] = uErr.Error()
+	}
+	if fErr == nil && uErr == nil && !from.IsZero() && !until.IsZero() && from.After(until) {
+		errors["date"] = "until date must be after from date"
+	}
+	return errors
+}
+
+func (q BookingQuery) CreateFilter() bson.M {
+	filter := bson.M{}
+	if q.UserID != "" {
+		userId, _ := primitive.ObjectIDFromHex(q.UserID)
+		filter["userID"] = userId
+	}
+	if q.RoomID != "" {
+		roomId, _ := primitive.ObjectIDFromHex(q.RoomID)
+		filter["roomID"] = roomId
+	}
+	if from, _ := parseQueryDate(q.From); !from.IsZero() {
+		filter["untilDate"] = bson.M{"$gt": from}
+	}
+	if until, _ := parseQueryDate(q.Until); !until.IsZero() {
+		filter["fromDate"] = bson.M{"$lt": until.AddDate(0, 0, 1)}
+	}
+	if q.Status != "" {
+		filter["status"] = q.Status
+	}
+	return filter
+}
+
+func parseQueryDate(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(nightLayout, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date '%s', expected YYYY-MM-DD", s)
+	}
+	return t, nil
+}
+
+// UpdateBookingParams are the changes a guest can make to a booking. Fields
+// left out of the body keep their current value.
+type UpdateBookingParams struct {
+	FromDate  *time.Time `json:"fromDate"`
+	UntilDate *time.Time `json:"untilDate"`
+	NumPeople int        `json:"numPeople"`
+	RoomID    string     `json:"roomId"`
+}
+
+var updateBookingKeys = map[string]bool{"fromDate": true, "untilDate": true, "numPeople": true, "roomId": true}
+
+// CheckBody checks if the json body contains the correct keys
+func (params UpdateBookingParams) CheckBody(jsonBody map[string]any) error {
+	for key := range jsonBody {
+		if !updateBookingKeys[key] {
+			return fmt.Errorf("the key '%s' is not a valid update property", key)
+		}
+	}
+	if len(jsonBody) == 0 {
+		return errors.New("no valid booking properties were provided")
+	}
+	return nil
+}
+
+// Apply returns the booking body resulting from applying the changes to b
+func (params UpdateBookingParams) Apply(b *Booking) BookingBody {
+	body := BookingBody{
+		FromDate:  b.FromDate,
+		UntilDate: b.UntilDate,
+		NumPeople: b.NumPeople,
+	}
+	if params.FromDate != nil {
+		body.FromDate = *params.FromDate
+	}
+	if params.UntilDate != nil {
+		body.UntilDate = *params.UntilDate
+	}
+	if params.NumPeople != 0 {
+		body.NumPeople = params.NumPeople
+	}
+	return body
+}
+
+// BookingChange records the values a booking had before it was modified and
+// how much its price changed.
+type BookingChange struct {
+	At              time.Time          `bson:"at" json:"at"`
+	RoomID          primitive.ObjectID `bson:"roomID" json:"roomID"`
+	FromDate        time.Time          `bson:"fromDate" json:"fromDate"`
+	UntilDate       time.Time          `bson:"untilDate" json:"untilDate"`
+	NumPeople       int                `bson:"numPeople" json:"numPeople"`
+	Price           int                `bson:"price" json:"price"`
+	PriceDifference int                `bson:"priceDifference" json:"priceDifference"`
+}
+
+// NewBookingChange records the current values of b before it is modified to
+// cost price.
+func NewBookingChange(b *Booking, price int, at time.Time) BookingChange {
+	return BookingChange{
+		At:              at,
+		RoomID:          b.RoomID,
+		FromDate:        b.FromDate,
+		UntilDate:       b.UntilDate,
+		NumPeople:       b.NumPeople,
+		Price:           b.Price,
+		PriceDifference: price - b.Price,
+	}
+}
+
+// IsModifiable reports whether the booking can still be changed at the given
+// time: it must hold its room and its stay can't have started.
+func (b *Booking) IsModifiable(now time.Time) bool {
+	if b.HasStarted(now) {
+		return false
+	}
+	for _, status := range ModifiableBookingStatuses {
+		if b.Status == status {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,158 @@
+package types
+
+import (
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+type BookingStatus string
+
+const (
+	BookingHeld             BookingStatus = "held"
+	BookingExpired          BookingStatus = "expired"
+	BookingPending          BookingStatus = "pending"
+	BookingConfirmed        BookingStatus = "confirmed"
+	BookingCheckedIn        BookingStatus = "checked_in"
+	BookingCheckedOut       BookingStatus = "checked_out"
+	BookingNoShow           BookingStatus = "no_show"
+	BookingCancelled        BookingStatus = "cancelled"
+	BookingCancelledWithFee BookingStatus = "cancelled_with_fee"
+)
+
+// bookingTransitions lists the statuses a booking can move to from each
+// status. Statuses without an entry are final.
+var bookingTransitions = map[BookingStatus][]BookingStatus{
+	BookingHeld:      {BookingPending, BookingExpired, BookingCancelled},
+	BookingPending:   {BookingConfirmed, BookingCancelled, BookingCancelledWithFee},
+	BookingConfirmed: {BookingCheckedIn, BookingNoShow, BookingCancelled, BookingCancelledWithFee},
+	BookingCheckedIn: {BookingCheckedOut},
+}
+
+// ActiveBookingStatuses are the statuses of bookings that hold their room
+var ActiveBookingStatuses = []BookingStatus{BookingHeld, BookingPending, BookingConfirmed, BookingCheckedIn}
+
+// ModifiableBookingStatuses are the statuses of bookings whose dates, room
+// or guests can still be changed
+var ModifiableBookingStatuses = []BookingStatus{BookingPending, BookingConfirmed}
+
+// BillableBookingStatuses are the statuses of bookings that are invoiced.
+// Holds, expired and cancelled bookings owe nothing.
+var BillableBookingStatuses = []BookingStatus{BookingPending, BookingConfirmed, BookingCheckedIn,
+	BookingCheckedOut, BookingNoShow, BookingCancelledWithFee}
+
+// LegacyBookingStatus maps the cancelled flag of bookings stored before they
+// had a status to their status. MigrateBookings applies it to those documents.
+var LegacyBookingStatus = map[bool]BookingStatus{
+	true:  BookingCancelled,
+	false: BookingConfirmed,
+}
+
+// StatusChange records when a booking entered a status
+type StatusChange struct {
+	Status BookingStatus `bson:"status" json:"status"`
+	At     time.Time     `bson:"at" json:"at"`
+}
+
+func (s BookingStatus) IsValid() bool {
+	switch s {
+	case BookingHeld, BookingExpired, BookingPending, BookingConfirmed, BookingCheckedIn,
+		BookingCheckedOut, BookingNoShow, BookingCancelled, BookingCancelledWithFee:
+		return true
+	}
+	return false
+}
+
+func (s BookingStatus) IsActive() bool {
+	for _, active := range ActiveBookingStatuses {
+		if s == active {
+			return true
+		}
+	}
+	return false
+}
+
+func (s BookingStatus) IsBillable() bool {
+	for _, billable := range BillableBookingStatuses {
+		if s == billable {
+			return true
+		}
+	}
+	return false
+}
+
+func (s BookingStatus) CanTransitionTo(to BookingStatus) bool {
+	for _, next := range bookingTransitions[s] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// TransitionSources returns the statuses a booking can be moved to status to
+// from.
+func TransitionSources(to BookingStatus) []BookingStatus {
+	sources := []BookingStatus{}
+	for from, nexts := range bookingTransitions {
+		for _, next := range nexts {
+			if next == to {
+				sources = append(sources, from)
+			}
+		}
+	}
+	return sources
+}
+
+// CheckTransitionDate returns why the booking can't be moved to status at
+// now, if the status depends on the day of arrival: guests check in from
+// their arrival day, and are no-shows once it is over. Days are UTC dates,
+// like the nights of the booking.
+func (b *Booking) CheckTransitionDate(status BookingStatus, now time.Time) error {
+	today, arrival := startOfDay(now), startOfDay(b.FromDate)
+	switch {
+	case status == BookingCheckedIn && today.Before(arrival):
+		return errors.New("the guest can't check in before the arrival day")
+	case status == BookingNoShow && !today.After(arrival):
+		return errors.New("the booking can't be a no-show before its arrival day is over")
+	}
+	return nil
+}
+
+// SetStatus moves the booking to status, recording the change in its history
+func (b *Booking) SetStatus(status BookingStatus, at time.Time) {
+	b.Status = status
+	b.History = append(b.History, StatusChange{Status: status, At: at})
+}
+
+func activeStatusFilter() bson.M {
+	return bson.M{"$in": ActiveBookingStatuses}
+}
+
+// IsExpired reports whether the booking is a hold that ran out at the given
+// time, even if it wasn't swept yet.
+func (b *Booking) IsExpired(now time.Time) bool {
+	return b.Status == BookingHeld && b.ExpiresAt != nil && !b.ExpiresAt.After(now)
+}
+
+// ReleasesPromo reports whether the last status change of the booking gives
+// back the use of its promo code. Cancelled bookings give it back, except
+// holds: like the holds that expire, they never redeemed their code, as that
+// only happens once they are booked.
+func (b *Booking) ReleasesPromo() bool {
+	n := len(b.History)
+	if b.Promo == nil || n < 2 {
+		return false
+	}
+	switch b.History[n-1].Status {
+	case BookingCancelled, BookingCancelledWithFee:
+		return b.History[n-2].Status != BookingHeld
+	}
+	return false
+}
+
+// CreateExpiredHoldsFilter matches the holds that ran out at the given time
+func CreateExpiredHoldsFilter(now time.Time) bson.M {
+	return bson.M{"status": BookingHeld, "expiresAt": bson.M{"$lte": now}}
+}
@@ -0,0 +1,125 @@
+package types
+
+import (
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const maxCalendarNights = 366
+
+type NightStatus string
+
+const (
+	NightFree    NightStatus = "free"
+	NightBooked  NightStatus = "booked"
+	NightBlocked NightStatus = "blocked"
+)
+
+// CalendarQuery are the query parameters of the calendar endpoints. From and
+// Until are YYYY-MM-DD dates, Until being the day after the last night.
+type CalendarQuery struct {
+	From  string `query:"from"`
+	Until string `query:"until"`
+}
+
+type CalendarNight struct {
+	Date   string      `json:"date"`
+	Status NightStatus `json:"status"`
+	Price  int         `json:"price"`
+}
+
+type RoomCalendar struct {
+	RoomID primitive.ObjectID `json:"roomID"`
+	Number string             `json:"number,omitempty"`
+	Type   RoomType           `json:"type"`
+	Nights []CalendarNight    `json:"nights"`
+}
+
+type HotelCalendar struct {
+	HotelID primitive.ObjectID `json:"hotelID"`
+	From    string             `json:"from"`
+	Until   string             `json:"until"`
+	Rooms   []RoomCalendar     `json:"rooms"`
+}
+
+func (q CalendarQuery) Validate() map[string]string {
+	errors := map[string]string{}
+	from, fErr := parseQueryDate(q.From)
+	if fErr != nil {
+		errors["from"] = fErr.Error()
+	} else if from.IsZero() {
+		errors["from"] = "from date is required"
+	}
+	until, uErr := parseQueryDate(q.Until)
+	if uErr != nil {
+		errors["until"] = uErr.Error()
+	} else if until.IsZero() {
+		errors["until"] = "until date is required"
+	}
+	if len(errors) == 0 {
+		if !until.After(from) {
+			errors["date"] = "end date must be after start date"
+		} else if until.Sub(from) > maxCalendarNights*24*time.Hour {
+			errors["date"] = fmt.Sprintf("calendar can't span more than %d nights", maxCalendarNights)
+		}
+	}
+	return errors
+}
+
+// BookingBody returns the whole calendar range as a booking body, to find
+// the bookings overlapping it.
+func (q CalendarQuery) BookingBody() BookingBody {
+	from, _ := parseQueryDate(q.From)
+	until, _ := parseQueryDate(q.Until)
+	return BookingBody{
+		FromDate:  from,
+		UntilDate: until,
+	}
+}
+
+// Overlaps reports whether the booking overlaps the dates of the body at the
+// given time, with the same semantics as CreateAvailabilityFilter.
+func (b BookingBody) Overlaps(booking *Booking, now time.Time) bool {
+	if !booking.Status.IsActive() || booking.IsExpired(now) {
+		return false
+	}
+	from, until := booking.FromDate, booking.UntilDate
+	return (!from.Before(b.FromDate) && from.Before(b.UntilDate)) ||
+		(until.After(b.FromDate) && !until.After(b.UntilDate)) ||
+		(!from.After(b.FromDate) && !until.Before(b.UntilDate))
+}
+
+// NewRoomCalendar lays out the nights of the query range for the room, priced
+// with price. A night is booked when one of the bookings overlaps it, and
+// blocked when it is already past or the room has been retired.
+func NewRoomCalendar(room *Room, bookings []*Booking, q CalendarQuery, now time.Time, price func(night time.Time) int) RoomCalendar {
+	body := q.BookingBody()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	calendar := RoomCalendar{
+		RoomID: room.ID,
+		Number: room.Number,
+		Type:   room.Type,
+		Nights: []CalendarNight{},
+	}
+	for night := body.FromDate; night.Before(body.UntilDate); night = night.AddDate(0, 0, 1) {
+		status := NightFree
+		nightBody := BookingBody{FromDate: night, UntilDate: night.AddDate(0, 0, 1)}
+		for _, booking := range bookings {
+			if booking.HoldsRoom(room.ID) && nightBody.Overlaps(booking, now) {
+				status = NightBooked
+				break
+			}
+		}
+		if status == NightFree && (room.Retired || night.Before(today)) {
+			status = NightBlocked
+		}
+		calendar.Nights = append(calendar.Nights, CalendarNight{
+			Date:   night.Format(nightLayout),
+			Status: status,
+			Price:  price(night),
+		})
+	}
+	return calendar
+}
@@ -0,0 +1,126 @@
+package types
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// CancellationPolicy decides how much of the price of a booking is refunded
+// when it is cancelled. Without tiers bookings are fully refundable.
+type CancellationPolicy struct {
+	NonRefundable bool               `bson:"nonRefundable" json:"nonRefundable"`
+	Tiers         []CancellationTier `bson:"tiers,omitempty" json:"tiers,omitempty"`
+}
+
+// CancellationTier refunds Percent of the price of bookings cancelled at
+// least HoursBefore hours before check-in. When several tiers apply the one
+// with the largest HoursBefore wins; when none does nothing is refunded.
+type CancellationTier struct {
+	HoursBefore int `bson:"hoursBefore" json:"hoursBefore"`
+	Percent     int `bson:"percent" json:"percent"`
+}
+
+// Cancellation records the money side of a cancelled booking. Refund is
+// what goes back to the guest, what they paid minus the penalty, and
+// RefundedAt is unset while it is pending.
+type Cancellation struct {
+	Refund     int        `bson:"refund" json:"refund"`
+	Penalty    int        `bson:"penalty" json:"penalty"`
+	At         time.Time  `bson:"at" json:"at"`
+	RefundedAt *time.Time `bson:"refundedAt,omitempty" json:"refundedAt,omitempty"`
+}
+
+func (p CancellationPolicy) Validate() map[string]string {
+	errors := map[string]string{}
+	errs := []string{}
+	seen := map[int]bool{}
+	for i, t := range p.Tiers {
+		if t.HoursBefore < 0 {
+			errs = append(errs, fmt.Sprintf("tier %d can't start after check-in", i))
+		}
+		if t.Percent < 0 || t.Percent > 100 {
+			errs = append(errs, fmt.Sprintf("tier %d refund must be between 0 and 100%%", i))
+		}
+		if seen[t.HoursBefore] {
+			errs = append(errs, fmt.Sprintf("tier %d repeats %d hours before check-in", i, t.HoursBefore))
+		}
+		seen[t.HoursBefore] = true
+	}
+	if len(errs) != 0 {
+		errors["tiers"] = strings.Join(errs, ", ")
+	}
+	if p.NonRefundable && len(p.Tiers) != 0 {
+		errors["nonRefundable"] = "non refundable policies can't have refund tiers"
+	}
+	return errors
+}
+
+// RefundPercent returns the percentage of the price refunded when cancelling
+// a stay starting at checkIn at the given time.
+func (p CancellationPolicy) RefundPercent(checkIn, now time.Time) int {
+	if p.NonRefundable {
+		return 0
+	}
+	if len(p.Tiers) == 0 {
+		return 100
+	}
+	tiers := make([]CancellationTier, len(p.Tiers))
+	copy(tiers, p.Tiers)
+	sort.Slice(tiers, func(i, j int) bool { return tiers[i].HoursBefore > tiers[j].HoursBefore })
+	hours := checkIn.Sub(now).Hours()
+	for _, t := range tiers {
+		if hours >= float64(t.HoursBefore) {
+			return t.Percent
+		}
+	}
+	return 0
+}
+
+// Cancel computes the refund and penalty of cancelling the booking now. The
+// penalty is the part of the price the policy doesn't waive, and only what
+// was paid beyond it is refunded.
+func (p CancellationPolicy) Cancel(b *Booking, paid PaymentSummary, now time.Time) *Cancellation {
+	waived := int(math.Round(float64(b.Price) * float64(p.RefundPercent(b.FromDate, now)) / 100))
+	penalty := b.Price - waived
+	return &Cancellation{
+		Refund:  paid.Refundable(penalty),
+		Penalty: penalty,
+		At:      now,
+	}
+}
+
+// CreatePendingRefundsFilter matches the cancelled bookings whose refund
+// hasn't been made yet
+func CreatePendingRefundsFilter() bson.M {
+	return bson.M{
+		"cancellation.refund":     bson.M{"$gt": 0},
+		"cancellation.refundedAt": bson.M{"$exists": false},
+	}
+}
+
+// CancellationPolicy returns the policy the booking was made under, or the
+// current policy of the hotel for bookings that didn't record one.
+func (b *Booking) CancellationPolicy(hotel *Hotel) CancellationPolicy {
+	if b.Policy != nil {
+		return *b.Policy
+	}
+	return hotel.Cancellation
+}
+
+// Status is the status of a booking cancelled with c
+func (c *Cancellation) Status() BookingStatus {
+	if c.Penalty > 0 {
+		return BookingCancelledWithFee
+	}
+	return BookingCancelled
+}
+
+// HasStarted reports whether the stay of the booking started before now
+func (b *Booking) HasStarted(now time.Time) bool {
+	return !b.FromDate.After(now)
+}
@@ -0,0 +1,37 @@
+package types
+
+import (
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var ErrInvalidVerificationToken = errors.New("invalid email verification token")
+
+// EmailVerification proves the user owns their email address through the
+// token of the link mailed to it. Only the hash of the token is stored.
+type EmailVerification struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	UserID    primitive.ObjectID `bson:"userID" json:"userID"`
+	TokenHash string             `bson:"tokenHash" json:"-"`
+	CreatedAt time.Time          `bson:"createdAt" json:"createdAt"`
+	ExpiresAt time.Time          `bson:"expiresAt" json:"expiresAt"`
+	UsedAt    *time.Time         `bson:"usedAt,omitempty" json:"usedAt,omitempty"`
+}
+
+// NewVerificationToken returns a random token of the email verification
+// with the hash it is stored as
+func NewVerificationToken(verificationID primitive.ObjectID) (string, string, error) {
+	return newSecretToken(verificationID)
+}
+
+// ParseVerificationToken returns the email verification id and the hash of
+// a token
+func ParseVerificationToken(token string) (primitive.ObjectID, string, error) {
+	verificationID, hash, ok := parseSecretToken(token)
+	if !ok {
+		return primitive.NilObjectID, "", ErrInvalidVerificationToken
+	}
+	return verificationID, hash, nil
+}
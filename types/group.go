@@ -0,0 +1,87 @@
+package types
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const maxGroupRooms = 10
+
+// GroupBooking is the parent reservation of the bookings of several rooms of
+// a hotel made together. Bookings is filled in when the group is read.
+type GroupBooking struct {
+	ID         primitive.ObjectID   `bson:"_id,omitempty" json:"id,omitempty"`
+	UserID     primitive.ObjectID   `bson:"userID" json:"userID"`
+	HotelID    primitive.ObjectID   `bson:"hotelID" json:"hotelID"`
+	FromDate   time.Time            `bson:"fromDate" json:"fromDate"`
+	UntilDate  time.Time            `bson:"untilDate" json:"untilDate"`
+	BookingIDs []primitive.ObjectID `bson:"bookingIDs" json:"bookingIDs"`
+	Price      int                  `bson:"price" json:"price"`
+	Currency   string               `bson:"currency,omitempty" json:"currency,omitempty"`
+	CreatedAt  time.Time            `bson:"createdAt" json:"createdAt"`
+	Bookings   []*Booking           `bson:"-" json:"bookings,omitempty"`
+}
+
+// GroupRoom is one of the rooms of a group booking and the guests staying
+// in it
+type GroupRoom struct {
+	RoomID    string `json:"roomId"`
+	NumPeople int    `json:"numPeople"`
+}
+
+type GroupBookingBody struct {
+	FromDate  time.Time   `json:"fromDate"`
+	UntilDate time.Time   `json:"untilDate"`
+	Rooms     []GroupRoom `json:"rooms"`
+	// PaymentToken identifies the guest's payment method at the gateway
+	PaymentToken string `json:"paymentToken,omitempty"`
+}
+
+// Validate checks the rooms list of the body. Every room is validated on
+// its own with Booking, once it is loaded.
+func (b GroupBookingBody) Validate() map[string]string {
+	errors := map[string]string{}
+	if len(b.Rooms) == 0 || len(b.Rooms) > maxGroupRooms {
+		errors["rooms"] = fmt.Sprintf("a group booking needs between 1 and %d rooms", maxGroupRooms)
+	}
+	seen := map[string]bool{}
+	for _, r := range b.Rooms {
+		if seen[r.RoomID] {
+			errors["rooms"] = fmt.Sprintf("room '%s' is listed more than once", r.RoomID)
+		}
+		seen[r.RoomID] = true
+	}
+	return errors
+}
+
+// Booking returns the booking body of the i-th room of the group
+func (b GroupBookingBody) Booking(i int) BookingBody {
+	return BookingBody{
+		FromDate:  b.FromDate,
+		UntilDate: b.UntilDate,
+		NumPeople: b.Rooms[i].NumPeople,
+	}
+}
+
+// ValidateRooms validates the booking of every room of the group, keying
+// the errors by the room's position in the body.
+func (b GroupBookingBody) ValidateRooms(rooms []*Room) map[string]string {
+	errors := map[string]string{}
+	for i, room := range rooms {
+		vErrors := b.Booking(i).Validate(room)
+		if len(vErrors) == 0 {
+			continue
+		}
+		errs := []string{}
+		for _, err := range vErrors {
+			errs = append(errs, err)
+		}
+		sort.Strings(errs)
+		errors[fmt.Sprintf("rooms.%d", i)] = strings.Join(errs, ", ")
+	}
+	return errors
+}
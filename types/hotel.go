@@ -1,6 +1,12 @@
 package types
 
 import (
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
@@ -10,6 +16,14 @@ type Hotel struct {
 	Location string               `bson:"location" json:"location"`
 	Rooms    []primitive.ObjectID `bson:"rooms" json:"rooms"`
 	Rating   float64              `bson:"rating" json:"rating"`
+	// Currency is the ISO 4217 code of the currency the hotel charges in.
+	// Its rooms and bookings are priced in it.
+	Currency string   `bson:"currency,omitempty" json:"currency,omitempty"`
+	RatePlan RatePlan `bson:"ratePlan" json:"ratePlan"`
+	// Cancellation is the policy applied to bookings cancelled by guests
+	Cancellation CancellationPolicy `bson:"cancellation" json:"cancellation"`
+	// Taxes are charged on top of the price of the rooms
+	Taxes TaxRules `bson:"taxes" json:"taxes"`
 }
 
 type HotelBookings struct {
@@ -31,9 +45,171 @@ const (
 	Deluxe
 )
 
+// Room is a room of a hotel. BasePrice is the price of a night in minor units
+// of Currency, the currency of the hotel.
 type Room struct {
-	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
-	Type      RoomType           `bson:"type,omitempty" json:"type,omitempty"`
-	BasePrice int                `bson:"basePrice,omitempty" json:"basePrice,omitempty"`
-	HotelId   primitive.ObjectID `bson:"hotelId,omitempty" json:"hotelId,omitempty"`
+	ID           primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	Type         RoomType           `bson:"type,omitempty" json:"type,omitempty"`
+	BasePrice    int                `bson:"basePrice,omitempty" json:"basePrice,omitempty"`
+	Currency     string             `bson:"currency,omitempty" json:"currency,omitempty"`
+	HotelId      primitive.ObjectID `bson:"hotelId,omitempty" json:"hotelId,omitempty"`
+	MaxOccupancy int                `bson:"maxOccupancy,omitempty" json:"maxOccupancy,omitempty"`
+	Beds         []Bed              `bson:"beds,omitempty" json:"beds,omitempty"`
+	Number       string             `bson:"number,omitempty" json:"number,omitempty"`
+	Floor        int                `bson:"floor" json:"floor"`
+	Retired      bool               `bson:"retired" json:"retired"`
+}
+
+const (
+	minHotelNameLen     = 2
+	minHotelLocationLen = 2
+	maxHotelRating      = 5
+)
+
+type NewHotelParams struct {
+	Name     string  `json:"name"`
+	Location string  `json:"location"`
+	Rating   float64 `json:"rating"`
+	// Currency defaults to DefaultCurrency. It can't be changed later on.
+	Currency string `json:"currency"`
+}
+
+type UpdateHotelParams struct {
+	Name     string   `json:"name"`
+	Location string   `json:"location"`
+	Rating   *float64 `json:"rating"`
+}
+
+func (params NewHotelParams) Validate() map[string]string {
+	errors := map[string]string{}
+	if len(params.Name) < minHotelNameLen {
+		errors["name"] = fmt.Sprintf("name must be at least %d characters long", minHotelNameLen)
+	}
+	if len(params.Location) < minHotelLocationLen {
+		errors["location"] = fmt.Sprintf("location must be at least %d characters long", minHotelLocationLen)
+	}
+	if err := validateRating(params.Rating); err != nil {
+		errors["rating"] = err.Error()
+	}
+	if params.Currency != "" {
+		if err := validateCurrency(params.Currency); err != nil {
+			errors["currency"] = err.Error()
+		}
+	}
+	return errors
+}
+
+func (params NewHotelParams) ToBson() bson.M {
+	return bson.M{
+		"name":     params.Name,
+		"location": params.Location,
+		"rating":   params.Rating,
+	}
+}
+
+func NewHotelFromParams(params *NewHotelParams) *Hotel {
+	return &Hotel{
+		Name:     params.Name,
+		Location: params.Location,
+		Rating:   params.Rating,
+		Currency: currencyOrDefault(params.Currency),
+		Rooms:    []primitive.ObjectID{},
+	}
+}
+
+// CurrencyCode returns the currency of the hotel, DefaultCurrency for hotels
+// stored without one.
+func (h *Hotel) CurrencyCode() string {
+	return currencyOrDefault(h.Currency)
+}
+
+func (params UpdateHotelParams) ToBson() bson.M {
+	bson := bson.M{}
+	if params.Name != "" {
+		bson["name"] = params.Name
+	}
+	if params.Location != "" {
+		bson["location"] = params.Location
+	}
+	if params.Rating != nil {
+		bson["rating"] = *params.Rating
+	}
+	return bson
+}
+
+func (params UpdateHotelParams) Validate() map[string]string {
+	errors := map[string]string{}
+	if params.Name != "" && len(params.Name) < minHotelNameLen {
+		errors["name"] = fmt.Sprintf("name must be at least %d characters long", minHotelNameLen)
+	}
+	if params.Location != "" && len(params.Location) < minHotelLocationLen {
+		errors["location"] = fmt.Sprintf("location must be at least %d characters long", minHotelLocationLen)
+	}
+	if params.Rating != nil {
+		if err := validateRating(*params.Rating); err != nil {
+			errors["rating"] = err.Error()
+		}
+	}
+	return errors
+}
+
+// CheckBody checks if the json body contains the correct keys
+func (params UpdateHotelParams) CheckBody(jsonBody map[string]any) error {
+	paramsMap := params.ToBson()
+	for key := range jsonBody {
+		_, ok := paramsMap[key]
+		if !ok {
+			return fmt.Errorf("the key '%s' is not a valid update property", key)
+		}
+	}
+	if len(jsonBody) == 0 {
+		return errors.New("no valid hotel properties were provided")
+	}
+	return nil
+}
+
+func validateRating(rating float64) error {
+	if rating < 0 || rating > maxHotelRating {
+		return fmt.Errorf("rating must be between 0 and %d", maxHotelRating)
+	}
+	return nil
+}
+
+// CreateActiveBookingsFilter matches the bookings of the hotel's rooms that
+// still hold their room and haven't ended or run out yet at the given time.
+func (h Hotel) CreateActiveBookingsFilter(now time.Time) bson.M {
+	return activeBookingsFilter(h.Rooms, now)
+}
+
+func activeBookingsFilter(rooms []primitive.ObjectID, now time.Time) bson.M {
+	filter := holdsRoomsFilter(rooms)
+	filter["status"] = activeStatusFilter()
+	filter["$nor"] = []bson.M{CreateExpiredHoldsFilter(now)}
+	filter["untilDate"] = bson.M{"$gt": now}
+	return filter
+}
+
+// HotelQuery are the filters accepted by the hotel list endpoint
+type HotelQuery struct {
+	Location  string  `query:"location"`
+	MinRating float64 `query:"minRating"`
+}
+
+func (q HotelQuery) Validate() map[string]string {
+	errors := map[string]string{}
+	if err := validateRating(q.MinRating); err != nil {
+		errors["minRating"] = err.Error()
+	}
+	return errors
+}
+
+func (q HotelQuery) CreateFilter() bson.M {
+	filter := bson.M{}
+	if q.Location != "" {
+		filter["location"] = bson.M{"$regex": regexp.QuoteMeta(q.Location), "$options": "i"}
+	}
+	if q.MinRating > 0 {
+		filter["rating"] = bson.M{"$gte": q.MinRating}
+	}
+	return filter
 }
@@ -0,0 +1,138 @@
+package types
+
+import (
+	"fmt"
+	"sort"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// TypeBookingBody books any room of Type in a hotel. The physical room is
+// assigned later, at the latest when the guest checks in.
+type TypeBookingBody struct {
+	BookingBody
+	Type RoomType `json:"type"`
+}
+
+// Validate checks the body against the rooms of the requested type, which
+// must be sorted by preference. It returns the rooms the booking fits in.
+func (b TypeBookingBody) Validate(rooms []*Room) ([]*Room, map[string]string) {
+	if _, ok := DefaultOccupancy[b.Type]; !ok {
+		return nil, map[string]string{"type": fmt.Sprintf("unknown room type: %d", b.Type)}
+	}
+	if b.QuoteID != "" {
+		return nil, map[string]string{"quoteId": "quotes can't be used to book a room type"}
+	}
+	if len(rooms) == 0 {
+		return nil, map[string]string{"type": fmt.Sprintf("the hotel has no %s rooms", b.Type)}
+	}
+	fits := []*Room{}
+	var errors map[string]string
+	for _, room := range rooms {
+		vErrors := b.BookingBody.Validate(room)
+		if len(vErrors) == 0 {
+			fits = append(fits, room)
+		} else if errors == nil {
+			errors = vErrors
+		}
+	}
+	if len(fits) == 0 {
+		return nil, errors
+	}
+	return fits, nil
+}
+
+// NightInventory is the number of rooms of a type still free on a night
+type NightInventory struct {
+	Date string `json:"date"`
+	Free int    `json:"free"`
+}
+
+// TypeInventory is the inventory of a room type of a hotel. Free is the
+// number of rooms free on every night of the range.
+type TypeInventory struct {
+	Type   RoomType         `json:"type"`
+	Rooms  int              `json:"rooms"`
+	Free   int              `json:"free"`
+	Nights []NightInventory `json:"nights"`
+}
+
+type HotelInventory struct {
+	HotelID primitive.ObjectID `json:"hotelID"`
+	From    string             `json:"from"`
+	Until   string             `json:"until"`
+	Types   []TypeInventory    `json:"types"`
+}
+
+// RoomsByType groups the bookable rooms by type, sorted by base price
+func RoomsByType(rooms []*Room) map[RoomType][]*Room {
+	byType := map[RoomType][]*Room{}
+	for _, room := range rooms {
+		if !room.Retired {
+			byType[room.Type] = append(byType[room.Type], room)
+		}
+	}
+	for _, rooms := range byType {
+		sort.SliceStable(rooms, func(i, j int) bool { return rooms[i].BasePrice < rooms[j].BasePrice })
+	}
+	return byType
+}
+
+// NewTypeInventory counts the free rooms of every night, given how many of
+// the rooms are taken on each of them.
+func NewTypeInventory(roomType RoomType, rooms int, nights []string, taken map[string]int) TypeInventory {
+	inventory := TypeInventory{
+		Type:   roomType,
+		Rooms:  rooms,
+		Free:   rooms,
+		Nights: []NightInventory{},
+	}
+	for _, night := range nights {
+		free := rooms - taken[night]
+		if free < inventory.Free {
+			inventory.Free = free
+		}
+		inventory.Nights = append(inventory.Nights, NightInventory{Date: night, Free: free})
+	}
+	return inventory
+}
+
+// RoomIDs returns the ids of the rooms
+func RoomIDs(rooms []*Room) []primitive.ObjectID {
+	ids := []primitive.ObjectID{}
+	for _, room := range rooms {
+		ids = append(ids, room.ID)
+	}
+	return ids
+}
+
+// IsAssigned reports whether the booking has a physical room
+func (b *Booking) IsAssigned() bool {
+	return !b.RoomID.IsZero()
+}
+
+// HoldsRoom reports whether the booking takes the room, either because it
+// was booked or because the room was allocated to the room type booking.
+func (b *Booking) HoldsRoom(roomID primitive.ObjectID) bool {
+	return b.RoomID == roomID || !b.AllocatedRoom.IsZero() && b.AllocatedRoom == roomID
+}
+
+type AssignRoomParams struct {
+	RoomID string `json:"roomId"`
+}
+
+// Validate checks that the room can host the booking. Room type bookings
+// can only be assigned rooms of their type.
+func (params AssignRoomParams) Validate(b *Booking, r *Room) map[string]string {
+	errors := map[string]string{}
+	if b.RoomType != 0 && r.Type != b.RoomType {
+		errors["roomId"] = fmt.Sprintf("the booking is for a %s room", b.RoomType)
+	}
+	if err := validateCapacity(b.NumPeople, r); err != nil {
+		errors["capacity"] = err.Error()
+	}
+	if r.Retired {
+		errors["room"] = "this room is no longer available for booking"
+	}
+	return errors
+}
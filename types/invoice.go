@@ -0,0 +1,118 @@
+package types
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Invoice itemizes what a booking costs and what the guest paid for it, in
+// the currency the booking is charged in.
+// Number is sequential per hotel and given to the booking the first time
+// its invoice is issued.
+type Invoice struct {
+	Number    int                `json:"number"`
+	IssuedAt  time.Time          `json:"issuedAt"`
+	BookingID primitive.ObjectID `json:"bookingID"`
+	Status    BookingStatus      `json:"status"`
+	Hotel     InvoiceHotel       `json:"hotel"`
+	Guest     InvoiceGuest       `json:"guest"`
+	Room      InvoiceRoom        `json:"room"`
+	FromDate  time.Time          `json:"fromDate"`
+	UntilDate time.Time          `json:"untilDate"`
+	NumPeople int                `json:"numPeople"`
+	Nights    []NightPrice       `json:"nights"`
+	Subtotal  int                `json:"subtotal"`
+	// Discount is what the promo code took off the subtotal
+	Discount  int       `json:"discount,omitempty"`
+	PromoCode string    `json:"promoCode,omitempty"`
+	Taxes     []TaxLine `json:"taxes"`
+	Total     int       `json:"total"`
+	Currency  string    `json:"currency"`
+	// Due is what the guest owes for the booking: its total, or only the
+	// penalty once it is cancelled.
+	Due      int        `json:"due"`
+	Payments []*Payment `json:"payments"`
+	Paid     int        `json:"paid"`
+	Refunded int        `json:"refunded"`
+	Balance  int        `json:"balance"`
+}
+
+type InvoiceHotel struct {
+	ID       primitive.ObjectID `json:"id"`
+	Name     string             `json:"name"`
+	Location string             `json:"location"`
+}
+
+type InvoiceGuest struct {
+	ID        primitive.ObjectID `json:"id"`
+	FirstName string             `json:"firstName"`
+	LastName  string             `json:"lastName"`
+	Email     string             `json:"email"`
+}
+
+// InvoiceRoom is the room of the booking. Room type bookings without a room
+// yet only have a type.
+type InvoiceRoom struct {
+	ID     primitive.ObjectID `json:"id,omitempty"`
+	Number string             `json:"number,omitempty"`
+	Type   RoomType           `json:"type"`
+}
+
+// NewInvoice builds the invoice of the booking. room is nil for room type
+// bookings that weren't assigned a room yet.
+func NewInvoice(b *Booking, room *Room, hotel *Hotel, guest *User, payments []*Payment, now time.Time) *Invoice {
+	invoice := &Invoice{
+		Number:    b.InvoiceNumber,
+		IssuedAt:  now,
+		BookingID: b.ID,
+		Status:    b.Status,
+		Hotel: InvoiceHotel{
+			ID:       hotel.ID,
+			Name:     hotel.Name,
+			Location: hotel.Location,
+		},
+		Guest: InvoiceGuest{
+			ID:        guest.ID,
+			FirstName: guest.FirstName,
+			LastName:  guest.LastName,
+			Email:     guest.Email,
+		},
+		Room:      InvoiceRoom{Type: b.RoomType},
+		FromDate:  b.FromDate,
+		UntilDate: b.UntilDate,
+		NumPeople: b.NumPeople,
+		Nights:    b.Nightly,
+		Subtotal:  b.Price - TaxTotal(b.Taxes),
+		Taxes:     b.Taxes,
+		Total:     b.Price,
+		Currency:  b.Charge().Currency,
+		Due:       b.Price,
+		Payments:  payments,
+	}
+	if invoice.Nights == nil {
+		invoice.Nights = []NightPrice{}
+	}
+	if invoice.Taxes == nil {
+		invoice.Taxes = []TaxLine{}
+	}
+	if invoice.Payments == nil {
+		invoice.Payments = []*Payment{}
+	}
+	if room != nil {
+		invoice.Room = InvoiceRoom{ID: room.ID, Number: room.Number, Type: room.Type}
+	}
+	if b.Promo != nil {
+		invoice.Discount = b.Promo.Amount
+		invoice.PromoCode = b.Promo.Code
+		invoice.Subtotal += b.Promo.Amount
+	}
+	if b.Cancellation != nil {
+		invoice.Due = b.Cancellation.Penalty
+	}
+	summary := SummarizePayments(payments)
+	invoice.Paid = summary.Captured
+	invoice.Refunded = summary.Refunded
+	invoice.Balance = invoice.Due - invoice.Paid + invoice.Refunded
+	return invoice
+}
@@ -0,0 +1,248 @@
+package types
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// DefaultCurrency is the currency of hotels, rooms and bookings stored before
+// they had one
+const DefaultCurrency = "USD"
+
+// ErrNoExchangeRate is returned when converting from or to a currency the
+// exchange rate table has no rate for
+var ErrNoExchangeRate = errors.New("no exchange rate for the currency")
+
+// currencyExponents are the supported ISO 4217 currencies with the number of
+// digits of their minor unit.
+var currencyExponents = map[string]int{
+	"AUD": 2,
+	"BRL": 2,
+	"CAD": 2,
+	"CHF": 2,
+	"CNY": 2,
+	"CZK": 2,
+	"DKK": 2,
+	"EUR": 2,
+	"GBP": 2,
+	"HUF": 2,
+	"INR": 2,
+	"JPY": 0,
+	"KRW": 0,
+	"MXN": 2,
+	"NOK": 2,
+	"PLN": 2,
+	"RON": 2,
+	"SEK": 2,
+	"USD": 2,
+}
+
+// Money is an amount in the minor unit of its currency, e.g. cents for USD
+// and yen for JPY.
+type Money struct {
+	Amount   int    `bson:"amount" json:"amount"`
+	Currency string `bson:"currency" json:"currency"`
+}
+
+func IsCurrency(code string) bool {
+	_, ok := currencyExponents[code]
+	return ok
+}
+
+func validateCurrency(code string) error {
+	if !IsCurrency(code) {
+		return fmt.Errorf("unsupported currency '%s'", code)
+	}
+	return nil
+}
+
+func currencyOrDefault(code string) string {
+	if code == "" {
+		return DefaultCurrency
+	}
+	return code
+}
+
+// FormatAmount formats an amount in minor units of the currency with its
+// decimals, e.g. 1234 USD as 12.34
+func FormatAmount(currency string, amount int) string {
+	exp := currencyExponents[currencyOrDefault(currency)]
+	if exp == 0 {
+		return fmt.Sprintf("%d", amount)
+	}
+	sign := ""
+	if amount < 0 {
+		sign, amount = "-", -amount
+	}
+	unit := int(math.Pow10(exp))
+	return fmt.Sprintf("%s%d.%0*d", sign, amount/unit, exp, amount%unit)
+}
+
+func (m Money) String() string {
+	return FormatAmount(m.Currency, m.Amount) + " " + currencyOrDefault(m.Currency)
+}
+
+// ExchangeRates is the table used to show prices in other currencies. Rates
+// are how many units of each currency one unit of Base buys. Charges are
+// never converted: guests always pay in the currency of the hotel.
+type ExchangeRates struct {
+	Base      string             `bson:"base" json:"base"`
+	Rates     map[string]float64 `bson:"rates" json:"rates"`
+	UpdatedAt time.Time          `bson:"updatedAt" json:"updatedAt"`
+}
+
+func (r ExchangeRates) Validate() map[string]string {
+	errors := map[string]string{}
+	if err := validateCurrency(r.Base); err != nil {
+		errors["base"] = err.Error()
+	}
+	errs := []string{}
+	for code, rate := range r.Rates {
+		if err := validateCurrency(code); err != nil {
+			errs = append(errs, err.Error())
+		} else if rate <= 0 {
+			errs = append(errs, fmt.Sprintf("the rate of %s must be positive", code))
+		}
+	}
+	if len(errs) != 0 {
+		sort.Strings(errs)
+		errors["rates"] = strings.Join(errs, ", ")
+	}
+	return errors
+}
+
+// Convert returns m in the currency to, rounded to its minor unit
+func (r *ExchangeRates) Convert(m Money, to string) (Money, error) {
+	from := currencyOrDefault(m.Currency)
+	if from == to {
+		return Money{Amount: m.Amount, Currency: to}, nil
+	}
+	fromRate, fromOk := r.rate(from)
+	toRate, toOk := r.rate(to)
+	if !fromOk || !toOk || !IsCurrency(to) {
+		return Money{}, ErrNoExchangeRate
+	}
+	major := float64(m.Amount) / math.Pow10(currencyExponents[from])
+	converted := major / fromRate * toRate
+	return Money{
+		Amount:   int(math.Round(converted * math.Pow10(currencyExponents[to]))),
+		Currency: to,
+	}, nil
+}
+
+func (r *ExchangeRates) rate(code string) (float64, bool) {
+	if code == r.Base {
+		return 1, true
+	}
+	rate, ok := r.Rates[code]
+	return rate, ok && rate > 0
+}
+
+// MinorUnits returns how many minor units make a unit of the currency, e.g.
+// 100 for USD and 1 for JPY
+func MinorUnits(currency string) int {
+	return int(math.Pow10(currencyExponents[currencyOrDefault(currency)]))
+}
+
+// Amounts stored before they had a currency are whole units of
+// DefaultCurrency. The MigrateCurrency methods turn them into minor units and
+// set the currency, returning the values of the fields they changed to be
+// $set, or nil when the document already had a currency.
+
+func (h *Hotel) MigrateCurrency() bson.M {
+	if h.Currency != "" {
+		return nil
+	}
+	units := MinorUnits(DefaultCurrency)
+	for i := range h.RatePlan.ExtraPerson {
+		h.RatePlan.ExtraPerson[i].Fee *= units
+	}
+	h.Taxes.CityTax *= units
+	for i := range h.Taxes.Fees {
+		h.Taxes.Fees[i].Amount *= units
+	}
+	h.Currency = DefaultCurrency
+	set := bson.M{"currency": h.Currency, "taxes.cityTax": h.Taxes.CityTax}
+	if len(h.RatePlan.ExtraPerson) != 0 {
+		set["ratePlan.extraPerson"] = h.RatePlan.ExtraPerson
+	}
+	if len(h.Taxes.Fees) != 0 {
+		set["taxes.fees"] = h.Taxes.Fees
+	}
+	return set
+}
+
+func (r *Room) MigrateCurrency() bson.M {
+	if r.Currency != "" {
+		return nil
+	}
+	r.BasePrice *= MinorUnits(DefaultCurrency)
+	r.Currency = DefaultCurrency
+	return bson.M{"currency": r.Currency, "basePrice": r.BasePrice}
+}
+
+func (b *Booking) MigrateCurrency() bson.M {
+	if b.Currency != "" {
+		return nil
+	}
+	units := MinorUnits(DefaultCurrency)
+	b.Currency = DefaultCurrency
+	b.Price *= units
+	set := bson.M{"currency": b.Currency, "price": b.Price}
+	if len(b.Nightly) != 0 {
+		for i := range b.Nightly {
+			night := &b.Nightly[i]
+			night.Base *= units
+			night.Season *= units
+			night.Weekday *= units
+			night.ExtraPerson *= units
+			night.Discount *= units
+			night.Total *= units
+		}
+		set["nightly"] = b.Nightly
+	}
+	if len(b.Taxes) != 0 {
+		for i := range b.Taxes {
+			b.Taxes[i].Amount *= units
+		}
+		set["taxes"] = b.Taxes
+	}
+	if len(b.Changes) != 0 {
+		for i := range b.Changes {
+			b.Changes[i].Price *= units
+			b.Changes[i].PriceDifference *= units
+		}
+		set["changes"] = b.Changes
+	}
+	if b.Cancellation != nil {
+		b.Cancellation.Refund *= units
+		b.Cancellation.Penalty *= units
+		set["cancellation.refund"] = b.Cancellation.Refund
+		set["cancellation.penalty"] = b.Cancellation.Penalty
+	}
+	return set
+}
+
+func (g *GroupBooking) MigrateCurrency() bson.M {
+	if g.Currency != "" {
+		return nil
+	}
+	g.Price *= MinorUnits(DefaultCurrency)
+	g.Currency = DefaultCurrency
+	return bson.M{"currency": g.Currency, "price": g.Price}
+}
+
+func (p *Payment) MigrateCurrency() bson.M {
+	if p.Currency != "" {
+		return nil
+	}
+	p.Amount *= MinorUnits(DefaultCurrency)
+	p.Currency = DefaultCurrency
+	return bson.M{"currency": p.Currency, "amount": p.Amount}
+}
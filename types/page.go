@@ -0,0 +1,84 @@
+package types
+
+import (
+	"fmt"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const (
+	DefaultPageLimit = 20
+	MaxPageLimit     = 100
+)
+
+var (
+	UserSortFields    = []string{"firstName", "lastName", "email"}
+	HotelSortFields   = []string{"name", "location", "rating"}
+	RoomSortFields    = []string{"type", "basePrice", "maxOccupancy", "number", "floor"}
+	BookingSortFields = []string{"fromDate", "untilDate", "price", "numPeople"}
+)
+
+// PageParams are the pagination query parameters shared by list endpoints.
+// Sort is a whitelisted field name, prefixed with '-' for descending order,
+// and Cursor is the opaque value returned as next by the previous page.
+type PageParams struct {
+	Limit  int    `query:"limit"`
+	Cursor string `query:"cursor"`
+	Sort   string `query:"sort"`
+}
+
+type PageMeta struct {
+	Limit int    `json:"limit"`
+	Sort  string `json:"sort,omitempty"`
+	Count int    `json:"count"`
+	Next  string `json:"next,omitempty"`
+}
+
+// Page is the envelope returned by every list endpoint
+type Page[T any] struct {
+	Data []*T     `json:"data"`
+	Page PageMeta `json:"page"`
+}
+
+// ListQuery is implemented by the typed filters of the list endpoints
+type ListQuery interface {
+	Validate() map[string]string
+	CreateFilter() bson.M
+}
+
+func (p *PageParams) Validate(sortFields []string) map[string]string {
+	errors := map[string]string{}
+	if p.Limit == 0 {
+		p.Limit = DefaultPageLimit
+	}
+	if p.Limit < 1 || p.Limit > MaxPageLimit {
+		errors["limit"] = fmt.Sprintf("limit must be between 1 and %d", MaxPageLimit)
+	}
+	if p.Sort != "" {
+		field, _ := p.SortField()
+		valid := false
+		for _, f := range sortFields {
+			if f == field {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			errors["sort"] = fmt.Sprintf("sort must be one of: %s", strings.Join(sortFields, ", "))
+		}
+	}
+	return errors
+}
+
+// SortField returns the field to sort by and whether the order is descending.
+// Pages without an explicit sort are ordered by id.
+func (p PageParams) SortField() (string, bool) {
+	if p.Sort == "" {
+		return "_id", false
+	}
+	if strings.HasPrefix(p.Sort, "-") {
+		return strings.TrimPrefix(p.Sort, "-"), true
+	}
+	return p.Sort, false
+}
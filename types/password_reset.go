@@ -0,0 +1,68 @@
+package types
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var ErrInvalidResetToken = errors.New("invalid password reset token")
+
+// PasswordReset is a request to choose a new password, proven by the token
+// mailed to the user. The token can be used once before it expires and only
+// its hash is stored.
+type PasswordReset struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	UserID    primitive.ObjectID `bson:"userID" json:"userID"`
+	TokenHash string             `bson:"tokenHash" json:"-"`
+	CreatedAt time.Time          `bson:"createdAt" json:"createdAt"`
+	ExpiresAt time.Time          `bson:"expiresAt" json:"expiresAt"`
+	UsedAt    *time.Time         `bson:"usedAt,omitempty" json:"usedAt,omitempty"`
+}
+
+type ForgotPasswordParams struct {
+	Email string `json:"email"`
+}
+
+type ResetPasswordParams struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+func (p ResetPasswordParams) Validate() map[string]string {
+	errors := map[string]string{}
+	if p.Token == "" {
+		errors["token"] = "the reset token is required"
+	}
+	if err := ValidatePassword(p.Password); len(err) > 0 {
+		errors["password"] = strings.Join(err, ", ")
+	}
+	return errors
+}
+
+// NewResetToken returns a random token of the password reset with the hash
+// it is stored as
+func NewResetToken(resetID primitive.ObjectID) (string, string, error) {
+	return newSecretToken(resetID)
+}
+
+// ParseResetToken returns the password reset id and the hash of a token
+func ParseResetToken(token string) (primitive.ObjectID, string, error) {
+	resetID, hash, ok := parseSecretToken(token)
+	if !ok {
+		return primitive.NilObjectID, "", ErrInvalidResetToken
+	}
+	return resetID, hash, nil
+}
+
+// HashPassword returns the hash a password is stored as
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), BcryptCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
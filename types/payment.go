@@ -0,0 +1,86 @@
+package types
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type PaymentKind string
+
+const (
+	PaymentAuthorize PaymentKind = "authorize"
+	PaymentCapture   PaymentKind = "capture"
+	PaymentRefund    PaymentKind = "refund"
+	PaymentVoid      PaymentKind = "void"
+)
+
+// Payment records a transaction made with the payment gateway for a
+// booking. Failed transactions are recorded too, with their error.
+type Payment struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	BookingID primitive.ObjectID `bson:"bookingID" json:"bookingID"`
+	Kind      PaymentKind        `bson:"kind" json:"kind"`
+	Amount    int                `bson:"amount" json:"amount"`
+	Currency  string             `bson:"currency" json:"currency"`
+	Reference string             `bson:"reference,omitempty" json:"reference,omitempty"`
+	Error     string             `bson:"error,omitempty" json:"error,omitempty"`
+	CreatedAt time.Time          `bson:"createdAt" json:"createdAt"`
+}
+
+func (p *Payment) Succeeded() bool {
+	return p.Error == ""
+}
+
+// PaymentParams is the body of the requests paying for existing bookings
+type PaymentParams struct {
+	PaymentToken string `json:"paymentToken"`
+}
+
+// PaymentSummary adds up the successful payments of a booking
+type PaymentSummary struct {
+	Authorized int `json:"authorized"`
+	Captured   int `json:"captured"`
+	Refunded   int `json:"refunded"`
+	// Charge is the reference of the capture refunds are made against
+	Charge string `json:"-"`
+}
+
+func SummarizePayments(payments []*Payment) PaymentSummary {
+	summary := PaymentSummary{}
+	for _, p := range payments {
+		if !p.Succeeded() {
+			continue
+		}
+		switch p.Kind {
+		case PaymentAuthorize:
+			summary.Authorized += p.Amount
+		case PaymentCapture:
+			summary.Captured += p.Amount
+			summary.Charge = p.Reference
+		case PaymentRefund:
+			summary.Refunded += p.Amount
+		}
+	}
+	return summary
+}
+
+// RefundedSince reports whether a refund was made at or after t
+func RefundedSince(payments []*Payment, t time.Time) bool {
+	for _, p := range payments {
+		if p.Kind == PaymentRefund && p.Succeeded() && !p.CreatedAt.Before(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// Refundable returns how much of what was paid goes back to the guest when
+// the booking is cancelled with the given penalty.
+func (s PaymentSummary) Refundable(penalty int) int {
+	refundable := s.Captured - s.Refunded - penalty
+	if refundable < 0 {
+		return 0
+	}
+	return refundable
+}
@@ -0,0 +1,317 @@
+package types
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"regexp"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// DiscountKind is how a promo code discounts the price of the rooms
+type DiscountKind string
+
+const (
+	PercentDiscount DiscountKind = "percent"
+	AmountDiscount  DiscountKind = "amount"
+)
+
+const (
+	maxVouchers      = 500
+	voucherSuffixLen = 8
+	// voucherAlphabet leaves out characters easily mistaken for others
+	voucherAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+)
+
+var (
+	PromoSortFields = []string{"code", "createdAt", "uses"}
+	promoCodeRegex  = regexp.MustCompile(`^[A-Z0-9-]{3,32}$`)
+)
+
+// Discount is what a promo code takes off the price of the rooms, before
+// taxes: Value percent of it, or Value in minor units of Currency.
+type Discount struct {
+	Kind     DiscountKind `bson:"kind" json:"kind"`
+	Value    int          `bson:"value" json:"value"`
+	Currency string       `bson:"currency,omitempty" json:"currency,omitempty"`
+}
+
+// PromoCode is a discount code guests can enter when booking. Codes of a
+// hotel only apply to its rooms, the others to every hotel. Zero limits
+// mean unlimited uses, zero dates an open range.
+type PromoCode struct {
+	ID       primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	Code     string             `bson:"code" json:"code"`
+	Discount `bson:",inline"`
+	HotelID  primitive.ObjectID `bson:"hotelID,omitempty" json:"hotelID,omitempty"`
+	// ValidFrom and ValidUntil bound when bookings can be made with the code
+	ValidFrom      time.Time `bson:"validFrom" json:"validFrom"`
+	ValidUntil     time.Time `bson:"validUntil" json:"validUntil"`
+	MinNights      int       `bson:"minNights" json:"minNights"`
+	MaxUses        int       `bson:"maxUses" json:"maxUses"`
+	MaxUsesPerUser int       `bson:"maxUsesPerUser" json:"maxUsesPerUser"`
+	// Uses counts the bookings made with the code, UsesByUser the ones of
+	// every user by id.
+	Uses       int            `bson:"uses" json:"uses"`
+	UsesByUser map[string]int `bson:"usesByUser,omitempty" json:"usesByUser,omitempty"`
+	CreatedAt  time.Time      `bson:"createdAt" json:"createdAt"`
+}
+
+// AppliedPromo is the promo code a booking was discounted with. Amount is
+// what was taken off the price of its rooms.
+type AppliedPromo struct {
+	ID       primitive.ObjectID `bson:"id" json:"id"`
+	Code     string             `bson:"code" json:"code"`
+	Discount `bson:",inline"`
+	Amount   int `bson:"amount" json:"amount"`
+}
+
+// PromoUse is a booking a promo code is entered for
+type PromoUse struct {
+	UserID   primitive.ObjectID
+	HotelID  primitive.ObjectID
+	Currency string
+	Nights   int
+	At       time.Time
+}
+
+// PromoUsage is a promo code with the bookings made with it
+type PromoUsage struct {
+	Promo    *PromoCode `json:"promo"`
+	Bookings []*Booking `json:"bookings"`
+}
+
+type PromoParams struct {
+	Code string `json:"code"`
+	Discount
+	HotelID        string    `json:"hotelId"`
+	ValidFrom      time.Time `json:"validFrom"`
+	ValidUntil     time.Time `json:"validUntil"`
+	MinNights      int       `json:"minNights"`
+	MaxUses        int       `json:"maxUses"`
+	MaxUsesPerUser int       `json:"maxUsesPerUser"`
+}
+
+// VoucherParams create Count single use codes, named after Code followed by
+// a random suffix.
+type VoucherParams struct {
+	PromoParams
+	Count int `json:"count"`
+}
+
+type PromoQuery struct {
+	HotelID string `query:"hotelId"`
+	// Code matches the codes starting with it, e.g. a batch of vouchers
+	Code string `query:"code"`
+}
+
+// NormalizePromoCode returns code the way it is stored, so guests can type
+// it in any case.
+func NormalizePromoCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+func (d Discount) Validate() map[string]string {
+	errors := map[string]string{}
+	switch d.Kind {
+	case PercentDiscount:
+		if d.Value < 1 || d.Value > 100 {
+			errors["value"] = "a percent discount must be between 1 and 100"
+		}
+	case AmountDiscount:
+		if d.Value < 1 {
+			errors["value"] = "the discount must be positive"
+		}
+		if err := validateCurrency(d.Currency); err != nil {
+			errors["currency"] = err.Error()
+		}
+	default:
+		errors["kind"] = fmt.Sprintf("discount kind must be %s or %s", PercentDiscount, AmountDiscount)
+	}
+	return errors
+}
+
+func (params PromoParams) Validate() map[string]string {
+	errors := params.Discount.Validate()
+	if code := NormalizePromoCode(params.Code); !promoCodeRegex.MatchString(code) {
+		errors["code"] = "code must be 3 to 32 letters, digits or dashes"
+	}
+	if params.HotelID != "" && !primitive.IsValidObjectID(params.HotelID) {
+		errors["hotelId"] = "invalid hotel id"
+	}
+	if !params.ValidFrom.IsZero() && !params.ValidUntil.IsZero() && !params.ValidUntil.After(params.ValidFrom) {
+		errors["validUntil"] = "validUntil must be after validFrom"
+	}
+	if params.MinNights < 0 {
+		errors["minNights"] = "minimum nights can't be negative"
+	}
+	if params.MaxUses < 0 || params.MaxUsesPerUser < 0 {
+		errors["maxUses"] = "usage limits can't be negative"
+	}
+	return errors
+}
+
+func (params VoucherParams) Validate() map[string]string {
+	errors := params.PromoParams.Validate()
+	// the code of vouchers is only the prefix of their codes
+	delete(errors, "code")
+	code := NormalizePromoCode(params.Code)
+	if len(code) < 2 || !promoCodeRegex.MatchString(code+strings.Repeat("X", voucherSuffixLen+1)) {
+		errors["code"] = fmt.Sprintf("code must be 2 to %d letters, digits or dashes", 32-voucherSuffixLen-1)
+	}
+	if params.Count < 1 || params.Count > maxVouchers {
+		errors["count"] = fmt.Sprintf("count must be between 1 and %d", maxVouchers)
+	}
+	return errors
+}
+
+func NewPromoFromParams(params PromoParams, now time.Time) *PromoCode {
+	hotelID, _ := primitive.ObjectIDFromHex(params.HotelID)
+	discount := params.Discount
+	if discount.Kind == PercentDiscount {
+		discount.Currency = ""
+	}
+	return &PromoCode{
+		Code:           NormalizePromoCode(params.Code),
+		Discount:       discount,
+		HotelID:        hotelID,
+		ValidFrom:      params.ValidFrom,
+		ValidUntil:     params.ValidUntil,
+		MinNights:      params.MinNights,
+		MaxUses:        params.MaxUses,
+		MaxUsesPerUser: params.MaxUsesPerUser,
+		CreatedAt:      now,
+	}
+}
+
+// NewVouchers returns the single use codes of the params
+func NewVouchers(params VoucherParams, now time.Time) ([]*PromoCode, error) {
+	vouchers := []*PromoCode{}
+	for i := 0; i < params.Count; i++ {
+		suffix, err := voucherSuffix()
+		if err != nil {
+			return nil, err
+		}
+		voucher := NewPromoFromParams(params.PromoParams, now)
+		voucher.Code += "-" + suffix
+		voucher.MaxUses = 1
+		voucher.MaxUsesPerUser = 0
+		vouchers = append(vouchers, voucher)
+	}
+	return vouchers, nil
+}
+
+func voucherSuffix() (string, error) {
+	var b strings.Builder
+	max := big.NewInt(int64(len(voucherAlphabet)))
+	for i := 0; i < voucherSuffixLen; i++ {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		b.WriteByte(voucherAlphabet[n.Int64()])
+	}
+	return b.String(), nil
+}
+
+// Check returns why the code can't be used for the booking, keyed by
+// promoCode like the errors of the booking body. The usage limits are
+// enforced again when the code is redeemed.
+func (p *PromoCode) Check(use PromoUse) map[string]string {
+	errs := []string{}
+	if !p.ValidFrom.IsZero() && use.At.Before(p.ValidFrom) {
+		errs = append(errs, fmt.Sprintf("the code can only be used from %s", p.ValidFrom.Format(nightLayout)))
+	}
+	if !p.ValidUntil.IsZero() && use.At.After(p.ValidUntil) {
+		errs = append(errs, fmt.Sprintf("the code expired on %s", p.ValidUntil.Format(nightLayout)))
+	}
+	errs = append(errs, p.stayErrors(use)...)
+	if p.MaxUses != 0 && p.Uses >= p.MaxUses {
+		errs = append(errs, "the code has been used up")
+	} else if p.MaxUsesPerUser != 0 && p.UsesByUser[use.UserID.Hex()] >= p.MaxUsesPerUser {
+		errs = append(errs, fmt.Sprintf("the code can only be used %d times per guest", p.MaxUsesPerUser))
+	}
+	return promoErrors(errs)
+}
+
+// CheckChange returns why the code a booking was made with doesn't apply
+// to the booking changed to use anymore. The code was already valid and
+// redeemed when the booking was made, so only the rules about the stay are
+// checked again.
+func (p *PromoCode) CheckChange(use PromoUse) map[string]string {
+	return promoErrors(p.stayErrors(use))
+}
+
+func (p *PromoCode) stayErrors(use PromoUse) []string {
+	errs := []string{}
+	if !p.HotelID.IsZero() && p.HotelID != use.HotelID {
+		errs = append(errs, "the code isn't valid for this hotel")
+	}
+	if p.Kind == AmountDiscount && p.Currency != use.Currency {
+		errs = append(errs, fmt.Sprintf("the code only applies to bookings in %s", p.Currency))
+	}
+	if use.Nights < p.MinNights {
+		errs = append(errs, fmt.Sprintf("the code needs a stay of at least %d nights", p.MinNights))
+	}
+	return errs
+}
+
+func promoErrors(errs []string) map[string]string {
+	errors := map[string]string{}
+	if len(errs) != 0 {
+		errors["promoCode"] = strings.Join(errs, ", ")
+	}
+	return errors
+}
+
+// Apply returns the promo as applied to a booking discounted by amount, nil
+// without a promo.
+func (p *PromoCode) Apply(amount int) *AppliedPromo {
+	if p == nil {
+		return nil
+	}
+	return &AppliedPromo{ID: p.ID, Code: p.Code, Discount: p.Discount, Amount: amount}
+}
+
+// GetDiscount returns the discount of the promo, nil without a promo
+func (p *PromoCode) GetDiscount() *Discount {
+	if p == nil {
+		return nil
+	}
+	return &p.Discount
+}
+
+// PromoUse returns the use of a promo code to book the body at the hotel
+func (b BookingBody) PromoUse(userID primitive.ObjectID, hotel *Hotel, now time.Time) PromoUse {
+	return PromoUse{
+		UserID:   userID,
+		HotelID:  hotel.ID,
+		Currency: hotel.CurrencyCode(),
+		Nights:   len(Booking{FromDate: b.FromDate, UntilDate: b.UntilDate}.Nights()),
+		At:       now,
+	}
+}
+
+func (q PromoQuery) Validate() map[string]string {
+	errors := map[string]string{}
+	if q.HotelID != "" && !primitive.IsValidObjectID(q.HotelID) {
+		errors["hotelId"] = "invalid hotel id"
+	}
+	return errors
+}
+
+func (q PromoQuery) CreateFilter() bson.M {
+	filter := bson.M{}
+	if q.HotelID != "" {
+		hotelId, _ := primitive.ObjectIDFromHex(q.HotelID)
+		filter["hotelID"] = hotelId
+	}
+	if code := NormalizePromoCode(q.Code); code != "" {
+		filter["code"] = bson.M{"$regex": "^" + regexp.QuoteMeta(code)}
+	}
+	return filter
+}
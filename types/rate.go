@@ -0,0 +1,165 @@
+package types
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// RatePlan holds the pricing rules of a hotel. Every rule applies to all the
+// rooms of the hotel unless it sets a RoomType.
+type RatePlan struct {
+	Seasons      []SeasonRule     `bson:"seasons,omitempty" json:"seasons,omitempty"`
+	Weekdays     []WeekdayRule    `bson:"weekdays,omitempty" json:"weekdays,omitempty"`
+	LengthOfStay []StayDiscount   `bson:"lengthOfStay,omitempty" json:"lengthOfStay,omitempty"`
+	ExtraPerson  []ExtraPersonFee `bson:"extraPerson,omitempty" json:"extraPerson,omitempty"`
+	// DepositPercent is the part of the price charged when booking. The
+	// whole price is charged when it is 0.
+	DepositPercent int `bson:"depositPercent,omitempty" json:"depositPercent,omitempty"`
+}
+
+// SeasonRule multiplies the base price of the nights between From and Until,
+// both inclusive YYYY-MM-DD dates.
+type SeasonRule struct {
+	RoomType   RoomType `bson:"roomType,omitempty" json:"roomType,omitempty"`
+	From       string   `bson:"from" json:"from"`
+	Until      string   `bson:"until" json:"until"`
+	Multiplier float64  `bson:"multiplier" json:"multiplier"`
+}
+
+// WeekdayRule adds a percentage to the nights starting on Weekday
+type WeekdayRule struct {
+	RoomType RoomType     `bson:"roomType,omitempty" json:"roomType,omitempty"`
+	Weekday  time.Weekday `bson:"weekday" json:"weekday"`
+	Percent  int          `bson:"percent" json:"percent"`
+}
+
+// StayDiscount takes a percentage off every night of stays of at least
+// MinNights nights.
+type StayDiscount struct {
+	RoomType  RoomType `bson:"roomType,omitempty" json:"roomType,omitempty"`
+	MinNights int      `bson:"minNights" json:"minNights"`
+	Percent   int      `bson:"percent" json:"percent"`
+}
+
+// ExtraPersonFee charges a fee per night for every guest above
+// BaseOccupancy.
+type ExtraPersonFee struct {
+	RoomType      RoomType `bson:"roomType,omitempty" json:"roomType,omitempty"`
+	BaseOccupancy int      `bson:"baseOccupancy" json:"baseOccupancy"`
+	Fee           int      `bson:"fee" json:"fee"`
+}
+
+// NightPrice is the itemized price of a single night
+type NightPrice struct {
+	Date        string `bson:"date" json:"date"`
+	Base        int    `bson:"base" json:"base"`
+	Season      int    `bson:"season" json:"season"`
+	Weekday     int    `bson:"weekday" json:"weekday"`
+	ExtraPerson int    `bson:"extraPerson" json:"extraPerson"`
+	Discount    int    `bson:"discount" json:"discount"`
+	Total       int    `bson:"total" json:"total"`
+}
+
+// PriceQuote is the price of a stay. Total is the price of the nights, plus
+// the taxes and fees of the hotel when it has them.
+type PriceQuote struct {
+	Nights   []NightPrice `json:"nights"`
+	Subtotal int          `json:"subtotal"`
+	// Discount is taken off the subtotal before taxes
+	Discount int       `json:"discount,omitempty"`
+	Taxes    []TaxLine `json:"taxes,omitempty"`
+	Total    int       `json:"total"`
+}
+
+// QuoteResponse is returned by the quote endpoint. ID is only set when the
+// booking is valid and the room available, and can be sent back as quoteId
+// when booking to get the quoted price.
+type QuoteResponse struct {
+	ID        string            `json:"id,omitempty"`
+	Valid     bool              `json:"valid"`
+	Errors    map[string]string `json:"errors,omitempty"`
+	Available bool              `json:"available"`
+	Nightly   []NightPrice      `json:"nightly"`
+	Subtotal  int               `json:"subtotal"`
+	Discount  int               `json:"discount,omitempty"`
+	Taxes     []TaxLine         `json:"taxes"`
+	Total     int               `json:"total"`
+	Currency  string            `json:"currency"`
+	// Display is the total in the currency the guest asked for
+	Display   *Money     `json:"display,omitempty"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+}
+
+// AppliesTo reports whether a rule scoped to ruleType applies to rooms of
+// type t.
+func (t RoomType) AppliesTo(ruleType RoomType) bool {
+	return ruleType == 0 || ruleType == t
+}
+
+func (p RatePlan) Validate() map[string]string {
+	errors := map[string]string{}
+	add := func(key string, errs []string) {
+		if len(errs) != 0 {
+			errors[key] = strings.Join(errs, ", ")
+		}
+	}
+	errs := []string{}
+	for i, s := range p.Seasons {
+		from, fErr := parseQueryDate(s.From)
+		until, uErr := parseQueryDate(s.Until)
+		switch {
+		case fErr != nil || uErr != nil || from.IsZero() || until.IsZero():
+			errs = append(errs, fmt.Sprintf("season %d needs from and until dates as YYYY-MM-DD", i))
+		case until.Before(from):
+			errs = append(errs, fmt.Sprintf("season %d ends before it starts", i))
+		}
+		if s.Multiplier <= 0 {
+			errs = append(errs, fmt.Sprintf("season %d multiplier must be greater than 0", i))
+		}
+	}
+	add("seasons", errs)
+	errs = []string{}
+	for i, w := range p.Weekdays {
+		if w.Weekday < time.Sunday || w.Weekday > time.Saturday {
+			errs = append(errs, fmt.Sprintf("weekday rule %d must use a weekday between 0 (sunday) and 6 (saturday)", i))
+		}
+		if w.Percent < -100 {
+			errs = append(errs, fmt.Sprintf("weekday rule %d can't take more than 100%%", i))
+		}
+	}
+	add("weekdays", errs)
+	errs = []string{}
+	for i, d := range p.LengthOfStay {
+		if d.MinNights < 1 {
+			errs = append(errs, fmt.Sprintf("length of stay discount %d needs at least 1 night", i))
+		}
+		if d.Percent < 0 || d.Percent > 100 {
+			errs = append(errs, fmt.Sprintf("length of stay discount %d must be between 0 and 100%%", i))
+		}
+	}
+	add("lengthOfStay", errs)
+	errs = []string{}
+	for i, e := range p.ExtraPerson {
+		if e.BaseOccupancy < 1 {
+			errs = append(errs, fmt.Sprintf("extra person fee %d needs a base occupancy of at least 1", i))
+		}
+		if e.Fee < 0 {
+			errs = append(errs, fmt.Sprintf("extra person fee %d can't be negative", i))
+		}
+	}
+	add("extraPerson", errs)
+	if p.DepositPercent < 0 || p.DepositPercent > 100 {
+		errors["depositPercent"] = "deposit must be between 0 and 100%"
+	}
+	return errors
+}
+
+// Deposit returns the amount charged when booking at the given price
+func (p RatePlan) Deposit(price int) int {
+	if p.DepositPercent == 0 {
+		return price
+	}
+	return int(math.Round(float64(price) * float64(p.DepositPercent) / 100))
+}
@@ -0,0 +1,258 @@
+package types
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const maxRoomOccupancy = 12
+
+// DefaultOccupancy is the capacity used for rooms stored before rooms had
+// their own maxOccupancy. MigrateRooms backfills it into those documents.
+var DefaultOccupancy = map[RoomType]int{
+	Single:  1,
+	Double:  3,
+	SeaSide: 3,
+	Deluxe:  4,
+}
+
+var bedSizes = map[string]bool{
+	"single": true,
+	"double": true,
+	"queen":  true,
+	"king":   true,
+	"sofa":   true,
+}
+
+type Bed struct {
+	Size  string `bson:"size" json:"size"`
+	Count int    `bson:"count" json:"count"`
+}
+
+type NewRoomParams struct {
+	Type         RoomType `json:"type"`
+	BasePrice    int      `json:"basePrice"`
+	MaxOccupancy int      `json:"maxOccupancy"`
+	Beds         []Bed    `json:"beds"`
+	Number       string   `json:"number"`
+	Floor        int      `json:"floor"`
+}
+
+type UpdateRoomParams struct {
+	Type         RoomType `json:"type"`
+	BasePrice    int      `json:"basePrice"`
+	MaxOccupancy int      `json:"maxOccupancy"`
+	Beds         []Bed    `json:"beds"`
+	Number       string   `json:"number"`
+	Floor        *int     `json:"floor"`
+}
+
+func (t RoomType) String() string {
+	switch t {
+	case Single:
+		return "single"
+	case Double:
+		return "double"
+	case SeaSide:
+		return "sea-side"
+	case Deluxe:
+		return "deluxe"
+	}
+	return fmt.Sprintf("unknown(%d)", int(t))
+}
+
+// Price returns the base price of the room in the currency of its hotel
+func (r Room) Price() Money {
+	return Money{Amount: r.BasePrice, Currency: currencyOrDefault(r.Currency)}
+}
+
+// Capacity returns the maximum number of guests the room accommodates,
+// falling back to the room type default for rooms that haven't been migrated.
+func (r Room) Capacity() int {
+	if r.MaxOccupancy > 0 {
+		return r.MaxOccupancy
+	}
+	return DefaultOccupancy[r.Type]
+}
+
+func (r Room) CreateActiveBookingsFilter(now time.Time) bson.M {
+	return activeBookingsFilter([]primitive.ObjectID{r.ID}, now)
+}
+
+func (params NewRoomParams) Validate() map[string]string {
+	errors := map[string]string{}
+	if _, ok := DefaultOccupancy[params.Type]; !ok {
+		errors["type"] = fmt.Sprintf("unknown room type: %d", params.Type)
+	}
+	if params.BasePrice <= 0 {
+		errors["basePrice"] = "base price must be greater than 0"
+	}
+	if err := validateOccupancy(params.MaxOccupancy); err != nil {
+		errors["maxOccupancy"] = err.Error()
+	}
+	if err := validateBeds(params.Beds); err != nil {
+		errors["beds"] = err.Error()
+	}
+	if params.Number == "" {
+		errors["number"] = "room number is required"
+	}
+	return errors
+}
+
+func NewRoomFromParams(params *NewRoomParams, hotelId primitive.ObjectID) *Room {
+	return &Room{
+		Type:         params.Type,
+		BasePrice:    params.BasePrice,
+		HotelId:      hotelId,
+		MaxOccupancy: params.MaxOccupancy,
+		Beds:         params.Beds,
+		Number:       params.Number,
+		Floor:        params.Floor,
+	}
+}
+
+func (params NewRoomParams) ToBson() bson.M {
+	return bson.M{
+		"type":         params.Type,
+		"basePrice":    params.BasePrice,
+		"maxOccupancy": params.MaxOccupancy,
+		"beds":         params.Beds,
+		"number":       params.Number,
+		"floor":        params.Floor,
+	}
+}
+
+func (params UpdateRoomParams) ToBson() bson.M {
+	bson := bson.M{}
+	if params.Type != 0 {
+		bson["type"] = params.Type
+	}
+	if params.BasePrice != 0 {
+		bson["basePrice"] = params.BasePrice
+	}
+	if params.MaxOccupancy != 0 {
+		bson["maxOccupancy"] = params.MaxOccupancy
+	}
+	if params.Beds != nil {
+		bson["beds"] = params.Beds
+	}
+	if params.Number != "" {
+		bson["number"] = params.Number
+	}
+	if params.Floor != nil {
+		bson["floor"] = *params.Floor
+	}
+	return bson
+}
+
+func (params UpdateRoomParams) Validate() map[string]string {
+	errors := map[string]string{}
+	if _, ok := DefaultOccupancy[params.Type]; params.Type != 0 && !ok {
+		errors["type"] = fmt.Sprintf("unknown room type: %d", params.Type)
+	}
+	if params.BasePrice < 0 {
+		errors["basePrice"] = "base price must be greater than 0"
+	}
+	if params.MaxOccupancy != 0 {
+		if err := validateOccupancy(params.MaxOccupancy); err != nil {
+			errors["maxOccupancy"] = err.Error()
+		}
+	}
+	if params.Beds != nil {
+		if err := validateBeds(params.Beds); err != nil {
+			errors["beds"] = err.Error()
+		}
+	}
+	return errors
+}
+
+// CheckBody checks if the json body contains the correct keys
+func (params UpdateRoomParams) CheckBody(jsonBody map[string]any) error {
+	paramsMap := params.ToBson()
+	for key := range jsonBody {
+		_, ok := paramsMap[key]
+		if !ok {
+			return fmt.Errorf("the key '%s' is not a valid update property", key)
+		}
+	}
+	if len(jsonBody) == 0 {
+		return errors.New("no valid room properties were provided")
+	}
+	return nil
+}
+
+func validateOccupancy(n int) error {
+	if n < 1 || n > maxRoomOccupancy {
+		return fmt.Errorf("max occupancy must be between 1 and %d", maxRoomOccupancy)
+	}
+	return nil
+}
+
+func validateBeds(beds []Bed) error {
+	if len(beds) == 0 {
+		return errors.New("at least one bed is required")
+	}
+	for _, bed := range beds {
+		if !bedSizes[bed.Size] {
+			return fmt.Errorf("unknown bed size: '%s'", bed.Size)
+		}
+		if bed.Count < 1 {
+			return fmt.Errorf("bed count for '%s' must be at least 1", bed.Size)
+		}
+	}
+	return nil
+}
+
+// RoomQuery are the filters accepted by the room list endpoint
+type RoomQuery struct {
+	HotelID  string   `query:"hotelId"`
+	Type     RoomType `query:"type"`
+	MinPrice int      `query:"minPrice"`
+	MaxPrice int      `query:"maxPrice"`
+	Retired  *bool    `query:"retired"`
+}
+
+func (q RoomQuery) Validate() map[string]string {
+	errors := map[string]string{}
+	if q.HotelID != "" && !primitive.IsValidObjectID(q.HotelID) {
+		errors["hotelId"] = "invalid hotel id"
+	}
+	if _, ok := DefaultOccupancy[q.Type]; q.Type != 0 && !ok {
+		errors["type"] = fmt.Sprintf("unknown room type: %d", q.Type)
+	}
+	if q.MinPrice < 0 || q.MaxPrice < 0 {
+		errors["price"] = "price range can't be negative"
+	} else if q.MaxPrice != 0 && q.MinPrice > q.MaxPrice {
+		errors["price"] = "minPrice must be lower than maxPrice"
+	}
+	return errors
+}
+
+func (q RoomQuery) CreateFilter() bson.M {
+	filter := bson.M{}
+	if q.HotelID != "" {
+		hotelId, _ := primitive.ObjectIDFromHex(q.HotelID)
+		filter["hotelId"] = hotelId
+	}
+	if q.Type != 0 {
+		filter["type"] = q.Type
+	}
+	price := bson.M{}
+	if q.MinPrice != 0 {
+		price["$gte"] = q.MinPrice
+	}
+	if q.MaxPrice != 0 {
+		price["$lte"] = q.MaxPrice
+	}
+	if len(price) != 0 {
+		filter["basePrice"] = price
+	}
+	if q.Retired != nil {
+		filter["retired"] = bson.M{"$ne": !*q.Retired}
+	}
+	return filter
+}
@@ -0,0 +1,92 @@
+package types
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var ErrInvalidRefreshToken = errors.New("invalid refresh token")
+
+// Session is a login of a user, kept alive by a refresh token that is
+// replaced every time it is used. Only the hash of the current refresh
+// token is stored.
+type Session struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	UserID    primitive.ObjectID `bson:"userID" json:"userID"`
+	TokenHash string             `bson:"tokenHash" json:"-"`
+	CreatedAt time.Time          `bson:"createdAt" json:"createdAt"`
+	ExpiresAt time.Time          `bson:"expiresAt" json:"expiresAt"`
+	RevokedAt *time.Time         `bson:"revokedAt,omitempty" json:"revokedAt,omitempty"`
+}
+
+// RevokedToken is the id of an access token revoked before it expires
+type RevokedToken struct {
+	TokenID   string    `bson:"tokenID" json:"tokenID"`
+	ExpiresAt time.Time `bson:"expiresAt" json:"expiresAt"`
+}
+
+// NewRefreshToken returns a random refresh token of the session with the
+// hash it is stored as. The token starts with the session id, so the session
+// can be found without storing the token itself.
+func NewRefreshToken(sessionID primitive.ObjectID) (string, string, error) {
+	return newSecretToken(sessionID)
+}
+
+// ParseRefreshToken returns the session id and the hash of a refresh token
+func ParseRefreshToken(token string) (primitive.ObjectID, string, error) {
+	sessionID, hash, ok := parseSecretToken(token)
+	if !ok {
+		return primitive.NilObjectID, "", ErrInvalidRefreshToken
+	}
+	return sessionID, hash, nil
+}
+
+// IsActive reports whether the session can still be refreshed
+func (s *Session) IsActive(now time.Time) bool {
+	return s.RevokedAt == nil && now.Before(s.ExpiresAt)
+}
+
+// Matches reports whether hash is the one of the current refresh token
+func (s *Session) Matches(hash string) bool {
+	return hashesMatch(s.TokenHash, hash)
+}
+
+// newSecretToken returns a random token made of id and a secret, with the
+// hash of the secret it is stored as
+func newSecretToken(id primitive.ObjectID) (string, string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	secret := base64.RawURLEncoding.EncodeToString(b)
+	return id.Hex() + "." + secret, hashToken(secret), nil
+}
+
+func parseSecretToken(token string) (primitive.ObjectID, string, bool) {
+	hexID, secret, ok := strings.Cut(token, ".")
+	if !ok || secret == "" {
+		return primitive.NilObjectID, "", false
+	}
+	id, err := primitive.ObjectIDFromHex(hexID)
+	if err != nil {
+		return primitive.NilObjectID, "", false
+	}
+	return id, hashToken(secret), true
+}
+
+func hashesMatch(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+func hashToken(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
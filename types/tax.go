@@ -0,0 +1,70 @@
+package types
+
+import (
+	"fmt"
+	"strings"
+)
+
+// TaxRules are the taxes and fees a hotel charges on top of the price of its
+// rooms. VAT applies to the room price and the fees, but not to the city tax.
+type TaxRules struct {
+	// CityTax is charged for every guest and night
+	CityTax    int   `bson:"cityTax" json:"cityTax"`
+	VATPercent int   `bson:"vatPercent" json:"vatPercent"`
+	Fees       []Fee `bson:"fees,omitempty" json:"fees,omitempty"`
+}
+
+// Fee is a fixed amount charged once per booking, e.g. a cleaning or resort
+// fee.
+type Fee struct {
+	Name   string `bson:"name" json:"name"`
+	Amount int    `bson:"amount" json:"amount"`
+}
+
+// TaxLine is a tax or fee line item of a quote, booking or invoice
+type TaxLine struct {
+	Name   string `bson:"name" json:"name"`
+	Amount int    `bson:"amount" json:"amount"`
+}
+
+const (
+	CityTaxLine = "City tax"
+	VATLine     = "VAT"
+)
+
+func (r TaxRules) Validate() map[string]string {
+	errors := map[string]string{}
+	if r.CityTax < 0 {
+		errors["cityTax"] = "city tax can't be negative"
+	}
+	if r.VATPercent < 0 || r.VATPercent > 100 {
+		errors["vatPercent"] = "VAT must be between 0 and 100%"
+	}
+	errs := []string{}
+	seen := map[string]bool{}
+	for i, f := range r.Fees {
+		name := strings.TrimSpace(f.Name)
+		if name == "" {
+			errs = append(errs, fmt.Sprintf("fee %d needs a name", i))
+		} else if seen[strings.ToLower(name)] || name == CityTaxLine || name == VATLine {
+			errs = append(errs, fmt.Sprintf("fee %d repeats the name '%s'", i, name))
+		}
+		seen[strings.ToLower(name)] = true
+		if f.Amount <= 0 {
+			errs = append(errs, fmt.Sprintf("fee %d must be positive", i))
+		}
+	}
+	if len(errs) != 0 {
+		errors["fees"] = strings.Join(errs, ", ")
+	}
+	return errors
+}
+
+// TaxTotal adds up the tax lines
+func TaxTotal(lines []TaxLine) int {
+	total := 0
+	for _, l := range lines {
+		total += l.Amount
+	}
+	return total
+}
@@ -5,14 +5,18 @@ import (
 	"fmt"
 	"regexp"
 	"strings"
+	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"golang.org/x/crypto/bcrypt"
 )
 
+// BcryptCost is the work factor passwords are hashed with. Tests lower it
+// to keep hashing fast.
+var BcryptCost = 12
+
 const (
-	bcryptCost  = 12
 	minFNameLen = 2
 	minLNameLen = 2
 	minPassLen  = 7
@@ -25,6 +29,12 @@ type User struct {
 	Email     string             `bson:"email" json:"email"`
 	Password  string             `bson:"password" json:"-"`
 	IsAdmin   bool               `bson:"isAdmin" json:"isAdmin"`
+	// EmailVerified is set once the user follows the link mailed when
+	// registering. Unverified users can't book.
+	EmailVerified bool `bson:"emailVerified" json:"emailVerified"`
+	// PasswordChangedAt is when the password was last reset, the access
+	// tokens issued up to its second are no longer accepted
+	PasswordChangedAt *time.Time `bson:"passwordChangedAt,omitempty" json:"-"`
 }
 
 type NewUserParams struct {
@@ -33,6 +43,8 @@ type NewUserParams struct {
 	Email     string `json:"email"`
 	Password  string `json:"password"`
 	IsAdmin   bool   `json:"isAdmin"`
+	// Verified creates the user with a verified email, only admins can set it
+	Verified bool `json:"verified"`
 }
 
 type UpdateUserParams struct {
@@ -86,16 +98,17 @@ func ValidatePassword(password string) []string {
 }
 
 func NewUserFromParams(params *NewUserParams) (*User, error) {
-	cryptPass, err := bcrypt.GenerateFromPassword([]byte(params.Password), bcryptCost)
+	cryptPass, err := HashPassword(params.Password)
 	if err != nil {
 		return nil, err
 	}
 	return &User{
-		FirstName: params.FirstName,
-		LastName:  params.LastName,
-		Email:     params.Email,
-		Password:  string(cryptPass),
-		IsAdmin:   params.IsAdmin,
+		FirstName:     params.FirstName,
+		LastName:      params.LastName,
+		Email:         params.Email,
+		Password:      cryptPass,
+		IsAdmin:       params.IsAdmin,
+		EmailVerified: params.Verified,
 	}, nil
 }
 
@@ -136,6 +149,52 @@ func (params UpdateUserParams) CheckBody(jsonBody map[string]any) error {
 	return nil
 }
 
+// UpdateMeParams are the changes users make to their own profile. Changing
+// the password requires the current one.
+type UpdateMeParams struct {
+	UpdateUserParams
+	Password        string `json:"password"`
+	CurrentPassword string `json:"currentPassword"`
+}
+
+func (params UpdateMeParams) Validate() map[string]string {
+	errors := params.UpdateUserParams.Validate()
+	if params.FirstName == "" && params.LastName == "" && params.Password == "" {
+		errors["user"] = "no valid user properties were provided"
+	}
+	if params.Password != "" {
+		if err := ValidatePassword(params.Password); len(err) > 0 {
+			errors["password"] = strings.Join(err, ", ")
+		}
+		if params.CurrentPassword == "" {
+			errors["currentPassword"] = "the current password is required to change it"
+		}
+	}
+	return errors
+}
+
 func IsValidPassword(ipass, upass string) bool {
 	return bcrypt.CompareHashAndPassword([]byte(upass), []byte(ipass)) == nil
 }
+
+// UserQuery are the filters accepted by the user list endpoint
+type UserQuery struct {
+	Name    string `query:"name"`
+	IsAdmin *bool  `query:"isAdmin"`
+}
+
+func (q UserQuery) Validate() map[string]string {
+	return map[string]string{}
+}
+
+func (q UserQuery) CreateFilter() bson.M {
+	filter := bson.M{}
+	if q.Name != "" {
+		name := bson.M{"$regex": regexp.QuoteMeta(q.Name), "$options": "i"}
+		filter["$or"] = []bson.M{{"firstName": name}, {"lastName": name}}
+	}
+	if q.IsAdmin != nil {
+		filter["isAdmin"] = *q.IsAdmin
+	}
+	return filter
+}